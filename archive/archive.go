@@ -0,0 +1,216 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// Writer streams an archive entry by entry. Names are slash separated paths
+// relative to the archive root.
+type Writer interface {
+	Dir(name string, info os.FileInfo) error
+	File(name string, info os.FileInfo, content io.Reader) error
+	Symlink(name string, info os.FileInfo, target string) error
+	Close() error
+}
+
+// ReadError means the content of an entry couldn't be read. The entry is
+// truncated (zip) or zero padded (tar), but the archive stays valid.
+type ReadError struct {
+	Name string
+	Err  error
+}
+
+func (err *ReadError) Error() string {
+	return "read " + err.Name + ": " + err.Err.Error()
+}
+
+type trackingReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (reader *trackingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	if err != nil && err != io.EOF {
+		reader.err = err
+	}
+	return n, err
+}
+
+func Extension(format string) string {
+	switch format {
+	case FormatTar:
+		return ".tar"
+	case FormatTarGz:
+		return ".tar.gz"
+	}
+	return ".zip"
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatTar:
+		return "application/x-tar"
+	case FormatTarGz:
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatZip, "":
+		return &zipWriter{zip: zip.NewWriter(w)}, nil
+	case FormatTar:
+		return &tarWriter{tar: tar.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{tar: tar.NewWriter(gz), gz: gz}, nil
+	}
+	return nil, fmt.Errorf("Unknown format %q", format)
+}
+
+type zipWriter struct {
+	zip *zip.Writer
+}
+
+func (writer *zipWriter) header(name string, info os.FileInfo) (*zip.FileHeader, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	return header, nil
+}
+
+func (writer *zipWriter) Dir(name string, info os.FileInfo) error {
+	header, err := writer.header(name+"/", info)
+	if err != nil {
+		return err
+	}
+	header.Method = zip.Store
+	_, err = writer.zip.CreateHeader(header)
+	return err
+}
+
+func (writer *zipWriter) File(name string, info os.FileInfo, content io.Reader) error {
+	header, err := writer.header(name, info)
+	if err != nil {
+		return err
+	}
+	w, err := writer.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	reader := &trackingReader{reader: content}
+	_, err = io.Copy(w, reader)
+	if err != nil && err == reader.err {
+		return &ReadError{Name: name, Err: err}
+	}
+	return err
+}
+
+func (writer *zipWriter) Symlink(name string, info os.FileInfo, target string) error {
+	header, err := writer.header(name, info)
+	if err != nil {
+		return err
+	}
+	header.Method = zip.Store
+	w, err := writer.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (writer *zipWriter) Close() error {
+	return writer.zip.Close()
+}
+
+type tarWriter struct {
+	tar *tar.Writer
+	gz  *gzip.Writer
+}
+
+func (writer *tarWriter) Dir(name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	return writer.tar.WriteHeader(header)
+}
+
+// File always writes info.Size() bytes, so a read error in the middle of a
+// file leaves a zero padded entry instead of a broken archive. The error is
+// still returned.
+func (writer *tarWriter) File(name string, info os.FileInfo, content io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	err = writer.tar.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	reader := &trackingReader{reader: io.LimitReader(content, header.Size)}
+	n, err := io.Copy(writer.tar, reader)
+	if err != nil && err != reader.err {
+		return err
+	}
+	if n == header.Size {
+		return nil
+	}
+	_, err = io.CopyN(writer.tar, zeros{}, header.Size-n)
+	if err != nil {
+		return err
+	}
+	if reader.err == nil {
+		return &ReadError{Name: name, Err: io.ErrUnexpectedEOF}
+	}
+	return &ReadError{Name: name, Err: reader.err}
+}
+
+func (writer *tarWriter) Symlink(name string, info os.FileInfo, target string) error {
+	header, err := tar.FileInfoHeader(info, target)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	return writer.tar.WriteHeader(header)
+}
+
+func (writer *tarWriter) Close() error {
+	err := writer.tar.Close()
+	if writer.gz == nil {
+		return err
+	}
+	gzErr := writer.gz.Close()
+	if err != nil {
+		return err
+	}
+	return gzErr
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
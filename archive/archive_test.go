@@ -0,0 +1,99 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"files_server/archive"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeArchive(t *testing.T, format string) ([]byte, error) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("content"), 0666))
+	fileInfo, err := os.Stat(fileName)
+	require.NoError(t, err)
+	dirInfo, err := os.Stat(dir)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	writer, err := archive.NewWriter(buf, format)
+	require.NoError(t, err)
+	require.NoError(t, writer.Dir("root", dirInfo))
+	require.NoError(t, writer.File("root/file.txt", fileInfo, strings.NewReader("content")))
+	readErr := writer.File("root/broken.txt", fileInfo, io.MultiReader(strings.NewReader("con"), iotest.ErrReader(errors.New("disk"))))
+	require.NoError(t, writer.Close())
+	return buf.Bytes(), readErr
+}
+
+func TestTar(t *testing.T) {
+	for _, format := range []string{archive.FormatTar, archive.FormatTarGz} {
+		t.Run(
+			format, func(t *testing.T) {
+				data, err := writeArchive(t, format)
+				readErr := &archive.ReadError{}
+				require.True(t, errors.As(err, &readErr))
+				require.Equal(t, "read root/broken.txt: disk", err.Error())
+
+				var reader io.Reader = bytes.NewReader(data)
+				if format == archive.FormatTarGz {
+					reader, err = gzip.NewReader(reader)
+					require.NoError(t, err)
+				}
+				tarReader := tar.NewReader(reader)
+				contents := map[string]string{}
+				for {
+					header, err := tarReader.Next()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+					b, err := ioutil.ReadAll(tarReader)
+					require.NoError(t, err)
+					contents[header.Name] = string(b)
+				}
+				require.Equal(t, map[string]string{
+					"root/":           "",
+					"root/file.txt":   "content",
+					"root/broken.txt": "con\x00\x00\x00\x00",
+				}, contents)
+			},
+		)
+	}
+}
+
+func TestZip(t *testing.T) {
+	data, err := writeArchive(t, archive.FormatZip)
+	readErr := &archive.ReadError{}
+	require.True(t, errors.As(err, &readErr))
+
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	names := []string{}
+	for _, file := range zipReader.File {
+		names = append(names, file.Name)
+	}
+	require.Equal(t, []string{"root/", "root/file.txt", "root/broken.txt"}, names)
+
+	file, err := zipReader.File[1].Open()
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "content", string(b))
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := archive.NewWriter(&bytes.Buffer{}, "rar")
+	require.Error(t, err)
+}
@@ -1,25 +1,321 @@
 package auth
 
 import (
+	"crypto/subtle"
+	"errors"
 	"files_server/dir"
+	"files_server/limit"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+var (
+	ErrNoCredentials = errors.New("No credentials")
+	ErrWrongPassword = errors.New("Wrong user or password")
+	ErrUnknownToken  = errors.New("Unknown token")
+)
+
+// WaitError is a limit that was hit, the client can try again after Wait.
+type WaitError struct {
+	Wait time.Duration
+}
+
+func (err *WaitError) Error() string {
+	return "Too many requests"
+}
+
+type User struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Admin    bool   `json:"admin"`
+}
+
+type Limits struct {
+	AuthRate        limit.Rate
+	IPRate          limit.Rate
+	TokenRate       limit.Rate
+	MaxSessions     int
+	SessionTTL      time.Duration
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		AuthRate:        limit.Rate{PerSecond: 1, Burst: 5},
+		IPRate:          limit.Rate{PerSecond: 50, Burst: 100},
+		TokenRate:       limit.Rate{PerSecond: 20, Burst: 40},
+		MaxSessions:     10,
+		SessionTTL:      24 * time.Hour,
+		MaxFailedLogins: 5,
+		LockoutDuration: 15 * time.Minute,
+	}
+}
+
+type Config struct {
+	// Users is the user store. When it is empty /auth hands out tokens to
+	// anyone and sessions are counted per client IP.
+	Users  []User
+	Limits Limits
+	Clock  limit.Clock
+	NewDir func() *dir.Dir
+}
+
+type session struct {
+	user     dir.User
+	dir      *dir.Dir
+	lastSeen time.Time
+}
+
 type AuthStorage struct {
-	authStorage map[string]*dir.Dir
+	mu           sync.Mutex
+	authStorage  map[string]*session
+	users        map[string]User
+	config       Config
+	authLimiter  *limit.Limiter
+	ipLimiter    *limit.Limiter
+	tokenLimiter *limit.Limiter
+	lockout      *limit.Lockout
 }
 
 func New() *AuthStorage {
-	return &AuthStorage{authStorage: map[string]*dir.Dir{}}
+	return NewWithConfig(Config{Limits: DefaultLimits()})
+}
+
+func NewWithConfig(config Config) *AuthStorage {
+	if config.Clock == nil {
+		config.Clock = limit.RealClock
+	}
+	if config.NewDir == nil {
+		config.NewDir = dir.New
+	}
+	users := map[string]User{}
+	for _, user := range config.Users {
+		users[user.Name] = user
+	}
+	return &AuthStorage{
+		authStorage:  map[string]*session{},
+		users:        users,
+		config:       config,
+		authLimiter:  limit.New(config.Limits.AuthRate, config.Clock),
+		ipLimiter:    limit.New(config.Limits.IPRate, config.Clock),
+		tokenLimiter: limit.New(config.Limits.TokenRate, config.Clock),
+		lockout:      limit.NewLockout(config.Limits.MaxFailedLogins, config.Limits.LockoutDuration, config.Clock),
+	}
 }
 
 func (authStorage *AuthStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	token := generateToken()
-	authStorage.authStorage[token] = dir.New()
+	name, password := credentials(r)
+	token, err := authStorage.Login(clientIP(r), name, password)
+	if err != nil {
+		authError(w, err)
+		return
+	}
 	w.Write([]byte(token))
 }
 
+// Login checks the credentials of a client at ip and opens a session for
+// it, applying the same limits and lockout as /auth. Without a user store
+// the session belongs to ip and the credentials are ignored.
+func (authStorage *AuthStorage) Login(ip, name, password string) (string, error) {
+	if ok, wait := authStorage.authLimiter.Allow(ip); !ok {
+		return "", &WaitError{Wait: wait}
+	}
+
+	user := dir.User{Name: ip}
+	if len(authStorage.users) > 0 {
+		if name == "" {
+			return "", ErrNoCredentials
+		}
+		// Failures count per user and client IP, so that nobody can lock a
+		// user out from elsewhere, and only for users that exist.
+		key := name + " " + ip
+		if locked, wait := authStorage.lockout.Locked(key); locked {
+			return "", &WaitError{Wait: wait}
+		}
+		if !authStorage.checkPassword(name, password) {
+			if _, ok := authStorage.users[name]; ok {
+				authStorage.lockout.Fail(key)
+			}
+			return "", ErrWrongPassword
+		}
+		authStorage.lockout.Reset(key)
+		user = dir.User{Name: name, Admin: authStorage.users[name].Admin}
+	}
+
+	token, wait := authStorage.newSession(user)
+	if token == "" {
+		return "", &WaitError{Wait: wait}
+	}
+	return token, nil
+}
+
+// Session finds the session's dir.Dir and user by token for a client at
+// ip, applying the per IP and per token limits.
+func (authStorage *AuthStorage) Session(ip, token string) (*dir.Dir, dir.User, error) {
+	if ok, wait := authStorage.ipLimiter.Allow(ip); !ok {
+		return nil, dir.User{}, &WaitError{Wait: wait}
+	}
+	currentDir, user := authStorage.session(token)
+	if currentDir == nil {
+		return nil, dir.User{}, ErrUnknownToken
+	}
+	if ok, wait := authStorage.tokenLimiter.Allow(token); !ok {
+		return nil, dir.User{}, &WaitError{Wait: wait}
+	}
+	return currentDir, user, nil
+}
+
+// Logout ends the session of token.
+func (authStorage *AuthStorage) Logout(token string) {
+	authStorage.mu.Lock()
+	defer authStorage.mu.Unlock()
+	authStorage.drop(token)
+}
+
+// Commands returns the handler for everything behind /auth. It finds the
+// session's dir.Dir by token and applies the per IP and per token limits.
+func (authStorage *AuthStorage) Commands() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentDir, user, err := authStorage.Session(clientIP(r), requestToken(r))
+		if err != nil {
+			authError(w, err)
+			return
+		}
+		currentDir.ServeHTTP(w, r.WithContext(dir.WithUser(r.Context(), user)))
+	})
+}
+
+func (authStorage *AuthStorage) checkPassword(name, password string) bool {
+	user, ok := authStorage.users[name]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1
+}
+
+func (authStorage *AuthStorage) newSession(user dir.User) (string, time.Duration) {
+	authStorage.mu.Lock()
+	defer authStorage.mu.Unlock()
+
+	now := authStorage.config.Clock.Now()
+	authStorage.expire(now)
+
+	maxSessions := authStorage.config.Limits.MaxSessions
+	if maxSessions > 0 {
+		live := 0
+		var oldest time.Time
+		for _, s := range authStorage.authStorage {
+			if s.user.Name != user.Name {
+				continue
+			}
+			live++
+			if oldest.IsZero() || s.lastSeen.Before(oldest) {
+				oldest = s.lastSeen
+			}
+		}
+		if live >= maxSessions {
+			wait := time.Minute
+			if ttl := authStorage.config.Limits.SessionTTL; ttl > 0 {
+				wait = oldest.Add(ttl).Sub(now)
+			}
+			return "", wait
+		}
+	}
+
+	token := generateToken()
+	for authStorage.authStorage[token] != nil {
+		token = generateToken()
+	}
+	authStorage.authStorage[token] = &session{user: user, dir: authStorage.config.NewDir(), lastSeen: now}
+	return token, 0
+}
+
+func (authStorage *AuthStorage) session(token string) (*dir.Dir, dir.User) {
+	if token == "" {
+		return nil, dir.User{}
+	}
+
+	authStorage.mu.Lock()
+	defer authStorage.mu.Unlock()
+
+	s, ok := authStorage.authStorage[token]
+	if !ok {
+		return nil, dir.User{}
+	}
+	now := authStorage.config.Clock.Now()
+	if authStorage.expired(s, now) {
+		authStorage.drop(token)
+		return nil, dir.User{}
+	}
+	s.lastSeen = now
+	return s.dir, s.user
+}
+
+func (authStorage *AuthStorage) expire(now time.Time) {
+	for token, s := range authStorage.authStorage {
+		if authStorage.expired(s, now) {
+			authStorage.drop(token)
+		}
+	}
+}
+
+func (authStorage *AuthStorage) expired(s *session, now time.Time) bool {
+	ttl := authStorage.config.Limits.SessionTTL
+	return ttl > 0 && now.Sub(s.lastSeen) >= ttl
+}
+
+func (authStorage *AuthStorage) drop(token string) {
+	delete(authStorage.authStorage, token)
+	authStorage.tokenLimiter.Forget(token)
+}
+
+func credentials(r *http.Request) (string, string) {
+	if name, password, ok := r.BasicAuth(); ok {
+		return name, password
+	}
+	return r.URL.Query().Get("user"), r.URL.Query().Get("password")
+}
+
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func authError(w http.ResponseWriter, err error) {
+	waitErr := &WaitError{}
+	if errors.As(err, &waitErr) {
+		tooManyRequests(w, waitErr.Wait)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
 var letters = []rune("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 func generateToken() string {
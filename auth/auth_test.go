@@ -0,0 +1,183 @@
+package auth_test
+
+import (
+	"files_server/auth"
+	"files_server/limit"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+func initTestServer(t *testing.T, config auth.Config) *httptest.Server {
+	authStorage := auth.NewWithConfig(config)
+	mux := http.NewServeMux()
+	mux.Handle("/", authStorage.Commands())
+	mux.Handle("/auth", authStorage)
+	testServer := httptest.NewServer(mux)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func get(t *testing.T, testServer *httptest.Server, path string) (*http.Response, string) {
+	resp, err := testServer.Client().Get(testServer.URL + path)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	return resp, string(b)
+}
+
+func TestAuthToken(t *testing.T) {
+	testServer := initTestServer(t, auth.Config{})
+
+	resp, _ := get(t, testServer, "/pwd")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, token := get(t, testServer, "/auth")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, token, 16)
+
+	resp, body := get(t, testServer, "/pwd?token="+token)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "/Users", body)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/pwd", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = testServer.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	testServer := initTestServer(t, auth.Config{
+		Clock: clock,
+		Limits: auth.Limits{
+			AuthRate:  limit.Rate{PerSecond: 0.5, Burst: 2},
+			TokenRate: limit.Rate{PerSecond: 1, Burst: 1},
+		},
+	})
+
+	_, token := get(t, testServer, "/auth")
+	resp, _ := get(t, testServer, "/auth")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = get(t, testServer, "/auth")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	clock.advance(2 * time.Second)
+	resp, _ = get(t, testServer, "/auth")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = get(t, testServer, "/pwd?token="+token)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = get(t, testServer, "/pwd?token="+token)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	clock.advance(time.Second)
+	resp, _ = get(t, testServer, "/pwd?token="+token)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAuthSessions(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	testServer := initTestServer(t, auth.Config{
+		Clock: clock,
+		Users: []auth.User{{Name: "user", Password: "secret"}},
+		Limits: auth.Limits{
+			MaxSessions: 2,
+			SessionTTL:  time.Hour,
+		},
+	})
+
+	resp, _ := get(t, testServer, "/auth")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, first := get(t, testServer, "/auth?user=user&password=secret")
+	clock.advance(time.Minute)
+	resp, _ = get(t, testServer, "/auth?user=user&password=secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = get(t, testServer, "/auth?user=user&password=secret")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "3540", resp.Header.Get("Retry-After"))
+
+	clock.advance(59 * time.Minute)
+	resp, _ = get(t, testServer, "/pwd?token="+first)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = get(t, testServer, "/auth?user=user&password=secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAuthLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	testServer := initTestServer(t, auth.Config{
+		Clock: clock,
+		Users: []auth.User{{Name: "user", Password: "secret"}},
+		Limits: auth.Limits{
+			MaxFailedLogins: 2,
+			LockoutDuration: time.Minute,
+		},
+	})
+
+	resp, _ := get(t, testServer, "/auth?user=user&password=wrong")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = get(t, testServer, "/auth?user=user&password=wrong")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = get(t, testServer, "/auth?user=user&password=secret")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "60", resp.Header.Get("Retry-After"))
+
+	clock.advance(time.Minute)
+	resp, _ = get(t, testServer, "/auth?user=user&password=secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAuthLockoutPerIP(t *testing.T) {
+	authStorage := auth.NewWithConfig(auth.Config{
+		Clock: &fakeClock{now: time.Unix(1000, 0)},
+		Users: []auth.User{{Name: "user", Password: "secret"}},
+		Limits: auth.Limits{
+			MaxFailedLogins: 2,
+			LockoutDuration: time.Minute,
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := authStorage.Login("10.0.0.1", "user", "wrong")
+		require.Equal(t, auth.ErrWrongPassword, err)
+	}
+	_, err := authStorage.Login("10.0.0.1", "user", "secret")
+	require.IsType(t, &auth.WaitError{}, err)
+	// Someone else isn't locked out by those failures.
+	_, err = authStorage.Login("10.0.0.2", "user", "secret")
+	require.NoError(t, err)
+
+	// Names that don't exist are never locked.
+	for i := 0; i < 3; i++ {
+		_, err = authStorage.Login("10.0.0.1", "nobody", "wrong")
+		require.Equal(t, auth.ErrWrongPassword, err)
+	}
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// LoadUsers reads a JSON array of users, e.g.
+// [{"name": "admin", "password": "secret", "admin": true}].
+func LoadUsers(fileName string) ([]User, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	users := []User{}
+	err = json.Unmarshal(data, &users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
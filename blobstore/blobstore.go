@@ -0,0 +1,259 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"files_server/commands"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrNotRegular = errors.New("Not a regular file")
+
+type Stats struct {
+	Blobs         int   `json:"blobs"`
+	References    int64 `json:"references"`
+	StoredBytes   int64 `json:"stored_bytes"`
+	LogicalBytes  int64 `json:"logical_bytes"`
+	SavedBytes    int64 `json:"saved_bytes"`
+	OrphanBlobs   int   `json:"orphan_blobs"`
+	OrphanedBytes int64 `json:"orphaned_bytes"`
+}
+
+type GCResult struct {
+	Removed    int   `json:"removed"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// Store keeps file contents once, named by sha256, and the visible files
+// are hard links to the blobs. The link count of a blob minus one is the
+// number of references to it, so the filesystem does the reference
+// counting. Files in the tree must be replaced by a rename and never
+// written in place. The store has to be on the same filesystem as the tree
+// it serves.
+//
+// Hard links share mode, owner and mtime, so a blob is named by those as
+// well and only files that have the same ones share it. Putting a file in
+// the store never changes what stat shows for it.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+	// inodes maps the inodes of the blobs to their names.
+	inodes map[uint64]string
+}
+
+func Open(dir string) (*Store, error) {
+	if !supported {
+		return nil, errors.New("Deduplication needs hard links and inodes")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{dir: dir, inodes: map[uint64]string{}}
+	err = store.walk(func(name string, info os.FileInfo) error {
+		store.inodes[inode(info)] = name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Put turns the regular file at fileName into a reference to the blob with
+// its content and attributes, creating the blob if it is new. It returns
+// the sha256 of the content.
+func (store *Store) Put(fileName string) (string, error) {
+	info, err := os.Lstat(fileName)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", ErrNotRegular
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if name, ok := store.inodes[inode(info)]; ok {
+		return name[:sha256.Size*2], nil
+	}
+	sum, err := hashFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	name := sum + "-" + attributes(info)
+	blob := store.blobName(name)
+
+	blobInfo, err := os.Lstat(blob)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(blob), 0700)
+		if err != nil {
+			return "", err
+		}
+		err = os.Link(fileName, blob)
+		if err != nil {
+			return "", err
+		}
+		store.inodes[inode(info)] = name
+		return sum, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if attributes(blobInfo) != attributes(info) {
+		// Changed behind the store's back, so it isn't a match anymore.
+		return sum, nil
+	}
+
+	tmp := filepath.Join(filepath.Dir(fileName), commands.TempBlob+sum[:16])
+	os.Remove(tmp)
+	err = os.Link(blob, tmp)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmp, fileName)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return sum, nil
+}
+
+// References returns the blobs referenced by the files at or below path.
+// Call it before removing path and pass the result to Release afterwards.
+func (store *Store) References(path string) []string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	names := []string{}
+	filepath.WalkDir(path, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if name, ok := store.inodes[inode(info)]; ok {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names
+}
+
+// Release removes the blobs among names that have no references left.
+func (store *Store) Release(names []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, name := range names {
+		info, err := os.Lstat(store.blobName(name))
+		if err != nil {
+			continue
+		}
+		if links(info) > 1 {
+			continue
+		}
+		err = store.remove(name, info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GC removes all blobs without references, e.g. after files were removed
+// behind the server's back.
+func (store *Store) GC() (GCResult, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	result := GCResult{}
+	err := store.walk(func(name string, info os.FileInfo) error {
+		if links(info) > 1 {
+			return nil
+		}
+		err := store.remove(name, info)
+		if err != nil {
+			return err
+		}
+		result.Removed++
+		result.FreedBytes += info.Size()
+		return nil
+	})
+	return result, err
+}
+
+func (store *Store) Stats() (Stats, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stats := Stats{}
+	err := store.walk(func(name string, info os.FileInfo) error {
+		stats.Blobs++
+		refs := int64(links(info)) - 1
+		if refs <= 0 {
+			stats.OrphanBlobs++
+			stats.OrphanedBytes += info.Size()
+			return nil
+		}
+		stats.References += refs
+		stats.StoredBytes += info.Size()
+		stats.LogicalBytes += refs * info.Size()
+		return nil
+	})
+	stats.SavedBytes = stats.LogicalBytes - stats.StoredBytes
+	return stats, err
+}
+
+func (store *Store) remove(name string, info os.FileInfo) error {
+	err := os.Remove(store.blobName(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(store.inodes, inode(info))
+	return nil
+}
+
+func (store *Store) walk(fn func(name string, info os.FileInfo) error) error {
+	return filepath.WalkDir(store.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		return fn(d.Name(), info)
+	})
+}
+
+// blobName is the path of the blob name, which starts with its sha256.
+func (store *Store) blobName(name string) string {
+	return filepath.Join(store.dir, name[:2], name)
+}
+
+func hashFile(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,118 @@
+package blobstore_test
+
+import (
+	"files_server/blobstore"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	root := t.TempDir()
+	store, err := blobstore.Open(filepath.Join(root, ".blobs"))
+	require.NoError(t, err)
+
+	tree := filepath.Join(root, "tree")
+	require.NoError(t, os.Mkdir(tree, 0777))
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		require.NoError(t, os.WriteFile(filepath.Join(tree, name), []byte("same content"), 0666))
+		require.NoError(t, os.Chtimes(filepath.Join(tree, name), mtime, mtime))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(tree, "other.bin"), []byte("other"), 0666))
+
+	sums := map[string]bool{}
+	for _, name := range []string{"a.bin", "b.bin", "c.bin", "other.bin"} {
+		sum, err := store.Put(filepath.Join(tree, name))
+		require.NoError(t, err)
+		sums[sum] = true
+	}
+	require.Len(t, sums, 2)
+
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, blobstore.Stats{
+		Blobs:        2,
+		References:   4,
+		StoredBytes:  12 + 5,
+		LogicalBytes: 3*12 + 5,
+		SavedBytes:   2 * 12,
+	}, stats)
+
+	b, err := os.ReadFile(filepath.Join(tree, "b.bin"))
+	require.NoError(t, err)
+	require.Equal(t, "same content", string(b))
+
+	refs := store.References(filepath.Join(tree, "a.bin"))
+	require.Len(t, refs, 1)
+	require.NoError(t, os.Remove(filepath.Join(tree, "a.bin")))
+	require.NoError(t, store.Release(refs))
+	stats, err = store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Blobs)
+	require.Equal(t, int64(3), stats.References)
+
+	refs = store.References(tree)
+	require.Len(t, refs, 3)
+	require.NoError(t, os.Remove(filepath.Join(tree, "b.bin")))
+	require.NoError(t, os.Remove(filepath.Join(tree, "c.bin")))
+	require.NoError(t, store.Release(refs))
+	stats, err = store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Blobs)
+
+	require.NoError(t, os.Remove(filepath.Join(tree, "other.bin")))
+	stats, err = store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.OrphanBlobs)
+
+	result, err := store.GC()
+	require.NoError(t, err)
+	require.Equal(t, blobstore.GCResult{Removed: 1, FreedBytes: 5}, result)
+
+	store, err = blobstore.Open(filepath.Join(root, ".blobs"))
+	require.NoError(t, err)
+	stats, err = store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, blobstore.Stats{}, stats)
+}
+
+func TestStoreKeepsAttributes(t *testing.T) {
+	root := t.TempDir()
+	store, err := blobstore.Open(filepath.Join(root, ".blobs"))
+	require.NoError(t, err)
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []struct {
+		name  string
+		mode  os.FileMode
+		mtime time.Time
+	}{
+		{name: "a.bin", mode: 0644, mtime: mtime},
+		{name: "b.bin", mode: 0600, mtime: mtime},
+		{name: "c.bin", mode: 0644, mtime: mtime.Add(time.Second)},
+		{name: "d.bin", mode: 0644, mtime: mtime},
+	}
+	for _, file := range files {
+		fileName := filepath.Join(root, file.name)
+		require.NoError(t, os.WriteFile(fileName, []byte("same content"), 0666))
+		require.NoError(t, os.Chmod(fileName, file.mode))
+		require.NoError(t, os.Chtimes(fileName, file.mtime, file.mtime))
+		_, err = store.Put(fileName)
+		require.NoError(t, err)
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(root, file.name))
+		require.NoError(t, err)
+		require.Equal(t, file.mode, info.Mode().Perm(), file.name)
+		require.True(t, file.mtime.Equal(info.ModTime()), file.name)
+	}
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Blobs, "only a.bin and d.bin share one")
+	require.Equal(t, int64(4), stats.References)
+}
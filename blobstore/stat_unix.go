@@ -0,0 +1,35 @@
+//go:build !windows
+
+package blobstore
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const supported = true
+
+func inode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
+
+func links(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// attributes are what hard links to an inode can't have different: mode,
+// owner and mtime.
+func attributes(info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Sprintf("%o-%d", info.Mode().Perm(), info.ModTime().UnixNano())
+	}
+	return fmt.Sprintf("%o-%d-%d-%d", st.Mode&07777, st.Uid, st.Gid, info.ModTime().UnixNano())
+}
@@ -0,0 +1,17 @@
+package blobstore
+
+import "os"
+
+const supported = false
+
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
+
+func links(info os.FileInfo) uint64 {
+	return 1
+}
+
+func attributes(info os.FileInfo) string {
+	return ""
+}
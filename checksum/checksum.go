@@ -0,0 +1,137 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zeebo/blake3"
+)
+
+const (
+	SHA256 = "sha256"
+	SHA1   = "sha1"
+	MD5    = "md5"
+	BLAKE3 = "blake3"
+)
+
+func New(algo string) (hash.Hash, error) {
+	switch algo {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case MD5:
+		return md5.New(), nil
+	case BLAKE3:
+		return blake3.New(), nil
+	}
+	return nil, fmt.Errorf("Unknown algorithm %q", algo)
+}
+
+type key struct {
+	dev   uint64
+	ino   uint64
+	size  int64
+	mtime time.Time
+}
+
+// Cache remembers file hashes by inode, size and mtime, so a file that was
+// renamed keeps its hashes and one that was changed loses them.
+type Cache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[key]map[string]string
+}
+
+func NewCache(maxEntries int) *Cache {
+	return &Cache{maxEntries: maxEntries, entries: map[key]map[string]string{}}
+}
+
+// Sum returns the hex hash of a regular file, from the cache if possible.
+func (cache *Cache) Sum(fileName, algo string) (string, error) {
+	h, err := New(algo)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", fileName)
+	}
+
+	k := fileKey(info)
+	if sum, ok := cache.get(k, algo); ok {
+		return sum, nil
+	}
+
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	after, err := file.Stat()
+	if err == nil && fileKey(after) == k {
+		cache.put(k, algo, sum)
+	}
+	return sum, nil
+}
+
+// Cached returns the hashes already known for info without reading the file.
+func (cache *Cache) Cached(info os.FileInfo) map[string]string {
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	sums, ok := cache.entries[fileKey(info)]
+	if !ok {
+		return nil
+	}
+	res := map[string]string{}
+	for algo, sum := range sums {
+		res[algo] = sum
+	}
+	return res
+}
+
+func (cache *Cache) get(k key, algo string) (string, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	sum, ok := cache.entries[k][algo]
+	return sum, ok
+}
+
+func (cache *Cache) put(k key, algo, sum string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	sums, ok := cache.entries[k]
+	if !ok {
+		if cache.maxEntries > 0 && len(cache.entries) >= cache.maxEntries {
+			for old := range cache.entries {
+				delete(cache.entries, old)
+				break
+			}
+		}
+		sums = map[string]string{}
+		cache.entries[k] = sums
+	}
+	sums[algo] = sum
+}
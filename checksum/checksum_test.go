@@ -0,0 +1,73 @@
+package checksum_test
+
+import (
+	"files_server/checksum"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSum(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("abc"), 0666))
+	cache := checksum.NewCache(0)
+
+	testCases := []struct {
+		algo            string
+		expected_result string
+	}{
+		{algo: checksum.SHA256, expected_result: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{algo: checksum.SHA1, expected_result: "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{algo: checksum.MD5, expected_result: "900150983cd24fb0d6963f7d28e17f72"},
+		{algo: checksum.BLAKE3, expected_result: "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+	}
+	for _, testCase := range testCases {
+		t.Run(
+			testCase.algo, func(t *testing.T) {
+				sum, err := cache.Sum(fileName, testCase.algo)
+				require.NoError(t, err)
+				require.Equal(t, testCase.expected_result, sum)
+			},
+		)
+	}
+
+	_, err := cache.Sum(fileName, "crc32")
+	require.Error(t, err)
+	_, err = cache.Sum(filepath.Dir(fileName), checksum.SHA256)
+	require.Error(t, err)
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("abc"), 0666))
+	cache := checksum.NewCache(0)
+
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.Nil(t, cache.Cached(info))
+
+	sum, err := cache.Sum(fileName, checksum.MD5)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{checksum.MD5: sum}, cache.Cached(info))
+
+	renamed := filepath.Join(dir, "renamed.txt")
+	require.NoError(t, os.Rename(fileName, renamed))
+	info, err = os.Stat(renamed)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{checksum.MD5: sum}, cache.Cached(info))
+
+	require.NoError(t, os.WriteFile(renamed, []byte("abd"), 0666))
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(renamed, later, later))
+	info, err = os.Stat(renamed)
+	require.NoError(t, err)
+	require.Nil(t, cache.Cached(info))
+
+	newSum, err := cache.Sum(renamed, checksum.MD5)
+	require.NoError(t, err)
+	require.NotEqual(t, sum, newSum)
+}
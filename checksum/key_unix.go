@@ -0,0 +1,17 @@
+//go:build !windows
+
+package checksum
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileKey(info os.FileInfo) key {
+	k := key{size: info.Size(), mtime: info.ModTime()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		k.dev = uint64(st.Dev)
+		k.ino = st.Ino
+	}
+	return k
+}
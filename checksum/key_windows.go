@@ -0,0 +1,18 @@
+package checksum
+
+import "os"
+
+// There is no inode in os.FileInfo on windows, so only size and mtime are
+// used there together with the name.
+func fileKey(info os.FileInfo) key {
+	return key{size: info.Size(), mtime: info.ModTime(), ino: nameHash(info.Name())}
+}
+
+func nameHash(name string) uint64 {
+	var h uint64 = 14695981039346656037
+	for i := 0; i < len(name); i++ {
+		h ^= uint64(name[i])
+		h *= 1099511628211
+	}
+	return h
+}
@@ -0,0 +1,359 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DefaultChunkSize = 8 << 20
+
+// Error is a response of the server that wasn't a success.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", err.StatusCode, http.StatusText(err.StatusCode), err.Message)
+}
+
+// IsDir tells if err is the server refusing to download a directory.
+func IsDir(err error) bool {
+	serverErr := &Error{}
+	return errors.As(err, &serverErr) && serverErr.StatusCode == http.StatusBadRequest && serverErr.Message == "Is a directory"
+}
+
+// Client talks to a files_server. The server keeps the current directory
+// per token, so Cd affects every later call with the same token.
+type Client struct {
+	BaseURL   string
+	Token     string
+	HTTP      *http.Client
+	ChunkSize int64
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: http.DefaultClient, ChunkSize: DefaultChunkSize}
+}
+
+// Login gets a token from /auth and keeps it in the client. An empty user
+// logs in to a server without a user store.
+func (client *Client) Login(user, password string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, client.BaseURL+"/auth", nil)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := client.send(req)
+	if err != nil {
+		return "", err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return "", err
+	}
+	client.Token = strings.TrimSpace(body)
+	return client.Token, nil
+}
+
+func (client *Client) Pwd() (string, error) {
+	return client.get("/pwd", nil)
+}
+
+func (client *Client) Cd(dir string) error {
+	_, err := client.get("/cd", url.Values{"dir": {dir}})
+	return err
+}
+
+func (client *Client) Ls(showHidden bool) ([]string, error) {
+	names := []string{}
+	err := client.getJSON("/ls", hidden(url.Values{}, showHidden), &names)
+	return names, err
+}
+
+// LsEntries lists the current directory with type, size and mtime.
+func (client *Client) LsEntries(showHidden bool) ([]commands.Entry, error) {
+	entries := []commands.Entry{}
+	err := client.getJSON("/ls", hidden(url.Values{"meta": {"true"}}, showHidden), &entries)
+	return entries, err
+}
+
+func (client *Client) Mkdir(dir string) error {
+	_, err := client.get("/mkdir", url.Values{"dirname": {dir}})
+	return err
+}
+
+func (client *Client) Touch(fileName string) error {
+	_, err := client.get("/touch", url.Values{"filename": {fileName}})
+	return err
+}
+
+func (client *Client) Rm(fileName string) error {
+	_, err := client.get("/rm", url.Values{"filename": {fileName}})
+	return err
+}
+
+func (client *Client) Mv(from, to string, overwrite bool) error {
+	query := url.Values{"from": {from}, "to": {to}}
+	if overwrite {
+		query.Set("overwrite", "true")
+	}
+	_, err := client.get("/mv", query)
+	return err
+}
+
+// Find calls found for every entry below dir, with paths relative to dir.
+func (client *Client) Find(dir string, query url.Values, found func(commands.Match) error) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("dir", dir)
+	resp, err := client.do(http.MethodGet, "/find", query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		match := commands.Match{}
+		err = json.Unmarshal(scanner.Bytes(), &match)
+		if err != nil {
+			return err
+		}
+		err = found(match)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Download writes the content of the remote file to w.
+func (client *Client) Download(remote string, w io.Writer) error {
+	resp, err := client.do(http.MethodGet, "/download", url.Values{"filename": {remote}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// DownloadFile downloads remote into local through a temporary file, so an
+// interrupted download doesn't leave a truncated local file.
+func (client *Client) DownloadFile(remote, local string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(local), ".fsclient-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = client.Download(remote, tmp)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), local)
+}
+
+// DownloadDir downloads everything below the remote dir into local, calling
+// progress with the relative path of every file before it is fetched.
+func (client *Client) DownloadDir(remote, local string, progress func(string)) error {
+	err := os.MkdirAll(local, 0755)
+	if err != nil {
+		return err
+	}
+	return client.Find(remote, url.Values{"hide": {"true"}}, func(match commands.Match) error {
+		target := filepath.Join(local, filepath.FromSlash(match.Path))
+		switch match.Type {
+		case commands.TypeDir:
+			return os.MkdirAll(target, 0755)
+		case commands.TypeFile:
+			if progress != nil {
+				progress(match.Path)
+			}
+			return client.DownloadFile(path.Join(remote, filepath.ToSlash(match.Path)), target)
+		}
+		return nil
+	})
+}
+
+// Upload sends the local file with the resumable upload protocol in chunks
+// of ChunkSize. A failed chunk is retried from the offset the server has,
+// and the server checks the sha256 of the result.
+func (client *Client) Upload(local, remote string) error {
+	file, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"filename": {remote}, "length": {strconv.FormatInt(info.Size(), 10)}}
+	id, err := client.text(http.MethodPost, "/upload", query)
+	if err != nil {
+		return err
+	}
+	id = strings.TrimSpace(id)
+
+	chunkSize := client.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	offset := int64(0)
+	retries := 0
+	for offset < info.Size() {
+		chunk := io.NewSectionReader(file, offset, chunkSize)
+		headers := map[string]string{"Upload-Offset": strconv.FormatInt(offset, 10)}
+		resp, err := client.do(http.MethodPatch, "/upload", url.Values{"id": {id}}, chunk, headers)
+		if err == nil {
+			resp.Body.Close()
+			offset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil {
+				return err
+			}
+			retries = 0
+			continue
+		}
+		serverErr := &Error{}
+		if errors.As(err, &serverErr) && serverErr.StatusCode != http.StatusConflict || retries >= 3 {
+			return err
+		}
+		retries++
+		resp, err = client.do(http.MethodHead, "/upload", url.Values{"id": {id}}, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		offset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	checksum := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	_, err = client.text(http.MethodPost, "/upload/finish", url.Values{"id": {id}, "checksum": {checksum}})
+	return err
+}
+
+// UploadDir uploads everything below local into the remote dir, creating
+// directories as needed.
+func (client *Client) UploadDir(local, remote string, progress func(string)) error {
+	return filepath.WalkDir(local, func(fullPath string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(local, fullPath)
+		if err != nil {
+			return err
+		}
+		target := path.Join(remote, filepath.ToSlash(rel))
+		if d.IsDir() {
+			return client.Mkdir(target)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if progress != nil {
+			progress(filepath.ToSlash(rel))
+		}
+		return client.Upload(fullPath, target)
+	})
+}
+
+func hidden(query url.Values, showHidden bool) url.Values {
+	if showHidden {
+		query.Set("hide", "true")
+	}
+	return query
+}
+
+func (client *Client) get(endpoint string, query url.Values) (string, error) {
+	return client.text(http.MethodGet, endpoint, query)
+}
+
+func (client *Client) getJSON(endpoint string, query url.Values, v interface{}) error {
+	resp, err := client.do(http.MethodGet, endpoint, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (client *Client) text(method, endpoint string, query url.Values) (string, error) {
+	resp, err := client.do(method, endpoint, query, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	return readBody(resp)
+}
+
+func (client *Client) do(method, endpoint string, query url.Values, body io.Reader, headers map[string]string) (*http.Response, error) {
+	return client.doContext(context.Background(), method, endpoint, query, body, headers)
+}
+
+func (client *Client) doContext(ctx context.Context, method, endpoint string, query url.Values, body io.Reader, headers map[string]string) (*http.Response, error) {
+	target := client.BaseURL + endpoint
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if client.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.Token)
+	}
+	return client.send(req)
+}
+
+func (client *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := client.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	return resp, nil
+}
+
+func readBody(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
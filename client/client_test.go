@@ -0,0 +1,140 @@
+package client_test
+
+import (
+	"bytes"
+	"errors"
+	"files_server/auth"
+	"files_server/client"
+	"files_server/dir"
+	"files_server/upload"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func initTestServer(t *testing.T, root string) *httptest.Server {
+	uploads, err := upload.NewStore(t.TempDir(), 0, nil)
+	require.NoError(t, err)
+	config := &dir.Config{Root: root, Uploads: uploads}
+	authStorage := auth.NewWithConfig(auth.Config{
+		Users:  []auth.User{{Name: "alice", Password: "secret"}},
+		Limits: auth.Limits{},
+		NewDir: func() *dir.Dir { return dir.NewWithConfig(config) },
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/", authStorage.Commands())
+	mux.Handle("/auth", authStorage)
+	testServer := httptest.NewServer(mux)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func TestClient(t *testing.T) {
+	root := t.TempDir()
+	testServer := initTestServer(t, root)
+	c := client.New(testServer.URL)
+	c.ChunkSize = 3
+
+	_, err := c.Login("alice", "wrong")
+	serverErr := &client.Error{}
+	require.True(t, errors.As(err, &serverErr))
+	require.Equal(t, http.StatusUnauthorized, serverErr.StatusCode)
+	_, err = c.Login("alice", "secret")
+	require.NoError(t, err)
+
+	require.NoError(t, c.Mkdir("docs"))
+	require.NoError(t, c.Cd("docs"))
+	pwd, err := c.Pwd()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "docs"), pwd)
+	require.NoError(t, c.Touch("empty.txt"))
+	require.NoError(t, c.Touch(".hidden"))
+
+	names, err := c.Ls(false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"empty.txt"}, names)
+	entries, err := c.LsEntries(true)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	local := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "src", "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "src", "a.txt"), []byte("hello world"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "src", "sub", "b.txt"), []byte(""), 0644))
+	uploaded := []string{}
+	require.NoError(t, c.UploadDir(filepath.Join(local, "src"), "copy", func(rel string) {
+		uploaded = append(uploaded, rel)
+	}))
+	require.Equal(t, []string{"a.txt", "sub/b.txt"}, uploaded)
+	content, err := os.ReadFile(filepath.Join(root, "docs", "copy", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(content))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.Download("copy/a.txt", buf))
+	require.Equal(t, "hello world", buf.String())
+	err = c.DownloadFile("copy", filepath.Join(local, "copy"))
+	require.True(t, client.IsDir(err))
+	require.NoError(t, c.DownloadDir("copy", filepath.Join(local, "back"), nil))
+	content, err = os.ReadFile(filepath.Join(local, "back", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(content))
+	_, err = os.Stat(filepath.Join(local, "back", "sub", "b.txt"))
+	require.NoError(t, err)
+
+	require.NoError(t, c.Mv("empty.txt", "copy/a.txt", true))
+	require.NoError(t, c.Rm("copy"))
+	names, err = c.Ls(false)
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+func TestSync(t *testing.T) {
+	root := t.TempDir()
+	testServer := initTestServer(t, root)
+	c := client.New(testServer.URL)
+	_, err := c.Login("alice", "secret")
+	require.NoError(t, err)
+
+	local := t.TempDir()
+	large := bytes.Repeat([]byte("0123456789abcdef"), 8192)
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "large.bin"), large, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "sub", "small.txt"), []byte("small"), 0644))
+
+	sent := []string{}
+	options := client.SyncOptions{DeltaMinSize: 1024, Progress: func(name string) { sent = append(sent, name) }}
+	diff, err := c.Sync(local, "mirror", options)
+	require.NoError(t, err)
+	require.Len(t, diff.Missing, 3)
+	require.Equal(t, []string{"large.bin", "sub/small.txt"}, sent)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "mirror", "extra"), 0755))
+	changed := append([]byte("changed"), large...)
+	require.NoError(t, os.WriteFile(filepath.Join(local, "large.bin"), changed, 0644))
+	sent = []string{}
+	diff, err = c.Sync(local, "mirror", options)
+	require.NoError(t, err)
+	require.Len(t, diff.Changed, 1)
+	require.Len(t, diff.Extra, 1)
+	require.Equal(t, []string{"large.bin"}, sent)
+	require.DirExists(t, filepath.Join(root, "mirror", "extra"))
+	content, err := os.ReadFile(filepath.Join(root, "mirror", "large.bin"))
+	require.NoError(t, err)
+	require.Equal(t, changed, content)
+
+	options.Delete = true
+	diff, err = c.Sync(local, "mirror", options)
+	require.NoError(t, err)
+	require.Empty(t, diff.Missing)
+	require.Empty(t, diff.Changed)
+	require.NoDirExists(t, filepath.Join(root, "mirror", "extra"))
+
+	diff, err = c.Sync(local, "mirror", options)
+	require.NoError(t, err)
+	require.True(t, diff.Empty())
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"files_server/dirsync"
+	"files_server/replica"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ReplicaStatus is the range of the server's replication log.
+func (client *Client) ReplicaStatus() (replica.Page, error) {
+	page := replica.Page{}
+	err := client.getJSON("/replica/log", nil, &page)
+	return page, err
+}
+
+// ReplicaLog gets up to limit entries after since, waiting up to wait for
+// one. It fails with replica.ErrTruncated when the log doesn't have them
+// anymore.
+func (client *Client) ReplicaLog(ctx context.Context, since uint64, limit int, wait time.Duration) (replica.Page, error) {
+	query := url.Values{"since": {strconv.FormatUint(since, 10)}, "limit": {strconv.Itoa(limit)}}
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+	page := replica.Page{}
+	resp, err := client.doContext(ctx, http.MethodGet, "/replica/log", query, nil, nil)
+	serverErr := &Error{}
+	if errors.As(err, &serverErr) && serverErr.StatusCode == http.StatusGone {
+		return page, replica.ErrTruncated
+	}
+	if err != nil {
+		return page, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	return page, err
+}
+
+// ReplicaFile opens the file name below the server's root. It fails with
+// os.ErrNotExist when there is none and replica.ErrIsDir for a directory.
+func (client *Client) ReplicaFile(name string) (io.ReadCloser, dirsync.File, error) {
+	file := dirsync.File{Path: name}
+	resp, err := client.do(http.MethodGet, "/replica/file", url.Values{"path": {name}}, nil, nil)
+	switch {
+	case IsDir(err):
+		return nil, file, replica.ErrIsDir
+	case notFound(err):
+		return nil, file, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	case err != nil:
+		return nil, file, err
+	}
+	file.Size = resp.ContentLength
+	mode, err := strconv.ParseUint(resp.Header.Get("X-Mode"), 8, 32)
+	if err == nil {
+		file.Mode = os.FileMode(mode).Perm()
+	}
+	file.ModTime, _ = time.Parse(time.RFC3339Nano, resp.Header.Get("X-Mtime"))
+	return resp.Body, file, nil
+}
+
+// ReplicaManifest is the manifest of the directory name below the
+// server's root, with sha256 hashes.
+func (client *Client) ReplicaManifest(name string) (dirsync.Manifest, error) {
+	manifest := dirsync.Manifest{}
+	err := client.getJSON("/replica/manifest", url.Values{"path": {name}}, &manifest)
+	if notFound(err) {
+		return manifest, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return manifest, err
+}
+
+func notFound(err error) bool {
+	serverErr := &Error{}
+	return errors.As(err, &serverErr) && serverErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"files_server/checksum"
+	"files_server/delta"
+	"files_server/dirsync"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultDeltaMinSize is the size from which Sync sends changed files as
+// deltas.
+const DefaultDeltaMinSize = 1 << 20
+
+type SyncOptions struct {
+	// Delete removes what the remote directory has and local doesn't.
+	Delete bool
+	// DeltaMinSize is the size from which changed files are sent as a
+	// delta, DefaultDeltaMinSize when 0.
+	DeltaMinSize int64
+	Progress     func(name string)
+}
+
+// Sync makes the remote directory like local, sending only the files that
+// are missing or changed, and of large changed files only the blocks the
+// server doesn't have. It returns what differed.
+func (client *Client) Sync(local, remote string, options SyncOptions) (dirsync.Diff, error) {
+	manifest, err := dirsync.Scan(context.Background(), local, dirsync.Options{Algo: checksum.SHA256, Sum: hashFile})
+	if err != nil {
+		return dirsync.Diff{}, err
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return dirsync.Diff{}, err
+	}
+	err = client.Mkdir(remote)
+	if err != nil {
+		return dirsync.Diff{}, err
+	}
+
+	diff := dirsync.Diff{}
+	err = client.postJSON("/sync/diff", url.Values{"dir": {remote}}, body, &diff)
+	if err != nil {
+		return diff, err
+	}
+	query := url.Values{"dir": {remote}}
+	if options.Delete {
+		query.Set("delete", "true")
+	}
+	left := dirsync.Diff{}
+	err = client.postJSON("/sync/apply", query, body, &left)
+	if err != nil {
+		return diff, err
+	}
+
+	deltaMinSize := options.DeltaMinSize
+	if deltaMinSize <= 0 {
+		deltaMinSize = DefaultDeltaMinSize
+	}
+	for i, file := range append(left.Missing, left.Changed...) {
+		if options.Progress != nil {
+			options.Progress(file.Path)
+		}
+		localName := filepath.Join(local, filepath.FromSlash(file.Path))
+		if i >= len(left.Missing) && file.Size >= deltaMinSize {
+			err = client.sendDelta(localName, remote, file)
+			if err == nil {
+				continue
+			}
+			// The server's copy changed since its signature, send it all.
+			serverErr := &Error{}
+			if !errors.As(err, &serverErr) || serverErr.StatusCode != http.StatusConflict {
+				return diff, err
+			}
+		}
+		err = client.sendFile(localName, remote, file)
+		if err != nil {
+			return diff, err
+		}
+	}
+	return diff, nil
+}
+
+func hashFile(fileName string) (string, error) {
+	hash, err := checksum.New(checksum.SHA256)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func syncQuery(remote string, file dirsync.File) url.Values {
+	return url.Values{
+		"dir":   {remote},
+		"path":  {file.Path},
+		"mtime": {file.ModTime.Format(time.RFC3339Nano)},
+		"mode":  {fmt.Sprintf("%o", file.Mode.Perm())},
+	}
+}
+
+func (client *Client) sendFile(localName, remote string, file dirsync.File) error {
+	content, err := os.Open(localName)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	resp, err := client.do(http.MethodPut, "/sync/file", syncQuery(remote, file), content, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// sendDelta sends file as a delta against the signature of the server's
+// copy, streaming it as it is made.
+func (client *Client) sendDelta(localName, remote string, file dirsync.File) error {
+	signature := delta.Signature{}
+	err := client.getJSON("/sync/signature", url.Values{"dir": {remote}, "path": {file.Path}}, &signature)
+	if err != nil {
+		return err
+	}
+	content, err := os.Open(localName)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(delta.Write(writer, signature, content))
+	}()
+	resp, err := client.do(http.MethodPut, "/sync/delta", syncQuery(remote, file), reader, nil)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (client *Client) postJSON(endpoint string, query url.Values, body []byte, v interface{}) error {
+	resp, err := client.do(http.MethodPost, endpoint, query, bytes.NewReader(body), map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
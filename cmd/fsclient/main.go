@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"files_server/client"
+	"files_server/replica"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: fsclient [flags] command [args]
+
+Commands:
+  login [-user name] [-password pw]  get a token, the password is read from
+                                     FSCLIENT_PASSWORD or stdin when missing
+  logout                             forget the token
+  pwd
+  cd dir
+  ls [-a] [-l]
+  mkdir dir
+  touch file
+  rm file
+  mv from to [-f]
+  upload local [remote]              files and directories, recursively
+  download remote [local]            files and directories, recursively
+  sync [-delete] local remote        send only what changed of a directory,
+                                     -delete removes what local doesn't have
+  replica-check [-user name] url     compare the whole tree with the follower
+                                     at url as an admin on both, the password
+                                     for url is read from FSCLIENT_PASSWORD
+
+Flags:
+`
+
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+type app struct {
+	configFile string
+	config     config
+	client     *client.Client
+	json       bool
+	out        io.Writer
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsclient:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("fsclient", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", defaultConfigFile(), "file the server and token are kept in")
+	server := flags.String("server", "", "server URL, remembered by login")
+	asJSON := flags.Bool("json", false, "print JSON instead of tables")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("No command")
+	}
+
+	a := &app{configFile: *configFile, json: *asJSON, out: out}
+	err = a.loadConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		a.config.Server = *server
+	}
+	if a.config.Server == "" {
+		a.config.Server = "http://localhost:8080"
+	}
+	a.client = client.New(a.config.Server)
+	a.client.Token = a.config.Token
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "login":
+		return a.login(args)
+	case "logout":
+		a.config.Token = ""
+		return a.saveConfig()
+	case "pwd":
+		return a.pwd()
+	case "cd":
+		return a.withArgs(args, 1, func() error { return a.client.Cd(args[0]) })
+	case "ls":
+		return a.ls(args)
+	case "mkdir":
+		return a.withArgs(args, 1, func() error { return a.client.Mkdir(args[0]) })
+	case "touch":
+		return a.withArgs(args, 1, func() error { return a.client.Touch(args[0]) })
+	case "rm":
+		return a.withArgs(args, 1, func() error { return a.client.Rm(args[0]) })
+	case "mv":
+		return a.mv(args)
+	case "upload":
+		return a.upload(args)
+	case "download":
+		return a.download(args)
+	case "sync":
+		return a.sync(args)
+	case "replica-check":
+		return a.replicaCheck(args)
+	}
+	flags.Usage()
+	return fmt.Errorf("Unknown command %q", command)
+}
+
+func (a *app) withArgs(args []string, n int, fn func() error) error {
+	if len(args) != n {
+		return fmt.Errorf("Expected %d arguments, got %d", n, len(args))
+	}
+	return fn()
+}
+
+func (a *app) login(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	user := flags.String("user", "", "user name, empty for a server without users")
+	password := flags.String("password", "", "password")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *user != "" && *password == "" {
+		*password = os.Getenv("FSCLIENT_PASSWORD")
+	}
+	if *user != "" && *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	token, err := a.client.Login(*user, *password)
+	if err != nil {
+		return err
+	}
+	a.config.Token = token
+	return a.saveConfig()
+}
+
+func (a *app) pwd() error {
+	dir, err := a.client.Pwd()
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(dir)
+	}
+	fmt.Fprintln(a.out, dir)
+	return nil
+}
+
+func (a *app) ls(args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	all := flags.Bool("a", false, "show hidden files")
+	long := flags.Bool("l", false, "show type, size and modification time")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if !*long {
+		names, err := a.client.Ls(*all)
+		if err != nil {
+			return err
+		}
+		if a.json {
+			return a.printJSON(names)
+		}
+		for _, name := range names {
+			fmt.Fprintln(a.out, name)
+		}
+		return nil
+	}
+
+	entries, err := a.client.LsEntries(*all)
+	if err != nil {
+		return err
+	}
+	if a.json {
+		return a.printJSON(entries)
+	}
+	table := tabwriter.NewWriter(a.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TYPE\tSIZE\tMODIFIED\tNAME")
+	for _, entry := range entries {
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\n", entry.Type, entry.Size, entry.ModTime.Local().Format(time.DateTime), entry.Name)
+	}
+	return table.Flush()
+}
+
+func (a *app) mv(args []string) error {
+	flags := flag.NewFlagSet("mv", flag.ContinueOnError)
+	force := flags.Bool("f", false, "overwrite the target")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	return a.withArgs(flags.Args(), 2, func() error {
+		return a.client.Mv(flags.Arg(0), flags.Arg(1), *force)
+	})
+}
+
+func (a *app) upload(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("Expected local [remote]")
+	}
+	local := args[0]
+	remote := filepath.Base(local)
+	if len(args) == 2 {
+		remote = args[1]
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		a.progress(remote)
+		return a.client.Upload(local, remote)
+	}
+	return a.client.UploadDir(local, remote, func(rel string) {
+		a.progress(path.Join(remote, rel))
+	})
+}
+
+func (a *app) download(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("Expected remote [local]")
+	}
+	remote := args[0]
+	local := path.Base(remote)
+	if len(args) == 2 {
+		local = args[1]
+	}
+
+	a.progress(local)
+	err := a.client.DownloadFile(remote, local)
+	if !client.IsDir(err) {
+		return err
+	}
+	return a.client.DownloadDir(remote, local, func(rel string) {
+		a.progress(filepath.Join(local, rel))
+	})
+}
+
+func (a *app) sync(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	remove := flags.Bool("delete", false, "remove what the local directory doesn't have")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	return a.withArgs(flags.Args(), 2, func() error {
+		remote := flags.Arg(1)
+		diff, err := a.client.Sync(flags.Arg(0), remote, client.SyncOptions{Delete: *remove, Progress: func(rel string) {
+			a.progress(path.Join(remote, rel))
+		}})
+		if err != nil {
+			return err
+		}
+		if a.json {
+			return a.printJSON(diff)
+		}
+		fmt.Fprintf(a.out, "%d missing, %d changed, %d extra\n", len(diff.Missing), len(diff.Changed), len(diff.Extra))
+		return nil
+	})
+}
+
+func (a *app) replicaCheck(args []string) error {
+	flags := flag.NewFlagSet("replica-check", flag.ContinueOnError)
+	user := flags.String("user", "", "user on the follower, empty for a server without users")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	return a.withArgs(flags.Args(), 1, func() error {
+		follower := client.New(flags.Arg(0))
+		_, err := follower.Login(*user, os.Getenv("FSCLIENT_PASSWORD"))
+		if err != nil {
+			return err
+		}
+		diff, err := replica.Check(a.client, follower)
+		if err != nil {
+			return err
+		}
+		if a.json {
+			err = a.printJSON(diff)
+		} else {
+			for _, file := range diff.Missing {
+				fmt.Fprintln(a.out, "missing", file.Path)
+			}
+			for _, file := range diff.Changed {
+				fmt.Fprintln(a.out, "changed", file.Path)
+			}
+			for _, file := range diff.Extra {
+				fmt.Fprintln(a.out, "extra  ", file.Path)
+			}
+		}
+		if err == nil && !diff.Empty() {
+			err = errors.New("Follower differs")
+		}
+		return err
+	})
+}
+
+func (a *app) progress(name string) {
+	if !a.json {
+		fmt.Fprintln(os.Stderr, name)
+	}
+}
+
+func (a *app) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(a.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".fsclient.json"
+	}
+	return filepath.Join(dir, "fsclient", "config.json")
+}
+
+func (a *app) loadConfig() error {
+	data, err := os.ReadFile(a.configFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &a.config)
+}
+
+// saveConfig writes the config only readable by the user, it holds a token.
+func (a *app) saveConfig() error {
+	err := os.MkdirAll(filepath.Dir(a.configFile), 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(a.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.configFile, data, 0600)
+}
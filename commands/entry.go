@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeFile    = "file"
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+	TypeOther   = "other"
+)
+
+type Entry struct {
+	Name    string            `json:"name"`
+	Path    string            `json:"path,omitempty"`
+	Type    string            `json:"type"`
+	Size    int64             `json:"size"`
+	ModTime time.Time         `json:"mtime"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+	Mode    os.FileMode       `json:"-"`
+	// Target is where a symlink points, Broken that nothing is there.
+	Target string `json:"target,omitempty"`
+	Broken bool   `json:"broken,omitempty"`
+	// Preview is the kind of /preview there is for the file, if any.
+	Preview string `json:"preview,omitempty"`
+}
+
+func NewEntry(name string, info os.FileInfo) Entry {
+	return Entry{
+		Name:    name,
+		Type:    FileType(info.Mode()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+	}
+}
+
+// LinkTarget reads the symlink fileName. A link that can't be read is
+// broken as well.
+func LinkTarget(fileName string) (target string, broken bool) {
+	target, err := os.Readlink(fileName)
+	if err != nil {
+		return "", true
+	}
+	_, err = os.Stat(fileName)
+	return target, err != nil
+}
+
+func FileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return TypeDir
+	case mode&os.ModeSymlink != 0:
+		return TypeSymlink
+	case mode.IsRegular():
+		return TypeFile
+	}
+	return TypeOther
+}
+
+func IsHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// Temporary files are made next to their target under one of these
+// prefixes, so they can be renamed into place.
+const (
+	TempWrite   = ".write-"
+	TempBatch   = ".batch-"
+	TempAttr    = ".attr-"
+	TempUpload  = ".upload-"
+	TempShare   = ".share-"
+	TempRestore = ".restore-"
+	TempBlob    = ".blob-"
+)
+
+var tempPrefixes = []string{TempWrite, TempBatch, TempAttr, TempUpload, TempShare, TempRestore, TempBlob}
+
+// IsTemp reports whether name is a temporary file that is still being
+// written.
+func IsTemp(name string) bool {
+	for _, prefix := range tempPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HumanSize formats size like ls -lh: bytes below 1K, one decimal below 10
+// of a unit and whole units above.
+func HumanSize(size int64) string {
+	if size < 1024 {
+		return strconv.FormatInt(size, 10)
+	}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(sizeUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	if rounded := math.Ceil(value*10) / 10; rounded < 10 {
+		return strconv.FormatFloat(rounded, 'f', 1, 64) + sizeUnits[unit]
+	}
+	return strconv.FormatFloat(math.Ceil(value), 'f', 0, 64) + sizeUnits[unit]
+}
+
+var sizeUnits = []string{"", "K", "M", "G", "T", "P", "E"}
+
+// LsTime is the time column of ls -l, with the year instead of the time
+// for entries older than half a year.
+func LsTime(t, now time.Time) string {
+	if now.Sub(t) > 182*24*time.Hour || t.After(now.Add(time.Hour)) {
+		return t.Format("Jan _2  2006")
+	}
+	return t.Format("Jan _2 15:04")
+}
@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+type FindOptions struct {
+	Name       string
+	Regexp     *regexp.Regexp
+	Type       string
+	MinSize    int64
+	MaxSize    int64
+	NewerThan  time.Time
+	OlderThan  time.Time
+	MaxDepth   int
+	ShowHidden bool
+	Grep       *regexp.Regexp
+	// Errors is called for entries that can't be read instead of skipping
+	// them silently.
+	Errors func(relPath string, err error)
+}
+
+// SkipDir can be returned by the callback of Find to not descend into the
+// directory it was called for.
+var SkipDir = errors.New("skip this directory")
+
+// NewFindOptions returns options that match everything: no size limits and
+// no depth limit.
+func NewFindOptions() FindOptions {
+	return FindOptions{MaxSize: -1}
+}
+
+type Line struct {
+	Number int    `json:"line"`
+	Text   string `json:"text"`
+}
+
+type Match struct {
+	Entry
+	Lines []Line `json:"lines,omitempty"`
+}
+
+// Find walks root directory by directory with Ls, so hidden entries and the
+// order of the results are the same as in /ls. Paths in matches are relative
+// to root. Subdirectories that can't be read are skipped.
+func Find(root string, options FindOptions, found func(Match) error) error {
+	names, err := Ls(root, options.ShowHidden)
+	if err != nil {
+		return err
+	}
+	return find(root, "", names, 1, options, found)
+}
+
+func find(root, rel string, names []string, depth int, options FindOptions, found func(Match) error) error {
+	for _, name := range names {
+		relPath := filepath.Join(rel, name)
+		fullPath := filepath.Join(root, relPath)
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			options.error(relPath, err)
+			continue
+		}
+
+		entry := NewEntry(name, info)
+		entry.Path = relPath
+		if entry.Type == TypeSymlink {
+			entry.Target, entry.Broken = LinkTarget(fullPath)
+		}
+		match, ok := options.Check(fullPath, entry)
+		if ok {
+			err = found(match)
+			if err == SkipDir {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if !info.IsDir() || (options.MaxDepth > 0 && depth >= options.MaxDepth) {
+			continue
+		}
+		children, err := Ls(fullPath, options.ShowHidden)
+		if err != nil {
+			options.error(relPath, err)
+			continue
+		}
+		err = find(root, relPath, children, depth+1, options, found)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (options FindOptions) error(relPath string, err error) {
+	if options.Errors != nil {
+		options.Errors(relPath, err)
+	}
+}
+
+// Check applies all options to entry, reading the file at fullPath when
+// there is a content pattern.
+func (options FindOptions) Check(fullPath string, entry Entry) (Match, bool) {
+	match := Match{Entry: entry}
+	if !options.MatchEntry(entry) {
+		return match, false
+	}
+	if options.Grep == nil {
+		return match, true
+	}
+	if entry.Type != TypeFile {
+		return match, false
+	}
+	lines, err := Grep(fullPath, options.Grep)
+	if err != nil || len(lines) == 0 {
+		return match, false
+	}
+	match.Lines = lines
+	return match, true
+}
+
+// MatchEntry checks everything except the content pattern.
+func (options FindOptions) MatchEntry(entry Entry) bool {
+	if options.Name != "" {
+		ok, err := filepath.Match(options.Name, entry.Name)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	if options.Regexp != nil && !options.Regexp.MatchString(entry.Name) {
+		return false
+	}
+	if options.Type != "" && options.Type != entry.Type {
+		return false
+	}
+	if entry.Size < options.MinSize {
+		return false
+	}
+	if options.MaxSize >= 0 && entry.Size > options.MaxSize {
+		return false
+	}
+	if !options.NewerThan.IsZero() && !entry.ModTime.After(options.NewerThan) {
+		return false
+	}
+	if !options.OlderThan.IsZero() && !entry.ModTime.Before(options.OlderThan) {
+		return false
+	}
+	return true
+}
+
+const sniffLen = 512
+
+// Grep returns the lines of a text file that match pattern. Binary files,
+// detected by a NUL byte in the first bytes, have no matching lines.
+func Grep(fileName string, pattern *regexp.Regexp) ([]Line, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	head, err := reader.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	lines := []Line{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		if pattern.Match(scanner.Bytes()) {
+			lines = append(lines, Line{Number: number, Text: scanner.Text()})
+		}
+	}
+	return lines, scanner.Err()
+}
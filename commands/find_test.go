@@ -0,0 +1,133 @@
+package commands_test
+
+import (
+	"files_server/commands"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func prepareFindDir(t *testing.T) string {
+	dir, err := os.MkdirTemp(os.TempDir(), "example")
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src", "pkg"), 0777))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".git"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("# readme\nhello world\n"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n\nfunc main() {\n\thello()\n}\n"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "pkg", "big.bin"), make([]byte, 2048), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "config"), []byte("hello"), 0666))
+
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "README.md"), old, old))
+	return dir
+}
+
+func TestFind(t *testing.T) {
+	dir := prepareFindDir(t)
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name            string
+		options         func() commands.FindOptions
+		expected_result []string
+	}{
+		{
+			name:            "All",
+			options:         commands.NewFindOptions,
+			expected_result: []string{"src", "src/pkg", "src/pkg/big.bin", "src/main.go", "README.md"},
+		},
+		{
+			name: "Hidden",
+			options: func() commands.FindOptions {
+				options := commands.NewFindOptions()
+				options.ShowHidden = true
+				options.Type = commands.TypeFile
+				return options
+			},
+			expected_result: []string{".git/config", "src/pkg/big.bin", "src/main.go", "README.md"},
+		},
+		{
+			name: "Glob",
+			options: func() commands.FindOptions {
+				options := commands.NewFindOptions()
+				options.Name = "*.go"
+				return options
+			},
+			expected_result: []string{"src/main.go"},
+		},
+		{
+			name: "Regexp and max depth",
+			options: func() commands.FindOptions {
+				options := commands.NewFindOptions()
+				options.Regexp = regexp.MustCompile("^[a-z]+$")
+				options.MaxDepth = 1
+				return options
+			},
+			expected_result: []string{"src"},
+		},
+		{
+			name: "Size",
+			options: func() commands.FindOptions {
+				options := commands.NewFindOptions()
+				options.Type = commands.TypeFile
+				options.MinSize = 1024
+				return options
+			},
+			expected_result: []string{"src/pkg/big.bin"},
+		},
+		{
+			name: "Older",
+			options: func() commands.FindOptions {
+				options := commands.NewFindOptions()
+				options.OlderThan = time.Now().Add(-24 * time.Hour)
+				return options
+			},
+			expected_result: []string{"README.md"},
+		},
+		{
+			name: "Grep",
+			options: func() commands.FindOptions {
+				options := commands.NewFindOptions()
+				options.Grep = regexp.MustCompile("hello")
+				return options
+			},
+			expected_result: []string{"src/main.go", "README.md"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(
+			testCase.name, func(t *testing.T) {
+				paths := []string{}
+				err := commands.Find(dir, testCase.options(), func(match commands.Match) error {
+					paths = append(paths, match.Path)
+					return nil
+				})
+				require.NoError(t, err)
+				require.Equal(t, testCase.expected_result, paths)
+			},
+		)
+	}
+}
+
+func TestGrep(t *testing.T) {
+	dir := prepareFindDir(t)
+	defer os.RemoveAll(dir)
+
+	lines, err := commands.Grep(filepath.Join(dir, "src", "main.go"), regexp.MustCompile("main|hello"))
+	require.NoError(t, err)
+	require.Equal(t, []commands.Line{
+		{Number: 1, Text: "package main"},
+		{Number: 3, Text: "func main() {"},
+		{Number: 4, Text: "\thello()"},
+	}, lines)
+
+	lines, err = commands.Grep(filepath.Join(dir, "src", "pkg", "big.bin"), regexp.MustCompile(""))
+	require.NoError(t, err)
+	require.Empty(t, lines)
+}
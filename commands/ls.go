@@ -1,31 +1,157 @@
 package commands
 
 import (
-	"io/ioutil"
-	"strings"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
 )
 
+// ReadDirBatch is the number of entries ReadDir reads at once.
+const ReadDirBatch = 256
+
 func Ls(dirName string, showHidden bool) ([]string, error) {
-	files, err := ioutil.ReadDir(dirName)
+	names := []string{}
+	options := LsOptions{ShowHidden: showHidden, NamesOnly: true}
+	err := LsFunc(context.Background(), dirName, options, func(entry Entry) error {
+		names = append(names, entry.Name)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return names, nil
+}
 
-	dirs := []string{}
-	dirFiles := []string{}
+type LsOptions struct {
+	ShowHidden bool
+	// Hashes, when set, gives the known hashes of an entry.
+	Hashes func(info os.FileInfo) map[string]string
+	// Preview, when set, gives the kind of preview a file has by its name.
+	Preview func(name string) string
+	// NamesOnly skips the stat of every entry, only Name and Type are set.
+	NamesOnly bool
+	// Unsorted gives the entries in directory order as they are read,
+	// instead of reading all names first to sort them.
+	Unsorted bool
+}
 
-	for _, file := range files {
-		if !showHidden && strings.HasPrefix(file.Name(), ".") {
-			continue
+// ReadDir calls fn for the entries of dirName in directory order, reading
+// them in batches with os.File.ReadDir. Entries are not stat'ed until their
+// Info is asked for. It stops with the error of ctx once ctx is done.
+func ReadDir(ctx context.Context, dirName string, fn func(os.DirEntry) error) error {
+	dir, err := os.Open(dirName)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	for {
+		entries, err := dir.ReadDir(ReadDirBatch)
+		for _, entry := range entries {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			fnErr := fn(entry)
+			if fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
 		}
-		if file.IsDir() {
-			dirs = append(dirs, file.Name())
-			continue
+		if err != nil {
+			return err
 		}
+	}
+}
 
-		dirFiles = append(dirFiles, file.Name())
+// LsFunc calls fn for every entry of dirName, directories first and then
+// the rest, both by name, or in directory order with Unsorted. Symlinks are
+// not followed. Temporary files are left out even with ShowHidden. An error
+// of fn or ctx stops the listing and is returned.
+func LsFunc(ctx context.Context, dirName string, options LsOptions, fn func(Entry) error) error {
+	visible := func(entry os.DirEntry) bool {
+		return !IsTemp(entry.Name()) && (options.ShowHidden || !IsHidden(entry.Name()))
+	}
+	if options.Unsorted {
+		return ReadDir(ctx, dirName, func(entry os.DirEntry) error {
+			if !visible(entry) {
+				return nil
+			}
+			return lsEntry(dirName, entry, options, fn)
+		})
+	}
+
+	dirs := []os.DirEntry{}
+	files := []os.DirEntry{}
+	err := ReadDir(ctx, dirName, func(entry os.DirEntry) error {
+		if !visible(entry) {
+			return nil
+		}
+		if entry.IsDir() {
+			dirs = append(dirs, entry)
+		} else {
+			files = append(files, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	dirs = append(dirs, dirFiles...)
 
-	return dirs, err
+	byName := func(entries []os.DirEntry) func(i, j int) bool {
+		return func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		}
+	}
+	sort.Slice(dirs, byName(dirs))
+	sort.Slice(files, byName(files))
+	for _, entry := range append(dirs, files...) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err = lsEntry(dirName, entry, options, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lsEntry stats entry unless only names are asked for. An entry removed
+// since it was read is skipped.
+func lsEntry(dirName string, dirEntry os.DirEntry, options LsOptions, fn func(Entry) error) error {
+	if options.NamesOnly {
+		return fn(Entry{Name: dirEntry.Name(), Type: FileType(dirEntry.Type()), Mode: dirEntry.Type()})
+	}
+	info, err := dirEntry.Info()
+	if err != nil {
+		return nil
+	}
+	entry := NewEntry(dirEntry.Name(), info)
+	if entry.Type == TypeSymlink {
+		entry.Target, entry.Broken = LinkTarget(filepath.Join(dirName, dirEntry.Name()))
+	}
+	if options.Hashes != nil {
+		entry.Hashes = options.Hashes(info)
+	}
+	if options.Preview != nil && entry.Type == TypeFile {
+		entry.Preview = options.Preview(entry.Name)
+	}
+	return fn(entry)
+}
+
+// LsEntries is Ls with metadata.
+func LsEntries(dirName string, options LsOptions) ([]Entry, error) {
+	entries := []Entry{}
+	err := LsFunc(context.Background(), dirName, options, func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
 }
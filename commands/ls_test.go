@@ -1,10 +1,14 @@
 package commands_test
 
 import (
+	"context"
+	"errors"
 	"files_server/commands"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -102,3 +106,99 @@ func TestLs(t *testing.T) {
 	}
 
 }
+
+func TestLsFunc(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.txt", "a.txt", "c.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "z"), 0755))
+
+	names := []string{}
+	stop := errors.New("stop")
+	err := commands.LsFunc(context.Background(), dir, commands.LsOptions{}, func(entry commands.Entry) error {
+		names = append(names, entry.Name)
+		if entry.Name == "a.txt" {
+			require.Equal(t, commands.TypeFile, entry.Type)
+			require.Equal(t, int64(5), entry.Size)
+			require.True(t, entry.Mode.IsRegular())
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, []string{"z", "a.txt"}, names)
+
+	entries, err := commands.LsEntries(dir, commands.LsOptions{NamesOnly: true})
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	require.Equal(t, commands.Entry{Name: "z", Type: commands.TypeDir, Mode: os.ModeDir}, entries[0])
+	require.Equal(t, commands.Entry{Name: "a.txt", Type: commands.TypeFile}, entries[1])
+}
+
+func TestLsFuncBatches(t *testing.T) {
+	dir := t.TempDir()
+	count := 2*commands.ReadDirBatch + 10
+	expected := []string{}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("%04d", i)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+		expected = append(expected, name)
+	}
+
+	names, err := commands.Ls(dir, false)
+	require.NoError(t, err)
+	require.Equal(t, expected, names)
+
+	unsorted := []string{}
+	err = commands.LsFunc(context.Background(), dir, commands.LsOptions{Unsorted: true}, func(entry commands.Entry) error {
+		unsorted = append(unsorted, entry.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, unsorted)
+
+	// A cancelled context stops the listing, e.g. when the client of /ls
+	// goes away.
+	for _, unsorted := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		seen := 0
+		err = commands.LsFunc(ctx, dir, commands.LsOptions{Unsorted: unsorted}, func(entry commands.Entry) error {
+			seen++
+			if seen == 10 {
+				cancel()
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 10, seen)
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	testCases := []struct {
+		size     int64
+		expected string
+	}{
+		{size: 0, expected: "0"},
+		{size: 1023, expected: "1023"},
+		{size: 1024, expected: "1.0K"},
+		{size: 1536, expected: "1.5K"},
+		{size: 1537, expected: "1.6K"},
+		{size: 10*1024 - 1, expected: "10K"},
+		{size: 10 * 1024, expected: "10K"},
+		{size: 200*1024 + 1, expected: "201K"},
+		{size: 5 << 20, expected: "5.0M"},
+		{size: 3 << 40, expected: "3.0T"},
+	}
+	for _, testCase := range testCases {
+		require.Equal(t, testCase.expected, commands.HumanSize(testCase.size), testCase.size)
+	}
+}
+
+func TestLsTime(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	require.Equal(t, "Jun  1 09:30", commands.LsTime(time.Date(2024, 6, 1, 9, 30, 0, 0, time.UTC), now))
+	require.Equal(t, "Nov 20  2023", commands.LsTime(time.Date(2023, 11, 20, 9, 30, 0, 0, time.UTC), now))
+	require.Equal(t, "Jul  1  2024", commands.LsTime(time.Date(2024, 7, 1, 9, 30, 0, 0, time.UTC), now), "in the future")
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stat is everything known about one file. Times other than mtime, ids,
+// inode and link count are only there where the system has them, UID and
+// GID are -1 then. Xattr values that aren't text are base64 with the 0s
+// prefix of getfattr.
+type Stat struct {
+	Name   string            `json:"name"`
+	Path   string            `json:"path"`
+	Type   string            `json:"type"`
+	Mode   string            `json:"mode"`
+	Perm   string            `json:"perm"`
+	Size   int64             `json:"size"`
+	UID    int               `json:"uid"`
+	GID    int               `json:"gid"`
+	User   string            `json:"user,omitempty"`
+	Group  string            `json:"group,omitempty"`
+	Atime  *time.Time        `json:"atime,omitempty"`
+	Mtime  time.Time         `json:"mtime"`
+	Ctime  *time.Time        `json:"ctime,omitempty"`
+	Inode  uint64            `json:"inode,omitempty"`
+	Links  uint64            `json:"links,omitempty"`
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+}
+
+// NewStat stats fileName without following a symlink.
+func NewStat(fileName string) (Stat, error) {
+	info, err := os.Lstat(fileName)
+	if err != nil {
+		return Stat{}, err
+	}
+	stat := Stat{
+		Name:  filepath.Base(fileName),
+		Path:  fileName,
+		Type:  FileType(info.Mode()),
+		Mode:  info.Mode().String(),
+		Perm:  fmt.Sprintf("%04o", uint32(info.Mode().Perm())),
+		Size:  info.Size(),
+		UID:   -1,
+		GID:   -1,
+		Mtime: info.ModTime(),
+	}
+	fillStat(&stat, fileName, info)
+	return stat, nil
+}
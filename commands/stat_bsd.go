@@ -0,0 +1,16 @@
+//go:build darwin || freebsd || netbsd
+
+package commands
+
+import (
+	"syscall"
+	"time"
+)
+
+func statTimes(st *syscall.Stat_t) (time.Time, time.Time) {
+	return time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec)), time.Unix(int64(st.Ctimespec.Sec), int64(st.Ctimespec.Nsec))
+}
+
+func xattrs(fileName string) map[string]string {
+	return nil
+}
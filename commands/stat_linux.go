@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"encoding/base64"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+func statTimes(st *syscall.Stat_t) (time.Time, time.Time) {
+	return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)), time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
+}
+
+// xattrs reads the extended attributes of fileName, nil when there are
+// none or the filesystem has none.
+func xattrs(fileName string) map[string]string {
+	size, err := unix.Llistxattr(fileName, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	list := make([]byte, size)
+	size, err = unix.Llistxattr(fileName, list)
+	if err != nil {
+		return nil
+	}
+	result := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		size, err := unix.Lgetxattr(fileName, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, size)
+		size, err = unix.Lgetxattr(fileName, name, value)
+		if err != nil {
+			continue
+		}
+		value = value[:size]
+		if utf8.Valid(value) {
+			result[name] = string(value)
+		} else {
+			result[name] = "0s" + base64.StdEncoding.EncodeToString(value)
+		}
+	}
+	return result
+}
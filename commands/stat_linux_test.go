@@ -0,0 +1,24 @@
+package commands_test
+
+import (
+	"files_server/commands"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestXattrs(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(fileName, nil, 0644))
+	if err := unix.Setxattr(fileName, "user.comment", []byte("draft"), 0); err != nil {
+		t.Skip("no user xattrs here:", err)
+	}
+	require.NoError(t, unix.Setxattr(fileName, "user.bin", []byte{0xff, 0x00}, 0))
+
+	stat, err := commands.NewStat(fileName)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"user.comment": "draft", "user.bin": "0s/wA="}, stat.Xattrs)
+}
@@ -0,0 +1,16 @@
+//go:build !windows && !linux && !darwin && !freebsd && !netbsd
+
+package commands
+
+import (
+	"syscall"
+	"time"
+)
+
+func statTimes(st *syscall.Stat_t) (time.Time, time.Time) {
+	return time.Time{}, time.Time{}
+}
+
+func xattrs(fileName string) map[string]string {
+	return nil
+}
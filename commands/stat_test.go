@@ -0,0 +1,31 @@
+package commands_test
+
+import (
+	"files_server/commands"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStat(t *testing.T) {
+	root := t.TempDir()
+	fileName := filepath.Join(root, "a.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("hello"), 0644))
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(root, "link")))
+
+	stat, err := commands.NewStat(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "a.txt", stat.Name)
+	require.Equal(t, int64(5), stat.Size)
+	require.Equal(t, "0644", stat.Perm)
+
+	stat, err = commands.NewStat(filepath.Join(root, "link"))
+	require.NoError(t, err)
+	require.Equal(t, commands.TypeSymlink, stat.Type)
+
+	_, err = commands.NewStat(filepath.Join(root, "missing"))
+	require.True(t, os.IsNotExist(err))
+
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package commands
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+func fillStat(stat *Stat, fileName string, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	stat.UID = int(st.Uid)
+	stat.GID = int(st.Gid)
+	if u, err := user.LookupId(strconv.Itoa(stat.UID)); err == nil {
+		stat.User = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(stat.GID)); err == nil {
+		stat.Group = g.Name
+	}
+	atime, ctime := statTimes(st)
+	if !atime.IsZero() {
+		stat.Atime, stat.Ctime = &atime, &ctime
+	}
+	stat.Inode = uint64(st.Ino)
+	stat.Links = uint64(st.Nlink)
+	stat.Xattrs = xattrs(fileName)
+}
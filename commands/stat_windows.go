@@ -0,0 +1,5 @@
+package commands
+
+import "os"
+
+func fillStat(stat *Stat, fileName string, info os.FileInfo) {}
@@ -0,0 +1,367 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	MinBlockSize = 1 << 10
+	MaxBlockSize = 128 << 10
+	// strongSize is how much of the sha256 of a block a Signature keeps.
+	strongSize = 16
+	// maxLiteral is how much unmatched data is buffered before it is sent.
+	maxLiteral = 256 << 10
+)
+
+var (
+	ErrDelta     = errors.New("Malformed delta")
+	ErrChecksum  = errors.New("Result of the delta doesn't match, the base file changed")
+	ErrBlockSize = errors.New("Bad block size")
+)
+
+// The delta format is a magic, the block size as uvarint and then ops:
+// opCopy with the first block and the number of blocks as uvarints,
+// opLiteral with a uvarint length and the data, and at last opEnd with the
+// sha256 of the whole result.
+const (
+	magic     = "FSD1"
+	opCopy    = 'C'
+	opLiteral = 'L'
+	opEnd     = 'E'
+)
+
+// Signature describes a file block by block, for the other side to find
+// which of its data the file already has.
+type Signature struct {
+	BlockSize int     `json:"block_size"`
+	Size      int64   `json:"size"`
+	Blocks    []Block `json:"blocks"`
+}
+
+type Block struct {
+	Weak   uint32 `json:"weak"`
+	Strong []byte `json:"strong"`
+}
+
+// BlockSize is a block size for a file of size bytes, about its square
+// root like rsync, so a signature stays small for large files.
+func BlockSize(size int64) int {
+	blockSize := int(math.Sqrt(float64(size)))
+	blockSize = blockSize / 8 * 8
+	return min(max(blockSize, MinBlockSize), MaxBlockSize)
+}
+
+func NewSignature(r io.Reader, blockSize int) (Signature, error) {
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return Signature{}, ErrBlockSize
+	}
+	signature := Signature{BlockSize: blockSize, Blocks: []Block{}}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			signature.Size += int64(n)
+			signature.Blocks = append(signature.Blocks, Block{Weak: weakSum(buf[:n]), Strong: strongSum(buf[:n])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return signature, nil
+		}
+		if err != nil {
+			return Signature{}, err
+		}
+	}
+}
+
+// weakSum is the rolling checksum of rsync: a is the sum of the bytes, b
+// the sum of the running a, both mod 2^16.
+func weakSum(data []byte) uint32 {
+	var a, b uint32
+	for i, c := range data {
+		a += uint32(c)
+		b += uint32(len(data)-i) * uint32(c)
+	}
+	return a&0xffff | b<<16
+}
+
+func strongSum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:strongSize]
+}
+
+// rolling is weakSum of a window that moves a byte at a time.
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRolling(window []byte) rolling {
+	sum := weakSum(window)
+	return rolling{a: sum & 0xffff, b: sum >> 16, n: uint32(len(window))}
+}
+
+func (r *rolling) roll(out, in byte) {
+	r.a = r.a - uint32(out) + uint32(in)
+	r.b = r.b - r.n*uint32(out) + r.a
+}
+
+func (r *rolling) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+// Write writes the delta that turns the file of signature into the content
+// of r. Blocks are only matched at their full size, the last one as well.
+func Write(w io.Writer, signature Signature, r io.Reader) error {
+	blockSize := signature.BlockSize
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return ErrBlockSize
+	}
+	blocks := map[uint32][]int{}
+	for i, block := range signature.Blocks {
+		blocks[block.Weak] = append(blocks[block.Weak], i)
+	}
+	blockLen := func(i int) int {
+		if i == len(signature.Blocks)-1 {
+			return int(signature.Size - int64(i)*int64(blockSize))
+		}
+		return blockSize
+	}
+
+	hash := sha256.New()
+	reader := bufio.NewReaderSize(io.TeeReader(r, hash), 64<<10)
+	encoder := &encoder{w: bufio.NewWriter(w)}
+	encoder.header(blockSize)
+
+	// data[lit:pos] is unmatched data not sent yet, data[pos:] the window.
+	data := make([]byte, 0, maxLiteral+2*blockSize)
+	lit, pos := 0, 0
+	eof := false
+	fill := func() error {
+		for !eof && len(data)-pos < blockSize {
+			if len(data) == cap(data) {
+				copy(data, data[lit:])
+				data = data[:len(data)-lit]
+				pos -= lit
+				lit = 0
+			}
+			n, err := reader.Read(data[len(data):cap(data)])
+			data = data[:len(data)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	match := func(window []byte, weak uint32) int {
+		for _, i := range blocks[weak] {
+			if blockLen(i) == len(window) && bytes.Equal(strongSum(window), signature.Blocks[i].Strong) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	err := fill()
+	if err != nil {
+		return err
+	}
+	var window rolling
+	rolled := false
+	for len(data)-pos >= blockSize {
+		if !rolled {
+			window = newRolling(data[pos : pos+blockSize])
+			rolled = true
+		}
+		if i := match(data[pos:pos+blockSize], window.sum()); i >= 0 {
+			err = encoder.literal(data[lit:pos])
+			if err == nil {
+				err = encoder.copy(i)
+			}
+			pos += blockSize
+			lit = pos
+			rolled = false
+			if err == nil {
+				err = fill()
+			}
+		} else {
+			out := data[pos]
+			pos++
+			if pos-lit >= maxLiteral {
+				err = encoder.literal(data[lit:pos])
+				lit = pos
+			}
+			if err == nil {
+				err = fill()
+			}
+			if err == nil && len(data)-pos >= blockSize {
+				window.roll(out, data[pos+blockSize-1])
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// What is left is shorter than a block, only a short last block can be
+	// in it, e.g. when the file was appended to.
+	tail := data[pos:]
+	last := len(signature.Blocks) - 1
+	if last >= 0 && blockLen(last) < blockSize && blockLen(last) <= len(tail) {
+		n := blockLen(last)
+		window := newRolling(tail[:n])
+		for offset := 0; ; offset++ {
+			if match(tail[offset:offset+n], window.sum()) == last {
+				err = encoder.literal(data[lit : pos+offset])
+				if err == nil {
+					err = encoder.copy(last)
+				}
+				lit = pos + offset + n
+				break
+			}
+			if offset+n == len(tail) {
+				break
+			}
+			window.roll(tail[offset], tail[offset+n])
+		}
+	}
+	if err == nil {
+		err = encoder.literal(data[lit:])
+	}
+	if err == nil {
+		err = encoder.end(hash.Sum(nil))
+	}
+	return err
+}
+
+// encoder writes ops, runs of consecutive blocks as one copy.
+type encoder struct {
+	w      *bufio.Writer
+	buf    [binary.MaxVarintLen64]byte
+	first  int
+	blocks int
+}
+
+func (encoder *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(encoder.buf[:], v)
+	encoder.w.Write(encoder.buf[:n])
+}
+
+func (encoder *encoder) header(blockSize int) {
+	encoder.w.WriteString(magic)
+	encoder.uvarint(uint64(blockSize))
+}
+
+func (encoder *encoder) copy(block int) error {
+	if encoder.blocks > 0 && block == encoder.first+encoder.blocks {
+		encoder.blocks++
+		return nil
+	}
+	err := encoder.flush()
+	encoder.first, encoder.blocks = block, 1
+	return err
+}
+
+func (encoder *encoder) flush() error {
+	if encoder.blocks == 0 {
+		return nil
+	}
+	encoder.w.WriteByte(opCopy)
+	encoder.uvarint(uint64(encoder.first))
+	encoder.uvarint(uint64(encoder.blocks))
+	encoder.blocks = 0
+	return nil
+}
+
+func (encoder *encoder) literal(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	encoder.flush()
+	encoder.w.WriteByte(opLiteral)
+	encoder.uvarint(uint64(len(data)))
+	_, err := encoder.w.Write(data)
+	return err
+}
+
+func (encoder *encoder) end(sum []byte) error {
+	encoder.flush()
+	encoder.w.WriteByte(opEnd)
+	encoder.w.Write(sum)
+	return encoder.w.Flush()
+}
+
+// Apply writes the result of delta on the base file of size bytes to w.
+// It fails with ErrChecksum when the result is not what the delta was made
+// for, e.g. because base is not the file of the signature anymore.
+func Apply(w io.Writer, base io.ReaderAt, size int64, delta io.Reader) error {
+	reader := bufio.NewReader(delta)
+	header := make([]byte, len(magic))
+	_, err := io.ReadFull(reader, header)
+	if err != nil || string(header) != magic {
+		return ErrDelta
+	}
+	blockSize, err := binary.ReadUvarint(reader)
+	if err != nil || blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return ErrDelta
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	for {
+		op, err := reader.ReadByte()
+		if err != nil {
+			return ErrDelta
+		}
+		switch op {
+		case opCopy:
+			first, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return ErrDelta
+			}
+			count, err := binary.ReadUvarint(reader)
+			if err != nil || count == 0 || first > uint64(size)/blockSize || count > uint64(size)/blockSize+1 {
+				return ErrDelta
+			}
+			offset := int64(first * blockSize)
+			length := min(int64(count*blockSize), size-offset)
+			if length <= 0 {
+				return ErrDelta
+			}
+			_, err = io.Copy(out, io.NewSectionReader(base, offset, length))
+			if err != nil {
+				return err
+			}
+		case opLiteral:
+			length, err := binary.ReadUvarint(reader)
+			if err != nil || length > math.MaxInt64 {
+				return ErrDelta
+			}
+			_, err = io.CopyN(out, reader, int64(length))
+			if err == io.EOF {
+				return ErrDelta
+			}
+			if err != nil {
+				return err
+			}
+		case opEnd:
+			sum := make([]byte, sha256.Size)
+			_, err := io.ReadFull(reader, sum)
+			if err != nil {
+				return ErrDelta
+			}
+			if !bytes.Equal(sum, hash.Sum(nil)) {
+				return ErrChecksum
+			}
+			return nil
+		default:
+			return ErrDelta
+		}
+	}
+}
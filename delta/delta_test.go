@@ -0,0 +1,87 @@
+package delta_test
+
+import (
+	"bytes"
+	"files_server/delta"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestBlockSize(t *testing.T) {
+	require.Equal(t, delta.MinBlockSize, delta.BlockSize(0))
+	require.Equal(t, 8192, delta.BlockSize(8192*8192))
+	require.Equal(t, delta.MaxBlockSize, delta.BlockSize(1<<50))
+}
+
+func TestDelta(t *testing.T) {
+	base := randomData(1, 100_000)
+	large := randomData(4, 1_500_000)
+	tests := []struct {
+		name              string
+		base              []byte
+		content           []byte
+		expected_max_size int
+	}{
+		{name: "same", base: base, content: base, expected_max_size: 100},
+		{name: "insert", base: base, content: concat(base[:50_000], []byte("inserted"), base[50_000:]), expected_max_size: 2200},
+		{name: "delete", base: base, content: concat(base[:30_000], base[31_000:]), expected_max_size: 2200},
+		{name: "append", base: base, content: concat(base, []byte("more")), expected_max_size: 200},
+		{name: "truncate", base: base, content: base[:77_777], expected_max_size: 1200},
+		{name: "moved_blocks", base: base, content: concat(base[60_000:], base[:60_000]), expected_max_size: 2200},
+		{name: "unrelated", base: base, content: randomData(2, 5000), expected_max_size: 5100},
+		{name: "empty_base", base: []byte{}, content: []byte("new file"), expected_max_size: 100},
+		{name: "empty_content", base: base, content: []byte{}, expected_max_size: 100},
+		{name: "large_unrelated", base: base, content: randomData(5, 700_000), expected_max_size: 701_000},
+		{name: "large_insert", base: large, content: concat(large[:900_000], randomData(6, 300_000), large[900_000:]), expected_max_size: 304_000},
+		{name: "small_base", base: []byte("short"), content: []byte("short"), expected_max_size: 100},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signature, err := delta.NewSignature(bytes.NewReader(test.base), delta.BlockSize(int64(len(test.base))))
+			require.NoError(t, err)
+			require.Equal(t, int64(len(test.base)), signature.Size)
+
+			patch := &bytes.Buffer{}
+			require.NoError(t, delta.Write(patch, signature, bytes.NewReader(test.content)))
+			require.LessOrEqual(t, patch.Len(), test.expected_max_size)
+
+			out := &bytes.Buffer{}
+			require.NoError(t, delta.Apply(out, bytes.NewReader(test.base), int64(len(test.base)), bytes.NewReader(patch.Bytes())))
+			require.Equal(t, string(test.content), out.String())
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	base := randomData(3, 10_000)
+	signature, err := delta.NewSignature(bytes.NewReader(base), delta.MinBlockSize)
+	require.NoError(t, err)
+	patch := &bytes.Buffer{}
+	require.NoError(t, delta.Write(patch, signature, bytes.NewReader(concat([]byte("x"), base))))
+
+	changed := concat(base[:5000], []byte("changed"), base[5007:])
+	err = delta.Apply(&bytes.Buffer{}, bytes.NewReader(changed), int64(len(changed)), bytes.NewReader(patch.Bytes()))
+	require.Equal(t, delta.ErrChecksum, err)
+
+	truncated := patch.Bytes()[:patch.Len()-10]
+	err = delta.Apply(&bytes.Buffer{}, bytes.NewReader(base), int64(len(base)), bytes.NewReader(truncated))
+	require.Equal(t, delta.ErrDelta, err)
+
+	err = delta.Apply(&bytes.Buffer{}, bytes.NewReader(base), int64(len(base)), bytes.NewReader([]byte("not a delta")))
+	require.Equal(t, delta.ErrDelta, err)
+
+	_, err = delta.NewSignature(bytes.NewReader(base), 10)
+	require.Equal(t, delta.ErrBlockSize, err)
+}
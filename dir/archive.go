@@ -0,0 +1,208 @@
+package dir
+
+import (
+	"bytes"
+	"errors"
+	"files_server/archive"
+	"files_server/commands"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const manifestName = "MANIFEST.txt"
+
+func (currentDir *Dir) archive(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dirName, err := currentDir.lookup(query.Get("path"), true)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	fileInfo, err := os.Stat(dirName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !fileInfo.IsDir() {
+		http.Error(w, "Not directory", http.StatusBadRequest)
+		return
+	}
+
+	for _, pattern := range append(query["include"], query["exclude"]...) {
+		_, err = path.Match(pattern, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	maxSize := currentDir.config.ArchiveMaxSize
+	if value := query.Get("maxsize"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if maxSize <= 0 || size < maxSize {
+			maxSize = size
+		}
+	}
+
+	format := query.Get("format")
+	out := &startWriter{Writer: w}
+	writer, err := archive.NewWriter(out, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	base := filepath.Base(dirName)
+	if base == "/" {
+		base = "root"
+	}
+	w.Header().Set("Content-Type", archive.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+archive.Extension(format)))
+
+	a := &archiver{
+		writer:   writer,
+		root:     dirName,
+		base:     base,
+		include:  query["include"],
+		exclude:  query["exclude"],
+		maxSize:  maxSize,
+		manifest: &bytes.Buffer{},
+	}
+	err = a.run(showHidden(r))
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// A truncated archive must not look like a complete download.
+		w.Header().Del("Content-Disposition")
+		textError(w, out, err)
+	}
+}
+
+type archiver struct {
+	writer   archive.Writer
+	root     string
+	base     string
+	include  []string
+	exclude  []string
+	maxSize  int64
+	written  int64
+	manifest *bytes.Buffer
+}
+
+func (a *archiver) run(showHidden bool) error {
+	options := commands.NewFindOptions()
+	options.ShowHidden = showHidden
+	options.Errors = func(relPath string, err error) {
+		a.skip(relPath, err.Error())
+	}
+
+	err := commands.Find(a.root, options, func(match commands.Match) error {
+		return a.add(match)
+	})
+	if err != nil {
+		return err
+	}
+
+	if a.manifest.Len() == 0 {
+		return nil
+	}
+	return a.writer.File(manifestName, manifestInfo{size: int64(a.manifest.Len())}, a.manifest)
+}
+
+func (a *archiver) add(match commands.Match) error {
+	rel := filepath.ToSlash(match.Path)
+	if matchAny(a.exclude, rel) {
+		if match.Type == commands.TypeDir {
+			return commands.SkipDir
+		}
+		return nil
+	}
+	if match.Type != commands.TypeDir && len(a.include) > 0 && !matchAny(a.include, rel) {
+		return nil
+	}
+
+	fullPath := filepath.Join(a.root, match.Path)
+	name := a.base + "/" + rel
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		a.skip(rel, err.Error())
+		return nil
+	}
+
+	switch match.Type {
+	case commands.TypeDir:
+		return a.writer.Dir(name, info)
+	case commands.TypeSymlink:
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			a.skip(rel, err.Error())
+			return nil
+		}
+		return a.writer.Symlink(name, info, target)
+	case commands.TypeFile:
+		return a.addFile(rel, name, fullPath, info)
+	}
+	a.skip(rel, "not a regular file")
+	return nil
+}
+
+func (a *archiver) addFile(rel, name, fullPath string, info os.FileInfo) error {
+	if a.maxSize > 0 && a.written+info.Size() > a.maxSize {
+		a.skip(rel, fmt.Sprintf("size cap of %d bytes reached", a.maxSize))
+		return nil
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		a.skip(rel, err.Error())
+		return nil
+	}
+	defer file.Close()
+
+	a.written += info.Size()
+	err = a.writer.File(name, info, file)
+	readErr := &archive.ReadError{}
+	if errors.As(err, &readErr) {
+		a.skip(rel, readErr.Err.Error())
+		return nil
+	}
+	return err
+}
+
+func (a *archiver) skip(rel, reason string) {
+	fmt.Fprintf(a.manifest, "skipped\t%s\t%s\n", rel, reason)
+}
+
+// matchAny matches the patterns against both the relative path and the name
+// of an entry, so "*.log" works at any depth and "build/*" only at the top.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type manifestInfo struct {
+	size int64
+}
+
+func (info manifestInfo) Name() string       { return manifestName }
+func (info manifestInfo) Size() int64        { return info.size }
+func (info manifestInfo) Mode() os.FileMode  { return 0644 }
+func (info manifestInfo) ModTime() time.Time { return time.Now() }
+func (info manifestInfo) IsDir() bool        { return false }
+func (info manifestInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,131 @@
+package dir_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"files_server/dir"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "build", "node_modules"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "build", "app.bin"), []byte("0123456789"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "build", "app.log"), []byte("log"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "build", ".env"), []byte("secret"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "build", "node_modules", "dep.js"), []byte("js"), 0666))
+
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	testCases := []struct {
+		name            string
+		query           string
+		expected_result int
+		expected_files  map[string]string
+	}{
+		{
+			name:            "Whole directory",
+			query:           "path=build",
+			expected_result: http.StatusOK,
+			expected_files: map[string]string{
+				"build/node_modules/":       "",
+				"build/node_modules/dep.js": "js",
+				"build/app.bin":             "0123456789",
+				"build/app.log":             "log",
+			},
+		},
+		{
+			name:            "Hidden, include and exclude",
+			query:           "path=build&hide=true&include=*.log&include=.*&exclude=node_modules",
+			expected_result: http.StatusOK,
+			expected_files: map[string]string{
+				"build/.env":    "secret",
+				"build/app.log": "log",
+			},
+		},
+		{
+			name:            "Size cap",
+			query:           "path=build&maxsize=5&exclude=node_modules",
+			expected_result: http.StatusOK,
+			expected_files: map[string]string{
+				"build/app.log": "log",
+				"MANIFEST.txt":  "skipped\tapp.bin\tsize cap of 5 bytes reached\n",
+			},
+		},
+		{
+			name:            "Not directory",
+			query:           "path=build/app.log",
+			expected_result: http.StatusBadRequest,
+		},
+		{
+			name:            "Bad format",
+			query:           "path=build&format=rar",
+			expected_result: http.StatusBadRequest,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(
+			testCase.name, func(t *testing.T) {
+				resp, err := testServer.Client().Get(testServer.URL + "/archive?" + testCase.query)
+				require.NoError(t, err)
+				b, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				require.NoError(t, err)
+				require.Equal(t, testCase.expected_result, resp.StatusCode)
+				if resp.StatusCode != http.StatusOK {
+					return
+				}
+				require.Equal(t, `attachment; filename="build.zip"`, resp.Header.Get("Content-Disposition"))
+
+				zipReader, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+				require.NoError(t, err)
+				files := map[string]string{}
+				for _, file := range zipReader.File {
+					reader, err := file.Open()
+					require.NoError(t, err)
+					content, err := ioutil.ReadAll(reader)
+					reader.Close()
+					require.NoError(t, err)
+					files[file.Name] = string(content)
+				}
+				require.Equal(t, testCase.expected_files, files)
+			},
+		)
+	}
+}
+
+// failingWriter fails every write after the first limit bytes.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (writer *failingWriter) Write(p []byte) (int, error) {
+	if writer.Body.Len()+len(p) > writer.limit {
+		return 0, errors.New("connection reset")
+	}
+	return writer.ResponseRecorder.Write(p)
+}
+
+func TestArchiveCutShort(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "build"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "build", "app.bin"), bytes.Repeat([]byte("0123456789"), 10000), 0666))
+	currentDir := dir.NewWithConfig(&dir.Config{Root: root})
+
+	writer := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 1000}
+	request := httptest.NewRequest(http.MethodGet, "/archive?path=build&format=tar", nil)
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		currentDir.ServeHTTP(writer, request)
+	})
+}
@@ -0,0 +1,134 @@
+package dir
+
+import (
+	"errors"
+	"files_server/commands"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+var (
+	ErrNotAdmin = errors.New("Only admins can do this")
+	ErrMode     = errors.New("Mode must be octal permissions like 0644")
+)
+
+// stat writes the metadata of filename as JSON, of a symlink itself rather
+// than its target.
+func (currentDir *Dir) stat(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	fileName, err := currentDir.lookup(fileName, false)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	stat, err := commands.NewStat(fileName)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	writeJSON(w, stat)
+}
+
+// chmod sets the permissions of filename to mode, in octal.
+func (currentDir *Dir) chmod(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fileName := query.Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	mode, err := parseMode(query.Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = currentDir.Chmod(fileName, mode)
+	if err != nil {
+		fileError(w, err)
+	}
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, ErrMode
+	}
+	return os.FileMode(mode), nil
+}
+
+// chown sets the owner of filename to user and group, names or ids. Either
+// can be left out. Only admins may give files away.
+func (currentDir *Dir) chown(w http.ResponseWriter, r *http.Request) {
+	if !UserFromContext(r.Context()).Admin {
+		http.Error(w, ErrNotAdmin.Error(), http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	fileName := query.Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	uid, err := lookupID(query.Get("user"), func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	gid, err := lookupID(query.Get("group"), func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = currentDir.Chown(fileName, uid, gid)
+	if err != nil {
+		fileError(w, err)
+	}
+}
+
+// lookupID is the number in name, or the id lookup finds for it. An empty
+// name is -1, which chown leaves as it is.
+func lookupID(name string, lookup func(name string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+func fileError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), fileStatus(err))
+}
+
+func fileStatus(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err) || errors.Is(err, ErrSymlink) || errors.Is(err, ErrSymlinkOutside):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
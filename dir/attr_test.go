@@ -0,0 +1,176 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/blobstore"
+	"files_server/commands"
+	"files_server/dir"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStat(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0640))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/stat?filename=a.txt", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var stat commands.Stat
+	require.NoError(t, json.Unmarshal([]byte(body), &stat))
+	require.Equal(t, "a.txt", stat.Name)
+	require.Equal(t, filepath.Join(root, "a.txt"), stat.Path)
+	require.Equal(t, commands.TypeFile, stat.Type)
+	require.Equal(t, "-rw-r-----", stat.Mode)
+	require.Equal(t, "0640", stat.Perm)
+	require.Equal(t, int64(5), stat.Size)
+	if runtime.GOOS != "windows" {
+		require.Equal(t, os.Getuid(), stat.UID)
+		require.Equal(t, uint64(1), stat.Links)
+		require.NotZero(t, stat.Inode)
+		require.NotNil(t, stat.Atime)
+		require.NotNil(t, stat.Ctime)
+	}
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/stat?filename=missing", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/stat", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestChmod(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), nil, 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	tests := []struct {
+		name            string
+		query           string
+		expected_status int
+		expected_mode   os.FileMode
+	}{
+		{name: "private", query: "filename=a.txt&mode=600", expected_status: http.StatusOK, expected_mode: 0600},
+		{name: "leading_zero", query: "filename=a.txt&mode=0755", expected_status: http.StatusOK, expected_mode: 0755},
+		{name: "not_octal", query: "filename=a.txt&mode=rwx", expected_status: http.StatusBadRequest, expected_mode: 0755},
+		{name: "too_big", query: "filename=a.txt&mode=4755", expected_status: http.StatusBadRequest, expected_mode: 0755},
+		{name: "missing", query: "filename=b.txt&mode=644", expected_status: http.StatusNotFound, expected_mode: 0755},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, _ := doRequest(t, testServer, http.MethodGet, "/chmod?"+test.query, "", nil)
+			require.Equal(t, test.expected_status, resp.StatusCode)
+			info, err := os.Stat(filepath.Join(root, "a.txt"))
+			require.NoError(t, err)
+			if runtime.GOOS != "windows" {
+				require.Equal(t, test.expected_mode, info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestChown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no owners on windows")
+	}
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), nil, 0644))
+	currentDir := dir.NewWithConfig(&dir.Config{Root: root})
+	admin := false
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentDir.ServeHTTP(w, r.WithContext(dir.WithUser(r.Context(), dir.User{Name: "alice", Admin: admin})))
+	}))
+	defer testServer.Close()
+
+	// Giving a file to its owner works without being root.
+	query := "/chown?filename=a.txt&user=" + strconv.Itoa(os.Getuid()) + "&group=" + strconv.Itoa(os.Getgid())
+	resp, body := doRequest(t, testServer, http.MethodGet, query, "", nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Equal(t, dir.ErrNotAdmin.Error()+"\n", body)
+
+	admin = true
+	resp, _ = doRequest(t, testServer, http.MethodGet, query, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/chown?filename=a.txt&user=no-such-user-here", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/chown?filename=b.txt&group="+strconv.Itoa(os.Getgid()), "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTouchTimes(t *testing.T) {
+	root := t.TempDir()
+	fileName := filepath.Join(root, "a.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("keep"), 0644))
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(fileName, old, old))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/touch?filename=a.txt", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), info.ModTime(), time.Minute)
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "keep", string(data))
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/touch?filename=a.txt&time=2020-01-02T03:04:05Z", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	info, err = os.Stat(fileName)
+	require.NoError(t, err)
+	require.True(t, old.Equal(info.ModTime()))
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/touch?filename=b.txt&time=yesterday", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.NoFileExists(t, filepath.Join(root, "b.txt"))
+}
+
+func TestAttributesOfDeduplicatedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no deduplication on windows")
+	}
+	root := t.TempDir()
+	blobs, err := blobstore.Open(filepath.Join(root, ".blobs"))
+	require.NoError(t, err)
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte("same"), 0644))
+		require.NoError(t, os.Chtimes(filepath.Join(root, name), mtime, mtime))
+		_, err = blobs.Put(filepath.Join(root, name))
+		require.NoError(t, err)
+	}
+	stats, err := blobs.Stats()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.References)
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Blobs: blobs}))
+	defer testServer.Close()
+
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/chmod?filename=a.txt&mode=600", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	a, err := os.Stat(filepath.Join(root, "a.txt"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(root, "b.txt"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), a.Mode().Perm())
+	require.NotEqual(t, os.FileMode(0600), b.Mode().Perm(), "the other copy keeps its mode")
+	require.False(t, os.SameFile(a, b))
+	data, err := os.ReadFile(filepath.Join(root, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "same", string(data))
+	// a.txt has a blob of its own now.
+	stats, err = blobs.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Blobs)
+	require.Equal(t, int64(2), stats.References)
+}
@@ -0,0 +1,653 @@
+package dir
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"files_server/replica"
+	"files_server/versions"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	maxBatchOps  = 1000
+	maxBatchSize = 32 << 20
+)
+
+var (
+	ErrBatchOp    = errors.New("Unknown op, use mkdir, touch, write, rm, mv or chmod")
+	ErrBatchArgs  = errors.New("Missing path, or from and to for mv")
+	ErrBatchSize  = fmt.Errorf("At most %d ops in one batch", maxBatchOps)
+	ErrBatchEmpty = errors.New("No ops")
+	ErrSkipped    = errors.New("Not run, an earlier op failed")
+)
+
+// batchLock is held by an atomic batch for all of its run, and read
+// locked by every other change through a Dir, see mutating. Nothing else
+// changes the tree while a batch may still be undone.
+var batchLock sync.RWMutex
+
+// mutating waits for a running atomic batch and keeps the next one from
+// starting until the returned func is called. The ops of an atomic batch
+// itself go through a Dir with a journal and don't wait.
+func (currentDir *Dir) mutating() func() {
+	if currentDir.journal != nil {
+		return func() {}
+	}
+	batchLock.RLock()
+	return batchLock.RUnlock
+}
+
+// batchOp is one step of a /batch. Path is for all ops but mv, which
+// moves From to To.
+type batchOp struct {
+	Op        string `json:"op"`
+	Path      string `json:"path,omitempty"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+	// Time is for touch, now when left out.
+	Time *time.Time `json:"time,omitempty"`
+	// Content is for write, Base64 when it is binary.
+	Content string `json:"content,omitempty"`
+	Base64  bool   `json:"base64,omitempty"`
+	// Mode is for chmod, in octal.
+	Mode string `json:"mode,omitempty"`
+
+	data []byte
+	mode os.FileMode
+}
+
+type batchResult struct {
+	Op     string `json:"op"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+	// RolledBack says an atomic batch failed and its done ops were undone.
+	RolledBack    bool   `json:"rolled_back,omitempty"`
+	RollbackError string `json:"rollback_error,omitempty"`
+	// VersionError says an atomic batch is done, but versions of what it
+	// replaced or removed couldn't all be kept.
+	VersionError string `json:"version_error,omitempty"`
+}
+
+// check validates op before anything of the batch runs.
+func (op *batchOp) check() error {
+	switch op.Op {
+	case "mkdir", "touch", "write", "rm", "chmod":
+		if op.Path == "" {
+			return ErrBatchArgs
+		}
+	case "mv":
+		if op.From == "" || op.To == "" {
+			return ErrBatchArgs
+		}
+	default:
+		return ErrBatchOp
+	}
+	var err error
+	switch {
+	case op.Op == "chmod":
+		op.mode, err = parseMode(op.Mode)
+	case op.Op == "write" && op.Base64:
+		op.data, err = base64.StdEncoding.DecodeString(op.Content)
+	case op.Op == "write":
+		op.data = []byte(op.Content)
+	}
+	return err
+}
+
+// batch runs the JSON array of ops in the body in order and writes the
+// result of each. Ops are independent unless atomic=true: then the first
+// failure stops the batch and undoes the ops before it.
+func (currentDir *Dir) batch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ops := []batchOp{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&ops)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case len(ops) == 0:
+		err = ErrBatchEmpty
+	case len(ops) > maxBatchOps:
+		err = ErrBatchSize
+	}
+	for i := range ops {
+		if err != nil {
+			break
+		}
+		if err = ops[i].check(); err != nil {
+			err = fmt.Errorf("Op %d: %w", i+1, err)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var res batchResponse
+	status := http.StatusOK
+	if r.URL.Query().Get("atomic") == "true" {
+		res, status = currentDir.runAtomic(ops)
+	} else {
+		for _, op := range ops {
+			result := opResult(op, currentDir.runOp(op))
+			if result.Status != http.StatusOK {
+				status = http.StatusMultiStatus
+			}
+			res.Results = append(res.Results, result)
+		}
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func opResult(op batchOp, err error) batchResult {
+	result := batchResult{Op: op.Op, Status: http.StatusOK}
+	if err == nil {
+		return result
+	}
+	result.Error = err.Error()
+	switch {
+	case err == ErrSkipped:
+		result.Status = http.StatusFailedDependency
+	case err == ErrExists:
+		result.Status = http.StatusConflict
+	case err == ErrRemoveRoot || err == ErrMoveRoot || errors.Is(err, syscall.EISDIR):
+		result.Status = http.StatusBadRequest
+	default:
+		result.Status = fileStatus(err)
+	}
+	return result
+}
+
+// runOp does op like its own endpoint would.
+func (currentDir *Dir) runOp(op batchOp) error {
+	switch op.Op {
+	case "mkdir":
+		return currentDir.Mkdir(op.Path)
+	case "touch":
+		err := currentDir.Touch(op.Path)
+		if err == nil && op.Time != nil {
+			err = currentDir.Chtimes(op.Path, *op.Time, *op.Time)
+		}
+		return err
+	case "write":
+		writer, err := currentDir.Create(op.Path, false)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(op.data)
+		if err != nil {
+			writer.Abort()
+			return err
+		}
+		return writer.Close()
+	case "rm":
+		return currentDir.Rm(op.Path)
+	case "mv":
+		return currentDir.Mv(op.From, op.To, op.Overwrite)
+	case "chmod":
+		return currentDir.Chmod(op.Path, op.mode)
+	}
+	return ErrBatchOp
+}
+
+// runAtomic runs ops so that either all of them are done or none. Other
+// changes wait for the batch, but readers see its ops one after the other
+// and, if it fails, their undo. What they never see is a half written
+// file, since every op is one rename into place. What an op removes or
+// replaces is only moved into a staging directory until the batch is done.
+// A failure undoes the done ops in reverse and puts that back. The ops are
+// replicated, and versions of what they replaced kept, only when all of
+// them are done.
+func (currentDir *Dir) runAtomic(ops []batchOp) (batchResponse, int) {
+	batchLock.Lock()
+	defer batchLock.Unlock()
+
+	journal := []replica.Entry{}
+	txDir := &Dir{path: currentDir.path, config: currentDir.config, journal: &journal}
+	tx := &batchTx{dir: txDir, staging: map[string]string{}}
+	res := batchResponse{}
+	status := http.StatusOK
+	for _, op := range ops {
+		if status != http.StatusOK {
+			res.Results = append(res.Results, opResult(op, ErrSkipped))
+			continue
+		}
+		result := opResult(op, tx.run(op))
+		res.Results = append(res.Results, result)
+		status = result.Status
+	}
+
+	if status == http.StatusOK {
+		if err := tx.saveVersions(); err != nil {
+			res.VersionError = err.Error()
+		}
+		tx.dropStaging()
+		if currentDir.config.Replication != nil {
+			currentDir.config.Replication.Append(journal...)
+		}
+		return res, status
+	}
+	res.RolledBack = true
+	if err := tx.rollback(); err != nil {
+		res.RollbackError = err.Error()
+	}
+	return res, status
+}
+
+// batchTx is an atomic batch in progress.
+type batchTx struct {
+	dir *Dir
+	// staging has a staging directory for each directory that needed one,
+	// the root if it is on the same filesystem.
+	staging map[string]string
+	count   int
+	undo    []func() error
+	// versions are kept from what was staged once the batch is done.
+	versions []stagedVersion
+}
+
+type stagedVersion struct {
+	fileName string
+	staged   string
+	reason   string
+}
+
+func (tx *batchTx) run(op batchOp) error {
+	switch op.Op {
+	case "mkdir":
+		return tx.mkdir(op.Path)
+	case "touch":
+		return tx.touch(op.Path, op.Time)
+	case "write":
+		return tx.write(op.Path, op.data)
+	case "rm":
+		return tx.rm(op.Path)
+	case "mv":
+		return tx.mv(op.From, op.To, op.Overwrite)
+	case "chmod":
+		return tx.chmod(op.Path, op.mode)
+	}
+	return ErrBatchOp
+}
+
+func (tx *batchTx) mkdir(name string) error {
+	dirName, err := tx.dir.lookup(name, true)
+	if err != nil || dirName == "/" {
+		return err
+	}
+	// The whole new part of the path appears with one rename.
+	top := ""
+	for current := dirName; ; current = filepath.Dir(current) {
+		_, err := os.Lstat(current)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		top = current
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	if top == "" {
+		err = os.MkdirAll(dirName, os.ModePerm)
+		if err == nil {
+			tx.dir.record(replica.OpMkdir, dirName, "")
+		}
+		return err
+	}
+	rel, err := filepath.Rel(top, dirName)
+	if err != nil {
+		return err
+	}
+	err = tx.place(top, func(tmp string) error {
+		return os.MkdirAll(filepath.Join(tmp, rel), os.ModePerm)
+	})
+	if err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return tx.dir.discard(top) })
+	tx.dir.record(replica.OpMkdir, dirName, "")
+	return nil
+}
+
+func (tx *batchTx) touch(name string, t *time.Time) error {
+	fileName, err := tx.dir.lookup(name, true)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		err = tx.place(fileName, func(tmp string) error {
+			file, err := os.Create(tmp)
+			if err != nil {
+				return err
+			}
+			return file.Close()
+		})
+		if err == nil && t != nil {
+			err = os.Chtimes(fileName, *t, *t)
+		}
+		if err != nil {
+			return err
+		}
+		tx.undo = append(tx.undo, func() error { return os.Remove(fileName) })
+		tx.dir.record(replica.OpTouch, fileName, "")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	mtime := time.Now()
+	if t != nil {
+		mtime = *t
+	}
+	err = tx.dir.Chtimes(name, mtime, mtime)
+	if err != nil {
+		return err
+	}
+	old := info.ModTime()
+	tx.undo = append(tx.undo, func() error { return os.Chtimes(fileName, old, old) })
+	return nil
+}
+
+func (tx *batchTx) write(name string, data []byte) error {
+	fileName, err := tx.dir.lookup(name, false)
+	if err != nil {
+		return err
+	}
+	staged := ""
+	info, err := os.Lstat(fileName)
+	switch {
+	case err == nil && info.Mode().IsRegular():
+		staged, err = tx.stage(fileName, true)
+		if err != nil {
+			return err
+		}
+	case err != nil && !os.IsNotExist(err):
+		return err
+	}
+
+	writer, err := tx.dir.Create(name, false)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error {
+		if staged == "" {
+			return tx.dir.discard(fileName)
+		}
+		return tx.putBack(staged, fileName)
+	})
+	if staged != "" {
+		tx.versions = append(tx.versions, stagedVersion{fileName, staged, versions.ReasonModify})
+	}
+	return nil
+}
+
+func (tx *batchTx) rm(name string) error {
+	fileName, err := tx.dir.lookup(name, false)
+	if err != nil {
+		return err
+	}
+	if fileName == "/" {
+		return ErrRemoveRoot
+	}
+	_, err = os.Lstat(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	staged, err := tx.stage(fileName, false)
+	if err != nil {
+		return err
+	}
+	tx.versions = append(tx.versions, stagedVersion{fileName, staged, versions.ReasonDelete})
+	tx.undo = append(tx.undo, func() error { return os.Rename(staged, fileName) })
+	tx.dir.record(replica.OpRm, fileName, "")
+	return nil
+}
+
+func (tx *batchTx) mv(from, to string, overwrite bool) error {
+	from, err := tx.dir.lookup(from, false)
+	if err != nil {
+		return err
+	}
+	to, err = tx.dir.lookup(to, false)
+	if err != nil {
+		return err
+	}
+	if from == "/" {
+		return ErrMoveRoot
+	}
+	if _, err := os.Lstat(from); err != nil {
+		return err
+	}
+
+	staged := ""
+	_, err = os.Lstat(to)
+	switch {
+	case err == nil && !overwrite:
+		return ErrExists
+	case err == nil:
+		staged, err = tx.stage(to, false)
+		if err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	err = os.Rename(from, to)
+	if err != nil {
+		if staged != "" {
+			os.Rename(staged, to)
+		}
+		return err
+	}
+	tx.undo = append(tx.undo, func() error {
+		err := os.Rename(to, from)
+		if err != nil || staged == "" {
+			return err
+		}
+		return os.Rename(staged, to)
+	})
+	if staged != "" {
+		tx.versions = append(tx.versions, stagedVersion{to, staged, versions.ReasonModify})
+	}
+	tx.dir.record(replica.OpMv, from, to)
+	return nil
+}
+
+func (tx *batchTx) chmod(name string, mode os.FileMode) error {
+	fileName, err := tx.dir.lookup(name, true)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	err = tx.dir.Chmod(name, mode)
+	if err != nil {
+		return err
+	}
+	old := info.Mode().Perm()
+	tx.undo = append(tx.undo, func() error { return os.Chmod(fileName, old) })
+	return nil
+}
+
+// stagingDirs are where stage and place try to put things, the root first
+// so a staging directory doesn't end up inside what a later op moves.
+func (tx *batchTx) stagingDirs(fileName string) []string {
+	return []string{tx.dir.config.Root, filepath.Dir(fileName)}
+}
+
+// stagingName is a new name in the staging directory of dirName.
+func (tx *batchTx) stagingName(dirName string) (string, error) {
+	staging, ok := tx.staging[dirName]
+	if !ok {
+		var err error
+		staging, err = os.MkdirTemp(dirName, commands.TempBatch+"*")
+		if err != nil {
+			return "", err
+		}
+		tx.staging[dirName] = staging
+	}
+	tx.count++
+	return filepath.Join(staging, strconv.Itoa(tx.count)), nil
+}
+
+// stage moves fileName into a staging directory, or with keep links or
+// copies it there, and returns where it is now.
+func (tx *batchTx) stage(fileName string, keep bool) (string, error) {
+	var err error
+	for _, dirName := range tx.stagingDirs(fileName) {
+		var staged string
+		staged, err = tx.stagingName(dirName)
+		if err != nil {
+			continue
+		}
+		if keep {
+			err = linkOrCopy(fileName, staged)
+		} else {
+			err = os.Rename(fileName, staged)
+		}
+		if err == nil || !errors.Is(err, syscall.EXDEV) {
+			return staged, err
+		}
+	}
+	return "", err
+}
+
+// place makes something new in a staging directory with build and renames
+// it to fileName.
+func (tx *batchTx) place(fileName string, build func(tmp string) error) error {
+	var err error
+	for _, dirName := range tx.stagingDirs(fileName) {
+		var tmp string
+		tmp, err = tx.stagingName(dirName)
+		if err != nil {
+			continue
+		}
+		err = build(tmp)
+		if err == nil {
+			err = os.Rename(tmp, fileName)
+		}
+		if err == nil || !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// putBack replaces fileName with what was staged from it.
+func (tx *batchTx) putBack(staged, fileName string) error {
+	blobs := tx.dir.config.Blobs
+	if blobs == nil {
+		return os.Rename(staged, fileName)
+	}
+	refs := blobs.References(fileName)
+	err := os.Rename(staged, fileName)
+	if err != nil {
+		return err
+	}
+	return blobs.Release(refs)
+}
+
+// linkOrCopy puts fileName at staged as well, with its mode and times.
+func linkOrCopy(fileName, staged string) error {
+	if os.Link(fileName, staged) == nil {
+		return nil
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = copyFile(file, fileName)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(staged, info.ModTime(), info.ModTime())
+}
+
+// saveVersions keeps versions of what the done batch replaced or removed,
+// as far as it can.
+func (tx *batchTx) saveVersions() error {
+	store := tx.dir.config.Versions
+	if store == nil {
+		return nil
+	}
+	var first error
+	for _, v := range tx.versions {
+		if err := store.SaveFrom(v.fileName, v.staged, v.reason); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// dropStaging removes the staging directories with what is left in them.
+func (tx *batchTx) dropStaging() {
+	for _, staging := range tx.staging {
+		tx.dir.discard(staging)
+	}
+}
+
+// rollback undoes the done ops in reverse, as far as it can.
+func (tx *batchTx) rollback() error {
+	var first error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil && first == nil {
+			first = err
+		}
+	}
+	tx.dropStaging()
+	return first
+}
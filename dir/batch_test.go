@@ -0,0 +1,281 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/blobstore"
+	"files_server/dir"
+	"files_server/versions"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type batchResponse struct {
+	Results []struct {
+		Op     string `json:"op"`
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	} `json:"results"`
+	RolledBack bool `json:"rolled_back"`
+}
+
+func statuses(res batchResponse) []int {
+	out := []int{}
+	for _, result := range res.Results {
+		out = append(out, result.Status)
+	}
+	return out
+}
+
+// tree is every path below root with the content of files, "/" for
+// directories. Staging directories left behind would show up as well.
+func tree(t *testing.T, root string) map[string]string {
+	out := map[string]string{}
+	require.NoError(t, filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		require.NoError(t, err)
+		rel, err := filepath.Rel(root, path)
+		require.NoError(t, err)
+		if rel == "." {
+			return nil
+		}
+		if info.IsDir() {
+			out[rel] = "/"
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		out[rel] = string(data)
+		return nil
+	}))
+	return out
+}
+
+func TestBatch(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "old.txt"), []byte("old"), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	ops := `[
+		{"op": "mkdir", "path": "build/out"},
+		{"op": "write", "path": "build/out/a.txt", "content": "a"},
+		{"op": "write", "path": "build/out/b.bin", "content": "AAEC", "base64": true},
+		{"op": "touch", "path": "build/stamp", "time": "2024-01-02T03:04:05Z"},
+		{"op": "chmod", "path": "build/out/a.txt", "mode": "600"},
+		{"op": "mv", "from": "old.txt", "to": "build/old.txt"},
+		{"op": "rm", "path": "missing/file.txt"},
+		{"op": "mv", "from": "build/old.txt", "to": "build/out/a.txt"},
+		{"op": "touch", "path": "nowhere/file.txt"}
+	]`
+	resp, body := doRequest(t, testServer, http.MethodPost, "/batch", ops, nil)
+	require.Equal(t, http.StatusMultiStatus, resp.StatusCode, body)
+	res := batchResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, []int{200, 200, 200, 200, 200, 200, 200, http.StatusConflict, http.StatusNotFound}, statuses(res))
+	require.Equal(t, "mv", res.Results[7].Op)
+	require.NotEmpty(t, res.Results[7].Error)
+	require.False(t, res.RolledBack)
+
+	require.Equal(t, map[string]string{
+		"build":           "/",
+		"build/out":       "/",
+		"build/out/a.txt": "a",
+		"build/out/b.bin": "\x00\x01\x02",
+		"build/stamp":     "",
+		"build/old.txt":   "old",
+	}, tree(t, root))
+	info, err := os.Stat(filepath.Join(root, "build/out/a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	info, err = os.Stat(filepath.Join(root, "build/stamp"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1704164645), info.ModTime().Unix())
+
+	tests := []struct {
+		name            string
+		method          string
+		body            string
+		expected_status int
+	}{
+		{name: "get", method: http.MethodGet, body: `[{"op": "mkdir", "path": "x"}]`, expected_status: http.StatusMethodNotAllowed},
+		{name: "not_json", method: http.MethodPost, body: `mkdir x`, expected_status: http.StatusBadRequest},
+		{name: "empty", method: http.MethodPost, body: `[]`, expected_status: http.StatusBadRequest},
+		{name: "unknown_op", method: http.MethodPost, body: `[{"op": "mkdir", "path": "x"}, {"op": "format"}]`, expected_status: http.StatusBadRequest},
+		{name: "no_path", method: http.MethodPost, body: `[{"op": "rm"}]`, expected_status: http.StatusBadRequest},
+		{name: "bad_mode", method: http.MethodPost, body: `[{"op": "chmod", "path": "x", "mode": "rwx"}]`, expected_status: http.StatusBadRequest},
+		{name: "bad_base64", method: http.MethodPost, body: `[{"op": "write", "path": "x", "content": "!", "base64": true}]`, expected_status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := doRequest(t, testServer, test.method, "/batch", test.body, nil)
+			require.Equal(t, test.expected_status, resp.StatusCode, body)
+			// Nothing of an invalid batch runs.
+			_, err := os.Stat(filepath.Join(root, "x"))
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestBatchAtomic(t *testing.T) {
+	root := t.TempDir()
+	blobs, err := blobstore.Open(t.TempDir())
+	require.NoError(t, err)
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Blobs: blobs}))
+	defer testServer.Close()
+
+	setup := `[
+		{"op": "mkdir", "path": "site"},
+		{"op": "write", "path": "site/index.html", "content": "v1"},
+		{"op": "write", "path": "site/copy.html", "content": "v1"},
+		{"op": "write", "path": "site/old.css", "content": "css"},
+		{"op": "chmod", "path": "site/old.css", "mode": "640"}
+	]`
+	resp, body := doRequest(t, testServer, http.MethodPost, "/batch?atomic=true", setup, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	before := tree(t, root)
+
+	failing := `[
+		{"op": "mkdir", "path": "site/assets/img"},
+		{"op": "write", "path": "site/index.html", "content": "v2"},
+		{"op": "write", "path": "site/assets/app.js", "content": "js"},
+		{"op": "chmod", "path": "site/old.css", "mode": "600"},
+		{"op": "rm", "path": "site/old.css"},
+		{"op": "mv", "from": "site/copy.html", "to": "site/index.html", "overwrite": true},
+		{"op": "touch", "path": "site/new.txt"},
+		{"op": "mv", "from": "site/missing.html", "to": "site/other.html"},
+		{"op": "mkdir", "path": "never"}
+	]`
+	resp, body = doRequest(t, testServer, http.MethodPost, "/batch?atomic=true", failing, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, body)
+	res := batchResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, []int{200, 200, 200, 200, 200, 200, 200, http.StatusNotFound, http.StatusFailedDependency}, statuses(res))
+	require.True(t, res.RolledBack)
+	require.Equal(t, before, tree(t, root))
+	info, err := os.Stat(filepath.Join(root, "site/old.css"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// The blobs of the undone writes are gone.
+	stats, err := blobs.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Blobs)
+	require.Equal(t, int64(3), stats.References)
+	require.Equal(t, 0, stats.OrphanBlobs)
+
+	succeeding := `[
+		{"op": "mkdir", "path": "site/assets"},
+		{"op": "write", "path": "site/assets/app.js", "content": "js"},
+		{"op": "rm", "path": "site/old.css"},
+		{"op": "mv", "from": "site/copy.html", "to": "site/index.html", "overwrite": true}
+	]`
+	resp, body = doRequest(t, testServer, http.MethodPost, "/batch?atomic=true", succeeding, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	require.Equal(t, map[string]string{
+		"site":               "/",
+		"site/assets":        "/",
+		"site/assets/app.js": "js",
+		"site/index.html":    "v1",
+	}, tree(t, root))
+
+}
+
+func TestBatchAtomicConcurrent(t *testing.T) {
+	root := t.TempDir()
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	// An undone batch must not take a change made while it ran with it.
+	expected := map[string]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		ops := []string{fmt.Sprintf(`{"op": "mkdir", "path": "%d"}`, i)}
+		for j := 0; j < 50; j++ {
+			ops = append(ops, fmt.Sprintf(`{"op": "write", "path": "%d/%d.txt", "content": "x"}`, i, j))
+		}
+		ops = append(ops, `{"op": "mv", "from": "missing.txt", "to": "other.txt"}`)
+		failing := "[" + strings.Join(ops, ",") + "]"
+		plain := fmt.Sprintf(`[{"op": "mkdir", "path": "%d/plain"}]`, i)
+		expected[fmt.Sprint(i)] = "/"
+		expected[fmt.Sprintf("%d/plain", i)] = "/"
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			resp, body := doRequest(t, testServer, http.MethodPost, "/batch?atomic=true", failing, nil)
+			require.Equal(t, http.StatusNotFound, resp.StatusCode, body)
+		}()
+		go func() {
+			defer wg.Done()
+			resp, body := doRequest(t, testServer, http.MethodPost, "/batch", plain, nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, expected, tree(t, root))
+}
+
+func TestBatchAtomicVersions(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs", "old"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "b.txt"), []byte("bb"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "c.txt"), []byte("ccc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "old", "d.txt"), []byte("dddd"), 0644))
+	store, err := versions.Open(t.TempDir(), 0, nil)
+	require.NoError(t, err)
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Versions: store}))
+	defer testServer.Close()
+	resp, body := doRequest(t, testServer, http.MethodGet, "/versions/policy?dir=docs&keep=5", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+
+	// Nothing is kept of a batch that is undone.
+	failing := `[
+		{"op": "write", "path": "docs/a.txt", "content": "new"},
+		{"op": "rm", "path": "docs/b.txt"},
+		{"op": "mv", "from": "docs/c.txt", "to": "docs/a.txt", "overwrite": true},
+		{"op": "mv", "from": "docs/missing.txt", "to": "docs/other.txt"}
+	]`
+	resp, body = doRequest(t, testServer, http.MethodPost, "/batch?atomic=true", failing, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode, body)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		require.Empty(t, listVersions(t, testServer, "docs/"+name), name)
+	}
+
+	succeeding := `[
+		{"op": "write", "path": "docs/a.txt", "content": "new"},
+		{"op": "rm", "path": "docs/b.txt"},
+		{"op": "mv", "from": "docs/c.txt", "to": "docs/a.txt", "overwrite": true},
+		{"op": "rm", "path": "docs/old"}
+	]`
+	resp, body = doRequest(t, testServer, http.MethodPost, "/batch?atomic=true", succeeding, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	reasons := func(name string) []string {
+		out := []string{}
+		for _, v := range listVersions(t, testServer, name) {
+			out = append(out, fmt.Sprintf("%s %d", v.Reason, v.Size))
+		}
+		return out
+	}
+	require.Equal(t, []string{"modify 1", "modify 3"}, reasons("docs/a.txt"))
+	require.Equal(t, []string{"delete 2"}, reasons("docs/b.txt"))
+	require.Equal(t, []string{"delete 4"}, reasons("docs/old/d.txt"))
+	require.Equal(t, map[string]string{"docs": "/", "docs/a.txt": "ccc"}, tree(t, root))
+
+	// Staging directories of a running batch are not listed.
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".batch-1", "docs"), 0755))
+	resp, body = doRequest(t, testServer, http.MethodGet, "/ls?hide=true", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	require.NotContains(t, body, ".batch-")
+	resp, body = doRequest(t, testServer, http.MethodGet, "/find?hide=true&name=docs", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	require.NotContains(t, body, ".batch-")
+	require.Contains(t, body, "docs")
+}
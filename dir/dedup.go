@@ -0,0 +1,176 @@
+package dir
+
+import (
+	"encoding/json"
+	"files_server/commands"
+	"files_server/versions"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// replace runs write, which puts new content at fileName, and moves the
+// result into the blob store. The file is already in place by then, so a
+// failure of the store only means it is not deduplicated. The old content
+// is kept as a version first if fileName is in a versioned directory.
+func (currentDir *Dir) replace(fileName string, write func() error) error {
+	err := currentDir.saveVersion(fileName, versions.ReasonModify)
+	if err != nil {
+		return err
+	}
+	blobs := currentDir.config.Blobs
+	if blobs == nil {
+		return write()
+	}
+	refs := blobs.References(fileName)
+	err = write()
+	if err != nil {
+		return err
+	}
+	blobs.Put(fileName)
+	return blobs.Release(refs)
+}
+
+// removeAll is os.RemoveAll that also frees blobs which lost their last
+// reference and keeps versions of the removed files.
+func (currentDir *Dir) removeAll(fileName string) error {
+	err := currentDir.saveVersion(fileName, versions.ReasonDelete)
+	if err != nil {
+		return err
+	}
+	return currentDir.discard(fileName)
+}
+
+// discard is os.RemoveAll that frees blobs which lost their last reference,
+// without keeping versions.
+func (currentDir *Dir) discard(fileName string) error {
+	if currentDir.config.Blobs == nil {
+		return os.RemoveAll(fileName)
+	}
+	refs := currentDir.config.Blobs.References(fileName)
+	err := os.RemoveAll(fileName)
+	if err != nil {
+		return err
+	}
+	return currentDir.config.Blobs.Release(refs)
+}
+
+// detach gives the file at fileName an inode of its own if it is a
+// reference to a blob, so changing its attributes doesn't change every file
+// with the same content. reattach deduplicates it again after the change.
+func (currentDir *Dir) detach(fileName string) error {
+	blobs := currentDir.config.Blobs
+	if blobs == nil {
+		return nil
+	}
+	info, err := os.Lstat(fileName)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	refs := blobs.References(fileName)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), commands.TempAttr+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = copyFile(tmp, fileName)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), fileName)
+	if err != nil {
+		return err
+	}
+	return blobs.Release(refs)
+}
+
+// reattach puts fileName back into the blob store once its attributes
+// changed, to share a blob with the files that have the same content and
+// attributes. Failing only means it isn't deduplicated.
+func (currentDir *Dir) reattach(fileName string) {
+	if currentDir.config.Blobs != nil {
+		currentDir.config.Blobs.Put(fileName)
+	}
+}
+
+func (currentDir *Dir) saveVersion(fileName, reason string) error {
+	// An atomic batch keeps versions from what it staged once it is done.
+	if currentDir.config.Versions == nil || currentDir.journal != nil {
+		return nil
+	}
+	return currentDir.config.Versions.Save(fileName, reason)
+}
+
+func (currentDir *Dir) dedup(w http.ResponseWriter, r *http.Request) {
+	blobs := currentDir.config.Blobs
+	if blobs == nil {
+		http.Error(w, "Deduplication is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	var res interface{}
+	var err error
+	switch r.URL.Path {
+	case "/dedup/stats":
+		res, err = blobs.Stats()
+	case "/dedup/gc":
+		res, err = blobs.GC()
+	case "/dedup/ingest":
+		res, err = currentDir.ingest(r)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+type ingestResult struct {
+	Files  int      `json:"files"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ingest turns the existing files below dir into blob references.
+func (currentDir *Dir) ingest(r *http.Request) (ingestResult, error) {
+	result := ingestResult{}
+	dirName, err := currentDir.lookup(r.URL.Query().Get("dir"), true)
+	if err != nil {
+		return result, err
+	}
+	options := commands.NewFindOptions()
+	options.ShowHidden = true
+	options.Type = commands.TypeFile
+	err = commands.Find(dirName, options, func(match commands.Match) error {
+		unlock := currentDir.mutating()
+		_, err := currentDir.config.Blobs.Put(filepath.Join(dirName, match.Path))
+		unlock()
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", match.Path, err))
+			return nil
+		}
+		result.Files++
+		return r.Context().Err()
+	})
+	return result, err
+}
@@ -0,0 +1,129 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/blobstore"
+	"files_server/dir"
+	"files_server/upload"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func uploadFile(t *testing.T, testServer *httptest.Server, fileName, content string) {
+	resp, id := doRequest(t, testServer, http.MethodPost, "/upload?filename="+fileName+"&length="+strconv.Itoa(len(content)), "", nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodPatch, "/upload?id="+id, content, map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodPost, "/upload/finish?id="+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func dedupStats(t *testing.T, testServer *httptest.Server) blobstore.Stats {
+	resp, body := doRequest(t, testServer, http.MethodGet, "/dedup/stats", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stats := blobstore.Stats{}
+	require.NoError(t, json.Unmarshal([]byte(body), &stats))
+	return stats
+}
+
+func TestDedup(t *testing.T) {
+	root := t.TempDir()
+	blobs, err := blobstore.Open(filepath.Join(root, ".blobs"))
+	require.NoError(t, err)
+	uploads, err := upload.NewStore(t.TempDir(), 0, nil)
+	require.NoError(t, err)
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Uploads: uploads, Blobs: blobs}))
+	defer testServer.Close()
+
+	uploadFile(t, testServer, "build1.bin", "artifact")
+	uploadFile(t, testServer, "build2.bin", "artifact")
+	uploadFile(t, testServer, "build3.bin", "different")
+
+	// Hard links share the mtime, so only files with the same one share a
+	// blob.
+	stats := dedupStats(t, testServer)
+	require.Equal(t, 3, stats.Blobs)
+	require.Equal(t, int64(0), stats.SavedBytes)
+	mtime := "2024-01-02T03:04:05Z"
+	for _, name := range []string{"build1.bin", "build2.bin"} {
+		resp, _ := doRequest(t, testServer, http.MethodGet, "/touch?filename="+name+"&time="+mtime, "", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	stats = dedupStats(t, testServer)
+	require.Equal(t, 2, stats.Blobs)
+	require.Equal(t, int64(3), stats.References)
+	require.Equal(t, int64(8), stats.SavedBytes)
+
+	uploadFile(t, testServer, "build3.bin", "artifact")
+	info, err := os.Stat(filepath.Join(root, "build3.bin"))
+	require.NoError(t, err)
+	require.NotEqual(t, 2024, info.ModTime().Year(), "the upload keeps its own mtime")
+	require.Equal(t, 2, dedupStats(t, testServer).Blobs)
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/touch?filename=build3.bin&time="+mtime, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stats = dedupStats(t, testServer)
+	require.Equal(t, 1, stats.Blobs)
+	require.Equal(t, int64(3), stats.References)
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/rm?filename=build1.bin", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 1, dedupStats(t, testServer).Blobs)
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/rm?filename=build2.bin", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/rm?filename=build3.bin", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, blobstore.Stats{}, dedupStats(t, testServer))
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/dedup/gc", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `{"removed":0,"freed_bytes":0}`, body)
+}
+
+func TestDedupIngest(t *testing.T) {
+	root := t.TempDir()
+	blobs, err := blobstore.Open(filepath.Join(root, ".blobs"))
+	require.NoError(t, err)
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []struct {
+		name  string
+		mode  os.FileMode
+		mtime time.Time
+	}{
+		{name: "a.bin", mode: 0644, mtime: mtime},
+		{name: "b.bin", mode: 0644, mtime: mtime},
+		{name: "c.bin", mode: 0600, mtime: mtime},
+		{name: "d.bin", mode: 0644, mtime: mtime.Add(time.Hour)},
+	}
+	for _, file := range files {
+		fileName := filepath.Join(root, "tree", file.name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fileName), 0755))
+		require.NoError(t, os.WriteFile(fileName, []byte("same"), file.mode))
+		require.NoError(t, os.Chmod(fileName, file.mode))
+		require.NoError(t, os.Chtimes(fileName, file.mtime, file.mtime))
+	}
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Blobs: blobs}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/dedup/ingest?dir=tree", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `{"files":4}`, body)
+	stats := dedupStats(t, testServer)
+	require.Equal(t, 3, stats.Blobs)
+	require.Equal(t, int64(4), stats.References)
+
+	// Nothing that stat shows changed.
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(root, "tree", file.name))
+		require.NoError(t, err)
+		require.Equal(t, file.mode, info.Mode().Perm(), file.name)
+		require.True(t, file.mtime.Equal(info.ModTime()), file.name)
+	}
+}
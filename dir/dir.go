@@ -1,20 +1,62 @@
 package dir
 
 import (
-	"encoding/json"
+	"files_server/blobstore"
+	"files_server/checksum"
 	"files_server/commands"
+	"files_server/disk"
+	"files_server/index"
+	"files_server/preview"
+	"files_server/replica"
+	"files_server/share"
+	"files_server/upload"
+	"files_server/versions"
+	"files_server/watch"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+type Config struct {
+	Root     string
+	Index    *index.Index
+	Watch    *watch.Hub
+	Uploads  *upload.Store
+	Hashes   *checksum.Cache
+	Blobs    *blobstore.Store
+	Versions *versions.Store
+	Shares   *share.Store
+	// DiskUsage caches /du, nil reads everything every time.
+	DiskUsage *disk.Cache
+	// Symlinks is the symlink policy, empty is SymlinkFollow.
+	Symlinks SymlinkPolicy
+	// Thumbs caches /thumb, nil makes every thumbnail again.
+	Thumbs *preview.Cache
+	// ArchiveMaxSize caps the file content of one /archive, 0 is no cap.
+	ArchiveMaxSize int64
+	// Replication logs the changes below Root for followers, nil logs
+	// nothing.
+	Replication *replica.Log
+}
+
+const defaultRoot = "/Users"
+
 type Dir struct {
-	path string
+	path   string
+	config *Config
+	// journal collects the log entries of an atomic batch until it is done.
+	journal *[]replica.Entry
 }
 
 func New() *Dir {
-	return &Dir{path: "/Users"}
+	return NewWithConfig(&Config{Root: defaultRoot, Hashes: checksum.NewCache(0)})
+}
+
+func NewWithConfig(config *Config) *Dir {
+	return &Dir{path: config.Root, config: config}
 }
 
 func (currentDir *Dir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -31,49 +73,108 @@ func (currentDir *Dir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		currentDir.ls(w, r)
 	case "/cd":
 		currentDir.cd(w, r)
+	case "/mv":
+		currentDir.mv(w, r)
+	case "/download":
+		currentDir.download(w, r)
+	case "/find":
+		currentDir.find(w, r)
+	case "/index":
+		currentDir.indexStatus(w)
+	case "/watch":
+		currentDir.watch(w, r)
+	case "/shell":
+		currentDir.shell(w, r)
+	case "/archive":
+		currentDir.archive(w, r)
+	case "/upload":
+		currentDir.upload(w, r)
+	case "/upload/finish":
+		currentDir.finishUpload(w, r)
+	case "/hash":
+		currentDir.hash(w, r)
+	case "/dedup/stats", "/dedup/gc", "/dedup/ingest":
+		currentDir.dedup(w, r)
+	case "/versions", "/versions/get", "/versions/diff", "/versions/restore", "/versions/policy":
+		currentDir.versions(w, r)
+	case "/share", "/share/list", "/share/revoke":
+		currentDir.share(w, r)
+	case "/stat":
+		currentDir.stat(w, r)
+	case "/chmod":
+		currentDir.chmod(w, r)
+	case "/chown":
+		currentDir.chown(w, r)
+	case "/ln":
+		currentDir.ln(w, r)
+	case "/readlink":
+		currentDir.readlink(w, r)
+	case "/cat":
+		currentDir.cat(w, r)
+	case "/tail":
+		currentDir.tail(w, r)
+	case "/edit":
+		currentDir.edit(w, r)
+	case "/thumb":
+		currentDir.thumb(w, r)
+	case "/preview":
+		currentDir.preview(w, r)
+	case "/batch":
+		currentDir.batch(w, r)
+	case "/sync/manifest", "/sync/diff", "/sync/apply", "/sync/signature", "/sync/file", "/sync/delta":
+		currentDir.sync(w, r)
+	case "/replica/log", "/replica/file", "/replica/manifest":
+		currentDir.replica(w, r)
+	case "/du":
+		currentDir.du(w, r)
+	case "/df":
+		currentDir.df(w)
 	default:
 		http.Error(w, "Not found", http.StatusNotFound)
 	}
 }
 
+func (currentDir *Dir) resolve(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name
+	}
+	return filepath.Join(currentDir.path, name)
+}
+
 func (currentDir *Dir) pwd(w http.ResponseWriter) {
-	w.Write([]byte(currentDir.path))
+	w.Write([]byte(currentDir.Pwd()))
 }
 
 func (currentDir *Dir) cd(w http.ResponseWriter, r *http.Request) {
-	dir := r.URL.Query().Get("dir")
-
-	if !strings.HasPrefix(dir, "/") {
-		dir = filepath.Join(currentDir.path, dir)
-	}
-	fileInfo, err := os.Stat(dir)
+	err := currentDir.Cd(r.URL.Query().Get("dir"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
 	}
-	if !fileInfo.IsDir() {
-		http.Error(w, "Not directory", http.StatusBadRequest)
-		return
-	}
-
-	currentDir.path = dir
 }
 
+// ls writes the current directory as a JSON array of names, or of entries
+// with meta=true. format= or the Accept header choose another format, see
+// lsFormats, and sort=false streams huge directories in directory order.
 func (currentDir *Dir) ls(w http.ResponseWriter, r *http.Request) {
-	hide := false
-	if r.URL.Query().Get("hide") == "true" {
-		hide = true
-	}
-	dir, err := commands.Ls(currentDir.path, hide)
+	format, err := lsFormatName(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	res, err := json.Marshal(dir)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	meta := r.URL.Query().Get("meta") == "true"
+	if meta && (format == "" || format == "json") && currentDir.indexed(currentDir.path) {
+		currentDir.lsMeta(w, r)
+		return
+	}
+	options := commands.LsOptions{ShowHidden: showHidden(r), Unsorted: r.URL.Query().Get("sort") == "false", Preview: lsPreviews(r)}
+	if r.URL.Query().Get("hashes") == "true" && currentDir.config.Hashes != nil {
+		options.Hashes = currentDir.config.Hashes.Cached
 	}
-	w.Write(res)
+	currentDir.writeLs(w, r, currentDir.path, format, options, meta)
+}
+
+func showHidden(r *http.Request) bool {
+	return r.URL.Query().Get("hide") == "true"
 }
 
 func (currentDir *Dir) mkdir(w http.ResponseWriter, r *http.Request) {
@@ -83,15 +184,7 @@ func (currentDir *Dir) mkdir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.HasPrefix(dirName, "/") {
-		dirName = filepath.Join(currentDir.path, dirName)
-	}
-
-	if dirName == "/" {
-		return
-	}
-
-	err := os.MkdirAll(dirName, os.ModePerm)
+	err := currentDir.Mkdir(dirName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -104,24 +197,24 @@ func (currentDir *Dir) touch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.HasPrefix(fileName, "/") {
-		fileName = filepath.Join(currentDir.path, fileName)
-	}
-
-	_, err := os.Stat(fileName)
-	if !os.IsNotExist(err) {
+	// time sets the times to something else than now, like touch -d.
+	var mtime time.Time
+	if t := r.URL.Query().Get("time"); t != "" {
+		var err error
+		mtime, err = time.Parse(time.RFC3339, t)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		return
 	}
 
-	file, err := os.Create(fileName)
+	err := currentDir.Touch(fileName)
+	if err == nil && !mtime.IsZero() {
+		err = currentDir.Chtimes(fileName, mtime, mtime)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		fileError(w, err)
 	}
-	file.Close()
 }
 
 func (currentDir *Dir) rm(w http.ResponseWriter, r *http.Request) {
@@ -131,17 +224,68 @@ func (currentDir *Dir) rm(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.HasPrefix(fileName, "/") {
-		fileName = filepath.Join(currentDir.path, fileName)
+	err := currentDir.Rm(fileName)
+	if err == ErrRemoveRoot {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (currentDir *Dir) mv(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from := query.Get("from")
+	to := query.Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "No from or to", http.StatusBadRequest)
+		return
+	}
+
+	err := currentDir.Mv(from, to, query.Get("overwrite") == "true")
+	switch {
+	case err == nil:
+	case err == ErrMoveRoot:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err == ErrExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case os.IsNotExist(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
+}
 
-	if fileName == "/" {
-		http.Error(w, "Can't delete root directory", http.StatusBadRequest)
+// download serves a file with range and conditional request support.
+// inline=true lets a browser show it instead of saving it.
+func (currentDir *Dir) download(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
 		return
 	}
 
-	err := os.RemoveAll(fileName)
+	file, err := currentDir.Open(fileName)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fileInfo.IsDir() {
+		http.Error(w, ErrIsDir.Error(), http.StatusBadRequest)
+		return
+	}
+
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "true" {
+		disposition = "inline"
 	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, fileInfo.Name()))
+	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
 }
@@ -1,13 +1,17 @@
 package dir_test
 
 import (
+	"encoding/json"
+	"files_server/commands"
 	"files_server/dir"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -197,6 +201,164 @@ func TestLs(t *testing.T) {
 	}
 }
 
+func TestLsFormats(t *testing.T) {
+	root, testServer := initTestEnv(t)
+	defer testServer.Close()
+	defer os.RemoveAll(root)
+
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a <b>.txt"), make([]byte, 2048), 0644))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "a <b>.txt"), mtime, mtime))
+	stamp := mtime.UTC().Format(time.RFC3339)
+
+	testCases := []struct {
+		name         string
+		query        string
+		accept       string
+		status       int
+		content_type string
+		check        func(t *testing.T, body string)
+	}{
+		{
+			name:   "Default",
+			status: http.StatusOK,
+			check: func(t *testing.T, body string) {
+				require.Equal(t, "[\"docs\",\"a \\u003cb\\u003e.txt\"]", body)
+			},
+		},
+		{
+			name:         "Json accept",
+			accept:       "application/json",
+			status:       http.StatusOK,
+			content_type: "application/json",
+			check: func(t *testing.T, body string) {
+				require.Equal(t, "[\"docs\",\"a \\u003cb\\u003e.txt\"]", body)
+			},
+		},
+		{
+			name:         "Ndjson",
+			query:        "format=ndjson",
+			status:       http.StatusOK,
+			content_type: "application/x-ndjson",
+			check: func(t *testing.T, body string) {
+				lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+				require.Len(t, lines, 2)
+				entry := commands.Entry{}
+				require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+				require.Equal(t, "a <b>.txt", entry.Name)
+				require.Equal(t, int64(2048), entry.Size)
+				require.True(t, mtime.Equal(entry.ModTime))
+			},
+		},
+		{
+			name:         "Csv",
+			accept:       "text/html;q=0.5, text/csv",
+			status:       http.StatusOK,
+			content_type: "text/csv; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				lines := strings.Split(body, "\n")
+				require.Equal(t, "name,type,size,mtime", lines[0])
+				require.Regexp(t, "^docs,dir,[0-9]+,", lines[1])
+				require.Equal(t, "a <b>.txt,file,2048,"+stamp, lines[2])
+			},
+		},
+		{
+			name:         "Text",
+			query:        "format=text",
+			accept:       "text/csv",
+			status:       http.StatusOK,
+			content_type: "text/plain; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+				require.Len(t, lines, 2)
+				require.Regexp(t, "^drwxr-xr-x .* docs$", lines[0])
+				require.Equal(t, "-rw-r--r--  2.0K "+mtime.Format("Jan _2 15:04")+" a <b>.txt", lines[1])
+			},
+		},
+		{
+			name:         "Html",
+			accept:       "text/html,application/xhtml+xml,*/*;q=0.8",
+			status:       http.StatusOK,
+			content_type: "text/html; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				require.True(t, strings.HasPrefix(body, "<table"))
+				require.Contains(t, body, "<td class=\"name\">a &lt;b&gt;.txt</td>")
+				require.Contains(t, body, "data-bytes=\"2048\">2.0K</td>")
+				require.True(t, strings.HasSuffix(body, "</table>\n"))
+			},
+		},
+		{
+			name:         "Unsorted",
+			query:        "format=ndjson&sort=false",
+			status:       http.StatusOK,
+			content_type: "application/x-ndjson",
+			check: func(t *testing.T, body string) {
+				require.Len(t, strings.Split(strings.TrimSuffix(body, "\n"), "\n"), 2)
+			},
+		},
+		{
+			name:         "Meta json",
+			query:        "format=json&meta=true",
+			status:       http.StatusOK,
+			content_type: "application/json",
+			check: func(t *testing.T, body string) {
+				entries := []commands.Entry{}
+				require.NoError(t, json.Unmarshal([]byte(body), &entries))
+				require.Len(t, entries, 2)
+			},
+		},
+		{
+			name:   "Meta",
+			query:  "meta=true",
+			status: http.StatusOK,
+			check: func(t *testing.T, body string) {
+				entries := []commands.Entry{}
+				require.NoError(t, json.Unmarshal([]byte(body), &entries))
+				require.Equal(t, "docs", entries[0].Name)
+				require.Equal(t, int64(2048), entries[1].Size)
+			},
+		},
+		{
+			name:   "Unknown accept",
+			accept: "image/png",
+			status: http.StatusOK,
+			check: func(t *testing.T, body string) {
+				require.True(t, strings.HasPrefix(body, "[\"docs\""))
+			},
+		},
+		{
+			name:         "Unknown format",
+			query:        "format=xml",
+			status:       http.StatusBadRequest,
+			content_type: "text/plain; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				require.Equal(t, dir.ErrFormat.Error()+"\n", body)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if testCase.accept != "" {
+				headers["Accept"] = testCase.accept
+			}
+			resp, body := doRequest(t, testServer, http.MethodGet, "/ls?"+testCase.query, "", headers)
+			require.Equal(t, testCase.status, resp.StatusCode)
+			if testCase.content_type != "" {
+				require.Equal(t, testCase.content_type, resp.Header.Get("Content-Type"))
+			}
+			testCase.check(t, body)
+		})
+	}
+
+	// An error before the first entry is still an error response.
+	require.NoError(t, os.RemoveAll(root))
+	resp, body := doRequest(t, testServer, http.MethodGet, "/ls?format=ndjson", "", nil)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, "open "+root+": no such file or directory\n", body)
+}
+
 func TestMkDir(t *testing.T) {
 	testCases := []testCase{
 		{
@@ -550,3 +712,65 @@ func TestRm(t *testing.T) {
 		)
 	}
 }
+
+func TestMv(t *testing.T) {
+	dir, testServer := initTestEnv(t)
+	defer testServer.Close()
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644))
+
+	tests := []struct {
+		name     string
+		path     string
+		expected int
+		file     string
+		content  string
+	}{
+		{name: "no target", path: "/mv?from=a.txt", expected: http.StatusBadRequest},
+		{name: "missing", path: "/mv?from=c.txt&to=d.txt", expected: http.StatusNotFound},
+		{name: "exists", path: "/mv?from=a.txt&to=b.txt", expected: http.StatusConflict, file: "b.txt", content: "b"},
+		{name: "rename", path: "/mv?from=a.txt&to=c.txt", expected: http.StatusOK, file: "c.txt", content: "a"},
+		{name: "overwrite", path: "/mv?from=c.txt&to=b.txt&overwrite=true", expected: http.StatusOK, file: "b.txt", content: "a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := testServer.Client().Get(testServer.URL + test.path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, test.expected, resp.StatusCode)
+			if test.file != "" {
+				content, err := os.ReadFile(filepath.Join(dir, test.file))
+				require.NoError(t, err)
+				require.Equal(t, test.content, string(content))
+			}
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	dir, testServer := initTestEnv(t)
+	defer testServer.Close()
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello world"), 0644))
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/download?filename=a.txt&inline=true", nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=6-")
+	resp, err := testServer.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, `inline; filename="a.txt"`, resp.Header.Get("Content-Disposition"))
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(body))
+
+	resp, err = testServer.Client().Get(testServer.URL + "/download?filename=missing.txt")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,61 @@
+package dir
+
+import (
+	"encoding/json"
+	"files_server/disk"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// du writes the usage of path, the current directory by default, with
+// depth levels of children, 1 by default. hardlinks=true counts files with
+// several links once.
+func (currentDir *Dir) du(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := disk.Options{Depth: 1, Hardlinks: query.Get("hardlinks") == "true"}
+	if depth := query.Get("depth"); depth != "" {
+		var err error
+		options.Depth, err = strconv.Atoi(depth)
+		if err != nil || options.Depth < 0 {
+			http.Error(w, "Bad depth", http.StatusBadRequest)
+			return
+		}
+	}
+	dirName, err := currentDir.lookup(query.Get("path"), true)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	usage, err := currentDir.config.DiskUsage.Usage(r.Context(), dirName, options)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, usage)
+}
+
+// df writes the capacity of the filesystem the current directory is on.
+func (currentDir *Dir) df(w http.ResponseWriter) {
+	space, err := disk.Free(currentDir.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, space)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,66 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/dir"
+	"files_server/disk"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDu(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs", "old"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a.txt"), make([]byte, 100), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "old", "b.txt"), make([]byte, 50), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, DiskUsage: disk.NewCache(0)}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/du", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var usage disk.Usage
+	require.NoError(t, json.Unmarshal([]byte(body), &usage))
+	require.Equal(t, root, usage.Path)
+	require.Equal(t, int64(2), usage.Files)
+	require.Equal(t, int64(3), usage.Dirs)
+	require.Len(t, usage.Children, 1)
+	require.Equal(t, "docs", usage.Children[0].Name)
+	require.Empty(t, usage.Children[0].Children)
+
+	_, body = doRequest(t, testServer, http.MethodGet, "/du?path=docs&depth=2", "", nil)
+	usage = disk.Usage{}
+	require.NoError(t, json.Unmarshal([]byte(body), &usage))
+	require.Equal(t, filepath.Join(root, "docs"), usage.Path)
+	require.Len(t, usage.Children, 1)
+	require.Equal(t, int64(1), usage.Children[0].Files)
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/du?depth=-1", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/du?path=missing", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Without a cache every request reads the tree.
+	uncached := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer uncached.Close()
+	resp, _ = doRequest(t, uncached, http.MethodGet, "/du?hardlinks=true", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestDf(t *testing.T) {
+	root := t.TempDir()
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/df", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var space disk.Space
+	require.NoError(t, json.Unmarshal([]byte(body), &space))
+	require.Equal(t, root, space.Path)
+	require.NotZero(t, space.Total)
+}
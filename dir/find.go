@@ -0,0 +1,125 @@
+package dir
+
+import (
+	"encoding/json"
+	"files_server/commands"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var findTypes = map[string]string{
+	"f":    commands.TypeFile,
+	"file": commands.TypeFile,
+	"d":    commands.TypeDir,
+	"dir":  commands.TypeDir,
+	"l":    commands.TypeSymlink,
+	"link": commands.TypeSymlink,
+}
+
+func (currentDir *Dir) find(w http.ResponseWriter, r *http.Request) {
+	options, err := findOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	root, err := currentDir.lookup(r.URL.Query().Get("dir"), true)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+	find := commands.Find
+	if currentDir.indexed(root) {
+		find = currentDir.config.Index.Find
+	}
+	err = find(root, options, func(match commands.Match) error {
+		started = true
+		err := encoder.Encode(match)
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return r.Context().Err()
+	})
+	if err != nil && !started {
+		w.Header().Del("Content-Type")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func findOptions(r *http.Request) (commands.FindOptions, error) {
+	query := r.URL.Query()
+	options := commands.NewFindOptions()
+	options.Name = query.Get("name")
+	options.ShowHidden = showHidden(r)
+
+	var err error
+	if value := query.Get("regex"); value != "" {
+		options.Regexp, err = regexp.Compile(value)
+		if err != nil {
+			return options, err
+		}
+	}
+	if value := query.Get("grep"); value != "" {
+		options.Grep, err = regexp.Compile(value)
+		if err != nil {
+			return options, err
+		}
+	}
+	if value := query.Get("type"); value != "" {
+		fileType, ok := findTypes[value]
+		if !ok {
+			return options, fmt.Errorf("Unknown type %q", value)
+		}
+		options.Type = fileType
+	}
+	if value := query.Get("minsize"); value != "" {
+		options.MinSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return options, err
+		}
+	}
+	if value := query.Get("maxsize"); value != "" {
+		options.MaxSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return options, err
+		}
+	}
+	if value := query.Get("maxdepth"); value != "" {
+		options.MaxDepth, err = strconv.Atoi(value)
+		if err != nil {
+			return options, err
+		}
+	}
+	if value := query.Get("newer"); value != "" {
+		options.NewerThan, err = parseTime(value)
+		if err != nil {
+			return options, err
+		}
+	}
+	if value := query.Get("older"); value != "" {
+		options.OlderThan, err = parseTime(value)
+		if err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// parseTime accepts RFC 3339 or unix seconds.
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
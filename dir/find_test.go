@@ -0,0 +1,89 @@
+package dir_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"files_server/commands"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFind(t *testing.T) {
+	path, testServer := initTestEnv(t)
+	defer testServer.Close()
+	defer os.RemoveAll(path)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(path, "logs"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "logs", "build.log"), []byte("ok\nerror: failed\n"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "logs", ".hidden.log"), []byte("error\n"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "notes.txt"), []byte("no errors here\n"), 0666))
+
+	testCases := []struct {
+		name            string
+		query           string
+		expected_result int
+		expected_paths  []string
+	}{
+		{
+			name:            "By name",
+			query:           "name=*.log",
+			expected_result: http.StatusOK,
+			expected_paths:  []string{"logs/build.log"},
+		},
+		{
+			name:            "Hidden",
+			query:           "name=*.log&hide=true",
+			expected_result: http.StatusOK,
+			expected_paths:  []string{"logs/.hidden.log", "logs/build.log"},
+		},
+		{
+			name:            "Grep",
+			query:           "grep=^error",
+			expected_result: http.StatusOK,
+			expected_paths:  []string{"logs/build.log"},
+		},
+		{
+			name:            "Type dir",
+			query:           "type=d",
+			expected_result: http.StatusOK,
+			expected_paths:  []string{"logs"},
+		},
+		{
+			name:            "Bad regex",
+			query:           "regex=(",
+			expected_result: http.StatusBadRequest,
+		},
+		{
+			name:            "No dir",
+			query:           "dir=not_exists",
+			expected_result: http.StatusInternalServerError,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(
+			testCase.name, func(t *testing.T) {
+				resp, err := testServer.Client().Get(testServer.URL + "/find?" + testCase.query)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+				require.Equal(t, testCase.expected_result, resp.StatusCode)
+				if resp.StatusCode != http.StatusOK {
+					return
+				}
+
+				paths := []string{}
+				scanner := bufio.NewScanner(resp.Body)
+				for scanner.Scan() {
+					match := commands.Match{}
+					require.NoError(t, json.Unmarshal(scanner.Bytes(), &match))
+					paths = append(paths, match.Path)
+				}
+				require.Equal(t, testCase.expected_paths, paths)
+			},
+		)
+	}
+}
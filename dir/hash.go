@@ -0,0 +1,105 @@
+package dir
+
+import (
+	"files_server/checksum"
+	"files_server/commands"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// hash returns the hex hash of filename, or with dir a manifest of all files
+// below it in the format of sha256sum and friends.
+func (currentDir *Dir) hash(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	algo := query.Get("algo")
+	if algo == "" {
+		algo = checksum.SHA256
+	}
+	if _, err := checksum.New(algo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cache := currentDir.config.Hashes
+	if cache == nil {
+		cache = checksum.NewCache(0)
+	}
+
+	if dirName := query.Get("dir"); dirName != "" {
+		dirName, err := currentDir.lookup(dirName, true)
+		if err != nil {
+			fileError(w, err)
+			return
+		}
+		currentDir.hashDir(w, r, cache, dirName, algo)
+		return
+	}
+
+	fileName := query.Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	fileName, err := currentDir.lookup(fileName, true)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	sum, err := cache.Sum(fileName, algo)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Write([]byte(sum))
+}
+
+func (currentDir *Dir) hashDir(w http.ResponseWriter, r *http.Request, cache *checksum.Cache, dirName, algo string) {
+	fileInfo, err := os.Stat(dirName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !fileInfo.IsDir() {
+		http.Error(w, "Not directory", http.StatusBadRequest)
+		return
+	}
+
+	// A manifest with files missing would pass for a complete one, so
+	// anything that can't be read or hashed fails it.
+	var failed error
+	options := commands.NewFindOptions()
+	options.ShowHidden = showHidden(r)
+	options.Type = commands.TypeFile
+	options.Errors = func(relPath string, err error) {
+		if failed == nil {
+			failed = err
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	out := &startWriter{Writer: w}
+	err = commands.Find(dirName, options, func(match commands.Match) error {
+		if failed != nil {
+			return failed
+		}
+		sum, err := cache.Sum(filepath.Join(dirName, match.Path), algo)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s  %s\n", sum, filepath.ToSlash(match.Path))
+		if err != nil {
+			return err
+		}
+		return r.Context().Err()
+	})
+	if err == nil {
+		err = failed
+	}
+	if err != nil {
+		textError(w, out, err)
+	}
+}
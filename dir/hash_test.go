@@ -0,0 +1,109 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/checksum"
+	"files_server/dir"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "sub"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("abc"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte(""), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".hidden"), []byte("abc"), 0666))
+
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Hashes: checksum.NewCache(0)}))
+	defer testServer.Close()
+
+	testCases := []struct {
+		name            string
+		query           string
+		expected_result int
+		expected_body   string
+	}{
+		{
+			name:            "File",
+			query:           "filename=a.txt",
+			expected_result: http.StatusOK,
+			expected_body:   "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+		{
+			name:            "Algorithm",
+			query:           "filename=a.txt&algo=md5",
+			expected_result: http.StatusOK,
+			expected_body:   "900150983cd24fb0d6963f7d28e17f72",
+		},
+		{
+			name:            "Unknown algorithm",
+			query:           "filename=a.txt&algo=crc",
+			expected_result: http.StatusBadRequest,
+			expected_body:   "Unknown algorithm \"crc\"\n",
+		},
+		{
+			name:            "No file",
+			query:           "filename=missing.txt",
+			expected_result: http.StatusNotFound,
+			expected_body:   "open " + filepath.Join(root, "missing.txt") + ": no such file or directory\n",
+		},
+		{
+			name:            "Directory manifest",
+			query:           "dir=.&algo=md5",
+			expected_result: http.StatusOK,
+			expected_body: "d41d8cd98f00b204e9800998ecf8427e  sub/b.txt\n" +
+				"900150983cd24fb0d6963f7d28e17f72  a.txt\n",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(
+			testCase.name, func(t *testing.T) {
+				resp, body := doRequest(t, testServer, http.MethodGet, "/hash?"+testCase.query, "", nil)
+				require.Equal(t, testCase.expected_result, resp.StatusCode)
+				require.Equal(t, testCase.expected_body, body)
+			},
+		)
+	}
+
+	entries := []struct {
+		Name   string            `json:"name"`
+		Hashes map[string]string `json:"hashes"`
+	}{}
+	resp, body := doRequest(t, testServer, http.MethodGet, "/ls?meta=true&hashes=true", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &entries))
+	require.Equal(t, "a.txt", entries[1].Name)
+	require.Equal(t, map[string]string{
+		"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"md5":    "900150983cd24fb0d6963f7d28e17f72",
+	}, entries[1].Hashes)
+}
+
+func TestHashUnreadable(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "deep", "d"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "deep", "d", "f.txt"), []byte("f"), 0666))
+	// Nest d deeper than a path can be, one level at a time, so f.txt can't
+	// be reached by name anymore.
+	long := strings.Repeat("x", 200)
+	for i := 0; i < 25; i++ {
+		parent := filepath.Join(root, "deep", "parent")
+		require.NoError(t, os.Mkdir(parent, 0777))
+		require.NoError(t, os.Rename(filepath.Join(root, "deep", "d"), filepath.Join(parent, fmt.Sprintf("%s%02d", long, i))))
+		require.NoError(t, os.Rename(parent, filepath.Join(root, "deep", "d")))
+	}
+
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+	resp, body := doRequest(t, testServer, http.MethodGet, "/hash?dir=deep", "", nil)
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Contains(t, body, "file name too long")
+}
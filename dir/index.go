@@ -0,0 +1,48 @@
+package dir
+
+import (
+	"encoding/json"
+	"files_server/commands"
+	"files_server/index"
+	"net/http"
+)
+
+func (currentDir *Dir) indexed(dirName string) bool {
+	return currentDir.config.Index != nil && currentDir.config.Index.Covers(dirName)
+}
+
+func (currentDir *Dir) indexStatus(w http.ResponseWriter) {
+	status := index.Status{State: "disabled"}
+	if currentDir.config.Index != nil {
+		status = currentDir.config.Index.Status()
+	}
+	res, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+// lsMeta writes the entries of an indexed directory as JSON, the others
+// are streamed by writeLs.
+func (currentDir *Dir) lsMeta(w http.ResponseWriter, r *http.Request) {
+	entries, err := currentDir.config.Index.List(currentDir.path, showHidden(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if previews := lsPreviews(r); previews != nil {
+		for i := range entries {
+			if entries[i].Type == commands.TypeFile {
+				entries[i].Preview = previews(entries[i].Name)
+			}
+		}
+	}
+	res, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
@@ -0,0 +1,64 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/commands"
+	"files_server/dir"
+	"files_server/index"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lsMeta(t *testing.T, testServer *httptest.Server) []commands.Entry {
+	resp, err := testServer.Client().Get(testServer.URL + "/ls?meta=true")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	entries := []commands.Entry{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+	return entries
+}
+
+func TestLsMeta(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "file.txt"), []byte("content"), 0666))
+
+	config := &dir.Config{Root: root}
+	testServer := httptest.NewServer(dir.NewWithConfig(config))
+	defer testServer.Close()
+
+	entries := lsMeta(t, testServer)
+	require.Len(t, entries, 2)
+	require.Equal(t, "dir", entries[0].Name)
+	require.Equal(t, commands.TypeDir, entries[0].Type)
+	require.Equal(t, "file.txt", entries[1].Name)
+	require.Equal(t, int64(7), entries[1].Size)
+	require.Nil(t, entries[1].Hashes)
+
+	resp, err := testServer.Client().Get(testServer.URL + "/index")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"state":"disabled"`)
+
+	config.Index, err = index.Open(root, filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	defer config.Index.Close()
+	config.Index.Start()
+	require.Eventually(t, config.Index.Ready, 5*time.Second, 10*time.Millisecond)
+
+	entries = lsMeta(t, testServer)
+	require.Len(t, entries, 2)
+	require.Equal(t, "file.txt", entries[1].Name)
+	require.NotEmpty(t, entries[1].Hashes["sha256"])
+}
@@ -0,0 +1,8 @@
+package dir
+
+import "os"
+
+// There is no inode in os.FileInfo on windows.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,217 @@
+package dir
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrFormat = errors.New("Unknown format, use json, ndjson, csv, text or html")
+
+// lsFormat writes a listing entry by entry, begin and end wrap the
+// entries. meta makes json list entries instead of names.
+type lsFormat struct {
+	contentType string
+	begin       func(w io.Writer) error
+	entry       func(w io.Writer, i int, entry commands.Entry, meta bool) error
+	end         func(w io.Writer) error
+}
+
+var lsFormats = map[string]lsFormat{
+	"json": {
+		contentType: "application/json",
+		begin:       writeString("["),
+		entry: func(w io.Writer, i int, entry commands.Entry, meta bool) error {
+			if i > 0 {
+				_, err := io.WriteString(w, ",")
+				if err != nil {
+					return err
+				}
+			}
+			var data []byte
+			var err error
+			if meta {
+				data, err = json.Marshal(entry)
+			} else {
+				data, err = json.Marshal(entry.Name)
+			}
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		},
+		end: writeString("]"),
+	},
+	"ndjson": {
+		contentType: "application/x-ndjson",
+		begin:       writeString(""),
+		entry: func(w io.Writer, i int, entry commands.Entry, meta bool) error {
+			return json.NewEncoder(w).Encode(entry)
+		},
+		end: writeString(""),
+	},
+	"csv": {
+		contentType: "text/csv; charset=utf-8",
+		begin: func(w io.Writer) error {
+			return writeCSV(w, "name", "type", "size", "mtime")
+		},
+		entry: func(w io.Writer, i int, entry commands.Entry, meta bool) error {
+			return writeCSV(w, entry.Name, entry.Type, strconv.FormatInt(entry.Size, 10), entry.ModTime.UTC().Format(time.RFC3339))
+		},
+		end: writeString(""),
+	},
+	"text": {
+		contentType: "text/plain; charset=utf-8",
+		begin:       writeString(""),
+		entry: func(w io.Writer, i int, entry commands.Entry, meta bool) error {
+			name := entry.Name
+			if entry.Target != "" {
+				name += " -> " + entry.Target
+			}
+			_, err := fmt.Fprintf(w, "%s %5s %s %s\n", entry.Mode, commands.HumanSize(entry.Size), commands.LsTime(entry.ModTime, time.Now()), name)
+			return err
+		},
+		end: writeString(""),
+	},
+	"html": {
+		contentType: "text/html; charset=utf-8",
+		begin:       writeString("<table class=\"listing\">\n<thead><tr><th>Name</th><th>Type</th><th>Size</th><th>Modified</th></tr></thead>\n<tbody>\n"),
+		entry: func(w io.Writer, i int, entry commands.Entry, meta bool) error {
+			class, name := entry.Type, html.EscapeString(entry.Name)
+			if entry.Target != "" {
+				name += " &rarr; <span class=\"target\">" + html.EscapeString(entry.Target) + "</span>"
+			}
+			if entry.Broken {
+				class += " broken"
+			}
+			_, err := fmt.Fprintf(w, "<tr class=\"%s\"><td class=\"name\">%s</td><td class=\"type\">%s</td><td class=\"size\" data-bytes=\"%d\">%s</td><td class=\"mtime\"><time datetime=\"%s\">%s</time></td></tr>\n",
+				class, name, entry.Type, entry.Size, commands.HumanSize(entry.Size),
+				entry.ModTime.UTC().Format(time.RFC3339), entry.ModTime.Format(time.DateTime))
+			return err
+		},
+		end: writeString("</tbody>\n</table>\n"),
+	},
+}
+
+// lsMediaTypes maps Accept media types to formats.
+var lsMediaTypes = map[string]string{
+	"application/json":     "json",
+	"application/x-ndjson": "ndjson",
+	"application/ndjson":   "ndjson",
+	"text/csv":             "csv",
+	"text/plain":           "text",
+	"text/html":            "html",
+}
+
+func writeString(s string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
+
+func writeCSV(w io.Writer, record ...string) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(record)
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// lsFormatName picks the format from format= or else the Accept header.
+// An empty name means the original JSON array of names.
+func lsFormatName(r *http.Request) (string, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		if _, ok := lsFormats[name]; !ok {
+			return "", ErrFormat
+		}
+		return name, nil
+	}
+	return negotiate(r.Header.Get("Accept")), nil
+}
+
+// negotiate returns the format of the media type in accept with the
+// highest quality, the first one on ties. Wildcards and unknown types
+// give no format.
+func negotiate(accept string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := lsMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best
+}
+
+// writeLs streams the listing of dirName in format. Until the first entry
+// is written an error still becomes an error response, a client that goes
+// away stops the listing.
+func (currentDir *Dir) writeLs(w http.ResponseWriter, r *http.Request, dirName, name string, options commands.LsOptions, meta bool) {
+	format := lsFormats[name]
+	if name == "" {
+		format = lsFormats["json"]
+	} else {
+		w.Header().Set("Content-Type", format.contentType)
+	}
+	flusher, _ := w.(http.Flusher)
+
+	if (name == "" || name == "json") && !meta {
+		options.NamesOnly = true
+	}
+	i := 0
+	err := commands.LsFunc(r.Context(), dirName, options, func(entry commands.Entry) error {
+		if i == 0 {
+			err := format.begin(w)
+			if err != nil {
+				return err
+			}
+		}
+		err := format.entry(w, i, entry, meta)
+		if err != nil {
+			return err
+		}
+		i++
+		if name == "ndjson" && flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil && i == 0 {
+		err = format.begin(w)
+	}
+	if err != nil {
+		if i == 0 {
+			w.Header().Del("Content-Type")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	format.end(w)
+}
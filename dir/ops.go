@@ -0,0 +1,376 @@
+package dir
+
+import (
+	"errors"
+	"files_server/commands"
+	"files_server/replica"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrNotDir     = errors.New("Not directory")
+	ErrRemoveRoot = errors.New("Can't delete root directory")
+	ErrMoveRoot   = errors.New("Can't move root directory")
+	ErrExists     = errors.New("Target exists")
+	ErrIsDir      = errors.New("Is a directory")
+	ErrNotEmpty   = errors.New("Directory not empty")
+)
+
+// The exported operations are what the endpoints do, for frontends other
+// than HTTP. Names are resolved against the current directory.
+
+func (currentDir *Dir) Pwd() string {
+	return currentDir.path
+}
+
+func (currentDir *Dir) Cd(name string) error {
+	dir, err := currentDir.lookup(name, true)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() {
+		return ErrNotDir
+	}
+
+	currentDir.path = dir
+	return nil
+}
+
+// Ls lists the directory name, the current one when name is empty.
+func (currentDir *Dir) Ls(name string, showHidden bool) ([]string, error) {
+	dirName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return commands.Ls(dirName, showHidden)
+}
+
+func (currentDir *Dir) Mkdir(name string) error {
+	dirName, err := currentDir.lookup(name, true)
+	if err != nil || dirName == "/" {
+		return err
+	}
+	defer currentDir.mutating()()
+	err = os.MkdirAll(dirName, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	currentDir.record(replica.OpMkdir, dirName, "")
+	return nil
+}
+
+// Touch creates an empty file, or sets the times of what exists at name to
+// now.
+func (currentDir *Dir) Touch(name string) error {
+	fileName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(fileName)
+	if err == nil {
+		now := time.Now()
+		return currentDir.Chtimes(name, now, now)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	defer currentDir.mutating()()
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	currentDir.record(replica.OpTouch, fileName, "")
+	return nil
+}
+
+func (currentDir *Dir) Rm(name string) error {
+	fileName, err := currentDir.lookup(name, false)
+	if err != nil {
+		return err
+	}
+	if fileName == "/" {
+		return ErrRemoveRoot
+	}
+	defer currentDir.mutating()()
+	err = currentDir.removeAll(fileName)
+	if err != nil {
+		return err
+	}
+	currentDir.record(replica.OpRm, fileName, "")
+	return nil
+}
+
+// Remove removes the file name, unlike Rm it refuses directories.
+func (currentDir *Dir) Remove(name string) error {
+	info, err := currentDir.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ErrIsDir
+	}
+	return currentDir.Rm(name)
+}
+
+// Rmdir removes the directory name if it is empty.
+func (currentDir *Dir) Rmdir(name string) error {
+	info, err := currentDir.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return ErrNotDir
+	}
+	names, err := currentDir.Ls(name, true)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return ErrNotEmpty
+	}
+	return currentDir.Rm(name)
+}
+
+// Mv renames from to to. An existing target is only replaced with
+// overwrite.
+func (currentDir *Dir) Mv(from, to string, overwrite bool) error {
+	from, err := currentDir.lookup(from, false)
+	if err != nil {
+		return err
+	}
+	to, err = currentDir.lookup(to, false)
+	if err != nil {
+		return err
+	}
+
+	if from == "/" {
+		return ErrMoveRoot
+	}
+	defer currentDir.mutating()()
+	if _, err := os.Lstat(from); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(to); err == nil && !overwrite {
+		return ErrExists
+	}
+
+	err = currentDir.replace(to, func() error {
+		return os.Rename(from, to)
+	})
+	if err != nil {
+		return err
+	}
+	currentDir.record(replica.OpMv, from, to)
+	return nil
+}
+
+// Abs is name resolved against the current directory.
+func (currentDir *Dir) Abs(name string) string {
+	return currentDir.resolve(name)
+}
+
+func (currentDir *Dir) Stat(name string) (os.FileInfo, error) {
+	fileName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fileName)
+}
+
+func (currentDir *Dir) Lstat(name string) (os.FileInfo, error) {
+	fileName, err := currentDir.lookup(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(fileName)
+}
+
+// Open opens name for reading.
+func (currentDir *Dir) Open(name string) (*os.File, error) {
+	fileName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fileName)
+}
+
+func (currentDir *Dir) Chtimes(name string, atime, mtime time.Time) error {
+	fileName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return err
+	}
+	defer currentDir.mutating()()
+	err = currentDir.detach(fileName)
+	if err != nil {
+		return err
+	}
+	err = os.Chtimes(fileName, atime, mtime)
+	if err != nil {
+		return err
+	}
+	currentDir.reattach(fileName)
+	currentDir.record(replica.OpChtimes, fileName, "")
+	return nil
+}
+
+func (currentDir *Dir) Chmod(name string, mode os.FileMode) error {
+	fileName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return err
+	}
+	defer currentDir.mutating()()
+	err = currentDir.detach(fileName)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(fileName, mode)
+	if err != nil {
+		return err
+	}
+	currentDir.reattach(fileName)
+	currentDir.record(replica.OpChmod, fileName, "")
+	return nil
+}
+
+// Chown changes the owner of name, not of a symlink's target. -1 keeps
+// the uid or gid.
+func (currentDir *Dir) Chown(name string, uid, gid int) error {
+	fileName, err := currentDir.lookup(name, false)
+	if err != nil {
+		return err
+	}
+	defer currentDir.mutating()()
+	err = currentDir.detach(fileName)
+	if err != nil {
+		return err
+	}
+	err = os.Lchown(fileName, uid, gid)
+	if err != nil {
+		return err
+	}
+	currentDir.reattach(fileName)
+	return nil
+}
+
+// Writer is new content of a file. It is written to a temporary file next
+// to the target that replaces it on Close, so the old content is versioned
+// and a deduplicated file is never changed in place.
+type Writer struct {
+	*os.File
+	target string
+	dir    *Dir
+	mode   os.FileMode
+	done   bool
+}
+
+// Create starts new content for name. With keep the writer starts with the
+// current content, for writers that change only part of a file.
+func (currentDir *Dir) Create(name string, keep bool) (*Writer, error) {
+	target, err := currentDir.lookup(name, false)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0644)
+	info, err := os.Stat(target)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, &os.PathError{Op: "create", Path: target, Err: syscall.EISDIR}
+	case err == nil:
+		mode = info.Mode().Perm()
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), commands.TempWrite+"*")
+	if err != nil {
+		return nil, err
+	}
+	writer := &Writer{File: tmp, target: target, dir: currentDir, mode: mode}
+	if keep && info != nil {
+		err = copyFile(tmp, target)
+		if err != nil {
+			writer.Abort()
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// WriteFile replaces the content of name with what r has, with mode if it
+// isn't 0.
+func (currentDir *Dir) WriteFile(name string, r io.Reader, mode os.FileMode) error {
+	writer, err := currentDir.Create(name, false)
+	if err != nil {
+		return err
+	}
+	if mode != 0 {
+		writer.mode = mode
+	}
+	_, err = io.Copy(writer, r)
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+	return writer.Close()
+}
+
+func copyFile(dst *os.File, src string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(dst, file)
+	return err
+}
+
+// Close puts the content in place of the target.
+func (writer *Writer) Close() error {
+	if writer.done {
+		return os.ErrClosed
+	}
+	writer.done = true
+	tmp := writer.Name()
+	defer os.Remove(tmp)
+	err := writer.File.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp, writer.mode)
+	if err != nil {
+		return err
+	}
+	defer writer.dir.mutating()()
+	err = writer.dir.replace(writer.target, func() error {
+		return os.Rename(tmp, writer.target)
+	})
+	if err != nil {
+		return err
+	}
+	writer.dir.record(replica.OpWrite, writer.target, "")
+	return nil
+}
+
+// Abort drops the content and leaves the target as it was.
+func (writer *Writer) Abort() error {
+	if writer.done {
+		return os.ErrClosed
+	}
+	writer.done = true
+	writer.File.Close()
+	return os.Remove(writer.Name())
+}
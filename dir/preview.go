@@ -0,0 +1,151 @@
+package dir
+
+import (
+	"files_server/preview"
+	"files_server/textfile"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPreviewLines = 20
+	maxPreviewLines     = 1000
+)
+
+type previewInfo struct {
+	Name    string         `json:"name"`
+	Kind    string         `json:"kind"`
+	Size    int64          `json:"size"`
+	ModTime time.Time      `json:"mtime"`
+	Text    *preview.Text  `json:"text,omitempty"`
+	PDF     *preview.PDF   `json:"pdf,omitempty"`
+	Image   *preview.Image `json:"image,omitempty"`
+	// Thumb is where the thumbnail of an image is.
+	Thumb string `json:"thumb,omitempty"`
+}
+
+// lsPreviews is the LsOptions.Preview of /ls?previews=true.
+func lsPreviews(r *http.Request) func(name string) string {
+	if r.URL.Query().Get("previews") != "true" {
+		return nil
+	}
+	return preview.Kind
+}
+
+// previewFile finds filename for /thumb and /preview, which only work on
+// regular files.
+func (currentDir *Dir) previewFile(w http.ResponseWriter, r *http.Request) (string, os.FileInfo, bool) {
+	name := r.URL.Query().Get("filename")
+	if name == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return "", nil, false
+	}
+	fileName, err := currentDir.lookup(name, true)
+	if err != nil {
+		fileError(w, err)
+		return "", nil, false
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		fileError(w, err)
+		return "", nil, false
+	}
+	if info.IsDir() {
+		http.Error(w, ErrIsDir.Error(), http.StatusBadRequest)
+		return "", nil, false
+	}
+	return fileName, info, true
+}
+
+// thumb writes a thumbnail of the image filename that fits in size x size
+// pixels, 256 by default.
+func (currentDir *Dir) thumb(w http.ResponseWriter, r *http.Request) {
+	size := preview.DefaultSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size < preview.MinSize || size > preview.MaxSize {
+			http.Error(w, preview.ErrSize.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	fileName, info, ok := currentDir.previewFile(w, r)
+	if !ok {
+		return
+	}
+	tag := fmt.Sprintf(`"%x-%x-%d"`, info.ModTime().UnixNano(), info.Size(), size)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, contentType, err := currentDir.config.Thumbs.Thumbnail(fileName, size)
+	switch {
+	case err == preview.ErrFormat:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case err == preview.ErrTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		fileError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// preview writes what there is to show of filename without downloading
+// it: the first lines=N lines of text, the page count of a PDF or the size
+// of an image with where its thumbnail is.
+func (currentDir *Dir) preview(w http.ResponseWriter, r *http.Request) {
+	lines := defaultPreviewLines
+	if value := r.URL.Query().Get("lines"); value != "" {
+		var err error
+		lines, err = strconv.Atoi(value)
+		if err != nil || lines <= 0 || lines > maxPreviewLines {
+			http.Error(w, fmt.Sprintf("Lines has to be from 1 to %d", maxPreviewLines), http.StatusBadRequest)
+			return
+		}
+	}
+	fileName, info, ok := currentDir.previewFile(w, r)
+	if !ok {
+		return
+	}
+
+	res := previewInfo{Name: info.Name(), Kind: preview.Kind(info.Name()), Size: info.Size(), ModTime: info.ModTime()}
+	var err error
+	switch res.Kind {
+	case preview.KindText:
+		var text preview.Text
+		text, err = preview.TextPreview(fileName, lines)
+		res.Text = &text
+	case preview.KindPDF:
+		var pdf preview.PDF
+		pdf, err = preview.PDFInfo(fileName)
+		res.PDF = &pdf
+	case preview.KindImage:
+		var image preview.Image
+		image, err = preview.ImageInfo(fileName)
+		res.Image = &image
+		res.Thumb = "/thumb?filename=" + url.QueryEscape(r.URL.Query().Get("filename"))
+	default:
+		err = preview.ErrNoPreview
+	}
+	switch {
+	case err == preview.ErrNoPreview || err == preview.ErrNotPDF || err == preview.ErrFormat || err == textfile.ErrBinary:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		fileError(w, err)
+		return
+	}
+	writeJSON(w, res)
+}
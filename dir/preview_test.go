@@ -0,0 +1,156 @@
+package dir_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"files_server/commands"
+	"files_server/dir"
+	"files_server/preview"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePNG(t *testing.T, fileName string, width, height int) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, png.Encode(buf, image.NewGray(image.Rect(0, 0, width, height))))
+	require.NoError(t, os.WriteFile(fileName, buf.Bytes(), 0644))
+}
+
+func TestThumb(t *testing.T) {
+	root := t.TempDir()
+	writePNG(t, filepath.Join(root, "photo.png"), 600, 300)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "fake.png"), []byte("text"), 0644))
+	thumbs, err := preview.NewCache(t.TempDir(), 0)
+	require.NoError(t, err)
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Thumbs: thumbs}))
+	defer testServer.Close()
+
+	tests := []struct {
+		name            string
+		query           string
+		expected_status int
+		expected_width  int
+	}{
+		{name: "default_size", query: "filename=photo.png", expected_status: http.StatusOK, expected_width: 256},
+		{name: "size", query: "filename=photo.png&size=64", expected_status: http.StatusOK, expected_width: 64},
+		{name: "bad_size", query: "filename=photo.png&size=4096", expected_status: http.StatusBadRequest},
+		{name: "not_an_image", query: "filename=fake.png", expected_status: http.StatusUnsupportedMediaType},
+		{name: "missing", query: "filename=missing.png", expected_status: http.StatusNotFound},
+		{name: "dir", query: "filename=.", expected_status: http.StatusBadRequest},
+		{name: "no_filename", query: "", expected_status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := doRequest(t, testServer, http.MethodGet, "/thumb?"+test.query, "", nil)
+			require.Equal(t, test.expected_status, resp.StatusCode, body)
+			if test.expected_status != http.StatusOK {
+				return
+			}
+			require.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+			config, err := png.DecodeConfig(bytes.NewReader([]byte(body)))
+			require.NoError(t, err)
+			require.Equal(t, test.expected_width, config.Width)
+
+			resp, _ = doRequest(t, testServer, http.MethodGet, "/thumb?"+test.query, "", map[string]string{"If-None-Match": resp.Header.Get("ETag")})
+			require.Equal(t, http.StatusNotModified, resp.StatusCode)
+		})
+	}
+	require.Equal(t, 2, thumbs.Len())
+}
+
+func TestPreview(t *testing.T) {
+	root := t.TempDir()
+	writePNG(t, filepath.Join(root, "photo.png"), 40, 30)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "run.sh"), []byte("#!/bin/sh\necho 1\necho 2\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "paper.pdf"), []byte("%PDF-1.5\n<< /Type /Pages /Count 12 >>\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.zip"), []byte("PK"), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	tests := []struct {
+		name            string
+		query           string
+		expected_status int
+		expected_kind   string
+		check           func(t *testing.T, info map[string]any)
+	}{
+		{
+			name: "text", query: "filename=run.sh&lines=2", expected_status: http.StatusOK, expected_kind: preview.KindText,
+			check: func(t *testing.T, info map[string]any) {
+				require.Equal(t, map[string]any{"language": "shell", "encoding": "utf-8", "lines": "#!/bin/sh\necho 1\n", "truncated": true}, info["text"])
+			},
+		},
+		{
+			name: "pdf", query: "filename=paper.pdf", expected_status: http.StatusOK, expected_kind: preview.KindPDF,
+			check: func(t *testing.T, info map[string]any) {
+				require.Equal(t, map[string]any{"version": "1.5", "pages": float64(12)}, info["pdf"])
+			},
+		},
+		{
+			name: "image", query: "filename=photo.png", expected_status: http.StatusOK, expected_kind: preview.KindImage,
+			check: func(t *testing.T, info map[string]any) {
+				require.Equal(t, map[string]any{"format": "png", "width": float64(40), "height": float64(30)}, info["image"])
+				require.Equal(t, "/thumb?filename=photo.png", info["thumb"])
+			},
+		},
+		{name: "no_preview", query: "filename=data.zip", expected_status: http.StatusUnsupportedMediaType},
+		{name: "bad_lines", query: "filename=run.sh&lines=0", expected_status: http.StatusBadRequest},
+		{name: "missing", query: "filename=missing.txt", expected_status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := doRequest(t, testServer, http.MethodGet, "/preview?"+test.query, "", nil)
+			require.Equal(t, test.expected_status, resp.StatusCode, body)
+			if test.expected_status != http.StatusOK {
+				return
+			}
+			info := map[string]any{}
+			require.NoError(t, json.Unmarshal([]byte(body), &info))
+			require.Equal(t, test.expected_kind, info["kind"])
+			test.check(t, info)
+		})
+	}
+}
+
+func TestLsPreviews(t *testing.T) {
+	root := t.TempDir()
+	writePNG(t, filepath.Join(root, "photo.png"), 4, 4)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "notes.md"), []byte("# Notes\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.zip"), []byte("PK"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs.txt"), 0755))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	for _, path := range []string{"/ls?meta=true&previews=true", "/ls?format=ndjson&previews=true"} {
+		t.Run(path, func(t *testing.T) {
+			resp, body := doRequest(t, testServer, http.MethodGet, path, "", nil)
+			require.Equal(t, http.StatusOK, resp.StatusCode, body)
+			previews := map[string]string{}
+			decoder := json.NewDecoder(bytes.NewReader([]byte(body)))
+			if path == "/ls?meta=true&previews=true" {
+				entries := []commands.Entry{}
+				require.NoError(t, decoder.Decode(&entries))
+				for _, entry := range entries {
+					previews[entry.Name] = entry.Preview
+				}
+			} else {
+				for decoder.More() {
+					entry := commands.Entry{}
+					require.NoError(t, decoder.Decode(&entry))
+					previews[entry.Name] = entry.Preview
+				}
+			}
+			require.Equal(t, map[string]string{"photo.png": "image", "notes.md": "text", "data.zip": "", "docs.txt": ""}, previews)
+		})
+	}
+
+	_, body := doRequest(t, testServer, http.MethodGet, "/ls?meta=true", "", nil)
+	require.NotContains(t, body, "preview")
+}
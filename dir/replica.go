@@ -0,0 +1,212 @@
+package dir
+
+import (
+	"files_server/checksum"
+	"files_server/dirsync"
+	"files_server/replica"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLogPage = 1000
+	maxLogPage     = 10000
+	// maxLogWait caps wait= of /replica/log, below usual proxy timeouts.
+	maxLogWait = time.Minute
+)
+
+// record adds a change of fileName, and of to for a rename, to the
+// replication log. Changes outside of the root, owners and symlinks aren't
+// replicated.
+func (currentDir *Dir) record(op, fileName, to string) {
+	log := currentDir.config.Replication
+	if log == nil {
+		return
+	}
+	from, fromOK := currentDir.relative(fileName)
+	target := fileName
+	entry := replica.Entry{Op: op, Path: from}
+	if op == replica.OpMv {
+		var toOK bool
+		entry.To, toOK = currentDir.relative(to)
+		target = to
+		switch {
+		case !toOK:
+			// Moved out of the root, which for a follower is a removal.
+			entry = replica.Entry{Op: replica.OpRm, Path: from}
+		case !fromOK:
+			entry = replica.Entry{Op: replica.OpWrite, Path: entry.To}
+			fromOK = true
+		}
+	}
+	if !fromOK {
+		return
+	}
+	if info, err := os.Lstat(target); err == nil && entry.Op != replica.OpRm {
+		entry.Mode = info.Mode().Perm()
+		entry.ModTime = info.ModTime()
+	}
+	if currentDir.journal != nil {
+		*currentDir.journal = append(*currentDir.journal, entry)
+		return
+	}
+	// A failed append makes followers resync, the change itself is done.
+	log.Append(entry)
+}
+
+// relative is fileName relative to the root with slashes, for the log.
+func (currentDir *Dir) relative(fileName string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Clean(currentDir.config.Root), fileName)
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// replica serves followers: /replica/log pages of the replication log,
+// /replica/file the content of a file and /replica/manifest the tree of a
+// directory. Paths are relative to the root, not the current directory,
+// so only admins may read them.
+func (currentDir *Dir) replica(w http.ResponseWriter, r *http.Request) {
+	if !UserFromContext(r.Context()).Admin {
+		http.Error(w, ErrNotAdmin.Error(), http.StatusForbidden)
+		return
+	}
+	switch r.URL.Path {
+	case "/replica/log":
+		currentDir.replicaLog(w, r)
+	case "/replica/file":
+		currentDir.replicaFile(w, r)
+	case "/replica/manifest":
+		currentDir.replicaManifest(w, r)
+	}
+}
+
+// replicaLog writes up to limit= entries after since=, waiting up to
+// wait= for one if there are none yet. Without since= it writes only the
+// range the log has.
+func (currentDir *Dir) replicaLog(w http.ResponseWriter, r *http.Request) {
+	log := currentDir.config.Replication
+	if log == nil {
+		http.Error(w, "Replication is disabled", http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("since") == "" {
+		writeJSON(w, log.Status())
+		return
+	}
+	since, err := strconv.ParseUint(query.Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad since", http.StatusBadRequest)
+		return
+	}
+	limit := defaultLogPage
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Bad limit", http.StatusBadRequest)
+			return
+		}
+	}
+	limit = min(limit, maxLogPage)
+	var wait time.Duration
+	if value := query.Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			http.Error(w, "Bad wait", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(min(wait, maxLogWait))
+		select {
+		case <-log.Wait(since):
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+	}
+	page, err := log.Entries(since, limit)
+	if err == replica.ErrTruncated {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	writeJSON(w, page)
+}
+
+// rootPath is path= below the root, the root itself when empty and
+// allowed.
+func (currentDir *Dir) rootPath(w http.ResponseWriter, r *http.Request, allowRoot bool) (string, bool) {
+	name := r.URL.Query().Get("path")
+	if name == "" && allowRoot {
+		return filepath.Clean(currentDir.config.Root), true
+	}
+	name, err := dirsync.Clean(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return filepath.Join(currentDir.config.Root, name), true
+}
+
+// replicaFile writes the content of path= with its mode and modification
+// time in the X-Mode and X-Mtime headers.
+func (currentDir *Dir) replicaFile(w http.ResponseWriter, r *http.Request) {
+	fileName, ok := currentDir.rootPath(w, r, false)
+	if !ok {
+		return
+	}
+	file, err := currentDir.Open(fileName)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, ErrIsDir.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("X-Mode", fmt.Sprintf("%o", info.Mode().Perm()))
+	w.Header().Set("X-Mtime", info.ModTime().Format(time.RFC3339Nano))
+	io.Copy(w, file)
+}
+
+// replicaManifest writes the manifest of the directory path=, the root by
+// default, with hashes in algo=, sha256 by default.
+func (currentDir *Dir) replicaManifest(w http.ResponseWriter, r *http.Request) {
+	dirName, ok := currentDir.rootPath(w, r, true)
+	if !ok {
+		return
+	}
+	algo := r.URL.Query().Get("algo")
+	if algo == "" {
+		algo = checksum.SHA256
+	}
+	if _, err := checksum.New(algo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	manifest, err := currentDir.Manifest(r.Context(), dirName, algo)
+	if err == ErrNotDir {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	writeJSON(w, manifest)
+}
@@ -0,0 +1,173 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/dir"
+	"files_server/replica"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func replicaOps(page replica.Page) []string {
+	out := []string{}
+	for _, entry := range page.Entries {
+		op := entry.Op + " " + entry.Path
+		if entry.To != "" {
+			op += " " + entry.To
+		}
+		out = append(out, op)
+	}
+	return out
+}
+
+func TestReplicationLog(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	log, err := replica.Open(filepath.Join(t.TempDir(), "log.jsonl"), 0)
+	require.NoError(t, err)
+	defer log.Close()
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Replication: log}))
+	defer testServer.Close()
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: http.MethodGet, path: "/mkdir?dirname=docs"},
+		{method: http.MethodGet, path: "/touch?filename=docs/a.txt"},
+		{method: http.MethodGet, path: "/touch?filename=docs/a.txt"},
+		{method: http.MethodGet, path: "/chmod?filename=docs/a.txt&mode=600"},
+		{method: http.MethodGet, path: "/mv?from=docs/a.txt&to=docs/b.txt"},
+		{method: http.MethodPost, path: "/batch", body: `[{"op": "write", "path": "docs/c.txt", "content": "c"}]`},
+		{method: http.MethodGet, path: "/rm?filename=docs/c.txt"},
+		{method: http.MethodGet, path: "/mv?from=docs/b.txt&to=" + filepath.Join(outside, "b.txt")},
+		{method: http.MethodGet, path: "/mkdir?dirname=" + filepath.Join(outside, "dir")},
+		// Rolled back, so it never happened for followers.
+		{method: http.MethodPost, path: "/batch?atomic=true", body: `[{"op": "mkdir", "path": "new"}, {"op": "rm", "path": "missing"}, {"op": "mv", "from": "missing", "to": "x"}]`},
+		{method: http.MethodPost, path: "/batch?atomic=true", body: `[{"op": "mkdir", "path": "new"}, {"op": "mv", "from": "docs", "to": "new/docs"}]`},
+	}
+	for _, request := range requests {
+		doRequest(t, testServer, request.method, request.path, request.body, nil)
+	}
+
+	page, err := log.Entries(0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"mkdir docs",
+		"touch docs/a.txt",
+		"chtimes docs/a.txt",
+		"chmod docs/a.txt",
+		"mv docs/a.txt docs/b.txt",
+		"write docs/c.txt",
+		"rm docs/c.txt",
+		"rm docs/b.txt",
+		"mkdir new",
+		"mv docs new/docs",
+	}, replicaOps(page))
+	require.Equal(t, os.FileMode(0600), page.Entries[3].Mode)
+	require.False(t, page.Entries[2].ModTime.IsZero())
+}
+
+func TestReplica(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("aaa"), 0640))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "docs", "a.txt"), mtime, mtime))
+	log, err := replica.Open(filepath.Join(t.TempDir(), "log.jsonl"), 4)
+	require.NoError(t, err)
+	defer log.Close()
+	config := &dir.Config{Root: root, Replication: log}
+	currentDir := dir.NewWithConfig(config)
+	admin := true
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentDir.ServeHTTP(w, r.WithContext(dir.WithUser(r.Context(), dir.User{Name: "alice", Admin: admin})))
+	}))
+	defer testServer.Close()
+
+	// Paths are relative to the root, not the current directory.
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/cd?dir=docs", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, body := doRequest(t, testServer, http.MethodGet, "/replica/file?path=docs/a.txt", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "aaa", body)
+	require.Equal(t, "640", resp.Header.Get("X-Mode"))
+	require.Equal(t, mtime.Format(time.RFC3339Nano), resp.Header.Get("X-Mtime"))
+
+	resp, body = doRequest(t, testServer, http.MethodGet, "/replica/manifest", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, `"path":"docs/a.txt"`)
+	require.Contains(t, body, `"algo":"sha256"`)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, currentDir.Touch("b.txt"))
+	}
+	resp, body = doRequest(t, testServer, http.MethodGet, "/replica/log", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page := replica.Page{}
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	require.Equal(t, replica.Page{First: 3, Last: 5, Entries: []replica.Entry{}}, page)
+	resp, body = doRequest(t, testServer, http.MethodGet, "/replica/log?since=3&limit=1", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	require.Equal(t, []string{"chtimes docs/b.txt"}, replicaOps(page))
+	require.Equal(t, uint64(4), page.Entries[0].Seq)
+
+	done := make(chan replica.Page)
+	go func() {
+		resp, body := doRequest(t, testServer, http.MethodGet, "/replica/log?since=5&wait=10s", "", nil)
+		page := replica.Page{}
+		if resp.StatusCode == http.StatusOK {
+			json.Unmarshal([]byte(body), &page)
+		}
+		done <- page
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, currentDir.Mkdir("new"))
+	select {
+	case page = <-done:
+		require.Equal(t, []string{"mkdir docs/new"}, replicaOps(page))
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait= didn't return with the new entry")
+	}
+
+	tests := []struct {
+		name            string
+		path            string
+		admin           bool
+		expected_status int
+	}{
+		{name: "not_admin", path: "/replica/log", expected_status: http.StatusForbidden},
+		{name: "truncated", path: "/replica/log?since=1", admin: true, expected_status: http.StatusGone},
+		{name: "ahead", path: "/replica/log?since=100", admin: true, expected_status: http.StatusGone},
+		{name: "bad_since", path: "/replica/log?since=x", admin: true, expected_status: http.StatusBadRequest},
+		{name: "bad_wait", path: "/replica/log?since=6&wait=x", admin: true, expected_status: http.StatusBadRequest},
+		{name: "missing_file", path: "/replica/file?path=missing", admin: true, expected_status: http.StatusNotFound},
+		{name: "file_is_dir", path: "/replica/file?path=docs", admin: true, expected_status: http.StatusBadRequest},
+		{name: "file_outside", path: "/replica/file?path=../etc/passwd", admin: true, expected_status: http.StatusBadRequest},
+		{name: "no_file", path: "/replica/file", admin: true, expected_status: http.StatusBadRequest},
+		{name: "manifest_of_file", path: "/replica/manifest?path=docs/a.txt", admin: true, expected_status: http.StatusBadRequest},
+		{name: "missing_manifest", path: "/replica/manifest?path=missing", admin: true, expected_status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			admin = test.admin
+			resp, _ := doRequest(t, testServer, http.MethodGet, test.path, "", nil)
+			require.Equal(t, test.expected_status, resp.StatusCode)
+		})
+	}
+
+	disabled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir.NewWithConfig(&dir.Config{Root: root}).ServeHTTP(w, r.WithContext(dir.WithUser(r.Context(), dir.User{Admin: true})))
+	}))
+	defer disabled.Close()
+	resp, _ = doRequest(t, disabled, http.MethodGet, "/replica/log?since=0", "", nil)
+	require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
@@ -0,0 +1,334 @@
+package dir
+
+import (
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"files_server/replica"
+	"files_server/share"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sharePrefix = "/s/"
+
+type shareLink struct {
+	share.Share
+	URL string `json:"url"`
+}
+
+// share manages the shares of the session user: /share creates one,
+// /share/list and /share/revoke list and remove them.
+func (currentDir *Dir) share(w http.ResponseWriter, r *http.Request) {
+	store := currentDir.config.Shares
+	if store == nil {
+		http.Error(w, "Sharing is disabled", http.StatusNotImplemented)
+		return
+	}
+	owner := UserFromContext(r.Context()).Name
+	query := r.URL.Query()
+
+	var res interface{}
+	switch r.URL.Path {
+	case "/share":
+		s, err := currentDir.createShare(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res = shareLink{Share: s, URL: sharePrefix + s.Token}
+	case "/share/list":
+		links := []shareLink{}
+		for _, s := range store.List(owner) {
+			links = append(links, shareLink{Share: s, URL: sharePrefix + s.Token})
+		}
+		res = links
+	case "/share/revoke":
+		err := store.Revoke(owner, query.Get("id"))
+		if err != nil {
+			shareError(w, err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func (currentDir *Dir) createShare(r *http.Request) (share.Share, error) {
+	query := r.URL.Query()
+	name := query.Get("path")
+	if name == "" {
+		return share.Share{}, errors.New("No path")
+	}
+	path, err := currentDir.lookup(name, true)
+	if err != nil {
+		return share.Share{}, err
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return share.Share{}, err
+	}
+
+	options := share.Options{Mode: query.Get("mode"), Password: query.Get("password")}
+	if value := query.Get("expires"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			options.Expires = time.Now().Add(d)
+		} else {
+			options.Expires, err = parseTime(value)
+			if err != nil {
+				return share.Share{}, err
+			}
+		}
+	}
+	if value := query.Get("maxdownloads"); value != "" {
+		options.MaxDownloads, err = strconv.Atoi(value)
+		if err != nil {
+			return share.Share{}, err
+		}
+	}
+	return currentDir.config.Shares.Create(UserFromContext(r.Context()).Name, path, fileInfo.IsDir(), options)
+}
+
+// Shares serves share links under /s/<token> without a session. The
+// password goes into ?password= or the basic auth password. Read shares of
+// a directory list it as JSON, serve files below it with ?path= and the
+// whole directory as an archive with ?format=. Upload shares take files by
+// PUT or POST with ?filename= and never overwrite.
+func Shares(config *Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Shares == nil {
+			http.Error(w, "Sharing is disabled", http.StatusNotImplemented)
+			return
+		}
+		password := r.URL.Query().Get("password")
+		if _, basic, ok := r.BasicAuth(); ok {
+			password = basic
+		}
+		s, err := config.Shares.Access(strings.TrimPrefix(r.URL.Path, sharePrefix), password)
+		if err != nil {
+			shareError(w, err)
+			return
+		}
+
+		currentDir := &Dir{path: s.Path, config: config}
+		switch {
+		case r.Method == http.MethodPut || r.Method == http.MethodPost:
+			if s.Mode != share.ModeUpload {
+				shareError(w, share.ErrNotPermitted)
+				return
+			}
+			currentDir.shareUpload(w, r, s)
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			if s.Mode != share.ModeRead {
+				shareError(w, share.ErrNotPermitted)
+				return
+			}
+			currentDir.shareDownload(w, r, s)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (currentDir *Dir) shareDownload(w http.ResponseWriter, r *http.Request, s share.Share) {
+	store := currentDir.config.Shares
+	query := r.URL.Query()
+	name := ""
+	if s.Dir {
+		name = query.Get("path")
+	}
+	name, err := currentDir.shareName(name)
+	if err != nil {
+		shareError(w, err)
+		return
+	}
+	file, err := currentDir.Open(name)
+	if err != nil {
+		shareError(w, err)
+		return
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		shareError(w, err)
+		return
+	}
+
+	if fileInfo.IsDir() && query.Get("format") == "" {
+		entries, err := commands.LsEntries(file.Name(), commands.LsOptions{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data, err := json.Marshal(entries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		store.Count(s.ID, "view")
+		w.Write(data)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w = &downloadWriter{ResponseWriter: w, count: func() error { return store.Count(s.ID, "download") }}
+	}
+	if fileInfo.IsDir() {
+		archiveQuery := r.URL.Query()
+		archiveQuery.Del("path")
+		archiveQuery.Del("hide")
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = archiveQuery.Encode()
+		(&Dir{path: file.Name(), config: currentDir.config}).archive(w, r)
+		return
+	}
+	w.Header().Set("ETag", etag(fileInfo))
+	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
+}
+
+// downloadWriter counts a download when the whole content is about to be
+// sent, so HEAD, ranges and 304 answers don't use up MaxDownloads.
+type downloadWriter struct {
+	http.ResponseWriter
+	count   func() error
+	started bool
+	err     error
+}
+
+func (writer *downloadWriter) WriteHeader(status int) {
+	if writer.started {
+		return
+	}
+	writer.started = true
+	if status == http.StatusOK {
+		writer.err = writer.count()
+	}
+	if writer.err != nil {
+		header := writer.Header()
+		header.Del("ETag")
+		header.Del("Last-Modified")
+		header.Del("Content-Disposition")
+		shareError(writer.ResponseWriter, writer.err)
+		return
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *downloadWriter) Write(data []byte) (int, error) {
+	writer.WriteHeader(http.StatusOK)
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// shareName cleans name of a visitor to a path below the shared directory.
+// Hidden files are not there for visitors, and symlinks don't lead out of
+// the shared directory whatever the symlink policy.
+func (currentDir *Dir) shareName(name string) (string, error) {
+	name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+name)), "/")
+	for _, part := range strings.Split(name, "/") {
+		if commands.IsHidden(part) {
+			return "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+	}
+	fileName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return "", err
+	}
+	realName, err := filepath.EvalSymlinks(fileName)
+	if err != nil {
+		return "", err
+	}
+	realShare, err := filepath.EvalSymlinks(currentDir.path)
+	if err != nil {
+		return "", err
+	}
+	if !within(realName, realShare) {
+		return "", ErrSymlinkOutside
+	}
+	return name, nil
+}
+
+func (currentDir *Dir) shareUpload(w http.ResponseWriter, r *http.Request, s share.Share) {
+	name := filepath.Base(filepath.Clean("/" + r.URL.Query().Get("filename")))
+	if name == "/" || name == "." || commands.IsHidden(name) {
+		http.Error(w, "Bad filename", http.StatusBadRequest)
+		return
+	}
+	target, err := currentDir.lookup(name, false)
+	if err != nil {
+		shareError(w, err)
+		return
+	}
+	if _, err := os.Lstat(target); err == nil {
+		http.Error(w, "File exists", http.StatusConflict)
+		return
+	}
+
+	exists := false
+	defer currentDir.mutating()()
+	err = currentDir.replace(target, func() error {
+		tmp, err := os.CreateTemp(s.Path, commands.TempShare+"*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, r.Body)
+		closeErr := tmp.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err != nil {
+			return err
+		}
+		// A link fails if target exists, unlike a rename.
+		err = os.Link(tmp.Name(), target)
+		if os.IsExist(err) {
+			exists = true
+		}
+		return err
+	})
+	if exists {
+		http.Error(w, "File exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	currentDir.record(replica.OpWrite, target, "")
+	currentDir.config.Shares.Count(s.ID, "upload")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func shareError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, share.ErrNotFound) || os.IsNotExist(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, share.ErrExpired) || errors.Is(err, share.ErrExhausted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, share.ErrPassword):
+		w.Header().Set("WWW-Authenticate", `Basic realm="share"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, share.ErrLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, share.ErrNotPermitted) || errors.Is(err, ErrSymlink) || errors.Is(err, ErrSymlinkOutside):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,127 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/dir"
+	"files_server/share"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type shareLink struct {
+	share.Share
+	URL string `json:"url"`
+}
+
+func createShare(t *testing.T, testServer *httptest.Server, query string) shareLink {
+	resp, body := doRequest(t, testServer, http.MethodGet, "/share?"+query, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	link := shareLink{}
+	require.NoError(t, json.Unmarshal([]byte(body), &link))
+	return link
+}
+
+func TestShares(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "public"), 0755))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "inbox"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "public", "a.txt"), []byte("hello"), 0644))
+	store, err := share.Open(filepath.Join(t.TempDir(), "shares.json"), nil)
+	require.NoError(t, err)
+	config := &dir.Config{Root: root, Shares: store}
+
+	currentDir := dir.NewWithConfig(config)
+	mux := http.NewServeMux()
+	mux.Handle("/s/", dir.Shares(config))
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentDir.ServeHTTP(w, r.WithContext(dir.WithUser(r.Context(), dir.User{Name: "alice"})))
+	}))
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	file := createShare(t, testServer, "path=public/a.txt&maxdownloads=1&password=pw")
+	require.Equal(t, "alice", file.Owner)
+	resp, _ := doRequest(t, testServer, http.MethodGet, file.URL, "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, body := doRequest(t, testServer, http.MethodGet, file.URL+"?password=pw", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "hello", body)
+	resp, _ = doRequest(t, testServer, http.MethodGet, file.URL+"?password=pw", "", nil)
+	require.Equal(t, http.StatusGone, resp.StatusCode)
+
+	// Only a whole download counts, not a probe, a range or a 304.
+	once := createShare(t, testServer, "path=public/a.txt&maxdownloads=1")
+	resp, body = doRequest(t, testServer, http.MethodHead, once.URL, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, body)
+	resp, body = doRequest(t, testServer, http.MethodGet, once.URL, "", map[string]string{"Range": "bytes=1-2"})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "el", body)
+	resp, _ = doRequest(t, testServer, http.MethodGet, once.URL, "", map[string]string{"If-None-Match": resp.Header.Get("ETag")})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp, body = doRequest(t, testServer, http.MethodGet, once.URL, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "hello", body)
+	resp, _ = doRequest(t, testServer, http.MethodHead, once.URL, "", nil)
+	require.Equal(t, http.StatusGone, resp.StatusCode)
+
+	public := createShare(t, testServer, "path=public")
+	resp, body = doRequest(t, testServer, http.MethodGet, public.URL, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, `"name":"a.txt"`)
+	resp, body = doRequest(t, testServer, http.MethodGet, public.URL+"?path=../../a.txt", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "hello", body)
+	// Visitors don't get hidden files or follow symlinks out of the share.
+	require.NoError(t, os.WriteFile(filepath.Join(root, "public", ".env"), []byte("secret"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "secret.txt"), filepath.Join(root, "public", "out.txt")))
+	require.NoError(t, os.Symlink("a.txt", filepath.Join(root, "public", "in.txt")))
+	resp, _ = doRequest(t, testServer, http.MethodGet, public.URL+"?path=.env", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, public.URL+"?path=out.txt", "", nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, body = doRequest(t, testServer, http.MethodGet, public.URL+"?path=in.txt", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "hello", body)
+	resp, body = doRequest(t, testServer, http.MethodGet, public.URL, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotContains(t, body, ".env")
+	resp, _ = doRequest(t, testServer, http.MethodPut, public.URL+"?filename=b.txt", "x", nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	inbox := createShare(t, testServer, "path=inbox&mode=upload")
+	resp, _ = doRequest(t, testServer, http.MethodPut, inbox.URL+"?filename=../report.txt", "report", nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	content, err := os.ReadFile(filepath.Join(root, "inbox", "report.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "report", string(content))
+	resp, _ = doRequest(t, testServer, http.MethodPut, inbox.URL+"?filename=report.txt", "other", nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, inbox.URL, "", nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body = doRequest(t, testServer, http.MethodGet, "/share/list", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	links := []shareLink{}
+	require.NoError(t, json.Unmarshal([]byte(body), &links))
+	require.Len(t, links, 4)
+	counts := map[string][3]int{}
+	for _, link := range links {
+		counts[link.ID] = [3]int{link.Views, link.Downloads, link.Uploads}
+	}
+	require.Equal(t, [3]int{0, 1, 0}, counts[file.ID])
+	require.Equal(t, [3]int{0, 1, 0}, counts[once.ID])
+	require.Equal(t, [3]int{2, 2, 0}, counts[public.ID])
+	require.Equal(t, [3]int{0, 0, 1}, counts[inbox.ID])
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/share/revoke?id="+public.ID, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, public.URL, "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
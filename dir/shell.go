@@ -0,0 +1,254 @@
+package dir
+
+import (
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"files_server/shell"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const maxShellMessage = 64 << 10
+
+var shellCommands = map[string]string{
+	"help":  "help",
+	"pwd":   "pwd",
+	"cd":    "cd [dir]",
+	"ls":    "ls [-a] [-l] [path...]",
+	"mkdir": "mkdir dir...",
+	"touch": "touch file...",
+	"rm":    "rm path...",
+	"mv":    "mv [-f] from to",
+}
+
+type shellRequest struct {
+	ID       int    `json:"id"`
+	Line     string `json:"line"`
+	Complete bool   `json:"complete,omitempty"`
+	Cursor   *int   `json:"cursor,omitempty"`
+}
+
+type shellResponse struct {
+	Type       string      `json:"type"`
+	ID         int         `json:"id,omitempty"`
+	Command    string      `json:"command,omitempty"`
+	Args       []string    `json:"args,omitempty"`
+	OK         *bool       `json:"ok,omitempty"`
+	Output     interface{} `json:"output,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Cwd        string      `json:"cwd,omitempty"`
+	Start      *int        `json:"start,omitempty"`
+	Candidates []string    `json:"candidates,omitempty"`
+}
+
+// shell runs command lines sent over a WebSocket, as JSON requests
+// {"id":1,"line":"ls -a && cd docs"} or as plain text. Every command gives a
+// "result", the line ends with "done" and the new cwd, and a request with
+// "complete":true gives a "completion" for the word at cursor. The
+// connection has its own current directory, starting at the session's.
+func (currentDir *Dir) shell(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxShellMessage)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			}
+		}
+	}()
+
+	session := &Dir{path: currentDir.path, config: currentDir.config}
+	err = conn.WriteJSON(shellResponse{Type: "ready", Cwd: session.Pwd()})
+	for err == nil {
+		var data []byte
+		_, data, err = conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		req := shellRequest{}
+		if json.Unmarshal(data, &req) != nil {
+			req = shellRequest{Line: string(data)}
+		}
+		for _, res := range session.runShell(req) {
+			err = conn.WriteJSON(res)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (currentDir *Dir) runShell(req shellRequest) []shellResponse {
+	if req.Complete {
+		cursor := len(req.Line)
+		if req.Cursor != nil {
+			cursor = *req.Cursor
+		}
+		completion := currentDir.completeShell(req.Line, cursor)
+		return []shellResponse{{Type: "completion", ID: req.ID, Start: &completion.Start, Candidates: completion.Candidates}}
+	}
+
+	cmds, err := shell.Parse(req.Line)
+	if err != nil {
+		return []shellResponse{{Type: "error", ID: req.ID, Error: err.Error()}}
+	}
+	responses := []shellResponse{}
+	ok := true
+	for _, cmd := range cmds {
+		args := []string{}
+		for _, word := range cmd.Args() {
+			args = append(args, shell.Expand(word, currentDir.path)...)
+		}
+		output, err := currentDir.runCommand(cmd.Name(), args)
+		ok = err == nil
+		res := shellResponse{Type: "result", ID: req.ID, Command: cmd.Name(), Args: args, OK: &ok, Output: output}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		responses = append(responses, res)
+		if !ok {
+			break
+		}
+	}
+	return append(responses, shellResponse{Type: "done", ID: req.ID, OK: &ok, Cwd: currentDir.Pwd()})
+}
+
+func (currentDir *Dir) runCommand(name string, args []string) (interface{}, error) {
+	flags, args := splitFlags(args)
+	switch name {
+	case "help":
+		usage := []string{}
+		for _, line := range shellCommands {
+			usage = append(usage, line)
+		}
+		sort.Strings(usage)
+		return usage, nil
+	case "pwd":
+		return currentDir.Pwd(), nil
+	case "cd":
+		if len(args) > 1 {
+			return nil, errors.New("Too many arguments")
+		}
+		dir := currentDir.config.Root
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		return nil, currentDir.Cd(dir)
+	case "ls":
+		return currentDir.shellLs(args, flags["a"], flags["l"])
+	case "mkdir":
+		return nil, eachArg(args, currentDir.Mkdir)
+	case "touch":
+		return nil, eachArg(args, currentDir.Touch)
+	case "rm":
+		return nil, eachArg(args, currentDir.Rm)
+	case "mv":
+		if len(args) != 2 {
+			return nil, errors.New("mv needs from and to")
+		}
+		return nil, currentDir.Mv(args[0], args[1], flags["f"])
+	}
+	return nil, fmt.Errorf("Unknown command %q, try help", name)
+}
+
+func (currentDir *Dir) shellLs(args []string, all, long bool) (interface{}, error) {
+	list := func(name string) (interface{}, error) {
+		if long {
+			dirName, err := currentDir.lookup(name, true)
+			if err != nil {
+				return nil, err
+			}
+			return commands.LsEntries(dirName, commands.LsOptions{ShowHidden: all})
+		}
+		return currentDir.Ls(name, all)
+	}
+	if len(args) <= 1 {
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		return list(name)
+	}
+	listings := map[string]interface{}{}
+	for _, name := range args {
+		listing, err := list(name)
+		if err != nil {
+			return listings, fmt.Errorf("%s: %w", name, err)
+		}
+		listings[name] = listing
+	}
+	return listings, nil
+}
+
+func (currentDir *Dir) completeShell(line string, cursor int) shell.Completion {
+	names := []string{}
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	dirsOnly := map[string]bool{"cd": true, "mkdir": true}
+	return shell.Complete(line, cursor, names, dirsOnly, func(dir string) ([]string, error) {
+		dirName, err := currentDir.lookup(dir, true)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := commands.LsEntries(dirName, commands.LsOptions{ShowHidden: true})
+		if err != nil {
+			return nil, err
+		}
+		names := []string{}
+		for _, entry := range entries {
+			if entry.Type == commands.TypeDir {
+				entry.Name += "/"
+			}
+			names = append(names, entry.Name)
+		}
+		return names, nil
+	})
+}
+
+// splitFlags takes the leading -x arguments, letters can be combined as in
+// -al. "--" ends the flags.
+func splitFlags(args []string) (map[string]bool, []string) {
+	flags := map[string]bool{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		if args[0] == "--" {
+			return flags, args[1:]
+		}
+		for _, c := range args[0][1:] {
+			flags[string(c)] = true
+		}
+		args = args[1:]
+	}
+	return flags, args
+}
+
+func eachArg(args []string, fn func(string) error) error {
+	if len(args) == 0 {
+		return errors.New("Missing operand")
+	}
+	for _, arg := range args {
+		err := fn(arg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+	}
+	return nil
+}
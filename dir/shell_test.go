@@ -0,0 +1,123 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/dir"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+type shellMessage struct {
+	Type       string          `json:"type"`
+	ID         int             `json:"id"`
+	Command    string          `json:"command"`
+	Args       []string        `json:"args"`
+	OK         bool            `json:"ok"`
+	Output     json.RawMessage `json:"output"`
+	Error      string          `json:"error"`
+	Cwd        string          `json:"cwd"`
+	Start      int             `json:"start"`
+	Candidates []string        `json:"candidates"`
+}
+
+// shellRun sends a request and reads messages up to the one ending it.
+func shellRun(t *testing.T, conn *websocket.Conn, request interface{}) []shellMessage {
+	require.NoError(t, conn.WriteJSON(request))
+	messages := []shellMessage{}
+	for {
+		message := shellMessage{}
+		require.NoError(t, conn.ReadJSON(&message))
+		messages = append(messages, message)
+		if message.Type != "result" {
+			return messages
+		}
+	}
+}
+
+func TestShell(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", ".hidden", "docs/readme.md"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte("x"), 0644))
+	}
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	t.Cleanup(testServer.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http")+"/shell", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ready := shellMessage{}
+	require.NoError(t, conn.ReadJSON(&ready))
+	require.Equal(t, "ready", ready.Type)
+	require.Equal(t, root, ready.Cwd)
+
+	messages := shellRun(t, conn, map[string]interface{}{"id": 1, "line": "ls -a"})
+	require.Len(t, messages, 2)
+	require.Equal(t, "result", messages[0].Type)
+	require.Equal(t, 1, messages[0].ID)
+	require.Equal(t, "ls", messages[0].Command)
+	require.True(t, messages[0].OK)
+	require.JSONEq(t, `["docs", ".hidden", "a.txt", "b.txt"]`, string(messages[0].Output))
+	require.Equal(t, "done", messages[1].Type)
+	require.True(t, messages[1].OK)
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 2, "line": "mkdir 'new dir' && mv *.txt 'new dir' && cd new\\ dir && pwd"})
+	require.Len(t, messages, 3)
+	require.Equal(t, "mv", messages[1].Command)
+	require.False(t, messages[1].OK)
+	require.Equal(t, []string{"a.txt", "b.txt", "new dir"}, messages[1].Args)
+	require.Contains(t, messages[1].Error, "mv needs from and to")
+	require.Equal(t, "done", messages[2].Type)
+	require.False(t, messages[2].OK)
+	require.Equal(t, root, messages[2].Cwd)
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 3, "line": "mv a.txt 'new dir/a.txt' && cd \"new dir\" && ls"})
+	require.Len(t, messages, 4)
+	require.JSONEq(t, `["a.txt"]`, string(messages[2].Output))
+	require.Equal(t, filepath.Join(root, "new dir"), messages[3].Cwd)
+	require.FileExists(t, filepath.Join(root, "new dir", "a.txt"))
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 4, "line": "ls -l ../docs"})
+	entries := []map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(messages[0].Output, &entries))
+	require.Len(t, entries, 1)
+	require.Equal(t, "readme.md", entries[0]["name"])
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("cd && rm b.txt && ls")))
+	messages = []shellMessage{}
+	for len(messages) == 0 || messages[len(messages)-1].Type == "result" {
+		message := shellMessage{}
+		require.NoError(t, conn.ReadJSON(&message))
+		messages = append(messages, message)
+	}
+	require.Len(t, messages, 4)
+	require.JSONEq(t, `["docs", "new dir"]`, string(messages[2].Output))
+	require.Equal(t, root, messages[3].Cwd)
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 5, "line": "nope"})
+	require.False(t, messages[0].OK)
+	require.Contains(t, messages[0].Error, "Unknown command")
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 6, "line": "ls 'docs"})
+	require.Len(t, messages, 1)
+	require.Equal(t, "error", messages[0].Type)
+	require.Equal(t, "Unterminated quote", messages[0].Error)
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 7, "complete": true, "line": "cd n"})
+	require.Equal(t, "completion", messages[0].Type)
+	require.Equal(t, 3, messages[0].Start)
+	require.Equal(t, []string{"'new dir/'"}, messages[0].Candidates)
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 8, "complete": true, "line": "ls docs/ && pwd", "cursor": 8})
+	require.Equal(t, []string{"docs/readme.md"}, messages[0].Candidates)
+
+	messages = shellRun(t, conn, map[string]interface{}{"id": 9, "complete": true, "line": "m"})
+	require.Equal(t, []string{"mkdir", "mv"}, messages[0].Candidates)
+}
@@ -0,0 +1,206 @@
+package dir
+
+import (
+	"errors"
+	"files_server/replica"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// SymlinkPolicy says which symlinks are followed. Links themselves can
+// always be listed, inspected, moved and removed.
+type SymlinkPolicy string
+
+const (
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkRoot follows links whose target is within Config.Root.
+	SymlinkRoot   SymlinkPolicy = "root"
+	SymlinkRefuse SymlinkPolicy = "refuse"
+)
+
+var (
+	ErrSymlink        = errors.New("Symlinks are not followed")
+	ErrSymlinkOutside = errors.New("Symlink leads outside the root")
+	ErrSymlinkPolicy  = errors.New("Symlink policy must be follow, root or refuse")
+)
+
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(s); policy {
+	case SymlinkFollow, SymlinkRoot, SymlinkRefuse:
+		return policy, nil
+	}
+	return "", ErrSymlinkPolicy
+}
+
+// lookup is resolve with the symlink policy applied to every directory on
+// the way to name, and to name itself with follow. Directories above the
+// root are trusted when name is within the root.
+func (currentDir *Dir) lookup(name string, follow bool) (string, error) {
+	fileName := currentDir.resolve(name)
+	policy := currentDir.config.Symlinks
+	if policy == "" || policy == SymlinkFollow {
+		return fileName, nil
+	}
+
+	root := filepath.Clean(currentDir.config.Root)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+	current := string(filepath.Separator)
+	if within(fileName, root) {
+		current = root
+	}
+	rel, err := filepath.Rel(current, fileName)
+	if err != nil || rel == "." {
+		return fileName, nil
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+		if i == len(parts)-1 && !follow {
+			break
+		}
+		info, err := os.Lstat(current)
+		if err != nil {
+			break
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if policy == SymlinkRefuse {
+			return "", ErrSymlink
+		}
+		target, err := filepath.EvalSymlinks(current)
+		if err != nil {
+			target, err = linkTarget(current)
+			if err != nil {
+				return "", err
+			}
+		}
+		if !within(target, realRoot) {
+			return "", ErrSymlinkOutside
+		}
+		current = target
+	}
+	return fileName, nil
+}
+
+// linkTarget is where the symlink fileName points, as an absolute path.
+func linkTarget(fileName string) (string, error) {
+	target, err := os.Readlink(fileName)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(fileName), target)
+	}
+	return target, nil
+}
+
+func within(fileName, dir string) bool {
+	return fileName == dir || strings.HasPrefix(fileName, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// Ln links name to target, a symlink that stores target as given unless
+// hard. name must not exist yet.
+func (currentDir *Dir) Ln(target, name string, hard bool) error {
+	linkName, err := currentDir.lookup(name, false)
+	if err != nil {
+		return err
+	}
+	defer currentDir.mutating()()
+	if _, err := os.Lstat(linkName); err == nil {
+		return ErrExists
+	}
+	if !hard {
+		if currentDir.config.Symlinks == SymlinkRefuse {
+			return ErrSymlink
+		}
+		return os.Symlink(target, linkName)
+	}
+
+	targetName, err := currentDir.lookup(target, true)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(targetName)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ErrIsDir
+	}
+	err = os.Link(targetName, linkName)
+	if err != nil {
+		return err
+	}
+	currentDir.record(replica.OpWrite, linkName, "")
+	return nil
+}
+
+type Link struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	// Resolved is the file the link ends up at, following further links.
+	Resolved string `json:"resolved,omitempty"`
+	Broken   bool   `json:"broken"`
+	// Followed says whether the symlink policy lets the link be followed.
+	Followed bool `json:"followed"`
+}
+
+// Readlink tells where the symlink name points.
+func (currentDir *Dir) Readlink(name string) (Link, error) {
+	fileName, err := currentDir.lookup(name, false)
+	if err != nil {
+		return Link{}, err
+	}
+	target, err := os.Readlink(fileName)
+	if err != nil {
+		return Link{}, err
+	}
+	link := Link{Name: fileName, Target: target}
+	link.Resolved, err = filepath.EvalSymlinks(fileName)
+	link.Broken = err != nil
+	_, err = currentDir.lookup(fileName, true)
+	link.Followed = err == nil
+	return link, nil
+}
+
+// ln links name to target, hard=true for a hard link.
+func (currentDir *Dir) ln(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	target, name := query.Get("target"), query.Get("name")
+	if target == "" || name == "" {
+		http.Error(w, "No target or name", http.StatusBadRequest)
+		return
+	}
+	err := currentDir.Ln(target, name, query.Get("hard") == "true")
+	switch {
+	case err == ErrExists || err == ErrIsDir:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		fileError(w, err)
+	}
+}
+
+func (currentDir *Dir) readlink(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	link, err := currentDir.Readlink(fileName)
+	if errors.Is(err, syscall.EINVAL) {
+		http.Error(w, "Not a symlink", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	writeJSON(w, link)
+}
@@ -0,0 +1,148 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/commands"
+	"files_server/dir"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("hello"), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	tests := []struct {
+		name            string
+		query           string
+		expected_status int
+	}{
+		{name: "symlink", query: "target=docs/a.txt&name=sym.txt", expected_status: http.StatusOK},
+		{name: "broken_symlink", query: "target=missing.txt&name=broken.txt", expected_status: http.StatusOK},
+		{name: "hardlink", query: "target=docs/a.txt&name=hard.txt&hard=true", expected_status: http.StatusOK},
+		{name: "exists", query: "target=docs/a.txt&name=sym.txt", expected_status: http.StatusBadRequest},
+		{name: "hardlink_to_dir", query: "target=docs&name=docs2&hard=true", expected_status: http.StatusBadRequest},
+		{name: "hardlink_to_missing", query: "target=missing.txt&name=x.txt&hard=true", expected_status: http.StatusNotFound},
+		{name: "no_target", query: "name=y.txt", expected_status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, _ := doRequest(t, testServer, http.MethodGet, "/ln?"+test.query, "", nil)
+			require.Equal(t, test.expected_status, resp.StatusCode)
+		})
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "sym.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "docs/a.txt", target)
+	a, err := os.Stat(filepath.Join(root, "docs", "a.txt"))
+	require.NoError(t, err)
+	hard, err := os.Lstat(filepath.Join(root, "hard.txt"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(a, hard))
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/readlink?filename=sym.txt", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var link dir.Link
+	require.NoError(t, json.Unmarshal([]byte(body), &link))
+	realRoot, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+	require.Equal(t, dir.Link{Name: filepath.Join(root, "sym.txt"), Target: "docs/a.txt", Resolved: filepath.Join(realRoot, "docs", "a.txt"), Followed: true}, link)
+
+	_, body = doRequest(t, testServer, http.MethodGet, "/readlink?filename=broken.txt", "", nil)
+	link = dir.Link{}
+	require.NoError(t, json.Unmarshal([]byte(body), &link))
+	require.True(t, link.Broken)
+	require.Empty(t, link.Resolved)
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/readlink?filename=hard.txt", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/readlink?filename=missing.txt", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = doRequest(t, testServer, http.MethodGet, "/ls?format=ndjson", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	entries := map[string]commands.Entry{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	for decoder.More() {
+		var entry commands.Entry
+		require.NoError(t, decoder.Decode(&entry))
+		entries[entry.Name] = entry
+	}
+	require.Equal(t, commands.TypeSymlink, entries["sym.txt"].Type)
+	require.Equal(t, "docs/a.txt", entries["sym.txt"].Target)
+	require.False(t, entries["sym.txt"].Broken)
+	require.Equal(t, "missing.txt", entries["broken.txt"].Target)
+	require.True(t, entries["broken.txt"].Broken)
+	require.Equal(t, commands.TypeFile, entries["hard.txt"].Type)
+	require.Empty(t, entries["hard.txt"].Target)
+
+	_, body = doRequest(t, testServer, http.MethodGet, "/ls?format=text", "", nil)
+	require.Contains(t, body, " sym.txt -> docs/a.txt\n")
+}
+
+func TestSymlinkPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("hello"), 0644))
+	require.NoError(t, os.Symlink("docs", filepath.Join(root, "inside")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "outside")))
+
+	tests := []struct {
+		name            string
+		policy          dir.SymlinkPolicy
+		path            string
+		expected_status int
+	}{
+		{name: "follow_inside", policy: dir.SymlinkFollow, path: "/download?filename=inside/a.txt", expected_status: http.StatusOK},
+		{name: "follow_outside", policy: dir.SymlinkFollow, path: "/download?filename=outside/secret.txt", expected_status: http.StatusOK},
+		{name: "default_follows", policy: "", path: "/cd?dir=outside", expected_status: http.StatusOK},
+		{name: "root_inside", policy: dir.SymlinkRoot, path: "/download?filename=inside/a.txt", expected_status: http.StatusOK},
+		{name: "root_outside", policy: dir.SymlinkRoot, path: "/download?filename=outside/secret.txt", expected_status: http.StatusForbidden},
+		{name: "root_cd_outside", policy: dir.SymlinkRoot, path: "/cd?dir=outside", expected_status: http.StatusBadRequest},
+		{name: "root_write_outside", policy: dir.SymlinkRoot, path: "/touch?filename=outside/new.txt", expected_status: http.StatusForbidden},
+		{name: "refuse", policy: dir.SymlinkRefuse, path: "/download?filename=inside/a.txt", expected_status: http.StatusForbidden},
+		{name: "refuse_ls", policy: dir.SymlinkRefuse, path: "/find?dir=inside", expected_status: http.StatusForbidden},
+		{name: "refuse_create", policy: dir.SymlinkRefuse, path: "/ln?target=docs&name=again", expected_status: http.StatusForbidden},
+		{name: "refuse_real_path", policy: dir.SymlinkRefuse, path: "/download?filename=docs/a.txt", expected_status: http.StatusOK},
+		{name: "refuse_stat_link", policy: dir.SymlinkRefuse, path: "/stat?filename=outside", expected_status: http.StatusOK},
+		{name: "refuse_readlink", policy: dir.SymlinkRefuse, path: "/readlink?filename=outside", expected_status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Symlinks: test.policy}))
+			defer testServer.Close()
+			resp, _ := doRequest(t, testServer, http.MethodGet, test.path, "", nil)
+			require.Equal(t, test.expected_status, resp.StatusCode)
+		})
+	}
+	require.NoFileExists(t, filepath.Join(outside, "new.txt"))
+
+	// Removing a link never touches its target.
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Symlinks: dir.SymlinkRefuse}))
+	defer testServer.Close()
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/rm?filename=outside", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.FileExists(t, filepath.Join(outside, "secret.txt"))
+
+	_, err := dir.ParseSymlinkPolicy("sometimes")
+	require.ErrorIs(t, err, dir.ErrSymlinkPolicy)
+}
@@ -0,0 +1,309 @@
+package dir
+
+import (
+	"context"
+	"encoding/json"
+	"files_server/checksum"
+	"files_server/commands"
+	"files_server/delta"
+	"files_server/dirsync"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxManifestSize caps the body of /sync/diff and /sync/apply.
+const maxManifestSize = 256 << 20
+
+// sync mirrors a client directory into dir, the current directory by
+// default. The client posts its manifest to /sync/diff for what differs,
+// or to /sync/apply to create the missing directories and, with
+// delete=true, remove what it doesn't have. Files are then sent whole to
+// /sync/file, or for a changed file as a delta against the /sync/signature
+// of the server's copy to /sync/delta.
+func (currentDir *Dir) sync(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dirName, err := currentDir.lookup(query.Get("dir"), true)
+	if err == nil {
+		var info os.FileInfo
+		info, err = os.Stat(dirName)
+		if err == nil && !info.IsDir() {
+			http.Error(w, ErrNotDir.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/sync/manifest":
+		algo := query.Get("algo")
+		if algo == "" {
+			algo = checksum.SHA256
+		}
+		if _, err := checksum.New(algo); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		manifest, err := currentDir.manifest(r.Context(), dirName, algo)
+		if err != nil {
+			fileError(w, err)
+			return
+		}
+		writeJSON(w, manifest)
+	case "/sync/diff", "/sync/apply":
+		currentDir.syncDiff(w, r, dirName)
+	case "/sync/signature":
+		currentDir.signature(w, r, dirName)
+	case "/sync/file", "/sync/delta":
+		currentDir.syncFile(w, r, dirName)
+	}
+}
+
+// Manifest scans the directory name with hashes in algo, none if it is
+// empty.
+func (currentDir *Dir) Manifest(ctx context.Context, name, algo string) (dirsync.Manifest, error) {
+	dirName, err := currentDir.lookup(name, true)
+	if err != nil {
+		return dirsync.Manifest{}, err
+	}
+	info, err := os.Stat(dirName)
+	if err != nil {
+		return dirsync.Manifest{}, err
+	}
+	if !info.IsDir() {
+		return dirsync.Manifest{}, ErrNotDir
+	}
+	return currentDir.manifest(ctx, dirName, algo)
+}
+
+func (currentDir *Dir) manifest(ctx context.Context, dirName, algo string) (dirsync.Manifest, error) {
+	options := dirsync.Options{Algo: algo, Skip: commands.IsTemp}
+	if algo != "" {
+		cache := currentDir.config.Hashes
+		if cache == nil {
+			cache = checksum.NewCache(0)
+		}
+		options.Sum = func(fileName string) (string, error) {
+			return cache.Sum(fileName, algo)
+		}
+	}
+	return dirsync.Scan(ctx, dirName, options)
+}
+
+func (currentDir *Dir) syncDiff(w http.ResponseWriter, r *http.Request, dirName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	local := dirsync.Manifest{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxManifestSize)).Decode(&local)
+	if err == nil {
+		err = local.Check()
+	}
+	if err == nil && local.Algo != "" {
+		_, err = checksum.New(local.Algo)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	remote, err := currentDir.manifest(r.Context(), dirName, local.Algo)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	diff := dirsync.Compare(local, remote)
+	if r.URL.Path == "/sync/apply" {
+		diff, err = currentDir.applyDiff(dirName, diff, r.URL.Query().Get("delete") == "true")
+		if err != nil {
+			fileError(w, err)
+			return
+		}
+	}
+	writeJSON(w, diff)
+}
+
+// applyDiff makes the missing directories and removes the extras with
+// remove. What is left for the client to send is returned.
+func (currentDir *Dir) applyDiff(dirName string, diff dirsync.Diff, remove bool) (dirsync.Diff, error) {
+	left := dirsync.Diff{Missing: []dirsync.File{}, Changed: diff.Changed, Extra: []dirsync.File{}}
+	removed := ""
+	for _, file := range diff.Extra {
+		if !remove {
+			left.Extra = append(left.Extra, file)
+			continue
+		}
+		// Extras are sorted, so what is below a removed directory follows it.
+		if removed != "" && strings.HasPrefix(file.Path, removed+"/") {
+			continue
+		}
+		name, err := dirsync.Clean(file.Path)
+		if err != nil {
+			return left, err
+		}
+		err = currentDir.Rm(filepath.Join(dirName, name))
+		if err != nil {
+			return left, err
+		}
+		removed = file.Path
+	}
+
+	for _, file := range diff.Missing {
+		if !file.Dir {
+			left.Missing = append(left.Missing, file)
+			continue
+		}
+		name, err := dirsync.Clean(file.Path)
+		if err != nil {
+			return left, err
+		}
+		err = currentDir.Mkdir(filepath.Join(dirName, name))
+		if err != nil {
+			return left, err
+		}
+	}
+	return left, nil
+}
+
+// syncPath is the file path= below dirName.
+func syncPath(w http.ResponseWriter, r *http.Request, dirName string) (string, bool) {
+	name, err := dirsync.Clean(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return filepath.Join(dirName, name), true
+}
+
+// signature writes the delta.Signature of the file path, with blocks of
+// block bytes or a size that suits the file.
+func (currentDir *Dir) signature(w http.ResponseWriter, r *http.Request, dirName string) {
+	fileName, ok := syncPath(w, r, dirName)
+	if !ok {
+		return
+	}
+	file, err := currentDir.Open(fileName)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, ErrIsDir.Error(), http.StatusBadRequest)
+		return
+	}
+	blockSize := delta.BlockSize(info.Size())
+	if value := r.URL.Query().Get("block"); value != "" {
+		blockSize, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, delta.ErrBlockSize.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	signature, err := delta.NewSignature(file, blockSize)
+	if err == delta.ErrBlockSize {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, signature)
+}
+
+// syncFile writes the file path from the body, which /sync/delta applies
+// as a delta to the current content. mode= sets the permissions in octal
+// and mtime= the modification time.
+func (currentDir *Dir) syncFile(w http.ResponseWriter, r *http.Request, dirName string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	fileName, ok := syncPath(w, r, dirName)
+	if !ok {
+		return
+	}
+	var mode os.FileMode
+	var mtime time.Time
+	var err error
+	if value := query.Get("mode"); value != "" {
+		mode, err = parseMode(value)
+	}
+	if value := query.Get("mtime"); err == nil && value != "" {
+		mtime, err = time.Parse(time.RFC3339Nano, value)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var base *os.File
+	var size int64
+	if r.URL.Path == "/sync/delta" {
+		base, err = currentDir.Open(fileName)
+		if err != nil {
+			fileError(w, err)
+			return
+		}
+		defer base.Close()
+		info, err := base.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		size = info.Size()
+	} else {
+		err = currentDir.Mkdir(filepath.Dir(fileName))
+		if err != nil {
+			fileError(w, err)
+			return
+		}
+	}
+
+	writer, err := currentDir.Create(fileName, false)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	if mode != 0 {
+		writer.mode = mode
+	}
+	if base != nil {
+		err = delta.Apply(writer, base, size, r.Body)
+	} else {
+		_, err = io.Copy(writer, r.Body)
+	}
+	if err != nil {
+		writer.Abort()
+		switch err {
+		case delta.ErrDelta:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case delta.ErrChecksum:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	err = writer.Close()
+	if err == nil && !mtime.IsZero() {
+		err = currentDir.Chtimes(fileName, mtime, mtime)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
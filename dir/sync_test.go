@@ -0,0 +1,203 @@
+package dir_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"files_server/delta"
+	"files_server/dir"
+	"files_server/dirsync"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func syncManifest(t *testing.T, root string) string {
+	manifest, err := dirsync.Scan(context.Background(), root, dirsync.Options{})
+	require.NoError(t, err)
+	body, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func syncPaths(files []dirsync.File) []string {
+	out := []string{}
+	for _, file := range files {
+		out = append(out, file.Path)
+	}
+	return out
+}
+
+func TestSync(t *testing.T) {
+	local := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "docs", "new"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "docs", "same.txt"), []byte("same"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "docs", "changed.txt"), []byte("new content"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "docs", "new", "file.txt"), []byte("new"), 0644))
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "target", "docs", "old"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "target", "docs", "same.txt"), []byte("same"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "target", "docs", "changed.txt"), []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "target", "docs", "old", "file.txt"), []byte("old"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "target", ".write-123"), []byte("partial"), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/sync/manifest?dir=target", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	manifest := dirsync.Manifest{}
+	require.NoError(t, json.Unmarshal([]byte(body), &manifest))
+	require.Equal(t, "sha256", manifest.Algo)
+	require.Equal(t, []string{"docs", "docs/changed.txt", "docs/old", "docs/old/file.txt", "docs/same.txt"}, syncPaths(manifest.Files))
+
+	// same.txt was written at another time, without hashes it differs.
+	mtime := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(local, "docs", "same.txt"), mtime, mtime))
+	require.NoError(t, os.Chtimes(filepath.Join(root, "target", "docs", "same.txt"), mtime, mtime))
+
+	resp, body = doRequest(t, testServer, http.MethodPost, "/sync/diff?dir=target", syncManifest(t, local), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diff := dirsync.Diff{}
+	require.NoError(t, json.Unmarshal([]byte(body), &diff))
+	require.Equal(t, []string{"docs/new", "docs/new/file.txt"}, syncPaths(diff.Missing))
+	require.Equal(t, []string{"docs/changed.txt"}, syncPaths(diff.Changed))
+	require.Equal(t, []string{"docs/old", "docs/old/file.txt"}, syncPaths(diff.Extra))
+
+	resp, body = doRequest(t, testServer, http.MethodPost, "/sync/apply?dir=target", syncManifest(t, local), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diff = dirsync.Diff{}
+	require.NoError(t, json.Unmarshal([]byte(body), &diff))
+	require.Equal(t, []string{"docs/new/file.txt"}, syncPaths(diff.Missing))
+	require.Equal(t, []string{"docs/old", "docs/old/file.txt"}, syncPaths(diff.Extra))
+	require.DirExists(t, filepath.Join(root, "target", "docs", "new"))
+	require.DirExists(t, filepath.Join(root, "target", "docs", "old"))
+
+	resp, body = doRequest(t, testServer, http.MethodPost, "/sync/apply?dir=target&delete=true", syncManifest(t, local), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diff = dirsync.Diff{}
+	require.NoError(t, json.Unmarshal([]byte(body), &diff))
+	require.Empty(t, diff.Extra)
+	require.NoDirExists(t, filepath.Join(root, "target", "docs", "old"))
+	require.FileExists(t, filepath.Join(root, "target", ".write-123"))
+
+	mtime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	query := url.Values{"dir": {"target"}, "path": {"docs/new/file.txt"}, "mode": {"600"}, "mtime": {mtime.Format(time.RFC3339Nano)}}
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/sync/file?"+query.Encode(), "new", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	fileName := filepath.Join(root, "target", "docs", "new", "file.txt")
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	require.True(t, mtime.Equal(info.ModTime()))
+
+	query.Set("path", "docs/changed.txt")
+	query.Del("mode")
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/sync/file?"+query.Encode(), "new content", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, os.Chtimes(filepath.Join(local, "docs", "new", "file.txt"), mtime, mtime))
+	require.NoError(t, os.Chtimes(filepath.Join(local, "docs", "changed.txt"), mtime, mtime))
+	resp, body = doRequest(t, testServer, http.MethodPost, "/sync/diff?dir=target", syncManifest(t, local), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diff = dirsync.Diff{}
+	require.NoError(t, json.Unmarshal([]byte(body), &diff))
+	require.True(t, diff.Empty(), body)
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		body            string
+		expected_status int
+	}{
+		{name: "escape", method: http.MethodPut, path: "/sync/file?dir=target&path=../x", body: "x", expected_status: http.StatusBadRequest},
+		{name: "bad_mode", method: http.MethodPut, path: "/sync/file?dir=target&path=x&mode=999", body: "x", expected_status: http.StatusBadRequest},
+		{name: "bad_mtime", method: http.MethodPut, path: "/sync/file?dir=target&path=x&mtime=yesterday", body: "x", expected_status: http.StatusBadRequest},
+		{name: "get_file", method: http.MethodGet, path: "/sync/file?dir=target&path=x", expected_status: http.StatusMethodNotAllowed},
+		{name: "bad_manifest", method: http.MethodPost, path: "/sync/diff?dir=target", body: `{"files":[{"path":"../x"}]}`, expected_status: http.StatusBadRequest},
+		{name: "bad_algo", method: http.MethodGet, path: "/sync/manifest?algo=none", expected_status: http.StatusBadRequest},
+		{name: "missing_dir", method: http.MethodGet, path: "/sync/manifest?dir=missing", expected_status: http.StatusNotFound},
+		{name: "file_dir", method: http.MethodGet, path: "/sync/manifest?dir=target/docs/same.txt", expected_status: http.StatusBadRequest},
+		{name: "missing_base", method: http.MethodPut, path: "/sync/delta?dir=target&path=missing.txt", body: "x", expected_status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, _ := doRequest(t, testServer, test.method, test.path, test.body, nil)
+			require.Equal(t, test.expected_status, resp.StatusCode)
+		})
+	}
+}
+
+func TestSyncKeepsTemporary(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "target"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "target", ".upload-123"), []byte("partial"), 0644))
+	currentDir := dir.NewWithConfig(&dir.Config{Root: root})
+	testServer := httptest.NewServer(currentDir)
+	defer testServer.Close()
+
+	// An upload that is still being written when the sync removes extras.
+	writer, err := currentDir.Create("target/upload.txt", false)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("upload"))
+	require.NoError(t, err)
+
+	resp, body := doRequest(t, testServer, http.MethodPost, "/sync/apply?dir=target&delete=true", syncManifest(t, t.TempDir()), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	diff := dirsync.Diff{}
+	require.NoError(t, json.Unmarshal([]byte(body), &diff))
+	require.Empty(t, diff.Extra)
+
+	require.NoError(t, writer.Close())
+	content, err := os.ReadFile(filepath.Join(root, "target", "upload.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "upload", string(content))
+	_, err = os.Stat(filepath.Join(root, "target", ".upload-123"))
+	require.NoError(t, err)
+}
+
+func TestSyncDelta(t *testing.T) {
+	base := make([]byte, 200_000)
+	rand.New(rand.NewSource(1)).Read(base)
+	content := bytes.Join([][]byte{base[:100_000], []byte("inserted"), base[100_000:]}, nil)
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data.bin"), base, 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/sync/signature?path=data.bin&block=100", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, body = doRequest(t, testServer, http.MethodGet, "/sync/signature?path=data.bin", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	signature := delta.Signature{}
+	require.NoError(t, json.Unmarshal([]byte(body), &signature))
+	require.Equal(t, int64(len(base)), signature.Size)
+
+	patch := &bytes.Buffer{}
+	require.NoError(t, delta.Write(patch, signature, bytes.NewReader(content)))
+	require.Less(t, patch.Len(), 5000)
+
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/sync/delta?path=data.bin", "not a delta", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/sync/delta?path=data.bin", patch.String(), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	data, err := os.ReadFile(filepath.Join(root, "data.bin"))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	// The base changed under the delta, which the client must send whole.
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/sync/delta?path=data.bin", patch.String(), nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	data, err = os.ReadFile(filepath.Join(root, "data.bin"))
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+}
@@ -0,0 +1,411 @@
+package dir
+
+import (
+	"errors"
+	"files_server/textfile"
+	"files_server/versions"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxEditSize caps the body of one /edit.
+const maxEditSize = 32 << 20
+
+// followPoll is how often /tail?follow=true looks for new lines.
+const followPoll = 250 * time.Millisecond
+
+var (
+	ErrSelector     = errors.New("Use only one of head, tail, lines or bytes")
+	ErrRange        = errors.New("Bad range, use from-to, from- or -count")
+	ErrPrecondition = errors.New("Send the ETag of the file in If-Match, or If-None-Match: * for a new file")
+	ErrChanged      = errors.New("File changed since it was read")
+	ErrEditMode     = errors.New("Unknown mode, use replace or patch")
+	ErrNotUTF8      = errors.New("Only UTF-8 files can be patched")
+)
+
+// editLock makes the check of the ETag and the write of an /edit one step
+// for all sessions.
+var editLock sync.Mutex
+
+// etag changes whenever a file is written: writes through the server
+// rename a new file into place, which has another inode even with the same
+// size and mtime, and anything else changes size or mtime.
+func etag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x-%x"`, inode(info), info.ModTime().UnixNano(), info.Size())
+}
+
+// startWriter remembers whether anything was written, after that an error
+// can't become an error response anymore.
+type startWriter struct {
+	io.Writer
+	started bool
+}
+
+func (writer *startWriter) Write(p []byte) (int, error) {
+	writer.started = writer.started || len(p) > 0
+	return writer.Writer.Write(p)
+}
+
+// openText opens filename for /cat and /tail with the encoding from
+// encoding= or detected. A binary file is refused unless an encoding is
+// given.
+func (currentDir *Dir) openText(w http.ResponseWriter, r *http.Request) (*os.File, os.FileInfo, string, bool) {
+	fileName := r.URL.Query().Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+	encoding := r.URL.Query().Get("encoding")
+	if encoding != "" && !textfile.Valid(encoding) {
+		http.Error(w, textfile.ErrEncoding.Error(), http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	file, err := currentDir.Open(fileName)
+	if err != nil {
+		fileError(w, err)
+		return nil, nil, "", false
+	}
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = ErrIsDir
+	}
+	if err == nil && encoding == "" {
+		encoding, err = textfile.DetectFile(file)
+	}
+	if err != nil {
+		file.Close()
+		status := http.StatusInternalServerError
+		if err == ErrIsDir {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return nil, nil, "", false
+	}
+	if encoding == textfile.Binary {
+		file.Close()
+		http.Error(w, textfile.ErrBinary.Error()+", use /download", http.StatusUnsupportedMediaType)
+		return nil, nil, "", false
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Encoding", encoding)
+	w.Header().Set("ETag", etag(info))
+	return file, info, encoding, true
+}
+
+// cat writes filename as UTF-8, all of it or the first or last lines with
+// head=N or tail=N, lines=from-to counted from 1, or bytes=from-to of the
+// file itself.
+func (currentDir *Dir) cat(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	selectors := 0
+	for _, key := range []string{"head", "tail", "lines", "bytes"} {
+		if query.Has(key) {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		http.Error(w, ErrSelector.Error(), http.StatusBadRequest)
+		return
+	}
+	var count int
+	var from, to int64 = 0, math.MaxInt64
+	var err error
+	switch {
+	case query.Has("head"):
+		count, err = strconv.Atoi(query.Get("head"))
+	case query.Has("tail"):
+		count, err = strconv.Atoi(query.Get("tail"))
+	case query.Has("lines"):
+		from, to, err = parseSpan(query.Get("lines"), math.MaxInt64)
+		if err == nil && (from == 0 || strings.HasPrefix(query.Get("lines"), "-")) {
+			err = ErrRange
+		}
+	}
+	if err != nil || count < 0 {
+		http.Error(w, ErrRange.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, info, encoding, ok := currentDir.openText(w, r)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	if r.Header.Get("If-None-Match") == w.Header().Get("ETag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	out := &startWriter{Writer: w}
+	switch {
+	case query.Has("head"):
+		err = textfile.Head(out, textfile.NewReader(file, encoding), count)
+	case query.Has("tail"):
+		err = writeTail(out, file, info.Size(), encoding, count)
+	case query.Has("lines"):
+		err = textfile.Lines(out, textfile.NewReader(file, encoding), int(from), int(min(to, math.MaxInt)))
+	case query.Has("bytes"):
+		from, to, err = parseSpan(query.Get("bytes"), info.Size())
+		if err != nil {
+			http.Error(w, ErrRange.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		_, err = io.Copy(out, textfile.NewReader(io.NewSectionReader(file, from, to-from+1), encoding))
+	default:
+		_, err = io.Copy(out, textfile.NewReader(file, encoding))
+	}
+	if err != nil {
+		textError(w, out, err)
+	}
+}
+
+// textError reports an error of /cat or /tail. Once the status is sent it
+// cuts the response short instead, so that the client doesn't take it for
+// the whole text.
+func textError(w http.ResponseWriter, out *startWriter, err error) {
+	if out.started {
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Del("ETag")
+	w.Header().Del("X-Encoding")
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// parseSpan reads from-to, from- or -count, inclusive, of something with
+// size units, e.g. lines=10-20 or bytes=-1024.
+func parseSpan(s string, size int64) (int64, int64, error) {
+	fromText, toText, found := strings.Cut(s, "-")
+	if !found {
+		return 0, 0, ErrRange
+	}
+	if fromText == "" {
+		count, err := strconv.ParseInt(toText, 10, 64)
+		if err != nil || count <= 0 {
+			return 0, 0, ErrRange
+		}
+		return max(size-count, 0), size - 1, nil
+	}
+	from, err := strconv.ParseInt(fromText, 10, 64)
+	if err != nil || from < 0 {
+		return 0, 0, ErrRange
+	}
+	to := size - 1
+	if toText != "" {
+		to, err = strconv.ParseInt(toText, 10, 64)
+		if err != nil || to < from {
+			return 0, 0, ErrRange
+		}
+	}
+	if from >= size {
+		return 0, 0, ErrRange
+	}
+	return from, min(to, size-1), nil
+}
+
+// writeTail writes the last count lines of the first size bytes of file.
+func writeTail(w io.Writer, file *os.File, size int64, encoding string, count int) error {
+	if !textfile.ASCIICompatible(encoding) {
+		return textfile.Tail(w, textfile.NewReader(io.NewSectionReader(file, 0, size), encoding), count)
+	}
+	offset, err := textfile.TailOffset(file, size, count)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, textfile.NewReader(io.NewSectionReader(file, offset, size-offset), encoding))
+	return err
+}
+
+// tail writes the last lines=N lines of filename, 10 by default. With
+// follow=true it goes on with what is appended until the client goes away,
+// like tail -F.
+func (currentDir *Dir) tail(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	count := 10
+	if value := query.Get("lines"); value != "" {
+		var err error
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 0 {
+			http.Error(w, ErrRange.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	file, info, encoding, ok := currentDir.openText(w, r)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	follow := query.Get("follow") == "true"
+	if follow {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Del("ETag")
+	}
+	out := &startWriter{Writer: w}
+	err := writeTail(out, file, info.Size(), encoding, count)
+	if err != nil || !follow {
+		if err != nil {
+			textError(w, out, err)
+		}
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	follower := textfile.Follow(r.Context(), file.Name(), info.Size(), followPoll)
+	defer follower.Close()
+	reader := textfile.NewReader(follower, encoding)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// edit writes the body to filename, as it is or with mode=patch as a
+// unified diff applied to the current content. If-Match has to have the
+// ETag the file had when it was read, or If-None-Match: * creates a new
+// file.
+func (currentDir *Dir) edit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	fileName := query.Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	mode := query.Get("mode")
+	if mode != "" && mode != "replace" && mode != "patch" {
+		http.Error(w, ErrEditMode.Error(), http.StatusBadRequest)
+		return
+	}
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch != "*" {
+		http.Error(w, ErrPrecondition.Error(), http.StatusPreconditionRequired)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEditSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	editLock.Lock()
+	defer editLock.Unlock()
+
+	info, err := currentDir.Stat(fileName)
+	switch {
+	case err == nil && info.IsDir():
+		http.Error(w, ErrIsDir.Error(), http.StatusBadRequest)
+		return
+	case err != nil && !os.IsNotExist(err):
+		fileError(w, err)
+		return
+	}
+	exists := err == nil
+	if exists && (ifNoneMatch == "*" || ifMatch != "*" && ifMatch != etag(info)) || !exists && ifMatch != "" {
+		if exists {
+			w.Header().Set("ETag", etag(info))
+		}
+		http.Error(w, ErrChanged.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	content := body
+	if mode == "patch" {
+		content, err = currentDir.patch(fileName, exists, string(body))
+		switch {
+		case err == versions.ErrPatch:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err == ErrNotUTF8:
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		case err != nil:
+			fileError(w, err)
+			return
+		}
+	}
+
+	writer, err := currentDir.Create(fileName, false)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	_, err = writer.Write(content)
+	if err != nil {
+		writer.Abort()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = writer.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info, err = currentDir.Stat(fileName)
+	if err == nil {
+		w.Header().Set("ETag", etag(info))
+	}
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// patch applies a unified diff to the UTF-8 text in fileName, keeping
+// whether it ends with a newline.
+func (currentDir *Dir) patch(fileName string, exists bool, diff string) ([]byte, error) {
+	old := []byte{}
+	if exists {
+		file, err := currentDir.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		encoding, err := textfile.DetectFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if encoding != textfile.UTF8 {
+			return nil, ErrNotUTF8
+		}
+		old, err = io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lines, err := versions.Patch(versions.Lines(string(old)), diff)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 && (len(old) == 0 || old[len(old)-1] == '\n') {
+		content += "\n"
+	}
+	return []byte(content), nil
+}
@@ -0,0 +1,190 @@
+package dir_test
+
+import (
+	"bufio"
+	"files_server/dir"
+	"files_server/versions"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCat(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "build.log"), []byte("1\n2\n3\n4\n5\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "latin1.txt"), []byte("gr\xfc\xdfe\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "app.bin"), []byte{0x7f, 'E', 'L', 'F', 2, 1, 1, 0, 0, 0}, 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	tests := []struct {
+		name              string
+		query             string
+		expected_status   int
+		expected_body     string
+		expected_encoding string
+	}{
+		{name: "all", query: "filename=build.log", expected_status: http.StatusOK, expected_body: "1\n2\n3\n4\n5\n", expected_encoding: "utf-8"},
+		{name: "head", query: "filename=build.log&head=2", expected_status: http.StatusOK, expected_body: "1\n2\n"},
+		{name: "tail", query: "filename=build.log&tail=2", expected_status: http.StatusOK, expected_body: "4\n5\n"},
+		{name: "lines", query: "filename=build.log&lines=2-3", expected_status: http.StatusOK, expected_body: "2\n3\n"},
+		{name: "lines_to_end", query: "filename=build.log&lines=4-", expected_status: http.StatusOK, expected_body: "4\n5\n"},
+		{name: "bytes", query: "filename=build.log&bytes=2-5", expected_status: http.StatusOK, expected_body: "2\n3\n"},
+		{name: "last_bytes", query: "filename=build.log&bytes=-4", expected_status: http.StatusOK, expected_body: "4\n5\n"},
+		{name: "latin1", query: "filename=latin1.txt", expected_status: http.StatusOK, expected_body: "grüße\n", expected_encoding: "iso-8859-1"},
+		{name: "binary", query: "filename=app.bin", expected_status: http.StatusUnsupportedMediaType},
+		{name: "binary_as_latin1", query: "filename=app.bin&encoding=iso-8859-1&bytes=1-3", expected_status: http.StatusOK, expected_body: "ELF"},
+		{name: "unknown_encoding", query: "filename=build.log&encoding=ebcdic", expected_status: http.StatusBadRequest},
+		{name: "two_selectors", query: "filename=build.log&head=1&tail=1", expected_status: http.StatusBadRequest},
+		{name: "bad_lines", query: "filename=build.log&lines=0-2", expected_status: http.StatusBadRequest},
+		{name: "bytes_past_end", query: "filename=build.log&bytes=100-", expected_status: http.StatusRequestedRangeNotSatisfiable},
+		{name: "missing", query: "filename=missing.log", expected_status: http.StatusNotFound},
+		{name: "dir", query: "filename=.", expected_status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := doRequest(t, testServer, http.MethodGet, "/cat?"+test.query, "", nil)
+			require.Equal(t, test.expected_status, resp.StatusCode, body)
+			if test.expected_status != http.StatusOK {
+				return
+			}
+			require.Equal(t, test.expected_body, body)
+			require.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+			if test.expected_encoding != "" {
+				require.Equal(t, test.expected_encoding, resp.Header.Get("X-Encoding"))
+			}
+		})
+	}
+
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/cat?filename=build.log", "", nil)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/cat?filename=build.log", "", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// A rewrite with the same size and mtime is another file all the same.
+	info, err := os.Stat(filepath.Join(root, "build.log"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "new.log"), []byte("5\n4\n3\n2\n1\n"), 0644))
+	require.NoError(t, os.Chtimes(filepath.Join(root, "new.log"), info.ModTime(), info.ModTime()))
+	require.NoError(t, os.Rename(filepath.Join(root, "new.log"), filepath.Join(root, "build.log")))
+	resp, body := doRequest(t, testServer, http.MethodGet, "/cat?filename=build.log", "", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "5\n4\n3\n2\n1\n", body)
+	require.NotEqual(t, etag, resp.Header.Get("ETag"))
+}
+
+func TestTailFollow(t *testing.T) {
+	root := t.TempDir()
+	fileName := filepath.Join(root, "build.log")
+	require.NoError(t, os.WriteFile(fileName, []byte("1\n2\n3\n"), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/tail?filename=build.log&lines=2", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "2\n3\n", body)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/tail?filename=build.log&lines=1&follow=true", nil)
+	require.NoError(t, err)
+	resp, err = testServer.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "3\n", line)
+
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer file.Close()
+	for _, step := range []string{"4\n", "done\n"} {
+		_, err = file.WriteString(step)
+		require.NoError(t, err)
+		done := make(chan string)
+		go func() {
+			line, _ := reader.ReadString('\n')
+			done <- line
+		}()
+		select {
+		case line = <-done:
+			require.Equal(t, step, line)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no line followed")
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	root := t.TempDir()
+	fileName := filepath.Join(root, "notes.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("one\ntwo\nthree\n"), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root}))
+	defer testServer.Close()
+	content := func() string {
+		data, err := os.ReadFile(fileName)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/cat?filename=notes.txt", "", nil)
+	etag := resp.Header.Get("ETag")
+
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/edit?filename=notes.txt", "new\n", nil)
+	require.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/edit?filename=notes.txt", "new\n", map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	diff := "--- a\n+++ b\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	resp, _ = doRequest(t, testServer, http.MethodPost, "/edit?filename=notes.txt&mode=patch", diff, map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "one\n2\nthree\n", content())
+	newTag := resp.Header.Get("ETag")
+	require.NotEqual(t, etag, newTag)
+
+	// Somebody else wrote in between, the old ETag is stale.
+	resp, body := doRequest(t, testServer, http.MethodPut, "/edit?filename=notes.txt", "lost\n", map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	require.Equal(t, dir.ErrChanged.Error()+"\n", body)
+	require.Equal(t, newTag, resp.Header.Get("ETag"))
+	require.Equal(t, "one\n2\nthree\n", content())
+
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/edit?filename=notes.txt&mode=patch", diff, map[string]string{"If-Match": newTag})
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, body = doRequest(t, testServer, http.MethodPut, "/edit?filename=notes.txt", "replaced\n", map[string]string{"If-Match": newTag})
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	require.Equal(t, "replaced\n", content())
+
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/edit?filename=new.txt", "fresh\n", map[string]string{"If-None-Match": "*"})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/edit?filename=new.txt", "again\n", map[string]string{"If-None-Match": "*"})
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/edit?filename=other.txt", "x", map[string]string{"If-Match": "*"})
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodPut, "/edit?filename=notes.txt&mode=append", "x", map[string]string{"If-Match": "*"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestEditKeepsVersions(t *testing.T) {
+	root := t.TempDir()
+	store, err := versions.Open(t.TempDir(), 0, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SetPolicy(root, versions.Policy{Keep: 5}))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("v1\n"), 0644))
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Versions: store}))
+	defer testServer.Close()
+
+	resp, body := doRequest(t, testServer, http.MethodPut, "/edit?filename=a.txt", "v2\n", map[string]string{"If-Match": "*"})
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	list, err := store.List(filepath.Join(root, "a.txt"))
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.True(t, strings.HasPrefix(list[0].Reason, versions.ReasonModify))
+}
@@ -0,0 +1,174 @@
+package dir
+
+import (
+	"encoding/json"
+	"files_server/replica"
+	"files_server/upload"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// upload implements a resumable upload in the spirit of tus:
+//
+//	POST   /upload?filename=...&length=N  creates an upload, returns its id
+//	HEAD   /upload?id=...                 current offset in Upload-Offset
+//	PATCH  /upload?id=...                 writes the body at Upload-Offset
+//	DELETE /upload?id=...                 drops the upload
+//	POST   /upload/finish?id=...&checksum=sha256:...
+func (currentDir *Dir) upload(w http.ResponseWriter, r *http.Request) {
+	if currentDir.config.Uploads == nil {
+		http.Error(w, "Uploads are disabled", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		currentDir.createUpload(w, r)
+	case http.MethodHead, http.MethodGet:
+		currentDir.uploadStatus(w, r)
+	case http.MethodPatch:
+		currentDir.writeUpload(w, r)
+	case http.MethodDelete:
+		err := currentDir.config.Uploads.Cancel(r.URL.Query().Get("id"))
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (currentDir *Dir) createUpload(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	fileName, err := currentDir.lookup(fileName, false)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.URL.Query().Get("length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Bad length", http.StatusBadRequest)
+		return
+	}
+
+	fileInfo, err := os.Stat(filepath.Dir(fileName))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !fileInfo.IsDir() {
+		http.Error(w, "Not directory", http.StatusBadRequest)
+		return
+	}
+	if fileInfo, err = os.Stat(fileName); err == nil && fileInfo.IsDir() {
+		http.Error(w, ErrIsDir.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := currentDir.config.Uploads.Create(fileName, length)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/upload?id="+u.ID)
+	setUploadHeaders(w, u)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(u.ID))
+}
+
+func (currentDir *Dir) uploadStatus(w http.ResponseWriter, r *http.Request) {
+	u, err := currentDir.config.Uploads.Get(r.URL.Query().Get("id"))
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	setUploadHeaders(w, u)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	res, err := json.Marshal(u)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+func (currentDir *Dir) writeUpload(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	u, err := currentDir.config.Uploads.Write(r.URL.Query().Get("id"), offset, r.Body)
+	if u.ID != "" {
+		setUploadHeaders(w, u)
+	}
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (currentDir *Dir) finishUpload(w http.ResponseWriter, r *http.Request) {
+	if currentDir.config.Uploads == nil {
+		http.Error(w, "Uploads are disabled", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The checksum is checked before replace keeps a version of the target.
+	query := r.URL.Query()
+	u, err := currentDir.config.Uploads.Verify(query.Get("id"), query.Get("checksum"))
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	defer currentDir.mutating()()
+	err = currentDir.replace(u.Target, func() error {
+		_, err := currentDir.config.Uploads.Finish(u.ID, "")
+		return err
+	})
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	currentDir.record(replica.OpWrite, u.Target, "")
+	w.Write([]byte(u.Target))
+}
+
+func setUploadHeaders(w http.ResponseWriter, u upload.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	switch err {
+	case upload.ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case upload.ErrOffset, upload.ErrBusy, upload.ErrIncomplete:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case upload.ErrBadSum:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case upload.ErrTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case upload.ErrChecksum:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,79 @@
+package dir_test
+
+import (
+	"files_server/dir"
+	"files_server/upload"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func doRequest(t *testing.T, testServer *httptest.Server, method, path string, body string, headers map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, testServer.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := testServer.Client().Do(req)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	return resp, string(b)
+}
+
+func TestUpload(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "sub"), 0777))
+	store, err := upload.NewStore(t.TempDir(), 0, nil)
+	require.NoError(t, err)
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Uploads: store}))
+	defer testServer.Close()
+
+	resp, _ := doRequest(t, testServer, http.MethodGet, "/cd?dir=sub", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = doRequest(t, testServer, http.MethodPost, "/upload?filename=missing/file.txt&length=3", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, id := doRequest(t, testServer, http.MethodPost, "/upload?filename=file.txt&length=10", "", nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "/upload?id="+id, resp.Header.Get("Location"))
+	require.Equal(t, "0", resp.Header.Get("Upload-Offset"))
+
+	resp, _ = doRequest(t, testServer, http.MethodPatch, "/upload?id="+id, "01234", map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+
+	resp, _ = doRequest(t, testServer, http.MethodPatch, "/upload?id="+id, "01234", map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+
+	resp, _ = doRequest(t, testServer, http.MethodHead, "/upload?id="+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+	require.Equal(t, "10", resp.Header.Get("Upload-Length"))
+
+	resp, _ = doRequest(t, testServer, http.MethodPost, "/upload/finish?id="+id, "", nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, _ = doRequest(t, testServer, http.MethodPatch, "/upload?id="+id, "56789", map[string]string{"Upload-Offset": "5"})
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, target := doRequest(t, testServer, http.MethodPost, "/upload/finish?id="+id+"&checksum=sha1:87acec17cd9dcd20a716cc2cf67417b71c8a7016", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, filepath.Join(root, "sub", "file.txt"), target)
+
+	b, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(b))
+
+	resp, _ = doRequest(t, testServer, http.MethodHead, "/upload?id="+id, "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
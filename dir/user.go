@@ -0,0 +1,21 @@
+package dir
+
+import "context"
+
+// User is who a request is made for. auth puts it into the request context,
+// a request without one is made by nobody in particular.
+type User struct {
+	Name  string
+	Admin bool
+}
+
+type userKey struct{}
+
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func UserFromContext(ctx context.Context) User {
+	user, _ := ctx.Value(userKey{}).(User)
+	return user
+}
@@ -0,0 +1,230 @@
+package dir
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"files_server/replica"
+	"files_server/versions"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const diffContext = 3
+
+type versionPolicy struct {
+	Dir string `json:"dir"`
+	versions.Policy
+	Versioned bool  `json:"versioned"`
+	Usage     int64 `json:"usage"`
+	Quota     int64 `json:"quota"`
+}
+
+func (currentDir *Dir) versions(w http.ResponseWriter, r *http.Request) {
+	store := currentDir.config.Versions
+	if store == nil {
+		http.Error(w, "Versioning is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	if r.URL.Path == "/versions/policy" {
+		currentDir.versionPolicy(w, r)
+		return
+	}
+
+	fileName := r.URL.Query().Get("filename")
+	if fileName == "" {
+		http.Error(w, "No filename", http.StatusBadRequest)
+		return
+	}
+	fileName, err := currentDir.lookup(fileName, false)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/versions":
+		list, err := store.List(fileName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res, err := json.Marshal(list)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(res)
+	case "/versions/get":
+		file, v, err := store.Open(fileName, r.URL.Query().Get("id"))
+		if err != nil {
+			versionError(w, err)
+			return
+		}
+		defer file.Close()
+		http.ServeContent(w, r, filepath.Base(fileName), v.ModTime, file)
+	case "/versions/diff":
+		currentDir.diffVersions(w, r, fileName)
+	case "/versions/restore":
+		currentDir.restoreVersion(w, r, fileName)
+	}
+}
+
+// versionPolicy shows the policy that applies to dir and changes it when
+// keep or maxage are given. keep=0&maxage=0 turns versioning off.
+func (currentDir *Dir) versionPolicy(w http.ResponseWriter, r *http.Request) {
+	store := currentDir.config.Versions
+	query := r.URL.Query()
+	dirName, err := currentDir.lookup(query.Get("dir"), true)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	if query.Has("keep") || query.Has("maxage") {
+		policy := versions.Policy{}
+		var err error
+		if value := query.Get("keep"); value != "" {
+			policy.Keep, err = strconv.Atoi(value)
+		}
+		if value := query.Get("maxage"); value != "" && err == nil {
+			policy.MaxAge, err = time.ParseDuration(value)
+		}
+		if err == nil && (policy.Keep < 0 || policy.MaxAge < 0) {
+			err = errors.New("Negative keep or maxage")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fileInfo, err := os.Stat(dirName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !fileInfo.IsDir() {
+			http.Error(w, "Not directory", http.StatusBadRequest)
+			return
+		}
+		err = store.SetPolicy(dirName, policy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	policy, ok := store.Policy(dirName)
+	res, err := json.Marshal(versionPolicy{
+		Dir:       dirName,
+		Policy:    policy,
+		Versioned: ok,
+		Usage:     store.Usage(),
+		Quota:     store.Quota(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+// diffVersions diffs version from against version to, or against the
+// current file when to is empty.
+func (currentDir *Dir) diffVersions(w http.ResponseWriter, r *http.Request, fileName string) {
+	query := r.URL.Query()
+	a, err := currentDir.readVersion(fileName, query.Get("from"))
+	if err != nil {
+		versionError(w, err)
+		return
+	}
+	b, err := currentDir.readVersion(fileName, query.Get("to"))
+	if err != nil {
+		versionError(w, err)
+		return
+	}
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		http.Error(w, "Binary files can't be diffed", http.StatusBadRequest)
+		return
+	}
+
+	edits, err := versions.Diff(versions.Lines(string(a)), versions.Lines(string(b)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	nameA := fileName + "@" + query.Get("from")
+	nameB := fileName
+	if to := query.Get("to"); to != "" {
+		nameB += "@" + to
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	versions.Unified(w, nameA, nameB, edits, diffContext)
+}
+
+func (currentDir *Dir) readVersion(fileName, id string) ([]byte, error) {
+	if id == "" {
+		return os.ReadFile(fileName)
+	}
+	file, _, err := currentDir.config.Versions.Open(fileName, id)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// restoreVersion writes a version back like any other change, so the
+// content it replaces becomes a version itself.
+func (currentDir *Dir) restoreVersion(w http.ResponseWriter, r *http.Request, fileName string) {
+	file, v, err := currentDir.config.Versions.Open(fileName, r.URL.Query().Get("id"))
+	if err != nil {
+		versionError(w, err)
+		return
+	}
+	defer file.Close()
+
+	defer currentDir.mutating()()
+	err = currentDir.replace(fileName, func() error {
+		tmp, err := os.CreateTemp(filepath.Dir(fileName), commands.TempRestore+"*")
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tmp, file)
+		closeErr := tmp.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err == nil {
+			err = os.Chtimes(tmp.Name(), time.Now(), v.ModTime)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), fileName)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	currentDir.record(replica.OpWrite, fileName, "")
+}
+
+func versionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, versions.ErrNotFound) || os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
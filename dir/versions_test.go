@@ -0,0 +1,82 @@
+package dir_test
+
+import (
+	"encoding/json"
+	"files_server/dir"
+	"files_server/upload"
+	"files_server/versions"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func listVersions(t *testing.T, testServer *httptest.Server, fileName string) []versions.Version {
+	resp, body := doRequest(t, testServer, http.MethodGet, "/versions?filename="+fileName, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	list := []versions.Version{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	return list
+}
+
+func TestVersions(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0755))
+	store, err := versions.Open(t.TempDir(), 0, nil)
+	require.NoError(t, err)
+	uploads, err := upload.NewStore(t.TempDir(), 0, nil)
+	require.NoError(t, err)
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Uploads: uploads, Versions: store}))
+	defer testServer.Close()
+
+	uploadFile(t, testServer, "docs/a.txt", "one\n")
+	uploadFile(t, testServer, "docs/a.txt", "two\n")
+	require.Empty(t, listVersions(t, testServer, "docs/a.txt"))
+
+	resp, body := doRequest(t, testServer, http.MethodGet, "/versions/policy?dir=docs&keep=5", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `{"dir":"`+filepath.Join(root, "docs")+`","keep":5,"max_age":0,"versioned":true,"usage":0,"quota":0}`, body)
+
+	uploadFile(t, testServer, "docs/a.txt", "three\n")
+	list := listVersions(t, testServer, "docs/a.txt")
+	require.Len(t, list, 1)
+	require.Equal(t, versions.ReasonModify, list[0].Reason)
+
+	// An upload with the wrong checksum changes nothing, so keeps nothing.
+	resp, id := doRequest(t, testServer, http.MethodPost, "/upload?filename=docs/a.txt&length=4", "", nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodPatch, "/upload?id="+id, "bad\n", map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = doRequest(t, testServer, http.MethodPost, "/upload/finish?id="+id+"&checksum=sha256:"+strings.Repeat("0", 64), "", nil)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	require.Len(t, listVersions(t, testServer, "docs/a.txt"), 1)
+
+	resp, body = doRequest(t, testServer, http.MethodGet, "/versions/get?filename=docs/a.txt&id="+list[0].ID, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "two\n", body)
+
+	resp, body = doRequest(t, testServer, http.MethodGet, "/versions/diff?filename=docs/a.txt&from="+list[0].ID, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	fileName := filepath.Join(root, "docs", "a.txt")
+	require.Equal(t, "--- "+fileName+"@"+list[0].ID+"\n+++ "+fileName+"\n@@ -1,1 +1,1 @@\n-two\n+three\n", body)
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/rm?filename=docs", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	list = listVersions(t, testServer, "docs/a.txt")
+	require.Len(t, list, 2)
+	require.Equal(t, versions.ReasonDelete, list[1].Reason)
+
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0755))
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/versions/restore?filename=docs/a.txt&id="+list[0].ID, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	content, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, "two\n", string(content))
+
+	resp, _ = doRequest(t, testServer, http.MethodGet, "/versions/get?filename=docs/a.txt&id=1", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,142 @@
+package dir
+
+import (
+	"encoding/json"
+	"files_server/watch"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const keepAlive = 30 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+func (currentDir *Dir) watch(w http.ResponseWriter, r *http.Request) {
+	if currentDir.config.Watch == nil {
+		http.Error(w, "Watch is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	dirName, err := currentDir.lookup(r.URL.Query().Get("dir"), true)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	fileInfo, err := os.Stat(dirName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !fileInfo.IsDir() {
+		http.Error(w, "Not directory", http.StatusBadRequest)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_id")
+	}
+	var since uint64
+	if lastID != "" {
+		since, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+	sub, err := currentDir.config.Watch.Subscribe(dirName, recursive, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		watchWebSocket(w, r, sub)
+		return
+	}
+	watchSSE(w, r, sub)
+}
+
+func watchSSE(w http.ResponseWriter, r *http.Request, sub *watch.Subscription) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			if event.ID > 0 {
+				fmt.Fprintf(w, "id: %d\n", event.ID)
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Op, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func watchWebSocket(w http.ResponseWriter, r *http.Request, sub *watch.Subscription) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		case event, ok := <-sub.Events():
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "resume with last_id"))
+				return
+			}
+			err = conn.WriteJSON(event)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package dir_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"files_server/dir"
+	"files_server/watch"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func initWatchEnv(t *testing.T) (string, *httptest.Server) {
+	root := t.TempDir()
+	hub, err := watch.NewHub(0, 100)
+	require.NoError(t, err)
+	t.Cleanup(func() { hub.Close() })
+
+	testServer := httptest.NewServer(dir.NewWithConfig(&dir.Config{Root: root, Watch: hub}))
+	t.Cleanup(testServer.Close)
+	return root, testServer
+}
+
+func TestWatchSSE(t *testing.T) {
+	root, testServer := initWatchEnv(t)
+
+	resp, err := testServer.Client().Get(testServer.URL + "/watch?dir=missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = testServer.Client().Get(testServer.URL + "/watch")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	touch, err := testServer.Client().Get(testServer.URL + "/touch?filename=new.txt")
+	require.NoError(t, err)
+	touch.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	require.Equal(t, "id: 1", lines[0])
+	require.Equal(t, "event: create", lines[1])
+
+	event := watch.Event{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &event))
+	require.Equal(t, filepath.Join(root, "new.txt"), event.Path)
+}
+
+func TestWatchWebSocket(t *testing.T) {
+	root, testServer := initWatchEnv(t)
+	require.NoError(t, os.Mkdir(filepath.Join(root, "sub"), 0777))
+
+	url := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/watch?recursive=true"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sub", "a.txt"), nil, 0666))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	event := watch.Event{}
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, watch.OpCreate, event.Op)
+	require.Equal(t, filepath.Join(root, "sub", "a.txt"), event.Path)
+	conn.Close()
+
+	require.NoError(t, os.Remove(filepath.Join(root, "sub", "a.txt")))
+	time.Sleep(100 * time.Millisecond)
+
+	conn, _, err = websocket.DefaultDialer.Dial(url+"&last_id=1", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, watch.OpDelete, event.Op)
+	require.Equal(t, uint64(2), event.ID)
+}
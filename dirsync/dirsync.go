@@ -0,0 +1,173 @@
+package dirsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var ErrPath = errors.New("Paths must be relative and stay inside the directory")
+
+// File is an entry of a Manifest. Path is relative to the synced
+// directory, with slashes.
+type File struct {
+	Path    string      `json:"path"`
+	Dir     bool        `json:"dir,omitempty"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	Hash    string      `json:"hash,omitempty"`
+}
+
+// Manifest is what a directory has. Algo is the algorithm of the hashes,
+// files without a hash are compared by size and modification time.
+type Manifest struct {
+	Algo  string `json:"algo,omitempty"`
+	Files []File `json:"files"`
+}
+
+// Diff is what the server has to change to be like the client: files it
+// lacks, files with other content and files only it has.
+type Diff struct {
+	Missing []File `json:"missing"`
+	Changed []File `json:"changed"`
+	Extra   []File `json:"extra"`
+}
+
+func (diff Diff) Empty() bool {
+	return len(diff.Missing) == 0 && len(diff.Changed) == 0 && len(diff.Extra) == 0
+}
+
+type Options struct {
+	Algo string
+	// Sum hashes a file with Algo, nil leaves the hashes out.
+	Sum func(fileName string) (string, error)
+	// Skip leaves out entries by name, e.g. temporary files.
+	Skip func(name string) bool
+}
+
+// Scan makes the manifest of everything below root in lexical order.
+// Symlinks and special files are left out.
+func Scan(ctx context.Context, root string, options Options) (Manifest, error) {
+	manifest := Manifest{Algo: options.Algo, Files: []File{}}
+	if options.Sum == nil {
+		manifest.Algo = ""
+	}
+	err := filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fullPath == root {
+			return nil
+		}
+		if options.Skip != nil && options.Skip(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err
+		}
+		file := File{Path: filepath.ToSlash(rel), Dir: entry.IsDir(), ModTime: info.ModTime(), Mode: info.Mode().Perm()}
+		if !file.Dir {
+			file.Size = info.Size()
+			if options.Sum != nil {
+				file.Hash, err = options.Sum(fullPath)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		manifest.Files = append(manifest.Files, file)
+		return nil
+	})
+	return manifest, err
+}
+
+// Check makes sure every path of manifest stays inside the directory it is
+// applied to and is there only once.
+func (manifest Manifest) Check() error {
+	seen := map[string]bool{}
+	for _, file := range manifest.Files {
+		clean, err := Clean(file.Path)
+		if err != nil {
+			return err
+		}
+		if seen[clean] {
+			return fmt.Errorf("Duplicate path %q", file.Path)
+		}
+		seen[clean] = true
+	}
+	return nil
+}
+
+// Clean turns a manifest path into a relative native path, refusing
+// anything that leads out of the directory.
+func Clean(name string) (string, error) {
+	native := filepath.FromSlash(path.Clean(name))
+	if name == "" || native == "." || !filepath.IsLocal(native) {
+		return "", ErrPath
+	}
+	return native, nil
+}
+
+// Compare finds what remote lacks to be like local. Files are the same
+// when their hashes are, if both sides have one, and else when size and
+// modification time to the second are. A file where remote has a
+// directory, or the other way around, is extra and missing.
+func Compare(local, remote Manifest) Diff {
+	diff := Diff{Missing: []File{}, Changed: []File{}, Extra: []File{}}
+	remoteFiles := map[string]File{}
+	for _, file := range remote.Files {
+		remoteFiles[path.Clean(file.Path)] = file
+	}
+	localPaths := map[string]bool{}
+	sameAlgo := local.Algo != "" && local.Algo == remote.Algo
+
+	for _, file := range local.Files {
+		name := path.Clean(file.Path)
+		localPaths[name] = true
+		other, ok := remoteFiles[name]
+		switch {
+		case !ok:
+			diff.Missing = append(diff.Missing, file)
+		case file.Dir != other.Dir:
+			diff.Extra = append(diff.Extra, other)
+			diff.Missing = append(diff.Missing, file)
+		case file.Dir:
+		case file.Size != other.Size:
+			diff.Changed = append(diff.Changed, file)
+		case sameAlgo && file.Hash != "" && other.Hash != "":
+			if file.Hash != other.Hash {
+				diff.Changed = append(diff.Changed, file)
+			}
+		case !file.ModTime.Truncate(time.Second).Equal(other.ModTime.Truncate(time.Second)):
+			diff.Changed = append(diff.Changed, file)
+		}
+	}
+	for _, file := range remote.Files {
+		if !localPaths[path.Clean(file.Path)] {
+			diff.Extra = append(diff.Extra, file)
+		}
+	}
+	sort.Slice(diff.Extra, func(i, j int) bool { return diff.Extra[i].Path < diff.Extra[j].Path })
+	return diff
+}
@@ -0,0 +1,116 @@
+package dirsync_test
+
+import (
+	"context"
+	"files_server/dirsync"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func paths(files []dirsync.File) []string {
+	out := []string{}
+	for _, file := range files {
+		out = append(out, file.Path)
+	}
+	return out
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b", "c.txt"), []byte("abc"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "top.txt"), []byte("top"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".tmp"), []byte("tmp"), 0644))
+	require.NoError(t, os.Symlink("top.txt", filepath.Join(root, "link")))
+
+	manifest, err := dirsync.Scan(context.Background(), root, dirsync.Options{
+		Algo: "len",
+		Sum: func(fileName string) (string, error) {
+			data, err := os.ReadFile(fileName)
+			return string(data), err
+		},
+		Skip: func(name string) bool { return name == ".tmp" },
+	})
+	require.NoError(t, err)
+	require.Equal(t, "len", manifest.Algo)
+	require.Equal(t, []string{"a", "a/b", "a/b/c.txt", "top.txt"}, paths(manifest.Files))
+	require.True(t, manifest.Files[1].Dir)
+	require.Equal(t, int64(3), manifest.Files[2].Size)
+	require.Equal(t, "abc", manifest.Files[2].Hash)
+	require.Equal(t, os.FileMode(0600), manifest.Files[3].Mode)
+	require.NoError(t, manifest.Check())
+
+	manifest, err = dirsync.Scan(context.Background(), root, dirsync.Options{Algo: "len"})
+	require.NoError(t, err)
+	require.Equal(t, "", manifest.Algo)
+	require.Equal(t, "", manifest.Files[2].Hash)
+}
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	remote := dirsync.Manifest{Algo: "sha256", Files: []dirsync.File{
+		{Path: "same.txt", Size: 1, ModTime: now, Hash: "1"},
+		{Path: "hash.txt", Size: 1, ModTime: now, Hash: "1"},
+		{Path: "size.txt", Size: 1, ModTime: now, Hash: "1"},
+		{Path: "touched.txt", Size: 1, ModTime: now, Hash: "1"},
+		{Path: "was_file", Size: 1, ModTime: now},
+		{Path: "dir", Dir: true, ModTime: now},
+		{Path: "old", Dir: true, ModTime: now},
+		{Path: "old/file.txt", Size: 1, ModTime: now},
+	}}
+	local := dirsync.Manifest{Algo: "sha256", Files: []dirsync.File{
+		{Path: "same.txt", Size: 1, ModTime: now.Add(time.Hour), Hash: "1"},
+		{Path: "hash.txt", Size: 1, ModTime: now, Hash: "2"},
+		{Path: "size.txt", Size: 2, ModTime: now, Hash: "1"},
+		{Path: "touched.txt", Size: 1, ModTime: now.Add(time.Hour)},
+		{Path: "was_file", Dir: true, ModTime: now},
+		{Path: "dir", Dir: true, ModTime: now.Add(time.Hour)},
+		{Path: "new.txt", Size: 1, ModTime: now},
+	}}
+	diff := dirsync.Compare(local, remote)
+	require.Equal(t, []string{"was_file", "new.txt"}, paths(diff.Missing))
+	require.Equal(t, []string{"hash.txt", "size.txt", "touched.txt"}, paths(diff.Changed))
+	require.Equal(t, []string{"old", "old/file.txt", "was_file"}, paths(diff.Extra))
+	require.False(t, diff.Empty())
+
+	// Without hashes on both sides only size and mtime count.
+	local.Algo = "md5"
+	diff = dirsync.Compare(local, remote)
+	require.Equal(t, []string{"same.txt", "size.txt", "touched.txt"}, paths(diff.Changed))
+
+	require.True(t, dirsync.Compare(remote, remote).Empty())
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		paths          []string
+		expected_error bool
+	}{
+		{name: "valid", paths: []string{"a", "a/b.txt", "./c"}},
+		{name: "parent", paths: []string{"../x"}, expected_error: true},
+		{name: "inner_parent", paths: []string{"a/../../x"}, expected_error: true},
+		{name: "absolute", paths: []string{"/etc/passwd"}, expected_error: true},
+		{name: "empty", paths: []string{""}, expected_error: true},
+		{name: "root", paths: []string{"a/.."}, expected_error: true},
+		{name: "duplicate", paths: []string{"a/b", "a/./b"}, expected_error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest := dirsync.Manifest{}
+			for _, name := range test.paths {
+				manifest.Files = append(manifest.Files, dirsync.File{Path: name})
+			}
+			err := manifest.Check()
+			if test.expected_error {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package disk
+
+// Space is the capacity of a filesystem in bytes. Available is what
+// unprivileged users can still write, Files are inodes where the
+// filesystem has them.
+type Space struct {
+	Path      string `json:"path"`
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Free      uint64 `json:"free"`
+	Available uint64 `json:"available"`
+	Files     uint64 `json:"files,omitempty"`
+	FilesFree uint64 `json:"files_free,omitempty"`
+}
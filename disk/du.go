@@ -0,0 +1,189 @@
+package disk
+
+import (
+	"context"
+	"files_server/commands"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Usage is what a directory and everything below it takes. Size adds up
+// the apparent sizes, Disk the allocated blocks.
+type Usage struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Size     int64    `json:"size"`
+	Disk     int64    `json:"disk"`
+	Files    int64    `json:"files"`
+	Dirs     int64    `json:"dirs"`
+	Errors   int64    `json:"errors,omitempty"`
+	Children []*Usage `json:"children,omitempty"`
+}
+
+type Options struct {
+	// Depth is how many levels of children are broken down, like du -d.
+	Depth int
+	// Hardlinks counts a file with several links once, like du does, instead
+	// of at every path it has.
+	Hardlinks bool
+}
+
+// Cache keeps what was found in every directory, so a directory whose
+// mtime didn't change is not read again. Only its own entries are cached,
+// the subdirectories are checked one by one. A file changed in place keeps
+// the mtime of its directory, so its new size is only seen once the
+// directory changes, files written through the server are renamed into
+// place and do change it.
+type Cache struct {
+	mu         sync.Mutex
+	dirs       map[string]*dirInfo
+	maxEntries int
+}
+
+type dirInfo struct {
+	modTime time.Time
+	// size, disk and files are the entries that aren't directories and
+	// have one link, the others are in links.
+	size, disk, files int64
+	links             []link
+	subdirs           []string
+}
+
+type link struct {
+	id         fileID
+	size, disk int64
+}
+
+// NewCache keeps up to maxEntries directories, 0 is no limit. When it is
+// full it starts over.
+func NewCache(maxEntries int) *Cache {
+	return &Cache{dirs: map[string]*dirInfo{}, maxEntries: maxEntries}
+}
+
+// Usage walks dirName. A nil cache reads everything. Directories that
+// can't be read are counted in Errors.
+func (cache *Cache) Usage(ctx context.Context, dirName string, options Options) (*Usage, error) {
+	dirName = filepath.Clean(dirName)
+	info, err := os.Lstat(dirName)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		size, disk := info.Size(), diskSize(info)
+		return &Usage{Name: info.Name(), Path: dirName, Size: size, Disk: disk, Files: 1}, nil
+	}
+	walker := &walker{cache: cache, ctx: ctx, options: options, seen: map[fileID]bool{}}
+	return walker.usage(dirName, info, 0)
+}
+
+type walker struct {
+	cache   *Cache
+	ctx     context.Context
+	options Options
+	seen    map[fileID]bool
+}
+
+func (walker *walker) usage(dirName string, info os.FileInfo, depth int) (*Usage, error) {
+	usage := &Usage{Name: info.Name(), Path: dirName, Size: info.Size(), Disk: diskSize(info), Dirs: 1}
+	dir, err := walker.cache.dir(walker.ctx, dirName, info)
+	if err != nil {
+		if walker.ctx.Err() != nil {
+			return nil, walker.ctx.Err()
+		}
+		usage.Errors++
+		return usage, nil
+	}
+
+	usage.Size += dir.size
+	usage.Disk += dir.disk
+	usage.Files += dir.files
+	for _, l := range dir.links {
+		if walker.options.Hardlinks {
+			if walker.seen[l.id] {
+				usage.Files++
+				continue
+			}
+			walker.seen[l.id] = true
+		}
+		usage.Size += l.size
+		usage.Disk += l.disk
+		usage.Files++
+	}
+
+	for _, name := range dir.subdirs {
+		subdir := filepath.Join(dirName, name)
+		subInfo, err := os.Lstat(subdir)
+		if err != nil || !subInfo.IsDir() {
+			usage.Errors++
+			continue
+		}
+		child, err := walker.usage(subdir, subInfo, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		usage.Size += child.Size
+		usage.Disk += child.Disk
+		usage.Files += child.Files
+		usage.Dirs += child.Dirs
+		usage.Errors += child.Errors
+		if depth < walker.options.Depth {
+			usage.Children = append(usage.Children, child)
+		}
+	}
+	sort.Slice(usage.Children, func(i, j int) bool {
+		return usage.Children[i].Disk > usage.Children[j].Disk
+	})
+	return usage, nil
+}
+
+// dir reads the entries of dirName unless the cache has them for the
+// mtime in info.
+func (cache *Cache) dir(ctx context.Context, dirName string, info os.FileInfo) (*dirInfo, error) {
+	if cache != nil {
+		cache.mu.Lock()
+		dir, ok := cache.dirs[dirName]
+		cache.mu.Unlock()
+		if ok && dir.modTime.Equal(info.ModTime()) {
+			return dir, nil
+		}
+	}
+
+	dir := &dirInfo{modTime: info.ModTime()}
+	err := commands.ReadDir(ctx, dirName, func(entry os.DirEntry) error {
+		if commands.IsTemp(entry.Name()) {
+			return nil
+		}
+		if entry.IsDir() {
+			dir.subdirs = append(dir.subdirs, entry.Name())
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if id, ok := linkID(info); ok {
+			dir.links = append(dir.links, link{id: id, size: info.Size(), disk: diskSize(info)})
+			return nil
+		}
+		dir.size += info.Size()
+		dir.disk += diskSize(info)
+		dir.files++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil {
+		cache.mu.Lock()
+		if cache.maxEntries > 0 && len(cache.dirs) >= cache.maxEntries {
+			cache.dirs = map[string]*dirInfo{}
+		}
+		cache.dirs[dirName] = dir
+		cache.mu.Unlock()
+	}
+	return dir, nil
+}
@@ -0,0 +1,139 @@
+package disk_test
+
+import (
+	"context"
+	"files_server/disk"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name string, size int) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0777))
+	require.NoError(t, os.WriteFile(name, make([]byte, size), 0644))
+}
+
+func names(usage *disk.Usage) []string {
+	result := []string{}
+	for _, child := range usage.Children {
+		result = append(result, child.Name)
+	}
+	return result
+}
+
+func TestUsage(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.txt"), 10)
+	writeFile(t, filepath.Join(root, "big", "b.bin"), 5000)
+	writeFile(t, filepath.Join(root, "big", "deep", "c.bin"), 3000)
+	writeFile(t, filepath.Join(root, "small", "d.txt"), 1)
+
+	rootInfo, err := os.Stat(root)
+	require.NoError(t, err)
+	dirSize := func(names ...string) int64 {
+		size := int64(0)
+		for _, name := range names {
+			info, err := os.Stat(filepath.Join(root, name))
+			require.NoError(t, err)
+			size += info.Size()
+		}
+		return size
+	}
+
+	cases := []struct {
+		name           string
+		depth          int
+		expected_names []string
+		expected_deep  []string
+	}{
+		{name: "summary", depth: 0, expected_names: []string{}},
+		{name: "one_level", depth: 1, expected_names: []string{"big", "small"}, expected_deep: []string{}},
+		{name: "two_levels", depth: 2, expected_names: []string{"big", "small"}, expected_deep: []string{"deep"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			usage, err := disk.NewCache(0).Usage(context.Background(), root, disk.Options{Depth: c.depth})
+			require.NoError(t, err)
+			require.Equal(t, filepath.Base(root), usage.Name)
+			require.Equal(t, int64(4), usage.Files)
+			require.Equal(t, int64(4), usage.Dirs)
+			require.Equal(t, rootInfo.Size()+dirSize("big", "big/deep", "small")+10+5000+3000+1, usage.Size)
+			require.Equal(t, c.expected_names, names(usage))
+			if c.expected_deep != nil {
+				big := usage.Children[0]
+				require.Equal(t, c.expected_deep, names(big))
+				require.Equal(t, int64(2), big.Files)
+				require.Equal(t, dirSize("big", "big/deep")+8000, big.Size)
+			}
+		})
+	}
+
+	_, err = disk.NewCache(0).Usage(context.Background(), filepath.Join(root, "missing"), disk.Options{})
+	require.True(t, os.IsNotExist(err))
+	usage, err := (*disk.Cache)(nil).Usage(context.Background(), filepath.Join(root, "a.txt"), disk.Options{})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), usage.Size)
+	require.Equal(t, int64(1), usage.Files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = disk.NewCache(0).Usage(ctx, root, disk.Options{})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHardlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no link counts on windows")
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "one", "a.bin"), 1000)
+	require.NoError(t, os.Mkdir(filepath.Join(root, "two"), 0777))
+	require.NoError(t, os.Link(filepath.Join(root, "one", "a.bin"), filepath.Join(root, "two", "a.bin")))
+
+	counted, err := disk.NewCache(0).Usage(context.Background(), root, disk.Options{})
+	require.NoError(t, err)
+	once, err := disk.NewCache(0).Usage(context.Background(), root, disk.Options{Hardlinks: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), counted.Files)
+	require.Equal(t, int64(2), once.Files)
+	require.Equal(t, counted.Size-1000, once.Size)
+	require.LessOrEqual(t, once.Disk, counted.Disk)
+}
+
+func TestCache(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "sub", "a.txt"), 10)
+	cache := disk.NewCache(0)
+	usage, err := cache.Usage(context.Background(), root, disk.Options{})
+	require.NoError(t, err)
+	before := usage.Size
+
+	// Changed in place the directory keeps its mtime, the cached size stays.
+	subInfo, err := os.Stat(filepath.Join(root, "sub"))
+	require.NoError(t, err)
+	writeFile(t, filepath.Join(root, "sub", "a.txt"), 20)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "sub"), subInfo.ModTime(), subInfo.ModTime()))
+	usage, err = cache.Usage(context.Background(), root, disk.Options{})
+	require.NoError(t, err)
+	require.Equal(t, before, usage.Size)
+
+	writeFile(t, filepath.Join(root, "sub", "b.txt"), 5)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "sub"), later, later))
+	usage, err = cache.Usage(context.Background(), root, disk.Options{})
+	require.NoError(t, err)
+	require.Equal(t, before+10+5, usage.Size)
+	require.Equal(t, int64(2), usage.Files)
+}
+
+func TestFree(t *testing.T) {
+	space, err := disk.Free(t.TempDir())
+	require.NoError(t, err)
+	require.NotZero(t, space.Total)
+	require.LessOrEqual(t, space.Available, space.Free)
+	require.Equal(t, space.Total-space.Free, space.Used)
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+
+package disk
+
+import (
+	"os"
+	"syscall"
+)
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// diskSize is what info takes on disk, st_blocks are 512 bytes.
+func diskSize(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512
+	}
+	return info.Size()
+}
+
+// linkID identifies a file with more than one link.
+func linkID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// Free reports the filesystem that path is on.
+func Free(path string) (*Space, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := uint64(st.Bsize)
+	space := &Space{
+		Path:      path,
+		Total:     uint64(st.Blocks) * blockSize,
+		Free:      uint64(st.Bfree) * blockSize,
+		Available: uint64(st.Bavail) * blockSize,
+		Files:     uint64(st.Files),
+		FilesFree: uint64(st.Ffree),
+	}
+	space.Used = space.Total - space.Free
+	return space, nil
+}
@@ -0,0 +1,31 @@
+package disk
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+type fileID struct{}
+
+func diskSize(info os.FileInfo) int64 {
+	return info.Size()
+}
+
+func linkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+func Free(path string) (*Space, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	space := &Space{Path: path}
+	err = windows.GetDiskFreeSpaceEx(name, &space.Available, &space.Total, &space.Free)
+	if err != nil {
+		return nil, err
+	}
+	space.Used = space.Total - space.Free
+	return space, nil
+}
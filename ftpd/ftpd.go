@@ -0,0 +1,520 @@
+package ftpd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"files_server/auth"
+	"files_server/commands"
+	"files_server/dir"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	idleTimeout = 5 * time.Minute
+	dataTimeout = 30 * time.Second
+)
+
+var features = []string{"EPSV", "PASV", "SIZE", "MDTM", "REST STREAM", "UTF8"}
+
+// Server is an FTP server in passive mode. Logins go through the auth
+// store like /auth, every connection is a session with its own dir.Dir
+// and the commands map onto its operations. With a TLS config clients can
+// switch to explicit TLS with AUTH TLS and protect data with PROT P.
+type Server struct {
+	auth *auth.AuthStorage
+	tls  *tls.Config
+}
+
+func New(authStorage *auth.AuthStorage, tlsConfig *tls.Config) *Server {
+	return &Server{auth: authStorage, tls: tlsConfig}
+}
+
+// Serve accepts connections on listener until it is closed.
+func (server *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.handle(conn)
+	}
+}
+
+type session struct {
+	server     *Server
+	conn       net.Conn
+	lines      *bufio.Scanner
+	ip         string
+	user       string
+	token      string
+	dir        *dir.Dir
+	passive    net.Listener
+	protected  bool
+	offset     int64
+	renameFrom string
+}
+
+func (server *Server) handle(conn net.Conn) {
+	session := &session{server: server, ip: remoteIP(conn.RemoteAddr())}
+	session.setConn(conn)
+	defer func() {
+		session.closePassive()
+		session.conn.Close()
+		if session.token != "" {
+			server.auth.Logout(session.token)
+		}
+	}()
+
+	session.reply(220, "files_server ready")
+	for {
+		session.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !session.lines.Scan() {
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimRight(session.lines.Text(), "\r"), " ")
+		if !session.run(strings.ToUpper(command), arg) {
+			return
+		}
+	}
+}
+
+func (session *session) setConn(conn net.Conn) {
+	session.conn = conn
+	session.lines = bufio.NewScanner(conn)
+}
+
+func (session *session) reply(code int, message string) {
+	fmt.Fprintf(session.conn, "%d %s\r\n", code, message)
+}
+
+func (session *session) replyErr(err error) {
+	session.reply(550, strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+// run executes one command and tells if the connection stays open.
+func (session *session) run(command, arg string) bool {
+	switch command {
+	case "QUIT":
+		session.reply(221, "Bye")
+		return false
+	case "NOOP":
+		session.reply(200, "OK")
+	case "SYST":
+		session.reply(215, "UNIX Type: L8")
+	case "FEAT":
+		session.feat()
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			session.reply(200, "UTF8 is always on")
+		} else {
+			session.reply(501, "Unknown option")
+		}
+	case "AUTH":
+		return session.authTLS(arg)
+	case "PBSZ":
+		session.reply(200, "PBSZ=0")
+	case "PROT":
+		session.prot(arg)
+	case "USER":
+		session.user = arg
+		session.reply(331, "Password required")
+	case "PASS":
+		session.login(arg)
+	default:
+		if session.dir == nil {
+			session.reply(530, "Not logged in")
+			return true
+		}
+		session.runFiles(command, arg)
+	}
+	return true
+}
+
+func (session *session) runFiles(command, arg string) {
+	switch command {
+	case "TYPE", "MODE", "STRU":
+		session.reply(200, "OK")
+	case "PWD", "XPWD":
+		session.reply(257, quote(session.dir.Pwd())+" is the current directory")
+	case "CWD", "XCWD":
+		session.cwd(arg)
+	case "CDUP", "XCUP":
+		session.cwd("..")
+	case "PASV":
+		session.pasv(false)
+	case "EPSV":
+		session.pasv(true)
+	case "LIST", "NLST":
+		session.list(arg, command == "NLST")
+	case "MKD", "XMKD":
+		if _, err := session.dir.Lstat(arg); err == nil {
+			session.replyErr(os.ErrExist)
+			return
+		}
+		err := session.dir.Mkdir(arg)
+		if err != nil {
+			session.replyErr(err)
+			return
+		}
+		session.reply(257, quote(session.dir.Abs(arg))+" created")
+	case "RMD", "XRMD":
+		session.done(session.dir.Rmdir(arg))
+	case "DELE":
+		session.done(session.dir.Remove(arg))
+	case "RNFR":
+		if _, err := session.dir.Lstat(arg); err != nil {
+			session.replyErr(err)
+			return
+		}
+		session.renameFrom = arg
+		session.reply(350, "Ready for RNTO")
+	case "RNTO":
+		from := session.renameFrom
+		session.renameFrom = ""
+		if from == "" {
+			session.reply(503, "RNFR first")
+			return
+		}
+		session.done(session.dir.Mv(from, arg, false))
+	case "SIZE":
+		info, err := session.dir.Stat(arg)
+		if err == nil && info.IsDir() {
+			err = dir.ErrIsDir
+		}
+		if err != nil {
+			session.replyErr(err)
+			return
+		}
+		session.reply(213, strconv.FormatInt(info.Size(), 10))
+	case "MDTM":
+		info, err := session.dir.Stat(arg)
+		if err != nil {
+			session.replyErr(err)
+			return
+		}
+		session.reply(213, info.ModTime().UTC().Format("20060102150405"))
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			session.reply(501, "Bad offset")
+			return
+		}
+		session.offset = offset
+		session.reply(350, "Restarting at "+arg)
+	case "RETR":
+		session.retr(arg)
+	case "STOR":
+		session.stor(arg)
+	default:
+		session.reply(502, "Command not implemented")
+	}
+}
+
+func (session *session) done(err error) {
+	if err != nil {
+		session.replyErr(err)
+		return
+	}
+	session.reply(250, "OK")
+}
+
+func (session *session) feat() {
+	fmt.Fprint(session.conn, "211-Features:\r\n")
+	lines := features
+	if session.server.tls != nil {
+		lines = append(lines[:len(lines):len(lines)], "AUTH TLS", "PBSZ", "PROT")
+	}
+	for _, feature := range lines {
+		fmt.Fprintf(session.conn, " %s\r\n", feature)
+	}
+	session.reply(211, "End")
+}
+
+func (session *session) authTLS(arg string) bool {
+	if session.server.tls == nil {
+		session.reply(502, "TLS is not configured")
+		return true
+	}
+	if !strings.EqualFold(arg, "TLS") && !strings.EqualFold(arg, "TLS-C") {
+		session.reply(504, "Only AUTH TLS")
+		return true
+	}
+	if _, ok := session.conn.(*tls.Conn); ok {
+		session.reply(503, "Already using TLS")
+		return true
+	}
+	session.reply(234, "AUTH TLS successful")
+	conn := tls.Server(session.conn, session.server.tls)
+	conn.SetDeadline(time.Now().Add(dataTimeout))
+	if conn.Handshake() != nil {
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+	session.setConn(conn)
+	return true
+}
+
+func (session *session) prot(arg string) {
+	switch strings.ToUpper(arg) {
+	case "C":
+		session.protected = false
+	case "P":
+		if _, ok := session.conn.(*tls.Conn); !ok {
+			session.reply(503, "AUTH TLS first")
+			return
+		}
+		session.protected = true
+	default:
+		session.reply(504, "Only PROT C or P")
+		return
+	}
+	session.reply(200, "PROT "+strings.ToUpper(arg))
+}
+
+func (session *session) login(password string) {
+	if session.dir != nil {
+		session.reply(503, "Already logged in")
+		return
+	}
+	token, err := session.server.auth.Login(session.ip, session.user, password)
+	if err != nil {
+		session.reply(530, err.Error())
+		return
+	}
+	currentDir, _, err := session.server.auth.Session(session.ip, token)
+	if err != nil {
+		session.server.auth.Logout(token)
+		session.reply(530, err.Error())
+		return
+	}
+	session.token, session.dir = token, currentDir
+	session.reply(230, "Logged in")
+}
+
+func (session *session) cwd(name string) {
+	err := session.dir.Cd(name)
+	if err != nil {
+		session.replyErr(err)
+		return
+	}
+	session.reply(250, "Directory changed to "+session.dir.Pwd())
+}
+
+// pasv listens for the next data connection on the address the client
+// reached us at.
+func (session *session) pasv(extended bool) {
+	session.closePassive()
+	host, _, err := net.SplitHostPort(session.conn.LocalAddr().String())
+	if err != nil {
+		session.reply(425, err.Error())
+		return
+	}
+	ip := net.ParseIP(host).To4()
+	if !extended && ip == nil {
+		session.reply(425, "Use EPSV for IPv6")
+		return
+	}
+	session.passive, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		session.reply(425, err.Error())
+		return
+	}
+	port := session.passive.Addr().(*net.TCPAddr).Port
+	if extended {
+		session.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+	session.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff))
+}
+
+func (session *session) closePassive() {
+	if session.passive != nil {
+		session.passive.Close()
+		session.passive = nil
+	}
+}
+
+// data accepts the data connection of the last PASV or EPSV. Connections
+// from another address than the control connection's are refused.
+func (session *session) data() (net.Conn, error) {
+	if session.passive == nil {
+		return nil, errors.New("Use PASV or EPSV first")
+	}
+	defer session.closePassive()
+	session.passive.(*net.TCPListener).SetDeadline(time.Now().Add(dataTimeout))
+	for {
+		conn, err := session.passive.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if remoteIP(conn.RemoteAddr()) != session.ip {
+			conn.Close()
+			continue
+		}
+		if !session.protected {
+			return conn, nil
+		}
+		tlsConn := tls.Server(conn, session.server.tls)
+		tlsConn.SetDeadline(time.Now().Add(dataTimeout))
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+}
+
+// transfer opens the data connection, runs send on it and replies with
+// the outcome.
+func (session *session) transfer(send func(conn net.Conn) error) {
+	session.reply(150, "Opening data connection")
+	conn, err := session.data()
+	if err != nil {
+		session.reply(425, err.Error())
+		return
+	}
+	err = send(conn)
+	closeErr := conn.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		session.reply(426, err.Error())
+		return
+	}
+	session.reply(226, "Transfer complete")
+}
+
+// list writes the directory like ls -l for LIST and only the names for
+// NLST. Options such as -la are skipped, -a shows hidden files.
+func (session *session) list(arg string, namesOnly bool) {
+	showHidden := false
+	name := ""
+	for _, field := range strings.Fields(arg) {
+		if strings.HasPrefix(field, "-") {
+			showHidden = showHidden || strings.Contains(field, "a")
+			continue
+		}
+		name = field
+	}
+	if name == "" {
+		name = session.dir.Pwd()
+	}
+
+	infos := []os.FileInfo{}
+	info, err := session.dir.Stat(name)
+	if err != nil {
+		session.replyErr(err)
+		return
+	}
+	if info.IsDir() {
+		names, err := session.dir.Ls(name, showHidden)
+		if err != nil {
+			session.replyErr(err)
+			return
+		}
+		for _, entry := range names {
+			entryInfo, err := session.dir.Lstat(filepath.Join(session.dir.Abs(name), entry))
+			if err == nil {
+				infos = append(infos, entryInfo)
+			}
+		}
+	} else {
+		infos = append(infos, info)
+	}
+
+	session.transfer(func(conn net.Conn) error {
+		w := bufio.NewWriter(conn)
+		now := time.Now()
+		for _, info := range infos {
+			if namesOnly {
+				fmt.Fprintf(w, "%s\r\n", info.Name())
+				continue
+			}
+			fmt.Fprintf(w, "%s 1 ftp ftp %12d %s %s\r\n", info.Mode(), info.Size(), commands.LsTime(info.ModTime(), now), info.Name())
+		}
+		return w.Flush()
+	})
+}
+
+func (session *session) retr(name string) {
+	offset := session.offset
+	session.offset = 0
+	file, err := session.dir.Open(name)
+	if err != nil {
+		session.replyErr(err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = dir.ErrIsDir
+	}
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		session.replyErr(err)
+		return
+	}
+	session.transfer(func(conn net.Conn) error {
+		_, err := io.Copy(conn, file)
+		return err
+	})
+}
+
+// stor writes through dir.Create, so the target is only replaced after a
+// complete transfer. After REST the content before the offset is kept.
+func (session *session) stor(name string) {
+	offset := session.offset
+	session.offset = 0
+	writer, err := session.dir.Create(name, offset > 0)
+	if err != nil {
+		session.replyErr(err)
+		return
+	}
+	// Does nothing once the writer is closed, and removes the temporary
+	// file when the transfer failed or never started.
+	defer writer.Abort()
+	if offset > 0 {
+		err = writer.Truncate(offset)
+		if err == nil {
+			_, err = writer.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			session.replyErr(err)
+			return
+		}
+	}
+	session.transfer(func(conn net.Conn) error {
+		_, err := io.Copy(writer, conn)
+		if err != nil {
+			return err
+		}
+		return writer.Close()
+	})
+}
+
+// quote puts a path in double quotes as in a 257 reply, doubling the
+// quotes inside.
+func quote(path string) string {
+	return `"` + strings.ReplaceAll(path, `"`, `""`) + `"`
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
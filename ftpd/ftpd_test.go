@@ -0,0 +1,199 @@
+package ftpd_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"files_server/auth"
+	"files_server/dir"
+	"files_server/ftpd"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/stretchr/testify/require"
+)
+
+func initTestServer(t *testing.T, tlsConfig *tls.Config) (string, string) {
+	root := t.TempDir()
+	authStorage := auth.NewWithConfig(auth.Config{
+		Users:  []auth.User{{Name: "alice", Password: "secret"}},
+		Limits: auth.DefaultLimits(),
+		NewDir: func() *dir.Dir { return dir.NewWithConfig(&dir.Config{Root: root}) },
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go ftpd.New(authStorage, tlsConfig).Serve(listener)
+	return root, listener.Addr().String()
+}
+
+func login(t *testing.T, addr string, options ...ftp.DialOption) *ftp.ServerConn {
+	conn, err := ftp.Dial(addr, append(options, ftp.DialWithTimeout(5*time.Second))...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Quit() })
+	require.NoError(t, conn.Login("alice", "secret"))
+	return conn
+}
+
+func retr(t *testing.T, conn *ftp.ServerConn, name string, offset uint64) string {
+	resp, err := conn.RetrFrom(name, offset)
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp)
+	require.NoError(t, err)
+	require.NoError(t, resp.Close())
+	return string(data)
+}
+
+func TestLogin(t *testing.T) {
+	_, addr := initTestServer(t, nil)
+
+	conn, err := ftp.Dial(addr, ftp.DialWithTimeout(5*time.Second))
+	require.NoError(t, err)
+	defer conn.Quit()
+	require.Error(t, conn.Login("alice", "wrong"))
+	_, err = conn.CurrentDir()
+	require.Error(t, err, "not logged in")
+	require.NoError(t, conn.Login("alice", "secret"))
+}
+
+func TestCommands(t *testing.T) {
+	root, addr := initTestServer(t, nil)
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".hidden"), nil, 0644))
+	conn := login(t, addr)
+
+	dir, err := conn.CurrentDir()
+	require.NoError(t, err)
+	require.Equal(t, root, dir)
+
+	require.NoError(t, conn.MakeDir("docs"))
+	require.Error(t, conn.MakeDir("docs"))
+	require.NoError(t, conn.ChangeDir("docs"))
+	dir, err = conn.CurrentDir()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "docs"), dir)
+	require.Error(t, conn.ChangeDir("missing"))
+
+	// Every connection has its own current directory.
+	other := login(t, addr)
+	dir, err = other.CurrentDir()
+	require.NoError(t, err)
+	require.Equal(t, root, dir)
+
+	require.NoError(t, conn.Stor("a.txt", bytes.NewBufferString("hello ftp")))
+	data, err := os.ReadFile(filepath.Join(root, "docs", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello ftp", string(data))
+	require.Equal(t, "hello ftp", retr(t, conn, "a.txt", 0))
+	require.Equal(t, "ftp", retr(t, conn, "a.txt", 6))
+
+	require.NoError(t, conn.StorFrom("a.txt", bytes.NewBufferString("FTP!"), 6))
+	require.Equal(t, "hello FTP!", retr(t, conn, "a.txt", 0))
+	size, err := conn.FileSize("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), size)
+
+	entries, err := conn.List("")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "a.txt", entries[0].Name)
+	require.Equal(t, ftp.EntryTypeFile, entries[0].Type)
+	require.Equal(t, uint64(10), entries[0].Size)
+
+	require.NoError(t, conn.ChangeDirToParent())
+	names, err := conn.NameList("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs"}, names)
+	entries, err = conn.List("-a")
+	require.NoError(t, err)
+	names = []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{".hidden", "docs"}, names)
+
+	require.NoError(t, conn.Rename("docs/a.txt", "b.txt"))
+	require.FileExists(t, filepath.Join(root, "b.txt"))
+	require.Error(t, conn.Rename("missing", "c.txt"))
+	_, err = conn.Retr("docs")
+	require.Error(t, err)
+	require.Error(t, conn.Delete("docs"), "DELE refuses directories")
+	require.NoError(t, conn.Stor("docs/keep.txt", bytes.NewBufferString("x")))
+	require.Error(t, conn.RemoveDir("docs"), "not empty")
+	require.NoError(t, conn.Delete("docs/keep.txt"))
+	require.NoError(t, conn.RemoveDir("docs"))
+	require.NoError(t, conn.Delete("b.txt"))
+	require.NoDirExists(t, filepath.Join(root, "docs"))
+	require.NoFileExists(t, filepath.Join(root, "b.txt"))
+}
+
+func TestExplicitTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	root, addr := initTestServer(t, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	conn := login(t, addr, ftp.DialWithExplicitTLS(&tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}))
+
+	require.NoError(t, conn.Stor("secret.txt", bytes.NewBufferString("over tls")))
+	data, err := os.ReadFile(filepath.Join(root, "secret.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "over tls", string(data))
+	require.Equal(t, "over tls", retr(t, conn, "secret.txt", 0))
+
+	_, addr = initTestServer(t, nil)
+	_, err = ftp.Dial(addr, ftp.DialWithTimeout(5*time.Second), ftp.DialWithExplicitTLS(&tls.Config{RootCAs: roots}))
+	require.Error(t, err, "TLS is not configured")
+}
+
+func TestStorWithoutDataConnection(t *testing.T) {
+	root, addr := initTestServer(t, nil)
+	conn, err := textproto.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	commands := []struct {
+		line string
+		code int
+	}{
+		{line: "USER alice", code: 331},
+		{line: "PASS secret", code: 230},
+		{line: "STOR a.txt", code: 150},
+	}
+	_, _, err = conn.ReadResponse(220)
+	require.NoError(t, err)
+	for _, command := range commands {
+		require.NoError(t, conn.PrintfLine("%s", command.line))
+		_, _, err = conn.ReadResponse(command.code)
+		require.NoError(t, err)
+	}
+	_, _, err = conn.ReadResponse(425)
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	require.Empty(t, entries, "the temporary file is removed")
+}
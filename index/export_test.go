@@ -0,0 +1,6 @@
+package index
+
+// SetAddWatch replaces how directories are watched.
+func (index *Index) SetAddWatch(addWatch func(path string) error) {
+	index.addWatch = addWatch
+}
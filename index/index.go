@@ -0,0 +1,682 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	StateStarting = "starting"
+	StateCrawling = "crawling"
+	StateReady    = "ready"
+	StateError    = "error"
+	StateClosed   = "closed"
+)
+
+var (
+	entriesBucket = []byte("entries")
+	ErrOutside    = errors.New("Path is outside of the index")
+)
+
+const batchSize = 1000
+
+// settleDelay is how long a file has to be left alone after a write before
+// it is hashed, so a file written in many chunks is hashed once.
+const settleDelay = 500 * time.Millisecond
+
+type Status struct {
+	State    string    `json:"state"`
+	Root     string    `json:"root"`
+	Entries  int       `json:"entries"`
+	Crawled  int64     `json:"crawled"`
+	Hashed   int64     `json:"hashed"`
+	Updates  int64     `json:"updates"`
+	Current  string    `json:"current,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// Unwatched counts the directories that couldn't be watched, e.g. at
+	// the inotify limit. Queries about them go to the disk.
+	Unwatched  int    `json:"unwatched,omitempty"`
+	WatchError string `json:"watch_error,omitempty"`
+}
+
+type record struct {
+	commands.Entry
+	Generation uint64 `json:"gen"`
+}
+
+// Index keeps name, size, mtime, type and sha256 of everything under root in
+// a bbolt file. It is filled by a crawl and then kept up to date by fsnotify.
+type Index struct {
+	root       string
+	db         *bolt.DB
+	watcher    *fsnotify.Watcher
+	addWatch   func(path string) error
+	mu         sync.Mutex
+	status     Status
+	generation uint64
+	// unwatched are the directories without a watch, relative to root.
+	unwatched map[string]bool
+	done      chan struct{}
+}
+
+func Open(root, dbPath string) (*Index, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(entriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	index := &Index{
+		root:      root,
+		db:        db,
+		watcher:   watcher,
+		addWatch:  watcher.Add,
+		status:    Status{State: StateStarting, Root: root},
+		unwatched: map[string]bool{},
+		done:      make(chan struct{}),
+	}
+	index.status.Entries = index.count()
+	return index, nil
+}
+
+// Start crawls the root in the background and then follows changes.
+func (index *Index) Start() {
+	go index.watch()
+	go index.crawl()
+}
+
+func (index *Index) Close() error {
+	index.mu.Lock()
+	index.status.State = StateClosed
+	index.mu.Unlock()
+
+	index.watcher.Close()
+	<-index.done
+	return index.db.Close()
+}
+
+func (index *Index) Root() string {
+	return index.root
+}
+
+func (index *Index) Status() Status {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	return index.status
+}
+
+func (index *Index) Ready() bool {
+	return index.Status().State == StateReady
+}
+
+// Covers reports whether the index can answer queries about dirName. It
+// can't when dirName is in or has below it a directory without a watch,
+// since changes there are missed.
+func (index *Index) Covers(dirName string) bool {
+	rel, err := index.rel(dirName)
+	if err != nil {
+		return false
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if index.status.State != StateReady {
+		return false
+	}
+	for dirRel := range index.unwatched {
+		_, in := below(dirRel, rel)
+		_, has := below(rel, dirRel)
+		if in || has || dirRel == rel {
+			return false
+		}
+	}
+	return true
+}
+
+// List returns the direct children of dirName in the same order as
+// commands.Ls: directories first, then everything else.
+func (index *Index) List(dirName string, showHidden bool) ([]commands.Entry, error) {
+	rel, err := index.rel(dirName)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []commands.Entry{}
+	files := []commands.Entry{}
+	found := false
+	err = index.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entriesBucket)
+		if rel != "" && bucket.Get(key(rel)) == nil {
+			return nil
+		}
+		found = true
+		prefix := append([]byte(rel), 0)
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && hasPrefix(k, prefix); k, v = cursor.Next() {
+			r := record{}
+			err := json.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			if !showHidden && commands.IsHidden(r.Name) {
+				continue
+			}
+			r.Path = ""
+			if r.Type == commands.TypeDir {
+				dirs = append(dirs, r.Entry)
+			} else {
+				files = append(files, r.Entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "open", Path: dirName, Err: fs.ErrNotExist}
+	}
+	return append(dirs, files...), nil
+}
+
+// Find answers commands.Find from the index. Paths in the matches are
+// relative to dirName, content patterns are still checked on disk. Parents
+// are always found before their children, so SkipDir works as well.
+// Matches are read batchSize at a time and passed to found outside of the
+// bolt transaction, so a slow client doesn't keep it open.
+func (index *Index) Find(dirName string, options commands.FindOptions, found func(commands.Match) error) error {
+	rel, err := index.rel(dirName)
+	if err != nil {
+		return err
+	}
+
+	prefix := []byte(rel)
+	next := prefix
+	skipped := []string{}
+	for next != nil {
+		matches := []commands.Match{}
+		next, err = index.findBatch(rel, next, options, &matches)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if underAny(skipped, match.Path) {
+				continue
+			}
+			if options.Grep != nil {
+				var ok bool
+				match, ok = options.Check(filepath.Join(dirName, match.Path), match.Entry)
+				if !ok {
+					continue
+				}
+			}
+			err = found(match)
+			if err == commands.SkipDir {
+				skipped = append(skipped, match.Path)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findBatch adds up to batchSize matches below rel to matches, starting at
+// the key from, and returns the key to go on with or nil at the end.
+func (index *Index) findBatch(rel string, from []byte, options commands.FindOptions, matches *[]commands.Match) ([]byte, error) {
+	var next []byte
+	err := index.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(entriesBucket).Cursor()
+		prefix := []byte(rel)
+		for k, v := cursor.Seek(from); k != nil && hasPrefix(k, prefix); k, v = cursor.Next() {
+			if len(*matches) >= batchSize {
+				next = append([]byte{}, k...)
+				return nil
+			}
+			r := record{}
+			err := json.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			sub, ok := below(rel, r.Path)
+			if !ok {
+				continue
+			}
+			parts := strings.Split(sub, "/")
+			if options.MaxDepth > 0 && len(parts) > options.MaxDepth {
+				continue
+			}
+			if !options.ShowHidden && anyHidden(parts) {
+				continue
+			}
+			r.Path = sub
+			if options.MatchEntry(r.Entry) {
+				*matches = append(*matches, commands.Match{Entry: r.Entry})
+			}
+		}
+		return nil
+	})
+	return next, err
+}
+
+func (index *Index) crawl() {
+	index.mu.Lock()
+	index.generation = uint64(time.Now().UnixNano())
+	generation := index.generation
+	index.status.State = StateCrawling
+	index.status.Started = time.Now()
+	index.status.Finished = time.Time{}
+	index.status.Crawled = 0
+	index.status.Hashed = 0
+	index.status.Unwatched = 0
+	index.status.WatchError = ""
+	index.unwatched = map[string]bool{}
+	index.mu.Unlock()
+
+	err := index.crawlDir(index.root, generation)
+	if err == nil {
+		err = index.dropOld(generation)
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if index.status.State == StateClosed {
+		return
+	}
+	index.status.Current = ""
+	index.status.Finished = time.Now()
+	index.status.Entries = index.count()
+	if err != nil {
+		index.status.State = StateError
+		index.status.Error = err.Error()
+		return
+	}
+	index.status.State = StateReady
+}
+
+func (index *Index) crawlDir(root string, generation uint64) error {
+	batch := []record{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if index.closed() {
+			return filepath.SkipAll
+		}
+		if err != nil || commands.IsTemp(d.Name()) {
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := index.addWatch(path); err != nil {
+				index.unwatch(path, err)
+			}
+		}
+		if path == index.root {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		r, err := index.record(path, info, generation)
+		if err != nil {
+			return nil
+		}
+		batch = append(batch, r)
+		if len(batch) >= batchSize {
+			err = index.put(batch...)
+			batch = batch[:0]
+		}
+
+		index.mu.Lock()
+		index.status.Crawled++
+		index.status.Current = r.Path
+		index.mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return index.put(batch...)
+}
+
+// unwatch remembers that the directory path has no watch.
+func (index *Index) unwatch(path string, err error) {
+	rel, relErr := index.rel(path)
+	if relErr != nil {
+		return
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if !index.unwatched[rel] {
+		index.unwatched[rel] = true
+		index.status.Unwatched++
+	}
+	index.status.WatchError = err.Error()
+}
+
+// record builds the entry for path, reusing the stored hash when size and
+// mtime haven't changed.
+func (index *Index) record(path string, info os.FileInfo, generation uint64) (record, error) {
+	rel, err := index.rel(path)
+	if err != nil {
+		return record{}, err
+	}
+	r := record{Entry: commands.NewEntry(info.Name(), info), Generation: generation}
+	r.Path = rel
+	if r.Type == commands.TypeSymlink {
+		r.Target, r.Broken = commands.LinkTarget(path)
+	}
+	if r.Type != commands.TypeFile {
+		return r, nil
+	}
+
+	old, ok := index.get(rel)
+	if ok && old.Size == r.Size && old.ModTime.Equal(r.ModTime) && old.Hashes != nil {
+		r.Hashes = old.Hashes
+		return r, nil
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return r, nil
+	}
+	r.Hashes = map[string]string{"sha256": sum}
+
+	index.mu.Lock()
+	index.status.Hashed++
+	index.mu.Unlock()
+	return r, nil
+}
+
+// watch applies the events of the watcher. Writes and attribute changes
+// wait in pending until the file settled, everything else is applied at
+// once.
+func (index *Index) watch() {
+	defer close(index.done)
+	pending := map[string]time.Time{}
+	var settle <-chan time.Time
+	for {
+		select {
+		case event, ok := <-index.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&^(fsnotify.Write|fsnotify.Chmod) == 0 {
+				pending[event.Name] = time.Now()
+			} else {
+				delete(pending, event.Name)
+				index.update(event)
+			}
+		case _, ok := <-index.watcher.Errors:
+			if !ok {
+				return
+			}
+		case now := <-settle:
+			settle = nil
+			for name, written := range pending {
+				if now.Sub(written) >= settleDelay {
+					delete(pending, name)
+					index.update(fsnotify.Event{Name: name, Op: fsnotify.Write})
+				}
+			}
+		}
+		if settle == nil && len(pending) > 0 {
+			settle = time.After(settleDelay)
+		}
+	}
+}
+
+func (index *Index) update(event fsnotify.Event) {
+	rel, err := index.rel(event.Name)
+	if err != nil || rel == "" || commands.IsTemp(filepath.Base(event.Name)) {
+		return
+	}
+
+	index.mu.Lock()
+	generation := index.generation
+	index.status.Updates++
+	index.mu.Unlock()
+
+	info, err := os.Lstat(event.Name)
+	if err != nil {
+		index.delete(rel)
+	} else if info.IsDir() && event.Has(fsnotify.Create) {
+		index.crawlDir(event.Name, generation)
+	} else if r, err := index.record(event.Name, info, generation); err == nil {
+		index.put(r)
+	}
+}
+
+func (index *Index) get(rel string) (record, bool) {
+	r := record{}
+	found := false
+	index.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(entriesBucket).Get(key(rel))
+		if v == nil {
+			return nil
+		}
+		found = json.Unmarshal(v, &r) == nil
+		return nil
+	})
+	return r, found
+}
+
+func (index *Index) put(records ...record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	added := 0
+	err := index.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entriesBucket)
+		for _, r := range records {
+			v, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			k := key(r.Path)
+			if bucket.Get(k) == nil {
+				added++
+			}
+			err = bucket.Put(k, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		index.counted(added)
+	}
+	return err
+}
+
+// delete removes rel and everything below it.
+func (index *Index) delete(rel string) error {
+	removed := 0
+	err := index.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entriesBucket)
+		keys := [][]byte{key(rel)}
+		prefix := []byte(rel)
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && hasPrefix(k, prefix); k, v = cursor.Next() {
+			r := record{}
+			if json.Unmarshal(v, &r) != nil {
+				continue
+			}
+			if _, ok := below(rel, r.Path); ok {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		var err error
+		removed, err = deleteKeys(bucket, keys)
+		return err
+	})
+	if err == nil {
+		index.counted(-removed)
+	}
+	return err
+}
+
+func (index *Index) dropOld(generation uint64) error {
+	removed := 0
+	err := index.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(entriesBucket)
+		keys := [][]byte{}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			r := record{}
+			if json.Unmarshal(v, &r) != nil || r.Generation != generation {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		var err error
+		removed, err = deleteKeys(bucket, keys)
+		return err
+	})
+	if err == nil {
+		index.counted(-removed)
+	}
+	return err
+}
+
+// deleteKeys deletes keys from bucket and returns how many were there.
+func deleteKeys(bucket *bolt.Bucket, keys [][]byte) (int, error) {
+	removed := 0
+	for _, k := range keys {
+		if bucket.Get(k) == nil {
+			continue
+		}
+		err := bucket.Delete(k)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// counted keeps Status.Entries up to date with n entries added or, if
+// negative, removed.
+func (index *Index) counted(n int) {
+	index.mu.Lock()
+	index.status.Entries += n
+	index.mu.Unlock()
+}
+
+func (index *Index) count() int {
+	count := 0
+	index.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(entriesBucket).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+func (index *Index) closed() bool {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	return index.status.State == StateClosed
+}
+
+// rel converts an absolute path to the slash separated path relative to
+// root, "" being the root itself.
+func (index *Index) rel(path string) (string, error) {
+	rel, err := filepath.Rel(index.root, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", ErrOutside
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// key is parent + "\x00" + name, so the children of a directory are next to
+// each other and can be listed with a prefix scan.
+func key(rel string) []byte {
+	parent, name := "", rel
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		parent, name = rel[:i], rel[i+1:]
+	}
+	return []byte(parent + "\x00" + name)
+}
+
+func below(dirRel, rel string) (string, bool) {
+	if dirRel == "" {
+		return rel, rel != ""
+	}
+	if !strings.HasPrefix(rel, dirRel+"/") {
+		return "", false
+	}
+	return rel[len(dirRel)+1:], true
+}
+
+func underAny(dirs []string, rel string) bool {
+	for _, dirRel := range dirs {
+		if _, ok := below(dirRel, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func anyHidden(parts []string) bool {
+	for _, part := range parts {
+		if commands.IsHidden(part) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(k, prefix []byte) bool {
+	return len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix)
+}
+
+func hashFile(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,199 @@
+package index_test
+
+import (
+	"errors"
+	"files_server/commands"
+	"files_server/index"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func names(entries []commands.Entry) []string {
+	res := []string{}
+	for _, entry := range entries {
+		res = append(res, entry.Name)
+	}
+	return res
+}
+
+func findPaths(t *testing.T, idx *index.Index, dirName string, options commands.FindOptions) []string {
+	paths := []string{}
+	err := idx.Find(dirName, options, func(match commands.Match) error {
+		paths = append(paths, match.Path)
+		return nil
+	})
+	require.NoError(t, err)
+	return paths
+}
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a", "b", "c.txt"), []byte("content"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a", ".hidden"), []byte("content"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "top.txt"), []byte("top"), 0666))
+
+	idx, err := index.Open(dir, filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	defer idx.Close()
+	idx.Start()
+
+	require.Eventually(t, idx.Ready, 5*time.Second, 10*time.Millisecond)
+	status := idx.Status()
+	require.Equal(t, 5, status.Entries)
+	require.Equal(t, int64(3), status.Hashed)
+
+	entries, err := idx.List(dir, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "top.txt"}, names(entries))
+
+	entries, err = idx.List(filepath.Join(dir, "a"), true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", ".hidden"}, names(entries))
+
+	entries, err = idx.List(filepath.Join(dir, "a", "b"), false)
+	require.NoError(t, err)
+	require.Equal(t, "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", entries[0].Hashes["sha256"])
+
+	_, err = idx.List(filepath.Join(dir, "missing"), false)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = idx.List(os.TempDir(), false)
+	require.Equal(t, index.ErrOutside, err)
+
+	options := commands.NewFindOptions()
+	options.Name = "*.txt"
+	require.Equal(t, []string{"top.txt", "a/b/c.txt"}, findPaths(t, idx, dir, options))
+	require.Equal(t, []string{"b/c.txt"}, findPaths(t, idx, filepath.Join(dir, "a"), options))
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "new"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "d.txt"), []byte("d"), 0666))
+	require.Eventually(t, func() bool {
+		return len(findPaths(t, idx, dir, options)) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "a")))
+	require.Eventually(t, func() bool {
+		entries, err := idx.List(dir, true)
+		return err == nil && len(entries) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"top.txt", "new/d.txt"}, findPaths(t, idx, dir, options))
+}
+
+func TestIndexReopen(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "index.db")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0666))
+
+	idx, err := index.Open(dir, dbPath)
+	require.NoError(t, err)
+	idx.Start()
+	require.Eventually(t, idx.Ready, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, idx.Close())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), []byte("other"), 0666))
+
+	idx, err = index.Open(dir, dbPath)
+	require.NoError(t, err)
+	defer idx.Close()
+	require.Equal(t, 1, idx.Status().Entries)
+	idx.Start()
+	require.Eventually(t, idx.Ready, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, idx.Status().Entries)
+	require.Equal(t, int64(1), idx.Status().Hashed)
+}
+
+func TestIndexFindBatches(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"d", "e"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0777))
+		for i := 0; i < 1500; i++ {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, sub, fmt.Sprintf("%04d.txt", i)), nil, 0666))
+		}
+	}
+	idx, err := index.Open(dir, filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	defer idx.Close()
+	idx.Start()
+	require.Eventually(t, idx.Ready, 10*time.Second, 10*time.Millisecond)
+
+	paths := findPaths(t, idx, dir, commands.NewFindOptions())
+	require.Len(t, paths, 3002)
+	require.Equal(t, []string{"d", "e", "d/0000.txt"}, paths[:3])
+	require.Equal(t, "e/1499.txt", paths[3001])
+
+	// SkipDir still skips children in later batches.
+	paths = []string{}
+	err = idx.Find(dir, commands.NewFindOptions(), func(match commands.Match) error {
+		paths = append(paths, match.Path)
+		if match.Path == "d" {
+			return commands.SkipDir
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, paths, 1502)
+	require.Equal(t, "e/0000.txt", paths[2])
+}
+
+func TestIndexUnwatched(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0777))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "b", "c"), 0777))
+	idx, err := index.Open(dir, filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	defer idx.Close()
+	idx.SetAddWatch(func(path string) error {
+		if path == filepath.Join(dir, "b") {
+			return errors.New("no space left on device")
+		}
+		return nil
+	})
+	idx.Start()
+	require.Eventually(t, idx.Ready, 5*time.Second, 10*time.Millisecond)
+
+	status := idx.Status()
+	require.Equal(t, 1, status.Unwatched)
+	require.Equal(t, "no space left on device", status.WatchError)
+	require.True(t, idx.Covers(filepath.Join(dir, "a")))
+	require.False(t, idx.Covers(filepath.Join(dir, "b")))
+	require.False(t, idx.Covers(filepath.Join(dir, "b", "c")))
+	require.False(t, idx.Covers(dir), "the root has b below it")
+}
+
+func TestIndexSettles(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := index.Open(dir, filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	defer idx.Close()
+	idx.Start()
+	require.Eventually(t, idx.Ready, 5*time.Second, 10*time.Millisecond)
+	hashed := idx.Status().Hashed
+
+	file, err := os.Create(filepath.Join(dir, "big.bin"))
+	require.NoError(t, err)
+	chunk := make([]byte, 4096)
+	for i := 0; i < 100; i++ {
+		_, err = file.Write(chunk)
+		require.NoError(t, err)
+	}
+	require.NoError(t, file.Close())
+
+	require.Eventually(t, func() bool {
+		entries, err := idx.List(dir, false)
+		return err == nil && len(entries) == 1 && entries[0].Size == 100*4096 && entries[0].Hashes != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	// The created file and then the written one, not every chunk.
+	require.LessOrEqual(t, idx.Status().Hashed-hashed, int64(2))
+	require.Equal(t, 1, idx.Status().Entries)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "big.bin")))
+	require.Eventually(t, func() bool {
+		return idx.Status().Entries == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,111 @@
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+var RealClock Clock = realClock{}
+
+// Rate describes a token bucket: PerSecond tokens are added every second up
+// to Burst. A zero PerSecond disables limiting.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+func (rate Rate) Disabled() bool {
+	return rate.PerSecond <= 0
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Limiter struct {
+	mu      sync.Mutex
+	rate    Rate
+	clock   Clock
+	buckets map[string]*bucket
+	calls   int
+}
+
+const sweepEvery = 1024
+
+func New(rate Rate, clock Clock) *Limiter {
+	if clock == nil {
+		clock = RealClock
+	}
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return &Limiter{rate: rate, clock: clock, buckets: map[string]*bucket{}}
+}
+
+// Allow takes one token from the bucket for key. When the bucket is empty it
+// returns false and how long the caller has to wait for the next token.
+func (limiter *Limiter) Allow(key string) (bool, time.Duration) {
+	if limiter.rate.Disabled() {
+		return true, 0
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.clock.Now()
+	limiter.calls++
+	if limiter.calls%sweepEvery == 0 {
+		limiter.sweep(now)
+	}
+
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limiter.rate.Burst), last: now}
+		limiter.buckets[key] = b
+	}
+	limiter.refill(b, now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / limiter.rate.PerSecond
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+func (limiter *Limiter) Forget(key string) {
+	limiter.mu.Lock()
+	delete(limiter.buckets, key)
+	limiter.mu.Unlock()
+}
+
+func (limiter *Limiter) refill(b *bucket, now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limiter.rate.Burst), b.tokens+elapsed*limiter.rate.PerSecond)
+		b.last = now
+	}
+}
+
+// sweep drops full buckets so that one-off clients don't keep memory forever.
+func (limiter *Limiter) sweep(now time.Time) {
+	for key, b := range limiter.buckets {
+		limiter.refill(b, now)
+		if b.tokens >= float64(limiter.rate.Burst) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package limit_test
+
+import (
+	"files_server/limit"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+func TestLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	limiter := limit.New(limit.Rate{PerSecond: 2, Burst: 3}, clock)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("ip")
+		require.True(t, ok)
+	}
+	ok, wait := limiter.Allow("ip")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = limiter.Allow("other")
+	require.True(t, ok)
+
+	clock.advance(500 * time.Millisecond)
+	ok, _ = limiter.Allow("ip")
+	require.True(t, ok)
+	ok, _ = limiter.Allow("ip")
+	require.False(t, ok)
+
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("ip")
+		require.True(t, ok)
+	}
+	ok, _ = limiter.Allow("ip")
+	require.False(t, ok)
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	limiter := limit.New(limit.Rate{}, &fakeClock{})
+	for i := 0; i < 100; i++ {
+		ok, _ := limiter.Allow("ip")
+		require.True(t, ok)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	lockout := limit.NewLockout(3, time.Minute, clock)
+
+	lockout.Fail("user")
+	lockout.Fail("user")
+	locked, _ := lockout.Locked("user")
+	require.False(t, locked)
+
+	lockout.Fail("user")
+	locked, wait := lockout.Locked("user")
+	require.True(t, locked)
+	require.Equal(t, time.Minute, wait)
+
+	clock.advance(time.Minute)
+	locked, _ = lockout.Locked("user")
+	require.False(t, locked)
+
+	lockout.Fail("user")
+	lockout.Fail("user")
+	lockout.Reset("user")
+	lockout.Fail("user")
+	locked, _ = lockout.Locked("user")
+	require.False(t, locked)
+
+	// Failures further apart than the lockout don't add up.
+	clock.advance(time.Minute)
+	lockout.Fail("user")
+	lockout.Fail("user")
+	locked, _ = lockout.Locked("user")
+	require.False(t, locked)
+}
+
+func TestLockoutSweep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	lockout := limit.NewLockout(3, time.Minute, clock)
+	for i := 0; i < 1000; i++ {
+		lockout.Fail(fmt.Sprint("user", i))
+	}
+	require.Equal(t, 1000, lockout.Len())
+
+	clock.advance(time.Minute)
+	for i := 0; i < 100; i++ {
+		lockout.Fail("other")
+	}
+	require.Equal(t, 1, lockout.Len())
+	locked, _ := lockout.Locked("other")
+	require.True(t, locked)
+}
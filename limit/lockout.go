@@ -0,0 +1,110 @@
+package limit
+
+import (
+	"sync"
+	"time"
+)
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// Lockout blocks a key for duration after max consecutive failures.
+// Failures older than duration are forgotten. A zero max disables it.
+type Lockout struct {
+	mu       sync.Mutex
+	max      int
+	duration time.Duration
+	clock    Clock
+	failures map[string]*failures
+	calls    int
+}
+
+func NewLockout(max int, duration time.Duration, clock Clock) *Lockout {
+	if clock == nil {
+		clock = RealClock
+	}
+	return &Lockout{max: max, duration: duration, clock: clock, failures: map[string]*failures{}}
+}
+
+func (lockout *Lockout) Locked(key string) (bool, time.Duration) {
+	if lockout.max <= 0 {
+		return false, 0
+	}
+
+	lockout.mu.Lock()
+	defer lockout.mu.Unlock()
+
+	f, ok := lockout.failures[key]
+	if !ok {
+		return false, 0
+	}
+	left := f.lockedUntil.Sub(lockout.clock.Now())
+	if left <= 0 {
+		if !f.lockedUntil.IsZero() {
+			delete(lockout.failures, key)
+		}
+		return false, 0
+	}
+	return true, left
+}
+
+func (lockout *Lockout) Fail(key string) {
+	if lockout.max <= 0 {
+		return
+	}
+
+	lockout.mu.Lock()
+	defer lockout.mu.Unlock()
+
+	now := lockout.clock.Now()
+	lockout.calls++
+	if lockout.calls%sweepEvery == 0 {
+		lockout.sweep(now)
+	}
+
+	f, ok := lockout.failures[key]
+	if !ok || lockout.stale(f, now) {
+		f = &failures{}
+		lockout.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= lockout.max {
+		f.lockedUntil = now.Add(lockout.duration)
+	}
+}
+
+// stale is true when f doesn't count anymore: the lock is over or, below
+// max, the last failure is older than duration.
+func (lockout *Lockout) stale(f *failures, now time.Time) bool {
+	if !f.lockedUntil.IsZero() {
+		return !now.Before(f.lockedUntil)
+	}
+	return now.Sub(f.last) >= lockout.duration
+}
+
+// sweep drops stale failures so that keys which are never tried again
+// don't keep memory forever.
+func (lockout *Lockout) sweep(now time.Time) {
+	for key, f := range lockout.failures {
+		if lockout.stale(f, now) {
+			delete(lockout.failures, key)
+		}
+	}
+}
+
+// Len is the number of keys with failures.
+func (lockout *Lockout) Len() int {
+	lockout.mu.Lock()
+	defer lockout.mu.Unlock()
+	return len(lockout.failures)
+}
+
+func (lockout *Lockout) Reset(key string) {
+	lockout.mu.Lock()
+	delete(lockout.failures, key)
+	lockout.mu.Unlock()
+}
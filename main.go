@@ -1,18 +1,204 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"files_server/auth"
+	"files_server/blobstore"
+	"files_server/checksum"
+	"files_server/client"
 	"files_server/dir"
+	"files_server/disk"
+	"files_server/ftpd"
+	"files_server/index"
+	"files_server/limit"
+	"files_server/preview"
+	"files_server/replica"
+	"files_server/sftpd"
+	"files_server/share"
+	"files_server/ui"
+	"files_server/upload"
+	"files_server/versions"
+	"files_server/watch"
+	"flag"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 )
 
 func main() {
+	limits := auth.DefaultLimits()
+	root := flag.String("root", "/Users", "directory new sessions start in")
+	indexFile := flag.String("index", "", "bbolt file for the background index of root, disabled when empty")
+	watchDebounce := flag.Duration("watch-debounce", 100*time.Millisecond, "window in which /watch folds events on one path")
+	watchHistory := flag.Int("watch-history", 10000, "events kept for /watch clients to resume from")
+	archiveMaxSize := flag.Int64("archive-max-size", 0, "bytes of file content in one /archive, 0 is no cap")
+	uploadDir := flag.String("upload-dir", filepath.Join(os.TempDir(), "files_server_uploads"), "staging directory for partial uploads, keep it outside of root")
+	uploadTTL := flag.Duration("upload-ttl", 24*time.Hour, "time after which an abandoned upload is removed")
+	hashCacheSize := flag.Int("hash-cache", 100000, "files whose hashes are kept in memory, 0 is no limit")
+	duCacheSize := flag.Int("du-cache", 100000, "directories whose /du usage is kept in memory, 0 is no limit")
+	thumbDir := flag.String("thumbs", filepath.Join(os.TempDir(), "files_server_thumbs"), "directory for cached /thumb images, keep it outside of root, disabled when empty")
+	thumbCacheSize := flag.Int("thumb-cache", 10000, "thumbnails kept in -thumbs, the least recently used are removed first, 0 is no limit")
+	blobDir := flag.String("blobs", "", "directory for deduplicated file contents on the same filesystem as root, disabled when empty")
+	versionDir := flag.String("versions", "", "directory for old versions of files in versioned directories, keep it outside of root, disabled when empty")
+	versionQuota := flag.Int64("versions-quota", 0, "bytes of old versions kept, the oldest are pruned first, 0 is no quota")
+	sharesFile := flag.String("shares", "", "JSON file for share links, disabled when empty")
+	sftpAddr := flag.String("sftp", "", "address of the SFTP listener, e.g. :2022, disabled when empty")
+	sftpHostKey := flag.String("sftp-host-key", "sftp_host_key", "PEM file with the SSH host key, created when missing")
+	ftpAddr := flag.String("ftp", "", "address of the FTP listener, e.g. :2121, disabled when empty")
+	ftpCert := flag.String("ftp-cert", "", "PEM certificate for FTP with AUTH TLS, plain FTP only when empty")
+	ftpKey := flag.String("ftp-key", "", "PEM key of -ftp-cert")
+	symlinks := flag.String("symlinks", string(dir.SymlinkFollow), "which symlinks are followed: follow, root for those within root, or refuse")
+	usersFile := flag.String("users", "", "JSON file with users, anyone can log in when empty")
+	replicationLog := flag.String("replication-log", "", "file for the log of changes that followers replicate, keep it outside of root, disabled when empty")
+	replicationLogSize := flag.Int("replication-log-size", 100000, "entries kept in -replication-log, followers further behind resync, 0 is no limit")
+	follow := flag.String("follow", "", "URL of a primary whose -replication-log this server follows into root, disabled when empty")
+	followUser := flag.String("follow-user", "", "admin user on the -follow primary, the password is read from FILES_SERVER_FOLLOW_PASSWORD")
+	followCheckpoint := flag.String("follow-checkpoint", "follow_checkpoint.json", "file with the last entry applied from -follow, keep it outside of root")
+	flag.Float64Var(&limits.AuthRate.PerSecond, "auth-rate", limits.AuthRate.PerSecond, "/auth requests per second per IP, 0 disables")
+	flag.IntVar(&limits.AuthRate.Burst, "auth-burst", limits.AuthRate.Burst, "/auth burst per IP")
+	flag.Float64Var(&limits.IPRate.PerSecond, "ip-rate", limits.IPRate.PerSecond, "command requests per second per IP, 0 disables")
+	flag.IntVar(&limits.IPRate.Burst, "ip-burst", limits.IPRate.Burst, "command burst per IP")
+	flag.Float64Var(&limits.TokenRate.PerSecond, "token-rate", limits.TokenRate.PerSecond, "command requests per second per token, 0 disables")
+	flag.IntVar(&limits.TokenRate.Burst, "token-burst", limits.TokenRate.Burst, "command burst per token")
+	flag.IntVar(&limits.MaxSessions, "max-sessions", limits.MaxSessions, "live sessions per user, 0 disables")
+	flag.DurationVar(&limits.SessionTTL, "session-ttl", limits.SessionTTL, "idle time after which a session is dropped, 0 disables")
+	flag.IntVar(&limits.MaxFailedLogins, "max-failed-logins", limits.MaxFailedLogins, "failed logins before lockout, 0 disables")
+	flag.DurationVar(&limits.LockoutDuration, "lockout", limits.LockoutDuration, "lockout duration")
+	flag.Parse()
+
+	var users []auth.User
+	var err error
+	if *usersFile != "" {
+		users, err = auth.LoadUsers(*usersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	symlinkPolicy, err := dir.ParseSymlinkPolicy(*symlinks)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dirConfig := &dir.Config{Root: *root, ArchiveMaxSize: *archiveMaxSize, Hashes: checksum.NewCache(*hashCacheSize), DiskUsage: disk.NewCache(*duCacheSize), Symlinks: symlinkPolicy}
+	dirConfig.Watch, err = watch.NewHub(*watchDebounce, *watchHistory)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dirConfig.Uploads, err = upload.NewStore(*uploadDir, *uploadTTL, limit.RealClock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dirConfig.Uploads.StartExpiry(time.Minute)
+	if *thumbDir != "" {
+		dirConfig.Thumbs, err = preview.NewCache(*thumbDir, *thumbCacheSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *blobDir != "" {
+		dirConfig.Blobs, err = blobstore.Open(*blobDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *versionDir != "" {
+		dirConfig.Versions, err = versions.Open(*versionDir, *versionQuota, limit.RealClock)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dirConfig.Versions.StartPruning(time.Hour)
+	}
+	if *sharesFile != "" {
+		dirConfig.Shares, err = share.Open(*sharesFile, limit.RealClock)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *replicationLog != "" {
+		dirConfig.Replication, err = replica.Open(*replicationLog, *replicationLogSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *indexFile != "" {
+		dirConfig.Index, err = index.Open(*root, *indexFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dirConfig.Index.Start()
+	}
+	if *follow != "" {
+		primary := client.New(*follow)
+		password := os.Getenv("FILES_SERVER_FOLLOW_PASSWORD")
+		_, err = primary.Login(*followUser, password)
+		if err != nil {
+			log.Fatal(err)
+		}
+		follower, err := replica.NewFollower(primary, dir.NewWithConfig(dirConfig), *followCheckpoint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go follower.Run(context.Background(), 10*time.Second, func(err error) {
+			log.Println("follow:", err)
+			// The primary restarted or dropped the idle session.
+			serverErr := &client.Error{}
+			if errors.As(err, &serverErr) && serverErr.StatusCode == http.StatusUnauthorized {
+				primary.Login(*followUser, password)
+			}
+		})
+	}
+
 	rand.Seed(time.Now().UnixNano())
-	http.Handle("/", dir.New())
-	http.Handle("/auth", auth.New())
+	authStorage := auth.NewWithConfig(auth.Config{
+		Users:  users,
+		Limits: limits,
+		Clock:  limit.RealClock,
+		NewDir: func() *dir.Dir {
+			return dir.NewWithConfig(dirConfig)
+		},
+	})
+	if *sftpAddr != "" {
+		hostKey, err := sftpd.LoadHostKey(*sftpHostKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listener, err := net.Listen("tcp", *sftpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Fatal(sftpd.New(authStorage, hostKey).Serve(listener))
+		}()
+	}
+	if *ftpAddr != "" {
+		var tlsConfig *tls.Config
+		if *ftpCert != "" {
+			cert, err := tls.LoadX509KeyPair(*ftpCert, *ftpKey)
+			if err != nil {
+				log.Fatal(err)
+			}
+			tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+		listener, err := net.Listen("tcp", *ftpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Fatal(ftpd.New(authStorage, tlsConfig).Serve(listener))
+		}()
+	}
+	http.Handle("/", authStorage.Commands())
+	http.Handle("/auth", authStorage)
+	http.Handle("/s/", dir.Shares(dirConfig))
+	http.Handle("/ui", ui.Handler())
+	http.Handle("/ui/", ui.Handler())
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
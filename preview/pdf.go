@@ -0,0 +1,62 @@
+package preview
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// maxPDFSize is how much of a PDF PDFInfo reads to count the pages.
+const maxPDFSize = 64 << 20
+
+var ErrNotPDF = errors.New("Not a PDF file")
+
+type PDF struct {
+	Version string `json:"version"`
+	Pages   int    `json:"pages"`
+}
+
+var (
+	pdfHeader = regexp.MustCompile(`^%PDF-(\d\.\d)`)
+	// pagesCount matches the /Count of a page tree node, which may come
+	// before or after its /Type.
+	pagesCount = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+	pageType   = regexp.MustCompile(`/Type\s*/Page\b`)
+)
+
+// PDFInfo reads the version and the page count of a PDF without a full
+// parser: the root of the page tree has the largest /Count. Pages in
+// compressed object streams are not found that way, so without any /Count
+// the /Page objects are counted, which may be 0.
+func PDFInfo(fileName string) (PDF, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return PDF{}, err
+	}
+	defer file.Close()
+	data, err := readPrefix(file, maxPDFSize)
+	if err != nil {
+		return PDF{}, err
+	}
+	header := pdfHeader.FindSubmatch(data)
+	if header == nil {
+		return PDF{}, ErrNotPDF
+	}
+
+	info := PDF{Version: string(header[1])}
+	for _, match := range pagesCount.FindAllSubmatch(data, -1) {
+		count := match[1]
+		if count == nil {
+			count = match[2]
+		}
+		n, err := strconv.Atoi(string(count))
+		if err == nil && n > info.Pages {
+			info.Pages = n
+		}
+	}
+	if info.Pages == 0 {
+		info.Pages = len(pageType.FindAllIndex(data, -1))
+	}
+	return info, nil
+}
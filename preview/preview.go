@@ -0,0 +1,149 @@
+package preview
+
+import (
+	"bytes"
+	"errors"
+	"files_server/textfile"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	KindImage = "image"
+	KindText  = "text"
+	KindPDF   = "pdf"
+)
+
+var ErrNoPreview = errors.New("No preview for this file")
+
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
+
+// languages maps extensions and well known file names to the language of
+// their content. Every file in it has a text preview.
+var languages = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".mjs": "javascript", ".ts": "typescript",
+	".tsx": "typescript", ".jsx": "javascript", ".java": "java", ".kt": "kotlin", ".c": "c",
+	".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp", ".cs": "csharp", ".rs": "rust",
+	".rb": "ruby", ".php": "php", ".swift": "swift", ".scala": "scala", ".sh": "shell",
+	".bash": "shell", ".zsh": "shell", ".ps1": "powershell", ".sql": "sql", ".html": "html",
+	".htm": "html", ".css": "css", ".scss": "scss", ".xml": "xml", ".svg": "xml", ".json": "json",
+	".yaml": "yaml", ".yml": "yaml", ".toml": "toml", ".ini": "ini", ".conf": "ini", ".cfg": "ini",
+	".md": "markdown", ".markdown": "markdown", ".rst": "rst", ".tex": "latex", ".csv": "csv",
+	".tsv": "csv", ".proto": "protobuf", ".lua": "lua", ".pl": "perl", ".r": "r", ".diff": "diff",
+	".patch": "diff", ".txt": "text", ".log": "text",
+	"Makefile": "make", "Dockerfile": "dockerfile", "Jenkinsfile": "groovy", "go.mod": "go-mod",
+	"README": "text", "LICENSE": "text",
+}
+
+// interpreters are the languages of scripts by their #! line.
+var interpreters = map[string]string{
+	"sh": "shell", "bash": "shell", "zsh": "shell", "python": "python", "python3": "python",
+	"node": "javascript", "ruby": "ruby", "perl": "perl", "php": "php", "lua": "lua",
+}
+
+// Kind is the kind of preview a file with name has, from its name alone so
+// listings stay cheap. Empty means none.
+func Kind(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case imageExtensions[ext]:
+		return KindImage
+	case ext == ".pdf":
+		return KindPDF
+	case languages[ext] != "" || languages[name] != "":
+		return KindText
+	}
+	return ""
+}
+
+type Text struct {
+	Language string `json:"language"`
+	Encoding string `json:"encoding"`
+	Lines    string `json:"lines"`
+	// Truncated says there is more after Lines.
+	Truncated bool `json:"truncated"`
+}
+
+// TextPreview reads the first n lines of fileName as UTF-8 and guesses the
+// language from the name, a #! line or the start of the content.
+func TextPreview(fileName string, n int) (Text, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return Text{}, err
+	}
+	defer file.Close()
+	encoding, err := textfile.DetectFile(file)
+	if err != nil {
+		return Text{}, err
+	}
+	if encoding == textfile.Binary {
+		return Text{}, textfile.ErrBinary
+	}
+
+	// One more line than asked for shows whether there are more.
+	buf := &bytes.Buffer{}
+	err = textfile.Head(buf, textfile.NewReader(file, encoding), n+1)
+	if err != nil {
+		return Text{}, err
+	}
+	lines, end := buf.String(), 0
+	for i := 0; i < n && end < len(lines); i++ {
+		next := strings.IndexByte(lines[end:], '\n')
+		if next < 0 {
+			end = len(lines)
+			break
+		}
+		end += next + 1
+	}
+	text := Text{Encoding: encoding, Lines: lines[:end], Truncated: end < len(lines)}
+	text.Language = Language(filepath.Base(fileName), text.Lines)
+	return text, nil
+}
+
+// Language guesses the language of a file from its name and the start of
+// its content.
+func Language(name, start string) string {
+	if language, ok := languages[name]; ok {
+		return language
+	}
+	if language, ok := languages[strings.ToLower(filepath.Ext(name))]; ok && language != "text" {
+		return language
+	}
+	if strings.HasPrefix(start, "#!") {
+		line, _, _ := strings.Cut(start[2:], "\n")
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			interpreter := filepath.Base(fields[0])
+			if interpreter == "env" && len(fields) > 1 {
+				interpreter = fields[1]
+			}
+			if language, ok := interpreters[interpreter]; ok {
+				return language
+			}
+		}
+	}
+	trimmed := strings.TrimSpace(start)
+	lower := strings.ToLower(trimmed)
+	switch {
+	case strings.HasPrefix(lower, "<!doctype html") || strings.HasPrefix(lower, "<html"):
+		return "html"
+	case strings.HasPrefix(trimmed, "<?xml"):
+		return "xml"
+	case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+		return "json"
+	case strings.HasPrefix(trimmed, "---\n"):
+		return "yaml"
+	case strings.HasPrefix(trimmed, "diff --git") || strings.HasPrefix(trimmed, "--- "):
+		return "diff"
+	case strings.HasPrefix(trimmed, "package "):
+		return "go"
+	}
+	return "text"
+}
+
+// readPrefix reads up to limit bytes of r.
+func readPrefix(r io.Reader, limit int64) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(r, limit))
+}
@@ -0,0 +1,236 @@
+package preview_test
+
+import (
+	"bytes"
+	"files_server/preview"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeImage writes a width x height image, left half red and right half
+// blue, in format.
+func writeImage(t *testing.T, fileName, format string, width, height int) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	switch format {
+	case "png":
+		require.NoError(t, png.Encode(buf, img))
+	case "jpeg":
+		require.NoError(t, jpeg.Encode(buf, img, nil))
+	case "gif":
+		require.NoError(t, gif.Encode(buf, img, nil))
+	}
+	require.NoError(t, os.WriteFile(fileName, buf.Bytes(), 0644))
+}
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		name          string
+		expected_kind string
+	}{
+		{name: "photo.JPG", expected_kind: preview.KindImage},
+		{name: "anim.gif", expected_kind: preview.KindImage},
+		{name: "paper.pdf", expected_kind: preview.KindPDF},
+		{name: "main.go", expected_kind: preview.KindText},
+		{name: "Makefile", expected_kind: preview.KindText},
+		{name: "notes.txt", expected_kind: preview.KindText},
+		{name: "archive.zip", expected_kind: ""},
+		{name: "noext", expected_kind: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected_kind, preview.Kind(test.name))
+		})
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		name              string
+		file              string
+		start             string
+		expected_language string
+	}{
+		{name: "extension", file: "main.go", start: "", expected_language: "go"},
+		{name: "file_name", file: "Dockerfile", start: "FROM alpine\n", expected_language: "dockerfile"},
+		{name: "shebang", file: "deploy", start: "#!/bin/bash\necho\n", expected_language: "shell"},
+		{name: "env_shebang", file: "run.txt", start: "#!/usr/bin/env python3\n", expected_language: "python"},
+		{name: "json_content", file: "data", start: "  {\"a\": 1}", expected_language: "json"},
+		{name: "html_content", file: "page", start: "<!DOCTYPE html>\n", expected_language: "html"},
+		{name: "plain", file: "notes.txt", start: "hello\n", expected_language: "text"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected_language, preview.Language(test.file, test.start))
+		})
+	}
+}
+
+func TestTextPreview(t *testing.T) {
+	root := t.TempDir()
+	fileName := filepath.Join(root, "main.go")
+	require.NoError(t, os.WriteFile(fileName, []byte("package main\n\nfunc main() {\n}\n"), 0644))
+
+	text, err := preview.TextPreview(fileName, 2)
+	require.NoError(t, err)
+	require.Equal(t, preview.Text{Language: "go", Encoding: "utf-8", Lines: "package main\n\n", Truncated: true}, text)
+
+	text, err = preview.TextPreview(fileName, 10)
+	require.NoError(t, err)
+	require.False(t, text.Truncated)
+
+	binary := filepath.Join(root, "app.txt")
+	require.NoError(t, os.WriteFile(binary, []byte{0, 1, 2, 3, 0, 0, 0, 5}, 0644))
+	_, err = preview.TextPreview(binary, 10)
+	require.Error(t, err)
+}
+
+func TestPDFInfo(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		name             string
+		content          string
+		expected_version string
+		expected_pages   int
+		expected_error   error
+	}{
+		{
+			name: "page_tree",
+			content: "%PDF-1.7\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n" +
+				"2 0 obj << /Type /Pages /Kids [3 0 R 4 0 R] /Count 3 >> endobj\n" +
+				"3 0 obj << /Type /Pages /Parent 2 0 R /Kids [5 0 R 6 0 R] /Count 2 >> endobj\n" +
+				"4 0 obj << /Type /Page /Parent 2 0 R >> endobj\n%%EOF\n",
+			expected_version: "1.7",
+			expected_pages:   3,
+		},
+		{
+			name:             "count_first",
+			content:          "%PDF-1.4\n2 0 obj << /Count 5 /Kids [] /Type /Pages >> endobj\n",
+			expected_version: "1.4",
+			expected_pages:   5,
+		},
+		{
+			name:             "pages_only",
+			content:          "%PDF-1.3\n3 0 obj << /Type /Page >> endobj\n4 0 obj << /Type /Page >> endobj\n",
+			expected_version: "1.3",
+			expected_pages:   2,
+		},
+		{name: "not_pdf", content: "hello", expected_error: preview.ErrNotPDF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(root, test.name+".pdf")
+			require.NoError(t, os.WriteFile(fileName, []byte(test.content), 0644))
+			info, err := preview.PDFInfo(fileName)
+			require.Equal(t, test.expected_error, err)
+			if err != nil {
+				return
+			}
+			require.Equal(t, preview.PDF{Version: test.expected_version, Pages: test.expected_pages}, info)
+		})
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		name                 string
+		format               string
+		width                int
+		height               int
+		size                 int
+		expected_type        string
+		expected_width       int
+		expected_height      int
+		expected_error       error
+		expected_left_is_red bool
+	}{
+		{name: "png", format: "png", width: 400, height: 200, size: 100, expected_type: "image/png", expected_width: 100, expected_height: 50, expected_left_is_red: true},
+		{name: "jpeg", format: "jpeg", width: 300, height: 600, size: 64, expected_type: "image/jpeg", expected_width: 32, expected_height: 64, expected_left_is_red: true},
+		{name: "gif", format: "gif", width: 50, height: 50, size: 32, expected_type: "image/png", expected_width: 32, expected_height: 32, expected_left_is_red: true},
+		{name: "small", format: "png", width: 20, height: 10, size: 64, expected_type: "image/png", expected_width: 20, expected_height: 10, expected_left_is_red: true},
+		{name: "too_small_size", format: "png", width: 20, height: 10, size: 8, expected_error: preview.ErrSize},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(root, test.name+"."+test.format)
+			writeImage(t, fileName, test.format, test.width, test.height)
+			data, contentType, err := preview.Thumbnail(fileName, test.size)
+			require.Equal(t, test.expected_error, err)
+			if err != nil {
+				return
+			}
+			require.Equal(t, test.expected_type, contentType)
+			thumb, _, err := image.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Equal(t, test.expected_width, thumb.Bounds().Dx())
+			require.Equal(t, test.expected_height, thumb.Bounds().Dy())
+			r, _, b, _ := thumb.At(0, 0).RGBA()
+			require.Equal(t, test.expected_left_is_red, r > b)
+			r, _, b, _ = thumb.At(test.expected_width-1, 0).RGBA()
+			require.Equal(t, test.expected_left_is_red, b > r)
+		})
+	}
+
+	notImage := filepath.Join(root, "fake.png")
+	require.NoError(t, os.WriteFile(notImage, []byte("not an image"), 0644))
+	_, _, err := preview.Thumbnail(notImage, 64)
+	require.Equal(t, preview.ErrFormat, err)
+}
+
+func TestCache(t *testing.T) {
+	root := t.TempDir()
+	cache, err := preview.NewCache(filepath.Join(root, "thumbs"), 4)
+	require.NoError(t, err)
+	fileName := filepath.Join(root, "photo.png")
+	writeImage(t, fileName, "png", 64, 64)
+
+	first, _, err := cache.Thumbnail(fileName, 32)
+	require.NoError(t, err)
+	require.Equal(t, 1, cache.Len())
+	second, _, err := cache.Thumbnail(fileName, 32)
+	require.NoError(t, err)
+	require.Equal(t, first, second)
+	require.Equal(t, 1, cache.Len())
+
+	// A changed file gets a new thumbnail.
+	writeImage(t, fileName, "png", 128, 32)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(fileName, later, later))
+	changed, _, err := cache.Thumbnail(fileName, 32)
+	require.NoError(t, err)
+	require.NotEqual(t, first, changed)
+	require.Equal(t, 2, cache.Len())
+
+	for size := 40; size < 44; size++ {
+		_, _, err := cache.Thumbnail(fileName, size)
+		require.NoError(t, err)
+	}
+	require.LessOrEqual(t, cache.Len(), 4)
+	files, err := os.ReadDir(filepath.Join(root, "thumbs"))
+	require.NoError(t, err)
+	require.Equal(t, cache.Len(), len(files))
+
+	var nilCache *preview.Cache
+	_, contentType, err := nilCache.Thumbnail(fileName, 32)
+	require.NoError(t, err)
+	require.Equal(t, "image/png", contentType)
+}
@@ -0,0 +1,273 @@
+package preview
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSize = 256
+	MinSize     = 16
+	MaxSize     = 1024
+	// maxPixels keeps a decoded source below about 160MB.
+	maxPixels = 40_000_000
+)
+
+var (
+	ErrSize     = fmt.Errorf("Size has to be from %d to %d", MinSize, MaxSize)
+	ErrTooLarge = errors.New("Image is too large for a thumbnail")
+	ErrFormat   = errors.New("Unsupported image format, only PNG, JPEG and GIF")
+)
+
+// Thumbnail scales the PNG, JPEG or GIF image in fileName down to fit in
+// size x size pixels, keeping the aspect ratio. It is a JPEG for a JPEG
+// source and a PNG else, see the returned content type.
+func Thumbnail(fileName string, size int) ([]byte, string, error) {
+	if size < MinSize || size > MaxSize {
+		return nil, "", ErrSize
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, "", ErrFormat
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, "", ErrTooLarge
+	}
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var src image.Image
+	switch format {
+	case "png":
+		src, err = png.Decode(file)
+	case "jpeg":
+		src, err = jpeg.Decode(file)
+	case "gif":
+		src, err = gif.Decode(file)
+	default:
+		return nil, "", ErrFormat
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	thumb := scale(src, size)
+	buf := &bytes.Buffer{}
+	if format == "jpeg" {
+		err = jpeg.Encode(buf, thumb, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(buf, thumb)
+	return buf.Bytes(), "image/png", err
+}
+
+type Image struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ImageInfo reads the format and the dimensions of an image from its header.
+func ImageInfo(fileName string) (Image, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return Image{}, err
+	}
+	defer file.Close()
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return Image{}, ErrFormat
+	}
+	return Image{Format: format, Width: config.Width, Height: config.Height}, nil
+}
+
+// scale fits src into size x size with a box filter: every pixel is the
+// average of the source pixels it covers. Smaller images are only copied.
+func scale(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	width, height := srcWidth, srcHeight
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(srcHeight*size/srcWidth, 1)
+		} else {
+			width, height = max(srcWidth*size/srcHeight, 1), size
+		}
+	}
+
+	// Drawing into RGBA first is fast for the usual source types and
+	// premultiplies alpha, so transparent pixels don't darken the average.
+	rgba := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	if width == srcWidth && height == srcHeight {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, max((x+1)*srcWidth/width, x*srcWidth/width+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride+x0*4 : sy*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			count := (x1 - x0) * (y1 - y0)
+			offset := y*dst.Stride + x*4
+			for i := range sum {
+				dst.Pix[offset+i] = uint8(sum[i] / count)
+			}
+		}
+	}
+	return dst
+}
+
+// Cache keeps thumbnails in a directory, keyed on the path, modification
+// time and size of the source and the thumbnail size, so a changed file
+// gets a new one. Above maxEntries the least recently used are removed.
+type Cache struct {
+	dir        string
+	maxEntries int
+
+	mutex   sync.Mutex
+	entries int
+}
+
+func NewCache(dirName string, maxEntries int) (*Cache, error) {
+	err := os.MkdirAll(dirName, 0700)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dirName)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dirName, maxEntries: maxEntries, entries: len(files)}, nil
+}
+
+// Thumbnail is the package Thumbnail of fileName from the cache. A nil
+// cache makes a new one every time.
+func (cache *Cache) Thumbnail(fileName string, size int) ([]byte, string, error) {
+	if cache == nil {
+		return Thumbnail(fileName, size)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return nil, "", err
+	}
+	key := cacheKey(fileName, info, size)
+	for ext, contentType := range thumbTypes {
+		cached := filepath.Join(cache.dir, key+ext)
+		data, err := os.ReadFile(cached)
+		if err == nil {
+			now := time.Now()
+			os.Chtimes(cached, now, now)
+			return data, contentType, nil
+		}
+	}
+
+	data, contentType, err := Thumbnail(fileName, size)
+	if err != nil {
+		return nil, "", err
+	}
+	cache.store(key+extensions[contentType], data)
+	return data, contentType, nil
+}
+
+var (
+	thumbTypes = map[string]string{".png": "image/png", ".jpg": "image/jpeg"}
+	extensions = map[string]string{"image/png": ".png", "image/jpeg": ".jpg"}
+)
+
+func cacheKey(fileName string, info os.FileInfo, size int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", fileName, info.ModTime().UnixNano(), info.Size(), size)))
+	return hex.EncodeToString(sum[:])
+}
+
+// store writes a thumbnail to the cache. Failing to is not an error, the
+// thumbnail is only made again next time.
+func (cache *Cache) store(name string, data []byte) {
+	tmp, err := os.CreateTemp(cache.dir, ".thumb-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err != nil || closeErr != nil {
+		return
+	}
+	if os.Rename(tmp.Name(), filepath.Join(cache.dir, name)) != nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries++
+	if cache.maxEntries > 0 && cache.entries > cache.maxEntries {
+		cache.prune()
+	}
+}
+
+// prune removes the least recently used thumbnails down to 3/4 of
+// maxEntries, so it doesn't run for every new one.
+func (cache *Cache) prune() {
+	files, err := os.ReadDir(cache.dir)
+	if err != nil {
+		return
+	}
+	type entry struct {
+		name string
+		used time.Time
+	}
+	entries := []entry{}
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		entries = append(entries, entry{file.Name(), info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	keep := cache.maxEntries * 3 / 4
+	removed := 0
+	for len(entries)-removed > keep {
+		os.Remove(filepath.Join(cache.dir, entries[removed].name))
+		removed++
+	}
+	cache.entries = len(entries) - removed
+}
+
+func (cache *Cache) Len() int {
+	if cache == nil {
+		return 0
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.entries
+}
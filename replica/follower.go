@@ -0,0 +1,345 @@
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"files_server/dirsync"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pageSize is how many entries a follower asks for at once.
+	pageSize = 1000
+	// pollWait is how long a request of Run waits for new entries.
+	pollWait = 30 * time.Second
+)
+
+var (
+	ErrIsDir = errors.New("Is a directory")
+	ErrOp    = errors.New("Unknown op")
+)
+
+// Primary is the server a Follower follows, a client.Client.
+type Primary interface {
+	ReplicaStatus() (Page, error)
+	ReplicaLog(ctx context.Context, since uint64, limit int, wait time.Duration) (Page, error)
+	ReplicaFile(name string) (io.ReadCloser, dirsync.File, error)
+	ReplicaManifest(name string) (dirsync.Manifest, error)
+}
+
+// Tree is what a Follower changes, a dir.Dir of its root.
+type Tree interface {
+	Mkdir(name string) error
+	Touch(name string) error
+	Rm(name string) error
+	Mv(from, to string, overwrite bool) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Lstat(name string) (os.FileInfo, error)
+	WriteFile(name string, r io.Reader, mode os.FileMode) error
+	Manifest(ctx context.Context, name, algo string) (dirsync.Manifest, error)
+}
+
+// Follower applies the log of a primary to its tree. The sequence number
+// of the last applied entry is kept in a checkpoint file, so that after a
+// restart it goes on from there. When the primary's log doesn't go back
+// that far anymore the whole tree is synced instead.
+//
+// Entries are applied again after a crash between applying and saving the
+// checkpoint, and an entry can be older than what the tree has after a
+// resync. Writes therefore fetch what the primary has now, and an op that
+// doesn't fit the tree makes the follower fetch its paths as well.
+type Follower struct {
+	primary    Primary
+	tree       Tree
+	checkpoint string
+	mu         sync.Mutex
+	seq        uint64
+}
+
+type checkpoint struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+}
+
+func NewFollower(primary Primary, tree Tree, checkpointFile string) (*Follower, error) {
+	follower := &Follower{primary: primary, tree: tree, checkpoint: checkpointFile}
+	data, err := os.ReadFile(checkpointFile)
+	if os.IsNotExist(err) {
+		return follower, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	saved := checkpoint{}
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return nil, err
+	}
+	follower.seq = saved.Seq
+	return follower, nil
+}
+
+// Seq is the last entry applied.
+func (follower *Follower) Seq() uint64 {
+	follower.mu.Lock()
+	defer follower.mu.Unlock()
+	return follower.seq
+}
+
+func (follower *Follower) save(seq uint64) error {
+	data, err := json.Marshal(checkpoint{Seq: seq, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	tmp := follower.checkpoint + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, follower.checkpoint)
+	}
+	if err != nil {
+		return err
+	}
+	follower.mu.Lock()
+	follower.seq = seq
+	follower.mu.Unlock()
+	return nil
+}
+
+// CatchUp applies the entries the follower doesn't have yet, or resyncs.
+func (follower *Follower) CatchUp(ctx context.Context) error {
+	for {
+		n, err := follower.step(ctx, 0)
+		if err != nil || n == 0 {
+			return err
+		}
+	}
+}
+
+// Run follows the primary until ctx is done. Errors, e.g. while the
+// primary is down, are passed to report and retried after retry.
+func (follower *Follower) Run(ctx context.Context, retry time.Duration, report func(error)) error {
+	for ctx.Err() == nil {
+		_, err := follower.step(ctx, pollWait)
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		if report != nil {
+			report(err)
+		}
+		timer := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	return ctx.Err()
+}
+
+// step applies one page of entries and returns how many there were.
+func (follower *Follower) step(ctx context.Context, wait time.Duration) (int, error) {
+	seq := follower.Seq()
+	page, err := follower.primary.ReplicaLog(ctx, seq, pageSize, wait)
+	if err == ErrTruncated {
+		return 1, follower.Resync(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range page.Entries {
+		if err := ctx.Err(); err != nil {
+			break
+		}
+		err = follower.apply(ctx, entry)
+		if err != nil {
+			break
+		}
+		seq = entry.Seq
+	}
+	if seq != follower.Seq() {
+		if saveErr := follower.save(seq); err == nil {
+			err = saveErr
+		}
+	}
+	return len(page.Entries), err
+}
+
+// Resync makes the whole tree like the primary's and continues with the
+// entries after it. Entries made during the sync are applied once more.
+func (follower *Follower) Resync(ctx context.Context) error {
+	status, err := follower.primary.ReplicaStatus()
+	if err != nil {
+		return err
+	}
+	err = follower.syncDir(ctx, "")
+	if err != nil {
+		return err
+	}
+	return follower.save(status.Last)
+}
+
+func (follower *Follower) apply(ctx context.Context, entry Entry) error {
+	tree := follower.tree
+	name, err := clean(entry.Path)
+	if err != nil {
+		return err
+	}
+	switch entry.Op {
+	case OpMkdir:
+		err = tree.Mkdir(name)
+	case OpTouch:
+		err = tree.Touch(name)
+		if err == nil && !entry.ModTime.IsZero() {
+			err = tree.Chtimes(name, entry.ModTime, entry.ModTime)
+		}
+	case OpWrite:
+		return follower.fetch(ctx, name)
+	case OpRm:
+		err = tree.Rm(name)
+	case OpMv:
+		to, err := clean(entry.To)
+		if err != nil {
+			return err
+		}
+		err = tree.Mv(name, to, true)
+		if err == nil {
+			return nil
+		}
+		err = follower.fetch(ctx, name)
+		if err == nil {
+			err = follower.fetch(ctx, to)
+		}
+		return err
+	case OpChmod:
+		err = tree.Chmod(name, entry.Mode)
+	case OpChtimes:
+		err = tree.Chtimes(name, entry.ModTime, entry.ModTime)
+	default:
+		return fmt.Errorf("%w %q", ErrOp, entry.Op)
+	}
+	if err != nil {
+		return follower.fetch(ctx, name)
+	}
+	return nil
+}
+
+// clean is a path of the log as a name for the tree.
+func clean(name string) (string, error) {
+	native, err := dirsync.Clean(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(native), nil
+}
+
+// fetch makes name like it is on the primary: it gets the file, syncs the
+// directory or removes what the primary doesn't have anymore.
+func (follower *Follower) fetch(ctx context.Context, name string) error {
+	tree := follower.tree
+	content, file, err := follower.primary.ReplicaFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return tree.Rm(name)
+	}
+	if err == ErrIsDir {
+		return follower.syncDir(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if parent := path.Dir(name); parent != "." {
+		err = tree.Mkdir(parent)
+		if err != nil {
+			return err
+		}
+	}
+	if info, err := tree.Lstat(name); err == nil && !info.Mode().IsRegular() {
+		err = tree.Rm(name)
+		if err != nil {
+			return err
+		}
+	}
+	err = tree.WriteFile(name, content, file.Mode)
+	if err == nil && !file.ModTime.IsZero() {
+		err = tree.Chtimes(name, file.ModTime, file.ModTime)
+	}
+	return err
+}
+
+// syncDir makes the directory name, the root when empty, like it is on the
+// primary.
+func (follower *Follower) syncDir(ctx context.Context, name string) error {
+	tree := follower.tree
+	remote, err := follower.primary.ReplicaManifest(name)
+	if errors.Is(err, fs.ErrNotExist) && name != "" {
+		return tree.Rm(name)
+	}
+	if err != nil {
+		return err
+	}
+	local, err := tree.Manifest(ctx, name, remote.Algo)
+	if err != nil && name != "" {
+		// Not there or not a directory.
+		err = tree.Rm(name)
+		if err == nil {
+			err = tree.Mkdir(name)
+		}
+		local = dirsync.Manifest{Algo: remote.Algo}
+	}
+	if err != nil {
+		return err
+	}
+
+	diff := dirsync.Compare(remote, local)
+	removed := ""
+	for _, file := range diff.Extra {
+		// Extras are sorted, so what is below a removed directory follows it.
+		if removed != "" && strings.HasPrefix(file.Path, removed+"/") {
+			continue
+		}
+		err = tree.Rm(path.Join(name, file.Path))
+		if err != nil {
+			return err
+		}
+		removed = file.Path
+	}
+	for _, file := range append(diff.Missing, diff.Changed...) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if file.Dir {
+			err = tree.Mkdir(path.Join(name, file.Path))
+		} else {
+			err = follower.fetch(ctx, path.Join(name, file.Path))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check compares the trees of a primary and a follower, the returned diff
+// is what the follower lacks to be like the primary.
+func Check(primary, follower Primary) (dirsync.Diff, error) {
+	want, err := primary.ReplicaManifest("")
+	if err != nil {
+		return dirsync.Diff{}, err
+	}
+	have, err := follower.ReplicaManifest("")
+	if err != nil {
+		return dirsync.Diff{}, err
+	}
+	return dirsync.Compare(want, have), nil
+}
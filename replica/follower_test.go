@@ -0,0 +1,167 @@
+package replica_test
+
+import (
+	"context"
+	"files_server/client"
+	"files_server/dir"
+	"files_server/replica"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// adminServer serves currentDir to an admin, without logging in.
+func adminServer(t *testing.T, currentDir *dir.Dir) *client.Client {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentDir.ServeHTTP(w, r.WithContext(dir.WithUser(r.Context(), dir.User{Name: "admin", Admin: true})))
+	}))
+	t.Cleanup(testServer.Close)
+	return client.New(testServer.URL)
+}
+
+type replicaTest struct {
+	primaryDir *dir.Dir
+	primary    *client.Client
+	follower   *client.Client
+	tree       *dir.Dir
+	checkpoint string
+}
+
+func newReplicaTest(t *testing.T, maxEntries int) *replicaTest {
+	log, err := replica.Open(filepath.Join(t.TempDir(), "log.jsonl"), maxEntries)
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Close() })
+	test := &replicaTest{
+		primaryDir: dir.NewWithConfig(&dir.Config{Root: t.TempDir(), Replication: log}),
+		tree:       dir.NewWithConfig(&dir.Config{Root: t.TempDir()}),
+		checkpoint: filepath.Join(t.TempDir(), "checkpoint.json"),
+	}
+	test.primary = adminServer(t, test.primaryDir)
+	test.follower = adminServer(t, test.tree)
+	return test
+}
+
+func (test *replicaTest) newFollower(t *testing.T) *replica.Follower {
+	follower, err := replica.NewFollower(test.primary, test.tree, test.checkpoint)
+	require.NoError(t, err)
+	return follower
+}
+
+func (test *replicaTest) requireInSync(t *testing.T) {
+	diff, err := replica.Check(test.primary, test.follower)
+	require.NoError(t, err)
+	require.True(t, diff.Empty(), "%+v", diff)
+}
+
+func (test *replicaTest) write(t *testing.T, name, content string) {
+	require.NoError(t, test.primaryDir.WriteFile(name, strings.NewReader(content), 0))
+}
+
+func TestFollower(t *testing.T) {
+	test := newReplicaTest(t, 0)
+	primary := test.primaryDir
+	require.NoError(t, primary.Mkdir("docs/old"))
+	test.write(t, "docs/a.txt", "a")
+	test.write(t, "docs/old/b.txt", "b")
+	require.NoError(t, primary.Touch("docs/empty.txt"))
+	require.NoError(t, primary.Chmod("docs/a.txt", 0600))
+
+	follower := test.newFollower(t)
+	require.NoError(t, follower.CatchUp(context.Background()))
+	test.requireInSync(t)
+	require.Equal(t, uint64(5), follower.Seq())
+	info, err := test.tree.Stat("docs/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The follower is down while the primary goes on, and catches up from
+	// its checkpoint when it is back.
+	require.NoError(t, primary.Mv("docs/old", "docs/new", false))
+	test.write(t, "docs/a.txt", "changed")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, primary.Chtimes("docs/a.txt", mtime, mtime))
+	require.NoError(t, primary.Rm("docs/empty.txt"))
+	// Written and moved away before the follower gets to it.
+	test.write(t, "tmp.txt", "tmp")
+	require.NoError(t, primary.Mv("tmp.txt", "docs/new/tmp.txt", false))
+
+	follower = test.newFollower(t)
+	require.Equal(t, uint64(5), follower.Seq())
+	require.NoError(t, follower.CatchUp(context.Background()))
+	test.requireInSync(t)
+	require.Equal(t, uint64(11), follower.Seq())
+	info, err = test.tree.Stat("docs/a.txt")
+	require.NoError(t, err)
+	require.True(t, mtime.Equal(info.ModTime()))
+
+	// Applying entries again, e.g. after a crash before the checkpoint was
+	// saved, ends the same.
+	require.NoError(t, os.Remove(test.checkpoint))
+	require.NoError(t, test.newFollower(t).CatchUp(context.Background()))
+	test.requireInSync(t)
+
+	// Changes on the follower show up in the check.
+	require.NoError(t, test.tree.Rm("docs/new/b.txt"))
+	require.NoError(t, test.tree.WriteFile("docs/a.txt", strings.NewReader("other"), 0))
+	require.NoError(t, test.tree.Mkdir("extra"))
+	diff, err := replica.Check(test.primary, test.follower)
+	require.NoError(t, err)
+	require.Len(t, diff.Missing, 1)
+	require.Len(t, diff.Changed, 1)
+	require.Len(t, diff.Extra, 1)
+}
+
+func TestFollowerResync(t *testing.T) {
+	test := newReplicaTest(t, 8)
+	primary := test.primaryDir
+	require.NoError(t, primary.Mkdir("docs"))
+	test.write(t, "docs/a.txt", "a")
+	follower := test.newFollower(t)
+	require.NoError(t, follower.CatchUp(context.Background()))
+
+	// The log drops what the follower still needs.
+	require.NoError(t, test.tree.Mkdir("stale"))
+	for i := 0; i < 10; i++ {
+		test.write(t, "docs/a.txt", string(rune('a'+i)))
+	}
+	require.NoError(t, primary.Mkdir("more/dirs"))
+	test.write(t, "more/b.txt", "b")
+	status, err := test.primary.ReplicaStatus()
+	require.NoError(t, err)
+	require.Greater(t, status.First, follower.Seq()+1)
+
+	require.NoError(t, follower.CatchUp(context.Background()))
+	test.requireInSync(t)
+	require.Equal(t, status.Last, follower.Seq())
+	_, err = test.tree.Stat("stale")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestFollowerRun(t *testing.T) {
+	test := newReplicaTest(t, 0)
+	follower := test.newFollower(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- follower.Run(ctx, 10*time.Millisecond, nil)
+	}()
+
+	require.NoError(t, test.primaryDir.Mkdir("docs"))
+	test.write(t, "docs/a.txt", "a")
+	require.Eventually(t, func() bool { return follower.Seq() == 2 }, 5*time.Second, 10*time.Millisecond)
+	test.requireInSync(t)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't stop")
+	}
+}
@@ -0,0 +1,226 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	OpMkdir   = "mkdir"
+	OpTouch   = "touch"
+	OpWrite   = "write"
+	OpRm      = "rm"
+	OpMv      = "mv"
+	OpChmod   = "chmod"
+	OpChtimes = "chtimes"
+)
+
+var ErrTruncated = errors.New("Log doesn't go back that far, resync")
+
+// Entry is one change of the primary's tree. Paths are relative to its
+// root, with slashes. Mode and ModTime are what Path, or To of a rename,
+// had right after the change.
+type Entry struct {
+	Seq     uint64      `json:"seq"`
+	Op      string      `json:"op"`
+	Path    string      `json:"path"`
+	To      string      `json:"to,omitempty"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	ModTime time.Time   `json:"mtime"`
+	Time    time.Time   `json:"time"`
+}
+
+// Page is a part of the log. First is the oldest entry the log still has,
+// Last+1 when it has none.
+type Page struct {
+	First   uint64  `json:"first"`
+	Last    uint64  `json:"last"`
+	Entries []Entry `json:"entries"`
+}
+
+// Log is the mutation log of a primary, one JSON entry per line in a file.
+// It is kept in memory as well, at most maxEntries of it: older entries are
+// dropped and followers that still need them resync.
+type Log struct {
+	mu         sync.Mutex
+	fileName   string
+	file       *os.File
+	maxEntries int
+	entries    []Entry
+	last       uint64
+	changed    chan struct{}
+}
+
+// Open reads the log in fileName, created when missing. A line cut short
+// by a crash is dropped.
+func Open(fileName string, maxEntries int) (*Log, error) {
+	log := &Log{fileName: fileName, maxEntries: maxEntries, changed: make(chan struct{})}
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		entry := Entry{}
+		if json.Unmarshal(line, &entry) != nil || entry.Seq <= log.last {
+			break
+		}
+		log.entries = append(log.entries, entry)
+		log.last = entry.Seq
+		offset += int64(len(line))
+	}
+	err = file.Truncate(offset)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	log.file = file
+	log.prune()
+	return log, nil
+}
+
+func (log *Log) Close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.file.Close()
+}
+
+// Append numbers entries and adds them to the log. When they can't be
+// written they are lost together with everything before them, so that
+// followers resync instead of missing a change.
+func (log *Log) Append(entries ...Entry) error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	now := time.Now()
+	for i := range entries {
+		log.last++
+		entries[i].Seq = log.last
+		if entries[i].Time.IsZero() {
+			entries[i].Time = now
+		}
+		encoder.Encode(entries[i])
+	}
+	defer log.notify()
+	_, err := log.file.Write(buf.Bytes())
+	if err != nil {
+		log.entries = nil
+		return err
+	}
+	log.entries = append(log.entries, entries...)
+	if log.maxEntries > 0 && len(log.entries) > log.maxEntries {
+		return log.compact()
+	}
+	return nil
+}
+
+func (log *Log) notify() {
+	close(log.changed)
+	log.changed = make(chan struct{})
+}
+
+// prune drops the oldest entries down to 3/4 of maxEntries, so that it
+// doesn't happen with every append.
+func (log *Log) prune() {
+	if log.maxEntries <= 0 || len(log.entries) <= log.maxEntries {
+		return
+	}
+	keep := log.maxEntries * 3 / 4
+	log.entries = append([]Entry(nil), log.entries[len(log.entries)-keep:]...)
+}
+
+// compact prunes the entries and writes the file anew with what is left.
+func (log *Log) compact() error {
+	log.prune()
+	tmp := log.fileName + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range log.entries {
+		encoder.Encode(entry)
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = os.Rename(tmp, log.fileName)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	log.file.Close()
+	log.file = file
+	return nil
+}
+
+// Entries returns up to limit entries after since, all of them when limit
+// is 0. It fails with ErrTruncated when entries after since were dropped,
+// or since is ahead of the log, e.g. because it was removed.
+func (log *Log) Entries(since uint64, limit int) (Page, error) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	page := log.page()
+	if since+1 < page.First || since > page.Last {
+		return page, ErrTruncated
+	}
+	start := len(log.entries) - int(page.Last-since)
+	entries := log.entries[start:]
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	page.Entries = append(page.Entries, entries...)
+	return page, nil
+}
+
+// Status is the range of the log without entries.
+func (log *Log) Status() Page {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.page()
+}
+
+func (log *Log) page() Page {
+	page := Page{First: log.last + 1, Last: log.last, Entries: []Entry{}}
+	if len(log.entries) > 0 {
+		page.First = log.entries[0].Seq
+	}
+	return page
+}
+
+// Wait is closed when there are entries after since.
+func (log *Log) Wait(since uint64) <-chan struct{} {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.last > since {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return log.changed
+}
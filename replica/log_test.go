@@ -0,0 +1,100 @@
+package replica_test
+
+import (
+	"files_server/replica"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func seqs(entries []replica.Entry) []uint64 {
+	out := []uint64{}
+	for _, entry := range entries {
+		out = append(out, entry.Seq)
+	}
+	return out
+}
+
+func TestLog(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "log.jsonl")
+	log, err := replica.Open(fileName, 0)
+	require.NoError(t, err)
+	require.Equal(t, replica.Page{First: 1, Last: 0, Entries: []replica.Entry{}}, log.Status())
+
+	wait := log.Wait(0)
+	require.NoError(t, log.Append(replica.Entry{Op: replica.OpMkdir, Path: "a"}))
+	select {
+	case <-wait:
+	case <-time.After(time.Second):
+		t.Fatal("Wait wasn't closed by Append")
+	}
+	require.NoError(t, log.Append(
+		replica.Entry{Op: replica.OpWrite, Path: "a/b.txt"},
+		replica.Entry{Op: replica.OpMv, Path: "a/b.txt", To: "c.txt"},
+	))
+
+	page, err := log.Entries(0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3}, seqs(page.Entries))
+	require.Equal(t, "c.txt", page.Entries[2].To)
+	require.False(t, page.Entries[0].Time.IsZero())
+	page, err = log.Entries(1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2}, seqs(page.Entries))
+	page, err = log.Entries(3, 0)
+	require.NoError(t, err)
+	require.Empty(t, page.Entries)
+	_, err = log.Entries(4, 0)
+	require.Equal(t, replica.ErrTruncated, err)
+
+	select {
+	case <-log.Wait(3):
+		t.Fatal("Wait was closed without new entries")
+	default:
+	}
+	require.NoError(t, log.Close())
+
+	// A line cut short by a crash is dropped on open.
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":4,"op":"rm","pa`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	log, err = replica.Open(fileName, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), log.Status().Last)
+	require.NoError(t, log.Append(replica.Entry{Op: replica.OpRm, Path: "c.txt"}))
+	require.NoError(t, log.Close())
+	log, err = replica.Open(fileName, 0)
+	require.NoError(t, err)
+	defer log.Close()
+	page, err = log.Entries(0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 3, 4}, seqs(page.Entries))
+}
+
+func TestLogMaxEntries(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "log.jsonl")
+	log, err := replica.Open(fileName, 8)
+	require.NoError(t, err)
+	for i := 0; i < 9; i++ {
+		require.NoError(t, log.Append(replica.Entry{Op: replica.OpTouch, Path: "file"}))
+	}
+	require.Equal(t, replica.Page{First: 4, Last: 9, Entries: []replica.Entry{}}, log.Status())
+	_, err = log.Entries(2, 0)
+	require.Equal(t, replica.ErrTruncated, err)
+	page, err := log.Entries(3, 0)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4, 5, 6, 7, 8, 9}, seqs(page.Entries))
+
+	require.NoError(t, log.Append(replica.Entry{Op: replica.OpTouch, Path: "file"}))
+	require.NoError(t, log.Close())
+	log, err = replica.Open(fileName, 8)
+	require.NoError(t, err)
+	defer log.Close()
+	require.Equal(t, uint64(4), log.Status().First)
+	require.Equal(t, uint64(10), log.Status().Last)
+}
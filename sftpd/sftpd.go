@@ -0,0 +1,294 @@
+package sftpd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"files_server/auth"
+	"files_server/dir"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const handshakeTimeout = 30 * time.Second
+
+// Server serves SFTP over SSH. Clients log in with a password like on /auth
+// and every connection is a session of the auth store, so it counts
+// against the session limits and works on a dir.Dir like HTTP commands.
+type Server struct {
+	auth   *auth.AuthStorage
+	config *ssh.ServerConfig
+}
+
+func New(authStorage *auth.AuthStorage, hostKey ssh.Signer) *Server {
+	server := &Server{auth: authStorage}
+	server.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			token, err := authStorage.Login(remoteIP(conn.RemoteAddr()), conn.User(), string(password))
+			if err != nil {
+				return nil, err
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"token": token}}, nil
+		},
+	}
+	server.config.AddHostKey(hostKey)
+	return server
+}
+
+// LoadHostKey reads a private key in PEM format. A missing file is created
+// with a new ed25519 key.
+func LoadHostKey(fileName string) (ssh.Signer, error) {
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(block)
+		err = os.WriteFile(fileName, data, 0600)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// Serve accepts connections on listener until it is closed.
+func (server *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, server.config)
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	defer sshConn.Close()
+
+	token := sshConn.Permissions.Extensions["token"]
+	defer server.auth.Logout(token)
+	currentDir, user, err := server.auth.Session(remoteIP(conn.RemoteAddr()), token)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "Only session channels")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests, &fileSystem{dir: currentDir, user: user})
+	}
+}
+
+// serveSession waits for the sftp subsystem request and serves it, other
+// requests such as shell or exec are refused.
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request, fileSystem *fileSystem) {
+	defer channel.Close()
+	for req := range requests {
+		subsystem := struct{ Name string }{}
+		if req.Type != "subsystem" || ssh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		go ssh.DiscardRequests(requests)
+
+		handlers := sftp.Handlers{FileGet: fileSystem, FilePut: fileSystem, FileCmd: fileSystem, FileList: fileSystem}
+		sftpServer := sftp.NewRequestServer(channel, handlers, sftp.WithStartDirectory(fileSystem.dir.Pwd()))
+		sftpServer.Serve()
+		sftpServer.Close()
+		return
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// fileSystem maps SFTP requests to the operations of a dir.Dir. Paths arrive
+// absolute, relative ones are resolved against the start directory.
+type fileSystem struct {
+	dir  *dir.Dir
+	user dir.User
+}
+
+func (fileSystem *fileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	file, err := fileSystem.dir.Open(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = dir.ErrIsDir
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (fileSystem *fileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return fileSystem.create(r)
+}
+
+func (fileSystem *fileSystem) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return fileSystem.create(r)
+}
+
+// create honours the open flags on top of dir.Create. Without O_TRUNC the
+// writer starts with the current content, the target is replaced when the
+// client closes the handle.
+func (fileSystem *fileSystem) create(r *sftp.Request) (*dir.Writer, error) {
+	flags := r.Pflags()
+	_, err := fileSystem.dir.Lstat(r.Filepath)
+	switch {
+	case err == nil && flags.Creat && flags.Excl:
+		return nil, os.ErrExist
+	case os.IsNotExist(err) && !flags.Creat:
+		return nil, os.ErrNotExist
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	}
+	return fileSystem.dir.Create(r.Filepath, !flags.Trunc)
+}
+
+func (fileSystem *fileSystem) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return fileSystem.setstat(r)
+	case "Rename":
+		return fileSystem.dir.Mv(r.Filepath, r.Target, false)
+	case "Mkdir":
+		if _, err := fileSystem.dir.Lstat(r.Filepath); err == nil {
+			return os.ErrExist
+		}
+		return fileSystem.dir.Mkdir(r.Filepath)
+	case "Rmdir":
+		return fileSystem.dir.Rmdir(r.Filepath)
+	case "Remove":
+		return fileSystem.dir.Remove(r.Filepath)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (fileSystem *fileSystem) PosixRename(r *sftp.Request) error {
+	return fileSystem.dir.Mv(r.Filepath, r.Target, true)
+}
+
+func (fileSystem *fileSystem) setstat(r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.UidGid && !fileSystem.user.Admin {
+		return os.ErrPermission
+	}
+	if flags.Size {
+		writer, err := fileSystem.dir.Create(r.Filepath, true)
+		if err != nil {
+			return err
+		}
+		err = writer.Truncate(int64(attrs.Size))
+		if err != nil {
+			writer.Abort()
+			return err
+		}
+		err = writer.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		err := fileSystem.dir.Chmod(r.Filepath, attrs.FileMode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		err := fileSystem.dir.Chown(r.Filepath, int(attrs.UID), int(attrs.GID))
+		if err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		return fileSystem.dir.Chtimes(r.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0))
+	}
+	return nil
+}
+
+func (fileSystem *fileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		names, err := fileSystem.dir.Ls(r.Filepath, true)
+		if err != nil {
+			return nil, err
+		}
+		infos := listerAt{}
+		for _, name := range names {
+			info, err := fileSystem.dir.Lstat(filepath.Join(fileSystem.dir.Abs(r.Filepath), name))
+			if err != nil {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+	case "Stat":
+		info, err := fileSystem.dir.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (fileSystem *fileSystem) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := fileSystem.dir.Lstat(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return listerAt{info}, nil
+}
+
+type listerAt []os.FileInfo
+
+func (list listerAt) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(list)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, list[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,207 @@
+package sftpd_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"files_server/auth"
+	"files_server/dir"
+	"files_server/limit"
+	"files_server/sftpd"
+	"files_server/versions"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type testEnv struct {
+	root     string
+	addr     string
+	hostKey  ssh.PublicKey
+	versions *versions.Store
+	http     *httptest.Server
+}
+
+func initTestEnv(t *testing.T) testEnv {
+	root := t.TempDir()
+	store, err := versions.Open(t.TempDir(), 0, limit.RealClock)
+	require.NoError(t, err)
+	require.NoError(t, store.SetPolicy(root, versions.Policy{Keep: 5}))
+	dirConfig := &dir.Config{Root: root, Versions: store}
+
+	limits := auth.DefaultLimits()
+	limits.MaxSessions = 1
+	authStorage := auth.NewWithConfig(auth.Config{
+		Users:  []auth.User{{Name: "alice", Password: "secret"}},
+		Limits: limits,
+		NewDir: func() *dir.Dir { return dir.NewWithConfig(dirConfig) },
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/auth", authStorage)
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go sftpd.New(authStorage, signer).Serve(listener)
+
+	return testEnv{root: root, addr: listener.Addr().String(), hostKey: signer.PublicKey(), versions: store, http: httpServer}
+}
+
+func (env testEnv) dial(password string) (*ssh.Client, error) {
+	return ssh.Dial("tcp", env.addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.FixedHostKey(env.hostKey),
+	})
+}
+
+func (env testEnv) httpLogin(t *testing.T) int {
+	req, err := http.NewRequest(http.MethodGet, env.http.URL+"/auth", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("alice", "secret")
+	resp, err := env.http.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestLogin(t *testing.T) {
+	env := initTestEnv(t)
+
+	_, err := env.dial("wrong")
+	require.Error(t, err)
+
+	conn, err := env.dial("secret")
+	require.NoError(t, err)
+	client, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	wd, err := client.Getwd()
+	require.NoError(t, err)
+	require.Equal(t, env.root, wd)
+
+	// The connection is a session, so with one session per user /auth has to
+	// wait until it is closed.
+	require.Equal(t, http.StatusTooManyRequests, env.httpLogin(t))
+	client.Close()
+	conn.Close()
+	require.Eventually(t, func() bool {
+		return env.httpLogin(t) == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFileOperations(t *testing.T) {
+	env := initTestEnv(t)
+	require.NoError(t, os.WriteFile(filepath.Join(env.root, "old.txt"), []byte("old content"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(env.root, ".hidden"), nil, 0644))
+
+	conn, err := env.dial("secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	file, err := client.Create("new.txt")
+	require.NoError(t, err)
+	_, err = file.Write([]byte("hello sftp"))
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(env.root, "new.txt"), "the file is put in place on close")
+	require.NoError(t, file.Close())
+	data, err := os.ReadFile(filepath.Join(env.root, "new.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello sftp", string(data))
+
+	file, err = client.Open(filepath.Join(env.root, "old.txt"))
+	require.NoError(t, err)
+	data, err = io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "old content", string(data))
+	require.NoError(t, file.Close())
+
+	// Overwriting goes through the same path as HTTP uploads, so the old
+	// content is kept as a version.
+	file, err = client.OpenFile("old.txt", os.O_WRONLY|os.O_TRUNC)
+	require.NoError(t, err)
+	_, err = file.Write([]byte("new content"))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	data, err = os.ReadFile(filepath.Join(env.root, "old.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "new content", string(data))
+	saved, err := env.versions.List(filepath.Join(env.root, "old.txt"))
+	require.NoError(t, err)
+	require.Len(t, saved, 1)
+
+	// Without O_TRUNC only the written part changes.
+	file, err = client.OpenFile("old.txt", os.O_WRONLY)
+	require.NoError(t, err)
+	_, err = file.WriteAt([]byte("NEW"), 0)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	data, err = os.ReadFile(filepath.Join(env.root, "old.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "NEW content", string(data))
+
+	_, err = client.OpenFile("old.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	require.Error(t, err)
+	_, err = client.OpenFile("missing.txt", os.O_WRONLY)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, client.Mkdir("docs"))
+	require.Error(t, client.Mkdir("docs"))
+	require.NoError(t, client.Rename("new.txt", "docs/moved.txt"))
+	require.Error(t, client.Rename("old.txt", "docs/moved.txt"), "rename doesn't overwrite")
+	require.NoError(t, client.PosixRename("old.txt", "docs/moved.txt"))
+
+	infos, err := client.ReadDir(".")
+	require.NoError(t, err)
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{".hidden", "docs"}, names)
+
+	info, err := client.Stat("docs/moved.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(len("NEW content")), info.Size())
+	require.False(t, info.IsDir())
+
+	require.NoError(t, client.Truncate("docs/moved.txt", 3))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, client.Chtimes("docs/moved.txt", mtime, mtime))
+	info, err = os.Stat(filepath.Join(env.root, "docs", "moved.txt"))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), info.Size())
+	require.True(t, mtime.Equal(info.ModTime()))
+	require.NoError(t, client.Chmod("docs/moved.txt", 0600))
+	info, err = os.Stat(filepath.Join(env.root, "docs", "moved.txt"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	require.Error(t, client.Chown("docs/moved.txt", os.Getuid(), os.Getgid()), "only admins")
+
+	_, err = client.Open("docs")
+	require.Error(t, err)
+	require.Error(t, client.Remove("docs"))
+	require.Error(t, client.RemoveDirectory("docs"), "not empty")
+	require.NoError(t, client.Remove("docs/moved.txt"))
+	require.NoError(t, client.RemoveDirectory("docs"))
+	_, err = client.Stat("docs")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
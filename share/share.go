@@ -0,0 +1,327 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"files_server/limit"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ModeRead   = "read"
+	ModeUpload = "upload"
+)
+
+// saveDelay is how long counts of accesses wait to be saved, so that a busy
+// share doesn't rewrite the file on every view.
+const saveDelay = 5 * time.Second
+
+var (
+	ErrNotFound     = errors.New("Share not found")
+	ErrExpired      = errors.New("Share expired")
+	ErrExhausted    = errors.New("Share download limit reached")
+	ErrPassword     = errors.New("Wrong share password")
+	ErrLocked       = errors.New("Too many wrong passwords")
+	ErrMode         = errors.New("Mode should be read or upload")
+	ErrNotPermitted = errors.New("Not permitted by the share mode")
+)
+
+type Share struct {
+	ID           string    `json:"id"`
+	Owner        string    `json:"owner"`
+	Path         string    `json:"path"`
+	Dir          bool      `json:"dir"`
+	Mode         string    `json:"mode"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires,omitempty"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Password     bool      `json:"password"`
+	Views        int       `json:"views"`
+	Downloads    int       `json:"downloads"`
+	Uploads      int       `json:"uploads"`
+	LastAccess   time.Time `json:"last_access,omitempty"`
+	Token        string    `json:"token,omitempty"`
+	passwordHash string
+}
+
+type stored struct {
+	Share
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+type file struct {
+	Secret string   `json:"secret"`
+	Shares []stored `json:"shares"`
+}
+
+// Options are the limits of a new share. Zero values don't limit.
+type Options struct {
+	Mode         string
+	Expires      time.Time
+	MaxDownloads int
+	Password     string
+}
+
+// Store keeps the shares in a JSON file. A share is reached through a token,
+// the share id signed with the store's secret, so ids can't be guessed or
+// forged and revoking a share is removing it. Counts of accesses are saved
+// with a delay, see Flush.
+type Store struct {
+	fileName string
+	clock    limit.Clock
+	lockout  *limit.Lockout
+	mu       sync.Mutex
+	secret   []byte
+	shares   map[string]*Share
+	dirty    bool
+	timer    *time.Timer
+}
+
+func Open(fileName string, clock limit.Clock) (*Store, error) {
+	if clock == nil {
+		clock = limit.RealClock
+	}
+	store := &Store{
+		fileName: fileName,
+		clock:    clock,
+		lockout:  limit.NewLockout(5, 15*time.Minute, clock),
+		shares:   map[string]*Share{},
+	}
+
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		store.secret, err = randomBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		return store, store.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := file{}
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+	store.secret, err = hex.DecodeString(f.Secret)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range f.Shares {
+		share := s.Share
+		share.passwordHash = s.PasswordHash
+		store.shares[share.ID] = &share
+	}
+	return store, nil
+}
+
+func (store *Store) Create(owner, path string, dir bool, options Options) (Share, error) {
+	if options.Mode == "" {
+		options.Mode = ModeRead
+	}
+	if options.Mode != ModeRead && options.Mode != ModeUpload {
+		return Share{}, ErrMode
+	}
+	if options.Mode == ModeUpload && !dir {
+		return Share{}, errors.New("Upload shares need a directory")
+	}
+	id, err := randomBytes(16)
+	if err != nil {
+		return Share{}, err
+	}
+
+	share := &Share{
+		ID:           hex.EncodeToString(id),
+		Owner:        owner,
+		Path:         path,
+		Dir:          dir,
+		Mode:         options.Mode,
+		Created:      store.clock.Now(),
+		Expires:      options.Expires,
+		MaxDownloads: options.MaxDownloads,
+	}
+	if options.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(options.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return Share{}, err
+		}
+		share.Password = true
+		share.passwordHash = string(hash)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.shares[share.ID] = share
+	err = store.save()
+	if err != nil {
+		delete(store.shares, share.ID)
+		return Share{}, err
+	}
+	return store.withToken(share), nil
+}
+
+// List returns the shares of owner, newest first.
+func (store *Store) List(owner string) []Share {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	shares := []Share{}
+	for _, share := range store.shares {
+		if share.Owner == owner {
+			shares = append(shares, store.withToken(share))
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].Created.After(shares[j].Created)
+	})
+	return shares
+}
+
+func (store *Store) Revoke(owner, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	share, ok := store.shares[id]
+	if !ok || share.Owner != owner {
+		return ErrNotFound
+	}
+	delete(store.shares, id)
+	return store.save()
+}
+
+// Access checks token and password and returns the share. It doesn't count
+// anything, call Count once the access succeeded.
+func (store *Store) Access(token, password string) (Share, error) {
+	id, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(store.sign(id))) {
+		return Share{}, ErrNotFound
+	}
+
+	store.mu.Lock()
+	share, ok := store.shares[id]
+	if !ok {
+		store.mu.Unlock()
+		return Share{}, ErrNotFound
+	}
+	s := *share
+	store.mu.Unlock()
+
+	if !s.Expires.IsZero() && !store.clock.Now().Before(s.Expires) {
+		return Share{}, ErrExpired
+	}
+	if s.Password {
+		if locked, _ := store.lockout.Locked(id); locked {
+			return Share{}, ErrLocked
+		}
+		// bcrypt is slow on purpose, so the store isn't locked meanwhile.
+		if bcrypt.CompareHashAndPassword([]byte(s.passwordHash), []byte(password)) != nil {
+			store.lockout.Fail(id)
+			return Share{}, ErrPassword
+		}
+		store.lockout.Reset(id)
+	}
+	if s.MaxDownloads > 0 && s.Downloads >= s.MaxDownloads {
+		return Share{}, ErrExhausted
+	}
+	return s, nil
+}
+
+// Count records an access of kind "view", "download" or "upload". A download
+// fails with ErrExhausted once MaxDownloads is reached, so concurrent
+// downloads can't go past it. Only downloads of a share with MaxDownloads
+// are saved right away, other counts within saveDelay are saved at once.
+func (store *Store) Count(id, kind string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	share, ok := store.shares[id]
+	if !ok {
+		return ErrNotFound
+	}
+	switch kind {
+	case "view":
+		share.Views++
+	case "download":
+		if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+			return ErrExhausted
+		}
+		share.Downloads++
+	case "upload":
+		share.Uploads++
+	}
+	share.LastAccess = store.clock.Now()
+	if kind == "download" && share.MaxDownloads > 0 {
+		return store.save()
+	}
+	store.dirty = true
+	if store.timer == nil {
+		store.timer = time.AfterFunc(saveDelay, func() { store.Flush() })
+	}
+	return nil
+}
+
+// Flush saves the counts that are waiting for saveDelay.
+func (store *Store) Flush() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if !store.dirty {
+		return nil
+	}
+	return store.save()
+}
+
+func (store *Store) withToken(share *Share) Share {
+	s := *share
+	s.Token = share.ID + "." + store.sign(share.ID)
+	return s
+}
+
+func (store *Store) sign(id string) string {
+	mac := hmac.New(sha256.New, store.secret)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (store *Store) save() error {
+	if store.timer != nil {
+		store.timer.Stop()
+		store.timer = nil
+	}
+	f := file{Secret: hex.EncodeToString(store.secret), Shares: []stored{}}
+	for _, share := range store.shares {
+		s := *share
+		s.Token = ""
+		f.Shares = append(f.Shares, stored{Share: s, PasswordHash: share.passwordHash})
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := store.fileName + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, store.fileName)
+	}
+	if err != nil {
+		return err
+	}
+	store.dirty = false
+	return nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
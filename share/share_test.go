@@ -0,0 +1,118 @@
+package share_test
+
+import (
+	"encoding/json"
+	"files_server/share"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func TestStore(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "shares.json")
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store, err := share.Open(fileName, clock)
+	require.NoError(t, err)
+
+	s, err := store.Create("alice", "/data/report.pdf", false, share.Options{
+		Expires:      clock.now.Add(time.Hour),
+		MaxDownloads: 2,
+		Password:     "secret",
+	})
+	require.NoError(t, err)
+	require.Equal(t, share.ModeRead, s.Mode)
+	require.True(t, s.Password)
+
+	_, err = store.Access(s.ID+".forged", "secret")
+	require.ErrorIs(t, err, share.ErrNotFound)
+	_, err = store.Access(s.Token, "wrong")
+	require.ErrorIs(t, err, share.ErrPassword)
+
+	for i := 0; i < 2; i++ {
+		_, err = store.Access(s.Token, "secret")
+		require.NoError(t, err)
+		require.NoError(t, store.Count(s.ID, "download"))
+	}
+	_, err = store.Access(s.Token, "secret")
+	require.ErrorIs(t, err, share.ErrExhausted)
+	require.ErrorIs(t, store.Count(s.ID, "download"), share.ErrExhausted)
+
+	reopened, err := share.Open(fileName, clock)
+	require.NoError(t, err)
+	list := reopened.List("alice")
+	require.Len(t, list, 1)
+	require.Equal(t, s.Token, list[0].Token)
+	require.Equal(t, 2, list[0].Downloads)
+	require.Empty(t, reopened.List("bob"))
+
+	drop, err := reopened.Create("alice", "/data/in", true, share.Options{Mode: share.ModeUpload, Expires: clock.now.Add(time.Minute)})
+	require.NoError(t, err)
+	_, err = reopened.Access(drop.Token, "")
+	require.NoError(t, err)
+	clock.now = clock.now.Add(time.Minute)
+	_, err = reopened.Access(drop.Token, "")
+	require.ErrorIs(t, err, share.ErrExpired)
+
+	require.ErrorIs(t, reopened.Revoke("bob", drop.ID), share.ErrNotFound)
+	require.NoError(t, reopened.Revoke("alice", drop.ID))
+	_, err = reopened.Access(drop.Token, "")
+	require.ErrorIs(t, err, share.ErrNotFound)
+
+	_, err = reopened.Create("alice", "/data/report.pdf", false, share.Options{Mode: share.ModeUpload})
+	require.Error(t, err)
+	_, err = reopened.Create("alice", "/data", true, share.Options{Mode: "write"})
+	require.ErrorIs(t, err, share.ErrMode)
+}
+
+func TestStoreSavesCountsLater(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "shares.json")
+	store, err := share.Open(fileName, nil)
+	require.NoError(t, err)
+	s, err := store.Create("alice", "/data", true, share.Options{})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.Count(s.ID, "view"))
+	}
+	reopened, err := share.Open(fileName, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, reopened.List("alice")[0].Views)
+
+	require.NoError(t, store.Flush())
+	reopened, err = share.Open(fileName, nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, reopened.List("alice")[0].Views)
+}
+
+func TestStorePasswordHash(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "shares.json")
+	store, err := share.Open(fileName, nil)
+	require.NoError(t, err)
+	s, err := store.Create("alice", "/data", true, share.Options{Password: "secret"})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	f := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &f))
+	stored := f["shares"].([]interface{})[0].(map[string]interface{})
+	require.Contains(t, stored["password_hash"], "$2a$")
+
+	store, err = share.Open(fileName, nil)
+	require.NoError(t, err)
+	_, err = store.Access(s.Token, "wrong")
+	require.ErrorIs(t, err, share.ErrPassword)
+	_, err = store.Access(s.Token, "secret")
+	require.NoError(t, err)
+}
@@ -0,0 +1,281 @@
+package shell
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrQuote = errors.New("Unterminated quote")
+	ErrEmpty = errors.New("Empty command around &&")
+)
+
+// Word is one argument after quote removal. Pattern is Text with the glob
+// characters that were quoted or escaped escaped again, so only the bare
+// ones take part in globbing.
+type Word struct {
+	Text    string
+	Pattern string
+	Glob    bool
+	Start   int
+	End     int
+}
+
+// Command is one simple command, its name is the first word.
+type Command struct {
+	Words []Word
+}
+
+func (command Command) Name() string {
+	return command.Words[0].Text
+}
+
+func (command Command) Args() []Word {
+	return command.Words[1:]
+}
+
+type token struct {
+	Word
+	and bool
+}
+
+// lex splits line into words and && operators. Single quotes keep
+// everything, double quotes allow \" and \\, a backslash outside quotes
+// escapes the next character. On an unterminated quote the words read so
+// far, including the open one, are returned with ErrQuote.
+func lex(line string) ([]token, error) {
+	tokens := []token{}
+	var text, pattern strings.Builder
+	inWord, glob := false, false
+	start := 0
+
+	begin := func(i int) {
+		if !inWord {
+			inWord = true
+			start = i
+		}
+	}
+	literal := func(c byte) {
+		text.WriteByte(c)
+		if strings.IndexByte(`*?[]\`, c) >= 0 {
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteByte(c)
+	}
+	end := func(i int) {
+		if !inWord {
+			return
+		}
+		tokens = append(tokens, token{Word: Word{Text: text.String(), Pattern: pattern.String(), Glob: glob, Start: start, End: i}})
+		text.Reset()
+		pattern.Reset()
+		inWord, glob = false, false
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			end(i)
+		case c == '&' && i+1 < len(line) && line[i+1] == '&':
+			end(i)
+			tokens = append(tokens, token{Word: Word{Text: "&&", Start: i, End: i + 2}, and: true})
+			i++
+		case c == '\\':
+			begin(i)
+			if i+1 < len(line) {
+				i++
+				literal(line[i])
+			}
+		case c == '\'':
+			begin(i)
+			closing := strings.IndexByte(line[i+1:], '\'')
+			if closing < 0 {
+				for j := i + 1; j < len(line); j++ {
+					literal(line[j])
+				}
+				end(len(line))
+				return tokens, ErrQuote
+			}
+			for j := i + 1; j <= i+closing; j++ {
+				literal(line[j])
+			}
+			i += closing + 1
+		case c == '"':
+			begin(i)
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+				}
+				literal(line[i])
+			}
+			if i >= len(line) {
+				end(len(line))
+				return tokens, ErrQuote
+			}
+		default:
+			begin(i)
+			text.WriteByte(c)
+			pattern.WriteByte(c)
+			if c == '*' || c == '?' || c == '[' {
+				glob = true
+			}
+		}
+	}
+	end(len(line))
+	return tokens, nil
+}
+
+// Parse splits line into commands chained with &&. An empty line gives no
+// commands.
+func Parse(line string) ([]Command, error) {
+	tokens, err := lex(line)
+	if err != nil {
+		return nil, err
+	}
+	commands := []Command{}
+	current := Command{}
+	for _, t := range tokens {
+		if t.and {
+			if len(current.Words) == 0 {
+				return nil, ErrEmpty
+			}
+			commands = append(commands, current)
+			current = Command{}
+			continue
+		}
+		current.Words = append(current.Words, t.Word)
+	}
+	if len(current.Words) == 0 {
+		if len(commands) > 0 {
+			return nil, ErrEmpty
+		}
+		return commands, nil
+	}
+	return append(commands, current), nil
+}
+
+// Expand globs word in cwd. Matches keep the form of the word, relative or
+// absolute, and are sorted. Hidden files only match a pattern whose name
+// starts with a dot. A word without glob characters or without matches is
+// returned as it is.
+func Expand(word Word, cwd string) []string {
+	if !word.Glob {
+		return []string{word.Text}
+	}
+	pattern := word.Pattern
+	absolute := filepath.IsAbs(pattern)
+	if !absolute {
+		pattern = filepath.Join(cwd, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return []string{word.Text}
+	}
+
+	showHidden := strings.HasPrefix(filepath.Base(word.Pattern), ".")
+	expanded := []string{}
+	for _, match := range matches {
+		if !showHidden && strings.HasPrefix(filepath.Base(match), ".") {
+			continue
+		}
+		if !absolute {
+			rel, err := filepath.Rel(cwd, match)
+			if err != nil {
+				continue
+			}
+			match = rel
+		}
+		expanded = append(expanded, match)
+	}
+	if len(expanded) == 0 {
+		return []string{word.Text}
+	}
+	sort.Strings(expanded)
+	return expanded
+}
+
+// Completion replaces line[Start:cursor] with one of the candidates.
+type Completion struct {
+	Start      int      `json:"start"`
+	Candidates []string `json:"candidates"`
+}
+
+// Complete completes the word at cursor: a command name at the start of a
+// command, a path otherwise. list returns the names in a directory, with a
+// trailing slash for directories; dirsOnly limits path completion to
+// directories for the given command names.
+func Complete(line string, cursor int, names []string, dirsOnly map[string]bool, list func(dir string) ([]string, error)) Completion {
+	if cursor < 0 || cursor > len(line) {
+		cursor = len(line)
+	}
+	tokens, _ := lex(line[:cursor])
+
+	prev := tokens
+	word := Word{Start: cursor, End: cursor}
+	if n := len(tokens); n > 0 && !tokens[n-1].and && tokens[n-1].End == cursor {
+		word = tokens[n-1].Word
+		prev = tokens[:n-1]
+	}
+	command := ""
+	first := true
+	for _, t := range prev {
+		if t.and {
+			command, first = "", true
+			continue
+		}
+		if first {
+			command = t.Text
+		}
+		first = false
+	}
+
+	completion := Completion{Start: word.Start, Candidates: []string{}}
+	if first {
+		for _, name := range names {
+			if strings.HasPrefix(name, word.Text) {
+				completion.Candidates = append(completion.Candidates, name)
+			}
+		}
+		sort.Strings(completion.Candidates)
+		return completion
+	}
+
+	dir, prefix := "", word.Text
+	if i := strings.LastIndex(word.Text, "/"); i >= 0 {
+		dir, prefix = word.Text[:i+1], word.Text[i+1:]
+	}
+	listDir := dir
+	if listDir == "" {
+		listDir = "."
+	}
+	entries, err := list(listDir)
+	if err != nil {
+		return completion
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, prefix) {
+			continue
+		}
+		if strings.HasPrefix(entry, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if dirsOnly[command] && !strings.HasSuffix(entry, "/") {
+			continue
+		}
+		completion.Candidates = append(completion.Candidates, Quote(dir+entry))
+	}
+	sort.Strings(completion.Candidates)
+	return completion
+}
+
+// Quote returns s as one word, quoting it only when needed.
+func Quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t'\"\\*?[&") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
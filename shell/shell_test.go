@@ -0,0 +1,137 @@
+package shell_test
+
+import (
+	"files_server/shell"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		words [][]string
+		err   error
+	}{
+		{name: "empty", line: "  ", words: [][]string{}},
+		{name: "simple", line: "ls -a  docs", words: [][]string{{"ls", "-a", "docs"}}},
+		{name: "single quotes", line: `mkdir 'a b' 'it"s'`, words: [][]string{{"mkdir", "a b", `it"s`}}},
+		{name: "double quotes", line: `touch "a \"b\" \\c" "x"y`, words: [][]string{{"touch", `a "b" \c`, "xy"}}},
+		{name: "backslash", line: `rm a\ b \&\&`, words: [][]string{{"rm", "a b", "&&"}}},
+		{name: "empty quotes", line: `touch ''`, words: [][]string{{"touch", ""}}},
+		{name: "chain", line: "mkdir x&&cd x && pwd", words: [][]string{{"mkdir", "x"}, {"cd", "x"}, {"pwd"}}},
+		{name: "quoted and", line: "touch '&&'", words: [][]string{{"touch", "&&"}}},
+		{name: "unterminated single", line: "ls 'docs", err: shell.ErrQuote},
+		{name: "unterminated double", line: `ls "docs\"`, err: shell.ErrQuote},
+		{name: "leading and", line: "&& ls", err: shell.ErrEmpty},
+		{name: "trailing and", line: "ls &&", err: shell.ErrEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, err := shell.Parse(tt.line)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			words := [][]string{}
+			for _, command := range commands {
+				texts := []string{}
+				for _, word := range command.Words {
+					texts = append(texts, word.Text)
+				}
+				words = append(words, texts)
+			}
+			require.Equal(t, tt.words, words)
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", ".hidden.txt", "c.log", "sub/d.txt", "*.txt"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), nil, 0644))
+	}
+
+	tests := []struct {
+		name     string
+		line     string
+		expanded []string
+	}{
+		{name: "literal", line: "a.txt", expanded: []string{"a.txt"}},
+		{name: "star", line: "*.txt", expanded: []string{"*.txt", "a.txt", "b.txt"}},
+		{name: "question", line: "?.log", expanded: []string{"c.log"}},
+		{name: "class", line: "[ab].txt", expanded: []string{"a.txt", "b.txt"}},
+		{name: "hidden", line: ".*.txt", expanded: []string{".hidden.txt"}},
+		{name: "subdir", line: "sub/*", expanded: []string{"sub/d.txt"}},
+		{name: "absolute", line: filepath.Join(root, "sub", "*.txt"), expanded: []string{filepath.Join(root, "sub", "d.txt")}},
+		{name: "no match", line: "*.md", expanded: []string{"*.md"}},
+		{name: "quoted", line: "'*.txt'", expanded: []string{"*.txt"}},
+		{name: "escaped", line: `\*.txt`, expanded: []string{"*.txt"}},
+		{name: "partly quoted", line: `'a'*`, expanded: []string{"a.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, err := shell.Parse("ls " + tt.line)
+			require.NoError(t, err)
+			require.Equal(t, tt.expanded, shell.Expand(commands[0].Args()[0], root))
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	listings := map[string][]string{
+		".":     {"docs/", "downloads/", "draft.txt", ".git/", "my file.txt"},
+		"docs/": {"a.txt", "archive/"},
+	}
+	list := func(dir string) ([]string, error) {
+		entries, ok := listings[dir]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return entries, nil
+	}
+	names := []string{"cd", "ls", "mkdir", "mv", "pwd"}
+	dirsOnly := map[string]bool{"cd": true}
+
+	tests := []struct {
+		name       string
+		line       string
+		cursor     int
+		start      int
+		candidates []string
+	}{
+		{name: "command", line: "m", cursor: -1, start: 0, candidates: []string{"mkdir", "mv"}},
+		{name: "all commands", line: "", cursor: -1, start: 0, candidates: names},
+		{name: "after and", line: "pwd && l", cursor: -1, start: 7, candidates: []string{"ls"}},
+		{name: "path", line: "ls d", cursor: -1, start: 3, candidates: []string{"docs/", "downloads/", "draft.txt"}},
+		{name: "dirs only", line: "cd d", cursor: -1, start: 3, candidates: []string{"docs/", "downloads/"}},
+		{name: "empty word", line: "ls ", cursor: -1, start: 3, candidates: []string{"'my file.txt'", "docs/", "downloads/", "draft.txt"}},
+		{name: "hidden", line: "ls .", cursor: -1, start: 3, candidates: []string{".git/"}},
+		{name: "subdir", line: "ls docs/a", cursor: -1, start: 3, candidates: []string{"docs/a.txt", "docs/archive/"}},
+		{name: "quoted prefix", line: "ls 'my", cursor: -1, start: 3, candidates: []string{"'my file.txt'"}},
+		{name: "cursor in line", line: "ls do && pwd", cursor: 5, start: 3, candidates: []string{"docs/", "downloads/"}},
+		{name: "missing dir", line: "ls nope/x", cursor: -1, start: 3, candidates: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completion := shell.Complete(tt.line, tt.cursor, names, dirsOnly, list)
+			require.Equal(t, tt.start, completion.Start)
+			require.Equal(t, tt.candidates, completion.Candidates)
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"plain", "a b", "it's", `"x"`, `a\b`, "*.txt", "", "a&&b"} {
+		commands, err := shell.Parse("ls " + shell.Quote(s))
+		require.NoError(t, err)
+		require.Equal(t, s, commands[0].Args()[0].Text)
+		require.False(t, commands[0].Args()[0].Glob)
+	}
+	require.Equal(t, "plain", shell.Quote("plain"))
+}
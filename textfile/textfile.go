@@ -0,0 +1,370 @@
+package textfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	UTF8    = "utf-8"
+	UTF16LE = "utf-16le"
+	UTF16BE = "utf-16be"
+	Latin1  = "iso-8859-1"
+	Binary  = "binary"
+)
+
+// SampleSize is how much of a file Detect needs to see.
+const SampleSize = 8192
+
+var (
+	ErrBinary   = errors.New("Binary file")
+	ErrEncoding = errors.New("Unknown encoding, use utf-8, utf-16le, utf-16be or iso-8859-1")
+)
+
+// Detect guesses the encoding of a file from its first bytes: a BOM,
+// the zero bytes of UTF-16, valid UTF-8 and else Latin-1, unless there
+// are too many control characters for text.
+func Detect(sample []byte) string {
+	for _, encoding := range []string{UTF8, UTF16LE, UTF16BE} {
+		if bytes.HasPrefix(sample, boms[encoding]) {
+			return encoding
+		}
+	}
+
+	if bytes.IndexByte(sample, 0) >= 0 {
+		even, odd := 0, 0
+		for i, b := range sample {
+			if b == 0 && i%2 == 0 {
+				even++
+			} else if b == 0 {
+				odd++
+			}
+		}
+		half := len(sample) / 2
+		switch {
+		case even == 0 && odd > half*3/4:
+			return UTF16LE
+		case odd == 0 && even > half*3/4:
+			return UTF16BE
+		}
+		return Binary
+	}
+
+	control := 0
+	for _, b := range sample {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' && b != 0x1b {
+			control++
+		}
+	}
+	if control > len(sample)/10 {
+		return Binary
+	}
+
+	// The sample may end in the middle of a character.
+	valid := sample
+	for i := len(sample) - 1; i >= 0 && i >= len(sample)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(sample[i]) {
+			if !utf8.FullRune(sample[i:]) {
+				valid = sample[:i]
+			}
+			break
+		}
+	}
+	if utf8.Valid(valid) {
+		return UTF8
+	}
+	return Latin1
+}
+
+// DetectFile detects the encoding of the file from its start and leaves
+// the offset there.
+func DetectFile(file *os.File) (string, error) {
+	sample := make([]byte, SampleSize)
+	n, err := io.ReadFull(file, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	return Detect(sample[:n]), nil
+}
+
+func Valid(encoding string) bool {
+	switch encoding {
+	case UTF8, UTF16LE, UTF16BE, Latin1:
+		return true
+	}
+	return false
+}
+
+// ASCIICompatible encodings have newlines as single '\n' bytes, so lines
+// can be found without decoding.
+func ASCIICompatible(encoding string) bool {
+	return encoding == UTF8 || encoding == Latin1
+}
+
+var boms = map[string][]byte{
+	UTF8:    {0xef, 0xbb, 0xbf},
+	UTF16LE: {0xff, 0xfe},
+	UTF16BE: {0xfe, 0xff},
+}
+
+// NewReader decodes r from encoding to UTF-8, without a BOM.
+func NewReader(r io.Reader, encoding string) io.Reader {
+	return &decoder{r: r, encoding: encoding, start: true}
+}
+
+type decoder struct {
+	r        io.Reader
+	encoding string
+	start    bool
+	// carry is the start of a character cut off by the previous read.
+	carry []byte
+	out   []byte
+	err   error
+}
+
+func (decoder *decoder) Read(p []byte) (int, error) {
+	for len(decoder.out) == 0 {
+		if decoder.err != nil {
+			return 0, decoder.err
+		}
+		buf := make([]byte, len(decoder.carry)+4096)
+		copy(buf, decoder.carry)
+		n, err := decoder.r.Read(buf[len(decoder.carry):])
+		decoder.err = err
+		decoder.decode(buf[:len(decoder.carry)+n], err != nil)
+	}
+	n := copy(p, decoder.out)
+	decoder.out = decoder.out[n:]
+	return n, nil
+}
+
+// decode turns in into output, keeping an incomplete character at the end
+// for the next read unless it is the last.
+func (decoder *decoder) decode(in []byte, last bool) {
+	decoder.carry = nil
+	if decoder.start {
+		bom := boms[decoder.encoding]
+		if len(in) < len(bom) && bytes.HasPrefix(bom, in) && !last {
+			decoder.carry = in
+			return
+		}
+		decoder.start = false
+		in = bytes.TrimPrefix(in, bom)
+	}
+
+	switch decoder.encoding {
+	case Latin1:
+		out := make([]rune, len(in))
+		for i, b := range in {
+			out[i] = rune(b)
+		}
+		decoder.out = []byte(string(out))
+	case UTF16LE, UTF16BE:
+		if len(in)%2 == 1 && !last {
+			decoder.carry = in[len(in)-1:]
+			in = in[:len(in)-1]
+		}
+		units := make([]uint16, len(in)/2)
+		for i := range units {
+			if decoder.encoding == UTF16LE {
+				units[i] = uint16(in[2*i]) | uint16(in[2*i+1])<<8
+			} else {
+				units[i] = uint16(in[2*i])<<8 | uint16(in[2*i+1])
+			}
+		}
+		// A surrogate pair may be cut in half as well.
+		if n := len(units); n > 0 && !last && utf16.IsSurrogate(rune(units[n-1])) && units[n-1] < 0xdc00 {
+			decoder.carry = append(in[len(in)-2:len(in):len(in)], decoder.carry...)
+			units = units[:n-1]
+		}
+		decoder.out = []byte(string(utf16.Decode(units)))
+	default:
+		decoder.out = in
+	}
+}
+
+// Head copies the first n lines of r to w.
+func Head(w io.Writer, r io.Reader, n int) error {
+	return Lines(w, r, 1, n)
+}
+
+// Lines copies lines from to to of r to w, counted from 1.
+func Lines(w io.Writer, r io.Reader, from, to int) error {
+	reader := bufio.NewReader(r)
+	for line := 1; line <= to; line++ {
+		data, err := reader.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			if line >= from {
+				if _, writeErr := w.Write(data); writeErr != nil {
+					return writeErr
+				}
+			}
+			data, err = reader.ReadSlice('\n')
+		}
+		if line >= from {
+			if _, writeErr := w.Write(data); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TailOffset is where the last n lines of the first end bytes of file
+// start, found by reading backwards. A newline at end doesn't start another
+// line. Only for ASCII compatible encodings.
+func TailOffset(file io.ReaderAt, end int64, n int) (int64, error) {
+	if n <= 0 {
+		return end, nil
+	}
+	buf := make([]byte, 64*1024)
+	offset := end
+	newlines := 0
+	for offset > 0 {
+		size := int64(len(buf))
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		_, err := file.ReadAt(buf[:size], offset)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := size - 1; i >= 0; i-- {
+			if buf[i] != '\n' || offset+i == end-1 {
+				continue
+			}
+			newlines++
+			if newlines == n {
+				return offset + i + 1, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// Tail copies the last n lines of r to w. It has to read all of r, use
+// TailOffset where possible.
+func Tail(w io.Writer, r io.Reader, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	// lines is a ring, count lines were read in total.
+	lines := make([][]byte, n)
+	count := 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lines[count%n] = line
+			count++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	first := 0
+	if count > n {
+		first = count - n
+	}
+	for i := first; i < count; i++ {
+		if _, err := w.Write(lines[i%n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Follow reads fileName from offset like tail -F: at the end it waits for
+// more every poll, starts over when the file is truncated and opens the new
+// file when it is replaced. It ends with the error of ctx.
+func Follow(ctx context.Context, fileName string, offset int64, poll time.Duration) io.ReadCloser {
+	return &follower{ctx: ctx, fileName: fileName, offset: offset, poll: poll}
+}
+
+type follower struct {
+	ctx      context.Context
+	fileName string
+	offset   int64
+	poll     time.Duration
+	file     *os.File
+}
+
+func (follower *follower) Read(p []byte) (int, error) {
+	for {
+		if err := follower.ctx.Err(); err != nil {
+			return 0, err
+		}
+		if follower.file == nil {
+			file, err := os.Open(follower.fileName)
+			if err == nil {
+				follower.file = file
+			} else if !os.IsNotExist(err) {
+				return 0, err
+			}
+		}
+		if follower.file != nil {
+			n, err := follower.file.ReadAt(p, follower.offset)
+			follower.offset += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			follower.check()
+		}
+
+		timer := time.NewTimer(follower.poll)
+		select {
+		case <-follower.ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// check notices a truncated or replaced file at the end of the current one.
+func (follower *follower) check() {
+	current, err := follower.file.Stat()
+	if err != nil {
+		return
+	}
+	if current.Size() < follower.offset {
+		follower.offset = 0
+		return
+	}
+	info, err := os.Stat(follower.fileName)
+	if err != nil || !os.SameFile(info, current) {
+		follower.file.Close()
+		follower.file = nil
+		follower.offset = 0
+	}
+}
+
+func (follower *follower) Close() error {
+	if follower.file == nil {
+		return nil
+	}
+	return follower.file.Close()
+}
@@ -0,0 +1,175 @@
+package textfile_test
+
+import (
+	"bytes"
+	"context"
+	"files_server/textfile"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+func utf16le(s string) []byte {
+	out := []byte{}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(unit), byte(unit>>8))
+	}
+	return out
+}
+
+func utf16be(s string) []byte {
+	out := []byte{}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(unit>>8), byte(unit))
+	}
+	return out
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{name: "empty", data: nil, expected: textfile.UTF8},
+		{name: "ascii", data: []byte("build ok\n"), expected: textfile.UTF8},
+		{name: "utf8", data: []byte("grüße ✓\n"), expected: textfile.UTF8},
+		{name: "utf8_cut_in_character", data: []byte("ok ✓")[:5], expected: textfile.UTF8},
+		{name: "utf8_bom", data: append([]byte{0xef, 0xbb, 0xbf}, "x"...), expected: textfile.UTF8},
+		{name: "utf16le_bom", data: append([]byte{0xff, 0xfe}, utf16le("x")...), expected: textfile.UTF16LE},
+		{name: "utf16be_bom", data: append([]byte{0xfe, 0xff}, utf16be("x")...), expected: textfile.UTF16BE},
+		{name: "utf16le", data: utf16le("log line\n"), expected: textfile.UTF16LE},
+		{name: "utf16be", data: utf16be("log line\n"), expected: textfile.UTF16BE},
+		{name: "latin1", data: []byte("gr\xfc\xdfe\n"), expected: textfile.Latin1},
+		{name: "binary", data: []byte{0x7f, 'E', 'L', 'F', 2, 1, 1, 0, 0, 0, 0, 0}, expected: textfile.Binary},
+		{name: "control", data: []byte{1, 2, 3, 4, 0xff, 5, 6}, expected: textfile.Binary},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected, textfile.Detect(test.data))
+		})
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	text := "grüße 😀\nline two\n"
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+	}{
+		{name: "utf8", data: []byte(text), encoding: textfile.UTF8},
+		{name: "utf8_bom", data: append([]byte{0xef, 0xbb, 0xbf}, text...), encoding: textfile.UTF8},
+		{name: "utf16le", data: append([]byte{0xff, 0xfe}, utf16le(text)...), encoding: textfile.UTF16LE},
+		{name: "utf16be", data: utf16be(text), encoding: textfile.UTF16BE},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// One byte at a time cuts every character and surrogate pair.
+			data, err := io.ReadAll(textfile.NewReader(iotest.OneByteReader(bytes.NewReader(test.data)), test.encoding))
+			require.NoError(t, err)
+			require.Equal(t, text, string(data))
+		})
+	}
+
+	data, err := io.ReadAll(textfile.NewReader(strings.NewReader("gr\xfc\xdfe"), textfile.Latin1))
+	require.NoError(t, err)
+	require.Equal(t, "grüße", string(data))
+}
+
+func TestLines(t *testing.T) {
+	text := "1\n2\n3\n4\n5"
+	tests := []struct {
+		name     string
+		fn       func(w io.Writer, r io.Reader) error
+		expected string
+	}{
+		{name: "head", fn: func(w io.Writer, r io.Reader) error { return textfile.Head(w, r, 2) }, expected: "1\n2\n"},
+		{name: "head_all", fn: func(w io.Writer, r io.Reader) error { return textfile.Head(w, r, 10) }, expected: text},
+		{name: "head_none", fn: func(w io.Writer, r io.Reader) error { return textfile.Head(w, r, 0) }, expected: ""},
+		{name: "lines", fn: func(w io.Writer, r io.Reader) error { return textfile.Lines(w, r, 2, 3) }, expected: "2\n3\n"},
+		{name: "lines_past_end", fn: func(w io.Writer, r io.Reader) error { return textfile.Lines(w, r, 5, 9) }, expected: "5"},
+		{name: "tail", fn: func(w io.Writer, r io.Reader) error { return textfile.Tail(w, r, 2) }, expected: "4\n5"},
+		{name: "tail_all", fn: func(w io.Writer, r io.Reader) error { return textfile.Tail(w, r, 10) }, expected: text},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			require.NoError(t, test.fn(buf, strings.NewReader(text)))
+			require.Equal(t, test.expected, buf.String())
+		})
+	}
+
+	long := strings.Repeat("x", 10000) + "\nend\n"
+	buf := &bytes.Buffer{}
+	require.NoError(t, textfile.Head(buf, strings.NewReader(long), 1))
+	require.Equal(t, strings.Repeat("x", 10000)+"\n", buf.String())
+}
+
+func TestTailOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		n        int
+		expected string
+	}{
+		{name: "trailing_newline", text: "a\nb\nc\n", n: 2, expected: "b\nc\n"},
+		{name: "no_trailing_newline", text: "a\nb\nc", n: 2, expected: "b\nc"},
+		{name: "more_than_there_are", text: "a\nb\n", n: 5, expected: "a\nb\n"},
+		{name: "zero", text: "a\n", n: 0, expected: ""},
+		{name: "long", text: strings.Repeat("line\n", 100000), n: 3, expected: "line\nline\nline\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := strings.NewReader(test.text)
+			offset, err := textfile.TailOffset(r, int64(len(test.text)), test.n)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, test.text[offset:])
+		})
+	}
+}
+
+func TestFollow(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "build.log")
+	require.NoError(t, os.WriteFile(fileName, []byte("old\n"), 0644))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	follower := textfile.Follow(ctx, fileName, 4, 10*time.Millisecond)
+	defer follower.Close()
+
+	read := func(expected string) {
+		buf := make([]byte, len(expected))
+		_, err := io.ReadFull(follower, buf)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(buf))
+	}
+
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString("step 1\n")
+	require.NoError(t, err)
+	read("step 1\n")
+
+	// Truncated, the file is read from the start again.
+	require.NoError(t, file.Truncate(0))
+	_, err = file.WriteString("new\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	read("new\n")
+
+	// Replaced, like a rotated log.
+	require.NoError(t, os.Rename(fileName, fileName+".1"))
+	require.NoError(t, os.WriteFile(fileName, []byte("rotated\n"), 0644))
+	read("rotated\n")
+
+	cancel()
+	_, err = follower.Read(make([]byte, 10))
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,428 @@
+"use strict";
+
+const chunkSize = 4 << 20;
+const previewBytes = 256 << 10;
+
+const state = {
+  token: sessionStorage.getItem("token") || "",
+  cwd: "/",
+  entries: [],
+  sortKey: "name",
+  sortDesc: false,
+};
+
+const $ = (selector) => document.querySelector(selector);
+
+class HTTPError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+async function api(path, options = {}) {
+  options.headers = Object.assign({}, options.headers, {
+    Authorization: "Bearer " + state.token,
+  });
+  const resp = await fetch(path, options);
+  if (resp.status === 401) {
+    logout();
+    throw new HTTPError(401, "Logged out");
+  }
+  if (!resp.ok && resp.status !== 206) {
+    throw new HTTPError(resp.status, (await resp.text()).trim());
+  }
+  return resp;
+}
+
+function query(params) {
+  return new URLSearchParams(params).toString();
+}
+
+function join(dir, name) {
+  return dir === "/" ? "/" + name : dir + "/" + name;
+}
+
+function showStatus(message, isError) {
+  const status = $("#status");
+  status.textContent = message || "";
+  status.classList.toggle("error", !!isError);
+}
+
+function fail(err) {
+  if (err.status !== 401) {
+    showStatus(err.message, true);
+  }
+}
+
+// Login
+
+async function login(event) {
+  event.preventDefault();
+  const form = event.target;
+  const headers = {};
+  if (form.user.value) {
+    headers.Authorization = "Basic " + btoa(form.user.value + ":" + form.password.value);
+  }
+  const resp = await fetch("/auth", { headers });
+  const body = (await resp.text()).trim();
+  if (!resp.ok) {
+    $("#login-error").textContent = body;
+    return;
+  }
+  state.token = body;
+  sessionStorage.setItem("token", body);
+  form.password.value = "";
+  $("#login-error").textContent = "";
+  start();
+}
+
+function logout() {
+  state.token = "";
+  sessionStorage.removeItem("token");
+  $("#browser").hidden = true;
+  $("#login").hidden = false;
+}
+
+async function start() {
+  $("#login").hidden = true;
+  $("#browser").hidden = false;
+  try {
+    const resp = await api("/pwd");
+    state.cwd = await resp.text();
+    await refresh();
+  } catch (err) {
+    fail(err);
+  }
+}
+
+// Listing
+
+async function cd(dir) {
+  try {
+    await api("/cd?" + query({ dir }));
+    state.cwd = dir;
+    showStatus("");
+    await refresh();
+  } catch (err) {
+    fail(err);
+  }
+}
+
+async function refresh() {
+  const params = { meta: "true" };
+  if ($("#show-hidden").checked) {
+    params.hide = "true";
+  }
+  const resp = await api("/ls?" + query(params));
+  state.entries = await resp.json();
+  render();
+}
+
+function compare(a, b) {
+  if ((a.type === "dir") !== (b.type === "dir")) {
+    return a.type === "dir" ? -1 : 1;
+  }
+  let result;
+  switch (state.sortKey) {
+    case "size":
+      result = a.size - b.size;
+      break;
+    case "mtime":
+      result = new Date(a.mtime) - new Date(b.mtime);
+      break;
+    case "type":
+      result = a.type.localeCompare(b.type);
+      break;
+    default:
+      result = a.name.localeCompare(b.name);
+  }
+  if (result === 0 && state.sortKey !== "name") {
+    result = a.name.localeCompare(b.name);
+  }
+  return state.sortDesc ? -result : result;
+}
+
+function humanSize(size) {
+  const units = ["B", "K", "M", "G", "T"];
+  let i = 0;
+  while (size >= 1024 && i < units.length - 1) {
+    size /= 1024;
+    i++;
+  }
+  return (i === 0 ? size : size.toFixed(1)) + units[i];
+}
+
+function button(label, onClick) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.addEventListener("click", onClick);
+  return b;
+}
+
+function render() {
+  renderBreadcrumbs();
+
+  document.querySelectorAll("th[data-key]").forEach((th) => {
+    th.classList.toggle("asc", th.dataset.key === state.sortKey && !state.sortDesc);
+    th.classList.toggle("desc", th.dataset.key === state.sortKey && state.sortDesc);
+  });
+
+  const tbody = $("#listing tbody");
+  tbody.replaceChildren();
+  if (state.cwd !== "/") {
+    const row = tbody.insertRow();
+    const link = document.createElement("a");
+    link.textContent = "..";
+    link.addEventListener("click", () => cd(parent(state.cwd)));
+    const cell = row.insertCell();
+    cell.className = "name";
+    cell.append(link);
+    row.insertCell().colSpan = 4;
+  }
+
+  for (const entry of [...state.entries].sort(compare)) {
+    const row = tbody.insertRow();
+    const path = join(state.cwd, entry.name);
+
+    const link = document.createElement("a");
+    link.textContent = entry.name + (entry.type === "dir" ? "/" : "");
+    link.addEventListener("click", () => (entry.type === "dir" ? cd(path) : preview(path, entry)));
+    const name = row.insertCell();
+    name.className = "name";
+    name.append(link);
+
+    row.insertCell().textContent = entry.type;
+    const size = row.insertCell();
+    size.className = "size";
+    size.textContent = entry.type === "dir" ? "" : humanSize(entry.size);
+    row.insertCell().textContent = new Date(entry.mtime).toLocaleString();
+
+    const ops = row.insertCell();
+    ops.className = "ops";
+    ops.append(button("Rename", () => rename(entry)), " ", button("Delete", () => remove(entry)));
+  }
+}
+
+function parent(path) {
+  const i = path.lastIndexOf("/");
+  return i <= 0 ? "/" : path.slice(0, i);
+}
+
+function renderBreadcrumbs() {
+  const nav = $("#breadcrumbs");
+  nav.replaceChildren();
+  const parts = state.cwd.split("/").filter((part) => part !== "");
+  const crumb = (label, path) => {
+    const link = document.createElement("a");
+    link.textContent = label;
+    link.addEventListener("click", () => cd(path));
+    nav.append(link);
+  };
+  crumb("/", "/");
+  let path = "";
+  parts.forEach((part, i) => {
+    path += "/" + part;
+    if (i > 0) {
+      const sep = document.createElement("span");
+      sep.className = "sep";
+      sep.textContent = "/";
+      nav.append(sep);
+    }
+    crumb(part, path);
+  });
+}
+
+function sortBy(key) {
+  if (state.sortKey === key) {
+    state.sortDesc = !state.sortDesc;
+  } else {
+    state.sortKey = key;
+    state.sortDesc = false;
+  }
+  render();
+}
+
+// Changes
+
+async function mkdir() {
+  const name = prompt("Folder name");
+  if (!name) {
+    return;
+  }
+  try {
+    await api("/mkdir?" + query({ dirname: join(state.cwd, name) }));
+    await refresh();
+  } catch (err) {
+    fail(err);
+  }
+}
+
+async function rename(entry) {
+  const name = prompt("New name", entry.name);
+  if (!name || name === entry.name) {
+    return;
+  }
+  try {
+    await api("/mv?" + query({ from: join(state.cwd, entry.name), to: join(state.cwd, name) }));
+    await refresh();
+  } catch (err) {
+    fail(err);
+  }
+}
+
+async function remove(entry) {
+  const what = entry.type === "dir" ? "the folder " + entry.name + " and everything in it" : entry.name;
+  if (!confirm("Delete " + what + "?")) {
+    return;
+  }
+  try {
+    await api("/rm?" + query({ filename: join(state.cwd, entry.name) }));
+    await refresh();
+  } catch (err) {
+    fail(err);
+  }
+}
+
+// Uploads use the resumable upload protocol, so a big file goes up in
+// chunks and a failed chunk can be sent again.
+
+async function uploadFiles(files) {
+  for (const file of files) {
+    const row = document.createElement("div");
+    const progress = document.createElement("progress");
+    progress.max = file.size || 1;
+    progress.value = 0;
+    row.append(progress, file.name);
+    $("#uploads").append(row);
+    try {
+      await uploadFile(file, join(state.cwd, file.name), progress);
+      row.remove();
+    } catch (err) {
+      row.classList.add("error");
+      row.append(": " + err.message);
+    }
+  }
+  try {
+    await refresh();
+  } catch (err) {
+    fail(err);
+  }
+}
+
+async function uploadFile(file, target, progress) {
+  let resp = await api("/upload?" + query({ filename: target, length: file.size }), { method: "POST" });
+  const id = (await resp.text()).trim();
+  let offset = 0;
+  let retries = 0;
+  while (offset < file.size) {
+    const chunk = file.slice(offset, offset + chunkSize);
+    try {
+      resp = await api("/upload?" + query({ id }), {
+        method: "PATCH",
+        headers: { "Upload-Offset": String(offset) },
+        body: chunk,
+      });
+      offset = Number(resp.headers.get("Upload-Offset"));
+      retries = 0;
+    } catch (err) {
+      if (err.status === 401 || ++retries > 3) {
+        throw err;
+      }
+      resp = await api("/upload?" + query({ id }), { method: "HEAD" });
+      offset = Number(resp.headers.get("Upload-Offset"));
+    }
+    progress.value = offset;
+  }
+  await api("/upload/finish?" + query({ id }), { method: "POST" });
+}
+
+// Preview
+
+const textTypes = /^(text\/|application\/(json|xml|javascript|x-sh))/;
+
+async function preview(path, entry) {
+  const dialog = $("#preview");
+  const body = $("#preview-body");
+  $("#preview-name").textContent = entry.name;
+  body.replaceChildren();
+  const download = $("#preview-download");
+  download.onclick = (event) => {
+    event.preventDefault();
+    save(path, entry.name);
+  };
+  dialog.showModal();
+
+  try {
+    const headers = entry.size > 0 ? { Range: "bytes=0-" + (previewBytes - 1) } : {};
+    const resp = await api("/download?" + query({ filename: path, inline: "true" }), { headers });
+    const type = resp.headers.get("Content-Type") || "";
+    if (type.startsWith("image/")) {
+      const full = await api("/download?" + query({ filename: path, inline: "true" }));
+      const img = document.createElement("img");
+      img.src = URL.createObjectURL(await full.blob());
+      img.onload = () => URL.revokeObjectURL(img.src);
+      body.append(img);
+      return;
+    }
+    const bytes = new Uint8Array(await resp.arrayBuffer());
+    if (!textTypes.test(type) && bytes.includes(0)) {
+      body.textContent = "No preview for " + (type || "this file") + ".";
+      return;
+    }
+    const pre = document.createElement("pre");
+    pre.textContent = new TextDecoder().decode(bytes);
+    if (entry.size > previewBytes) {
+      pre.textContent += "\n… (" + humanSize(entry.size - previewBytes) + " more)";
+    }
+    body.append(pre);
+  } catch (err) {
+    body.textContent = err.message;
+  }
+}
+
+async function save(path, name) {
+  try {
+    const resp = await api("/download?" + query({ filename: path }));
+    const link = document.createElement("a");
+    link.href = URL.createObjectURL(await resp.blob());
+    link.download = name;
+    link.click();
+    setTimeout(() => URL.revokeObjectURL(link.href), 10000);
+  } catch (err) {
+    fail(err);
+  }
+}
+
+// Wiring
+
+$("#login-form").addEventListener("submit", login);
+$("#logout").addEventListener("click", logout);
+$("#mkdir").addEventListener("click", mkdir);
+$("#show-hidden").addEventListener("change", () => refresh().catch(fail));
+$("#upload-input").addEventListener("change", (event) => {
+  uploadFiles([...event.target.files]);
+  event.target.value = "";
+});
+$("#preview-close").addEventListener("click", () => $("#preview").close());
+document.querySelectorAll("th[data-key]").forEach((th) => {
+  th.addEventListener("click", () => sortBy(th.dataset.key));
+});
+
+const drop = $("#drop");
+drop.addEventListener("dragover", (event) => {
+  event.preventDefault();
+  drop.classList.add("over");
+});
+drop.addEventListener("dragleave", () => drop.classList.remove("over"));
+drop.addEventListener("drop", (event) => {
+  event.preventDefault();
+  drop.classList.remove("over");
+  uploadFiles([...event.dataTransfer.files]);
+});
+
+if (state.token) {
+  start();
+} else {
+  logout();
+}
@@ -0,0 +1,39 @@
+package ui_test
+
+import (
+	"files_server/ui"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	testServer := httptest.NewServer(ui.Handler())
+	defer testServer.Close()
+
+	tests := []struct {
+		path        string
+		contentType string
+		contains    string
+	}{
+		{path: "/ui", contentType: "text/html; charset=utf-8", contains: `<script src="app.js">`},
+		{path: "/ui/app.js", contentType: "text/javascript; charset=utf-8", contains: "/upload/finish"},
+		{path: "/ui/style.css", contentType: "text/css; charset=utf-8", contains: "table"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			resp, err := testServer.Client().Get(testServer.URL + test.path)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, test.contentType, resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), test.contains)
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package upload
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"files_server/commands"
+	"files_server/limit"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("Upload not found")
+	ErrOffset     = errors.New("Offset doesn't match the upload")
+	ErrTooLarge   = errors.New("Chunk goes past the upload length")
+	ErrIncomplete = errors.New("Upload is not complete")
+	ErrBusy       = errors.New("Upload is being written")
+	ErrChecksum   = errors.New("Checksum mismatch")
+	ErrBadSum     = errors.New("Checksum should be sha256, sha1 or md5 followed by :hex")
+)
+
+type Upload struct {
+	ID      string    `json:"id"`
+	Target  string    `json:"target"`
+	Length  int64     `json:"length"`
+	Offset  int64     `json:"offset"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	busy    bool
+}
+
+// Store keeps partial uploads in a staging directory, one .part file with
+// the data and one .json file with the Upload, so they survive restarts.
+type Store struct {
+	dir     string
+	ttl     time.Duration
+	clock   limit.Clock
+	mu      sync.Mutex
+	uploads map[string]*Upload
+}
+
+func NewStore(dir string, ttl time.Duration, clock limit.Clock) (*Store, error) {
+	if clock == nil {
+		clock = limit.RealClock
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{dir: dir, ttl: ttl, clock: clock, uploads: map[string]*Upload{}}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range files {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			continue
+		}
+		u := &Upload{}
+		if json.Unmarshal(data, u) != nil {
+			continue
+		}
+		info, err := os.Stat(store.partName(u.ID))
+		if err != nil {
+			continue
+		}
+		u.Offset = info.Size()
+		store.uploads[u.ID] = u
+	}
+	return store, nil
+}
+
+func (store *Store) Create(target string, length int64) (Upload, error) {
+	if length < 0 {
+		return Upload{}, fmt.Errorf("Bad length %d", length)
+	}
+	id, err := newID()
+	if err != nil {
+		return Upload{}, err
+	}
+	now := store.clock.Now()
+	u := &Upload{ID: id, Target: target, Length: length, Created: now, Updated: now}
+
+	file, err := os.OpenFile(store.partName(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return Upload{}, err
+	}
+	file.Close()
+	err = store.save(u)
+	if err != nil {
+		os.Remove(store.partName(id))
+		return Upload{}, err
+	}
+
+	store.mu.Lock()
+	store.uploads[id] = u
+	store.mu.Unlock()
+	return *u, nil
+}
+
+func (store *Store) Get(id string) (Upload, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	u, ok := store.uploads[id]
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	return *u, nil
+}
+
+// Write appends the chunk in body at offset, which has to be the current
+// offset of the upload. On a broken connection the part that did arrive is
+// kept and the client can continue from the new offset.
+func (store *Store) Write(id string, offset int64, body io.Reader) (Upload, error) {
+	u, err := store.acquire(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer store.release(u)
+
+	if offset != u.Offset {
+		return *u, ErrOffset
+	}
+
+	file, err := os.OpenFile(store.partName(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return *u, err
+	}
+	defer file.Close()
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return *u, err
+	}
+
+	n, err := io.Copy(file, io.LimitReader(body, u.Length-u.Offset+1))
+	if u.Offset+n > u.Length {
+		n = u.Length - u.Offset
+		file.Truncate(u.Length)
+		err = ErrTooLarge
+	}
+
+	store.mu.Lock()
+	u.Offset += n
+	u.Updated = store.clock.Now()
+	store.mu.Unlock()
+	saveErr := store.save(u)
+	if err == nil {
+		err = saveErr
+	}
+	return *u, err
+}
+
+// Finish verifies the checksum, given as "algo:hex", and moves the data to
+// the target path.
+func (store *Store) Finish(id, checksum string) (Upload, error) {
+	u, err := store.acquire(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer store.release(u)
+
+	err = store.check(u, checksum)
+	if err != nil {
+		return *u, err
+	}
+	err = moveFile(store.partName(id), u.Target)
+	if err != nil {
+		return *u, err
+	}
+	store.forget(u)
+	return *u, nil
+}
+
+// Verify is the check of Finish without moving anything, for callers that
+// have to prepare the target first.
+func (store *Store) Verify(id, checksum string) (Upload, error) {
+	u, err := store.acquire(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer store.release(u)
+	return *u, store.check(u, checksum)
+}
+
+func (store *Store) check(u *Upload, checksum string) error {
+	if u.Offset != u.Length {
+		return ErrIncomplete
+	}
+	if checksum == "" {
+		return nil
+	}
+	return verify(store.partName(u.ID), checksum)
+}
+
+func (store *Store) Cancel(id string) error {
+	u, err := store.acquire(id)
+	if err != nil {
+		return err
+	}
+	defer store.release(u)
+	os.Remove(store.partName(id))
+	store.forget(u)
+	return nil
+}
+
+// Expire removes uploads that weren't written to for longer than the ttl.
+func (store *Store) Expire() int {
+	if store.ttl <= 0 {
+		return 0
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.clock.Now()
+	expired := 0
+	for id, u := range store.uploads {
+		if u.busy || now.Sub(u.Updated) < store.ttl {
+			continue
+		}
+		delete(store.uploads, id)
+		os.Remove(store.partName(id))
+		os.Remove(store.metaName(id))
+		expired++
+	}
+	return expired
+}
+
+func (store *Store) StartExpiry(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			store.Expire()
+		}
+	}()
+}
+
+func (store *Store) acquire(id string) (*Upload, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	u, ok := store.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if u.busy {
+		return nil, ErrBusy
+	}
+	u.busy = true
+	return u, nil
+}
+
+func (store *Store) release(u *Upload) {
+	store.mu.Lock()
+	u.busy = false
+	store.mu.Unlock()
+}
+
+func (store *Store) forget(u *Upload) {
+	store.mu.Lock()
+	delete(store.uploads, u.ID)
+	store.mu.Unlock()
+	os.Remove(store.metaName(u.ID))
+}
+
+func (store *Store) save(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := store.metaName(u.ID) + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, store.metaName(u.ID))
+}
+
+func (store *Store) partName(id string) string {
+	return filepath.Join(store.dir, id+".part")
+}
+
+func (store *Store) metaName(id string) string {
+	return filepath.Join(store.dir, id+".json")
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func verify(fileName, checksum string) error {
+	algo, expected, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return ErrBadSum
+	}
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return ErrBadSum
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != strings.ToLower(expected) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// moveFile renames src to dst and falls back to copying when the staging
+// area is on another filesystem. The copy goes to a temporary file next to
+// dst first, so dst is never seen half written.
+func moveFile(src, dst string) error {
+	err := os.Chmod(src, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := os.Stat(filepath.Dir(dst)); statErr != nil {
+		return statErr
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), commands.TempUpload+"*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(src)
+}
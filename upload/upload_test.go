@@ -0,0 +1,96 @@
+package upload_test
+
+import (
+	"files_server/upload"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func TestUpload(t *testing.T) {
+	staging := t.TempDir()
+	target := filepath.Join(t.TempDir(), "file.txt")
+	store, err := upload.NewStore(staging, time.Hour, nil)
+	require.NoError(t, err)
+
+	u, err := store.Create(target, 11)
+	require.NoError(t, err)
+
+	u, err = store.Write(u.ID, 0, strings.NewReader("hello "))
+	require.NoError(t, err)
+	require.Equal(t, int64(6), u.Offset)
+
+	_, err = store.Write(u.ID, 0, strings.NewReader("hello "))
+	require.Equal(t, upload.ErrOffset, err)
+
+	_, err = store.Finish(u.ID, "")
+	require.Equal(t, upload.ErrIncomplete, err)
+
+	store, err = upload.NewStore(staging, time.Hour, nil)
+	require.NoError(t, err)
+	u, err = store.Get(u.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), u.Offset)
+
+	u, err = store.Write(u.ID, 6, strings.NewReader("world!!"))
+	require.Equal(t, upload.ErrTooLarge, err)
+	require.Equal(t, int64(11), u.Offset)
+
+	_, err = store.Finish(u.ID, "sha256:00")
+	require.Equal(t, upload.ErrChecksum, err)
+	_, err = store.Finish(u.ID, "crc:00")
+	require.Equal(t, upload.ErrBadSum, err)
+
+	_, err = store.Finish(u.ID, "md5:5EB63BBBE01EEED093CB22BB8F5ACDC3")
+	require.NoError(t, err)
+	b, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(b))
+
+	_, err = store.Get(u.ID)
+	require.Equal(t, upload.ErrNotFound, err)
+	files, err := os.ReadDir(staging)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestUploadExpire(t *testing.T) {
+	staging := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store, err := upload.NewStore(staging, time.Hour, clock)
+	require.NoError(t, err)
+
+	old, err := store.Create(filepath.Join(staging, "old"), 10)
+	require.NoError(t, err)
+	clock.now = clock.now.Add(30 * time.Minute)
+	fresh, err := store.Create(filepath.Join(staging, "fresh"), 10)
+	require.NoError(t, err)
+
+	clock.now = clock.now.Add(30 * time.Minute)
+	_, err = store.Write(fresh.ID, 0, strings.NewReader("12345"))
+	require.NoError(t, err)
+	require.Equal(t, 1, store.Expire())
+
+	_, err = store.Get(old.ID)
+	require.Equal(t, upload.ErrNotFound, err)
+	_, err = store.Get(fresh.ID)
+	require.NoError(t, err)
+
+	clock.now = clock.now.Add(time.Hour)
+	require.Equal(t, 1, store.Expire())
+	files, err := os.ReadDir(staging)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
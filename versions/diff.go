@@ -0,0 +1,260 @@
+package versions
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDiffCells bounds the table of the LCS, one byte per cell, so two files
+// with 5000 changed lines each can still be diffed.
+const maxDiffCells = 25 << 20
+
+var ErrDiffTooLarge = errors.New("Files are too different to diff")
+
+type Edit struct {
+	Op   byte
+	Text string
+}
+
+// Lines splits text into lines without the line endings.
+func Lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Diff returns the edits that turn a into b: ' ' for kept lines, '-' for
+// removed and '+' for added ones.
+func Diff(a, b []string) ([]Edit, error) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma := a[prefix : len(a)-suffix]
+	mb := b[prefix : len(b)-suffix]
+	n, m := len(ma), len(mb)
+	if n*m > maxDiffCells {
+		return nil, ErrDiffTooLarge
+	}
+
+	edits := make([]Edit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, Edit{' ', line})
+	}
+
+	// dirs[i*m+j] says how the LCS of ma[i:] and mb[j:] starts: 0 is a
+	// common line, 1 skips ma[i] and 2 skips mb[j].
+	dirs := make([]byte, n*m)
+	next := make([]int, m+1)
+	cur := make([]int, m+1)
+	for i := n - 1; i >= 0; i-- {
+		cur[m] = 0
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case ma[i] == mb[j]:
+				cur[j] = next[j+1] + 1
+			case next[j] >= cur[j+1]:
+				cur[j] = next[j]
+				dirs[i*m+j] = 1
+			default:
+				cur[j] = cur[j+1]
+				dirs[i*m+j] = 2
+			}
+		}
+		next, cur = cur, next
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch dirs[i*m+j] {
+		case 0:
+			edits = append(edits, Edit{' ', ma[i]})
+			i++
+			j++
+		case 1:
+			edits = append(edits, Edit{'-', ma[i]})
+			i++
+		default:
+			edits = append(edits, Edit{'+', mb[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		edits = append(edits, Edit{'-', ma[i]})
+	}
+	for ; j < m; j++ {
+		edits = append(edits, Edit{'+', mb[j]})
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, Edit{' ', line})
+	}
+	return edits, nil
+}
+
+// Unified writes the edits as a unified diff with context lines around each
+// change. Nothing is written when there are no changes.
+func Unified(w io.Writer, nameA, nameB string, edits []Edit, context int) error {
+	buf := bufio.NewWriter(w)
+	header := false
+	aPos, bPos := 0, 0
+	for k := 0; k < len(edits); {
+		if edits[k].Op == ' ' {
+			aPos++
+			bPos++
+			k++
+			continue
+		}
+
+		start := k
+		for start > 0 && k-start < context && edits[start-1].Op == ' ' {
+			start--
+		}
+		end := k
+		for end < len(edits) {
+			if edits[end].Op != ' ' {
+				end++
+				continue
+			}
+			same := end
+			for same < len(edits) && edits[same].Op == ' ' {
+				same++
+			}
+			if same == len(edits) || same-end > 2*context {
+				end += min(context, same-end)
+				break
+			}
+			end = same
+		}
+
+		aStart, bStart := aPos-(k-start), bPos-(k-start)
+		aCount, bCount := 0, 0
+		for _, edit := range edits[start:end] {
+			if edit.Op != '+' {
+				aCount++
+			}
+			if edit.Op != '-' {
+				bCount++
+			}
+		}
+
+		if !header {
+			fmt.Fprintf(buf, "--- %s\n+++ %s\n", nameA, nameB)
+			header = true
+		}
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, edit := range edits[start:end] {
+			buf.WriteByte(edit.Op)
+			buf.WriteString(edit.Text)
+			buf.WriteByte('\n')
+		}
+
+		aPos, bPos = aStart+aCount, bStart+bCount
+		k = end
+	}
+	return buf.Flush()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+var ErrPatch = errors.New("Patch does not apply")
+
+// Patch applies a unified diff, as Unified writes it, to lines. Context and
+// removed lines have to match exactly at the positions the hunks give.
+func Patch(lines []string, patch string) ([]string, error) {
+	patchLines := Lines(patch)
+	result := make([]string, 0, len(lines))
+	pos := 0
+	for i := 0; i < len(patchLines); i++ {
+		if !strings.HasPrefix(patchLines[i], "@@ ") {
+			continue
+		}
+		var aStart, aCount, bStart, bCount int
+		if !parseHunk(patchLines[i], &aStart, &aCount, &bStart, &bCount) {
+			return nil, ErrPatch
+		}
+		if aCount > 0 {
+			aStart--
+		}
+		if aStart < pos || aStart > len(lines) {
+			return nil, ErrPatch
+		}
+		result = append(result, lines[pos:aStart]...)
+		pos = aStart
+
+		for aCount > 0 || bCount > 0 {
+			i++
+			if i >= len(patchLines) {
+				return nil, ErrPatch
+			}
+			line := patchLines[i]
+			op, text := byte(' '), ""
+			if line != "" {
+				op, text = line[0], line[1:]
+			}
+			switch op {
+			case ' ', '-':
+				if aCount == 0 || pos >= len(lines) || lines[pos] != text {
+					return nil, ErrPatch
+				}
+				if op == ' ' {
+					if bCount == 0 {
+						return nil, ErrPatch
+					}
+					result = append(result, text)
+					bCount--
+				}
+				pos++
+				aCount--
+			case '+':
+				if bCount == 0 {
+					return nil, ErrPatch
+				}
+				result = append(result, text)
+				bCount--
+			case '\\':
+			default:
+				return nil, ErrPatch
+			}
+		}
+	}
+	return append(result, lines[pos:]...), nil
+}
+
+// parseHunk reads "@@ -a,b +c,d @@", a count left out is 1.
+func parseHunk(header string, aStart, aCount, bStart, bCount *int) bool {
+	fields := strings.Fields(header)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return false
+	}
+	return parseRange(fields[1][1:], aStart, aCount) && parseRange(fields[2][1:], bStart, bCount)
+}
+
+func parseRange(s string, start, count *int) bool {
+	*count = 1
+	startText, countText, found := strings.Cut(s, ",")
+	_, err := fmt.Sscan(startText, start)
+	if err != nil || *start < 0 {
+		return false
+	}
+	if found {
+		_, err = fmt.Sscan(countText, count)
+		if err != nil || *count < 0 {
+			return false
+		}
+	}
+	return true
+}
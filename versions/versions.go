@@ -0,0 +1,457 @@
+package versions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"files_server/limit"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ReasonModify = "modify"
+	ReasonDelete = "delete"
+)
+
+var ErrNotFound = errors.New("Version not found")
+
+// Policy says how many versions of a file to keep: the newest Keep of them
+// and all younger than MaxAge. A zero field doesn't limit.
+type Policy struct {
+	Keep   int           `json:"keep"`
+	MaxAge time.Duration `json:"max_age"`
+}
+
+func (policy Policy) Enabled() bool {
+	return policy.Keep > 0 || policy.MaxAge > 0
+}
+
+type Version struct {
+	ID      string    `json:"id"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Saved   time.Time `json:"saved"`
+	Reason  string    `json:"reason"`
+}
+
+// Store keeps copies of files from versioned directories before they are
+// overwritten or removed. Policies are set per directory and apply to
+// everything below it, the nearest directory wins.
+type Store struct {
+	dir      string
+	quota    int64
+	clock    limit.Clock
+	mu       sync.Mutex
+	policies map[string]Policy
+	usage    int64
+}
+
+func Open(dir string, quota int64, clock limit.Clock) (*Store, error) {
+	if clock == nil {
+		clock = limit.RealClock
+	}
+	err := os.MkdirAll(filepath.Join(dir, "files"), 0700)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{dir: dir, quota: quota, clock: clock, policies: map[string]Policy{}}
+
+	data, err := os.ReadFile(store.policiesName())
+	if err == nil {
+		err = json.Unmarshal(data, &store.policies)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = store.eachIndex(func(versions []Version) error {
+		for _, v := range versions {
+			store.usage += v.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *Store) Quota() int64 {
+	return store.quota
+}
+
+func (store *Store) Usage() int64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.usage
+}
+
+// SetPolicy enables versioning below dirName, a policy that isn't Enabled
+// removes it.
+func (store *Store) SetPolicy(dirName string, policy Policy) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	dirName = filepath.Clean(dirName)
+	if policy.Enabled() {
+		store.policies[dirName] = policy
+	} else {
+		delete(store.policies, dirName)
+	}
+	data, err := json.Marshal(store.policies)
+	if err != nil {
+		return err
+	}
+	return writeFile(store.policiesName(), data)
+}
+
+func (store *Store) Policy(path string) (Policy, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.policy(path)
+}
+
+func (store *Store) policy(path string) (Policy, bool) {
+	path = filepath.Clean(path)
+	for {
+		if policy, ok := store.policies[path]; ok {
+			return policy, true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return Policy{}, false
+		}
+		path = parent
+	}
+}
+
+// Save keeps the current content of path, or of every file below it for a
+// directory, if it is in a versioned directory. Missing files are ignored.
+func (store *Store) Save(path, reason string) error {
+	return store.SaveFrom(path, path, reason)
+}
+
+// SaveFrom is Save with the content that was at path read from from, where
+// it was moved or copied meanwhile.
+func (store *Store) SaveFrom(path, from, reason string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	path = filepath.Clean(path)
+	from = filepath.Clean(from)
+	info, err := os.Lstat(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		return store.save(path, from, info, reason)
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	return filepath.WalkDir(from, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(from, fullPath)
+		if err != nil {
+			return err
+		}
+		return store.save(filepath.Join(path, rel), fullPath, info, reason)
+	})
+}
+
+func (store *Store) save(path, from string, info os.FileInfo, reason string) error {
+	policy, ok := store.policy(path)
+	if !ok {
+		return nil
+	}
+
+	versions, err := store.index(path)
+	if err != nil {
+		return err
+	}
+	now := store.clock.Now()
+	id := now.UnixNano()
+	if len(versions) > 0 {
+		last, _ := strconv.ParseInt(versions[len(versions)-1].ID, 10, 64)
+		if last >= id {
+			id = last + 1
+		}
+	}
+	v := Version{
+		ID:      strconv.FormatInt(id, 10),
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Saved:   now,
+		Reason:  reason,
+	}
+
+	err = os.MkdirAll(store.fileDir(path), 0700)
+	if err != nil {
+		return err
+	}
+	err = copyFile(from, store.dataName(path, v.ID))
+	if err != nil {
+		return err
+	}
+	store.usage += v.Size
+	versions = append(versions, v)
+	versions, err = store.prune(path, versions, policy)
+	if err != nil {
+		return err
+	}
+	err = store.writeIndex(path, versions)
+	if err != nil {
+		return err
+	}
+	return store.enforceQuota()
+}
+
+// List returns the versions of path, oldest first.
+func (store *Store) List(path string) ([]Version, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.index(filepath.Clean(path))
+}
+
+func (store *Store) Open(path, id string) (*os.File, Version, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	path = filepath.Clean(path)
+	versions, err := store.index(path)
+	if err != nil {
+		return nil, Version{}, err
+	}
+	for _, v := range versions {
+		if v.ID == id {
+			file, err := os.Open(store.dataName(path, id))
+			return file, v, err
+		}
+	}
+	return nil, Version{}, ErrNotFound
+}
+
+// Prune applies the policies and the quota to all versions, e.g. to drop
+// versions that got too old.
+func (store *Store) Prune() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	err := store.eachIndex(func(versions []Version) error {
+		if len(versions) == 0 {
+			return nil
+		}
+		path := versions[0].Path
+		policy, _ := store.policy(path)
+		kept, err := store.prune(path, versions, policy)
+		if err != nil {
+			return err
+		}
+		return store.writeIndex(path, kept)
+	})
+	if err != nil {
+		return err
+	}
+	return store.enforceQuota()
+}
+
+func (store *Store) StartPruning(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			store.Prune()
+		}
+	}()
+}
+
+func (store *Store) prune(path string, versions []Version, policy Policy) ([]Version, error) {
+	now := store.clock.Now()
+	kept := []Version{}
+	for i, v := range versions {
+		tooMany := policy.Keep > 0 && len(versions)-i > policy.Keep
+		tooOld := policy.MaxAge > 0 && now.Sub(v.Saved) > policy.MaxAge
+		if !policy.Enabled() || tooMany || tooOld {
+			err := store.drop(path, v)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept, nil
+}
+
+// enforceQuota drops the oldest versions of all files until the store fits
+// into the quota.
+func (store *Store) enforceQuota() error {
+	if store.quota <= 0 || store.usage <= store.quota {
+		return nil
+	}
+	all := []Version{}
+	err := store.eachIndex(func(versions []Version) error {
+		all = append(all, versions...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Saved.Before(all[j].Saved)
+	})
+
+	dropped := map[string]map[string]bool{}
+	for _, v := range all {
+		if store.usage <= store.quota {
+			break
+		}
+		err = store.drop(v.Path, v)
+		if err != nil {
+			return err
+		}
+		if dropped[v.Path] == nil {
+			dropped[v.Path] = map[string]bool{}
+		}
+		dropped[v.Path][v.ID] = true
+	}
+
+	for path, ids := range dropped {
+		versions, err := store.index(path)
+		if err != nil {
+			return err
+		}
+		kept := []Version{}
+		for _, v := range versions {
+			if !ids[v.ID] {
+				kept = append(kept, v)
+			}
+		}
+		err = store.writeIndex(path, kept)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *Store) drop(path string, v Version) error {
+	err := os.Remove(store.dataName(path, v.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	store.usage -= v.Size
+	return nil
+}
+
+func (store *Store) index(path string) ([]Version, error) {
+	versions := []Version{}
+	data, err := os.ReadFile(store.indexName(path))
+	if os.IsNotExist(err) {
+		return versions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &versions)
+	return versions, err
+}
+
+func (store *Store) writeIndex(path string, versions []Version) error {
+	if len(versions) == 0 {
+		os.Remove(store.indexName(path))
+		os.Remove(store.fileDir(path))
+		return nil
+	}
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	return writeFile(store.indexName(path), data)
+}
+
+func (store *Store) eachIndex(fn func([]Version) error) error {
+	indexes, err := filepath.Glob(filepath.Join(store.dir, "files", "*", "index.json"))
+	if err != nil {
+		return err
+	}
+	for _, indexName := range indexes {
+		data, err := os.ReadFile(indexName)
+		if err != nil {
+			continue
+		}
+		versions := []Version{}
+		if json.Unmarshal(data, &versions) != nil {
+			continue
+		}
+		err = fn(versions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *Store) policiesName() string {
+	return filepath.Join(store.dir, "policies.json")
+}
+
+func (store *Store) fileDir(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(store.dir, "files", hex.EncodeToString(sum[:16]))
+}
+
+func (store *Store) indexName(path string) string {
+	return filepath.Join(store.fileDir(path), "index.json")
+}
+
+func (store *Store) dataName(path, id string) string {
+	return filepath.Join(store.fileDir(path), id+".data")
+}
+
+func writeFile(fileName string, data []byte) error {
+	tmp := fileName + ".tmp"
+	err := os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
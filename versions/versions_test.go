@@ -0,0 +1,231 @@
+package versions_test
+
+import (
+	"bytes"
+	"files_server/versions"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func readVersion(t *testing.T, store *versions.Store, path, id string) string {
+	file, _, err := store.Open(path, id)
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestStore(t *testing.T) {
+	root := t.TempDir()
+	storeDir := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store, err := versions.Open(storeDir, 0, clock)
+	require.NoError(t, err)
+
+	fileName := filepath.Join(root, "docs", "a.txt")
+	require.NoError(t, os.MkdirAll(filepath.Dir(fileName), 0755))
+	require.NoError(t, os.WriteFile(fileName, []byte("one"), 0644))
+
+	require.NoError(t, store.Save(fileName, versions.ReasonModify))
+	list, err := store.List(fileName)
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	require.NoError(t, store.SetPolicy(root, versions.Policy{Keep: 2}))
+	for _, content := range []string{"two", "three", "four!"} {
+		clock.now = clock.now.Add(time.Minute)
+		require.NoError(t, store.Save(fileName, versions.ReasonModify))
+		require.NoError(t, os.WriteFile(fileName, []byte(content), 0644))
+	}
+
+	list, err = store.List(fileName)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "two", readVersion(t, store, fileName, list[0].ID))
+	require.Equal(t, "three", readVersion(t, store, fileName, list[1].ID))
+	require.Equal(t, int64(8), store.Usage())
+
+	require.NoError(t, store.Save(filepath.Join(root, "docs"), versions.ReasonDelete))
+	list, err = store.List(fileName)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, versions.ReasonDelete, list[1].Reason)
+	require.Equal(t, "four!", readVersion(t, store, fileName, list[1].ID))
+
+	_, _, err = store.Open(fileName, "1")
+	require.ErrorIs(t, err, versions.ErrNotFound)
+
+	reopened, err := versions.Open(storeDir, 0, clock)
+	require.NoError(t, err)
+	require.Equal(t, store.Usage(), reopened.Usage())
+	policy, ok := reopened.Policy(fileName)
+	require.True(t, ok)
+	require.Equal(t, versions.Policy{Keep: 2}, policy)
+}
+
+func TestPrune(t *testing.T) {
+	root := t.TempDir()
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store, err := versions.Open(t.TempDir(), 10, clock)
+	require.NoError(t, err)
+	require.NoError(t, store.SetPolicy(root, versions.Policy{MaxAge: time.Hour}))
+
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	require.NoError(t, os.WriteFile(a, []byte("aaaa"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("bbbb"), 0644))
+
+	require.NoError(t, store.Save(a, versions.ReasonModify))
+	clock.now = clock.now.Add(time.Minute)
+	require.NoError(t, store.Save(b, versions.ReasonModify))
+	clock.now = clock.now.Add(time.Minute)
+	require.NoError(t, store.Save(b, versions.ReasonModify))
+	require.Equal(t, int64(8), store.Usage())
+
+	list, err := store.List(a)
+	require.NoError(t, err)
+	require.Empty(t, list)
+	list, err = store.List(b)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	clock.now = clock.now.Add(time.Hour - 30*time.Second)
+	require.NoError(t, store.Prune())
+	list, err = store.List(b)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, int64(4), store.Usage())
+
+	require.NoError(t, store.SetPolicy(root, versions.Policy{}))
+	require.NoError(t, store.Prune())
+	require.Equal(t, int64(0), store.Usage())
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		diff string
+	}{
+		{
+			name: "same",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			diff: "",
+		},
+		{
+			name: "change",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			diff: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "two hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			diff: "--- a\n+++ b\n@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -7,4 +8,3 @@\n 7\n 8\n 9\n-10\n",
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "x\n",
+			diff: "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+x\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			edits, err := versions.Diff(versions.Lines(test.a), versions.Lines(test.b))
+			require.NoError(t, err)
+			buf := &bytes.Buffer{}
+			require.NoError(t, versions.Unified(buf, "a", "b", edits, 3))
+			require.Equal(t, test.diff, buf.String())
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        string
+		patch    string
+		expected string
+		err      error
+	}{
+		{
+			name:     "change",
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			patch:    "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+			expected: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+		},
+		{
+			name:     "two hunks",
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			patch:    "@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -7,4 +8,3 @@\n 7\n 8\n 9\n-10\n",
+			expected: "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+		},
+		{
+			name:     "into empty",
+			a:        "",
+			patch:    "@@ -0,0 +1,1 @@\n+x\n",
+			expected: "x\n",
+		},
+		{
+			name:     "counts left out",
+			a:        "a\nb\n",
+			patch:    "@@ -2 +2 @@\n-b\n+c\n",
+			expected: "a\nc\n",
+		},
+		{
+			name:  "context changed",
+			a:     "1\n2\n3\n",
+			patch: "@@ -1,2 +1,2 @@\n 1\n-two\n+2\n",
+			err:   versions.ErrPatch,
+		},
+		{
+			name:  "past the end",
+			a:     "1\n",
+			patch: "@@ -5,1 +5,1 @@\n-5\n+6\n",
+			err:   versions.ErrPatch,
+		},
+		{
+			name:  "cut short",
+			a:     "1\n2\n",
+			patch: "@@ -1,2 +1,2 @@\n 1\n",
+			err:   versions.ErrPatch,
+		},
+		{
+			name:  "bad header",
+			a:     "1\n",
+			patch: "@@ -x +1 @@\n",
+			err:   versions.ErrPatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, err := versions.Patch(versions.Lines(test.a), test.patch)
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, versions.Lines(test.expected), lines)
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package watch
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	OpCreate = "create"
+	OpModify = "modify"
+	OpDelete = "delete"
+	OpRename = "rename"
+	// OpReset is sent on resume when events after the requested ID are no
+	// longer in the history, so the client has to list the directory again.
+	OpReset = "reset"
+)
+
+const subscriptionBuffer = 256
+
+type Event struct {
+	ID   uint64    `json:"id"`
+	Op   string    `json:"op"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+type Subscription struct {
+	hub       *Hub
+	dir       string
+	recursive bool
+	dirs      []string
+	events    chan Event
+	closed    bool
+}
+
+// Hub shares one fsnotify watcher between all subscriptions, numbers the
+// events and keeps the last of them so that clients can resume.
+type Hub struct {
+	mu          sync.Mutex
+	watcher     *fsnotify.Watcher
+	debounce    time.Duration
+	historySize int
+	history     []Event
+	nextID      uint64
+	watched     map[string]int
+	pending     map[string]*pending
+	subs        map[*Subscription]struct{}
+	done        chan struct{}
+}
+
+type pending struct {
+	op    string
+	timer *time.Timer
+}
+
+func NewHub(debounce time.Duration, historySize int) (*Hub, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	hub := &Hub{
+		watcher:     watcher,
+		debounce:    debounce,
+		historySize: historySize,
+		nextID:      1,
+		watched:     map[string]int{},
+		pending:     map[string]*pending{},
+		subs:        map[*Subscription]struct{}{},
+		done:        make(chan struct{}),
+	}
+	go hub.run()
+	return hub, nil
+}
+
+func (hub *Hub) Close() error {
+	err := hub.watcher.Close()
+	<-hub.done
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, p := range hub.pending {
+		p.timer.Stop()
+	}
+	for sub := range hub.subs {
+		sub.close()
+	}
+	return err
+}
+
+// Subscribe starts watching dirName. Events after lastID that are still in
+// the history are delivered first; lastID 0 means no replay.
+func (hub *Hub) Subscribe(dirName string, recursive bool, lastID uint64) (*Subscription, error) {
+	sub := &Subscription{
+		hub:       hub,
+		dir:       filepath.Clean(dirName),
+		recursive: recursive,
+		events:    make(chan Event, subscriptionBuffer),
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	err := hub.addWatch(sub, sub.dir)
+	if err != nil {
+		hub.removeWatches(sub)
+		return nil, err
+	}
+
+	if lastID > 0 {
+		if len(hub.history) > 0 && hub.history[0].ID > lastID+1 || len(hub.history) == 0 && hub.nextID > lastID+1 {
+			sub.send(Event{Op: OpReset, Path: sub.dir, Time: time.Now()})
+		}
+		for _, event := range hub.history {
+			if event.ID > lastID && sub.matches(event.Path) {
+				sub.send(event)
+			}
+		}
+	}
+	if sub.closed {
+		hub.removeWatches(sub)
+	} else {
+		hub.subs[sub] = struct{}{}
+	}
+	return sub, nil
+}
+
+// Events is closed when the subscription is closed or when the client falls
+// too far behind; it can then resume from the last received ID.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+func (sub *Subscription) Close() {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+	sub.hub.removeWatches(sub)
+	delete(sub.hub.subs, sub)
+	sub.close()
+}
+
+func (sub *Subscription) close() {
+	if !sub.closed {
+		sub.closed = true
+		close(sub.events)
+	}
+}
+
+func (sub *Subscription) send(event Event) bool {
+	if sub.closed {
+		return false
+	}
+	select {
+	case sub.events <- event:
+		return true
+	default:
+		sub.close()
+		return false
+	}
+}
+
+func (sub *Subscription) matches(path string) bool {
+	if sub.recursive {
+		return strings.HasPrefix(path, sub.dir+"/") || sub.dir == "/" && path != "/"
+	}
+	return filepath.Dir(path) == sub.dir
+}
+
+func (hub *Hub) addWatch(sub *Subscription, dirName string) error {
+	if !sub.recursive {
+		return hub.watchDir(sub, dirName)
+	}
+	return filepath.WalkDir(dirName, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dirName {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return hub.watchDir(sub, path)
+	})
+}
+
+func (hub *Hub) watchDir(sub *Subscription, dirName string) error {
+	if hub.watched[dirName] == 0 {
+		err := hub.watcher.Add(dirName)
+		if err != nil {
+			return err
+		}
+	}
+	hub.watched[dirName]++
+	sub.dirs = append(sub.dirs, dirName)
+	return nil
+}
+
+func (hub *Hub) removeWatches(sub *Subscription) {
+	for _, dirName := range sub.dirs {
+		hub.watched[dirName]--
+		if hub.watched[dirName] <= 0 {
+			delete(hub.watched, dirName)
+			hub.watcher.Remove(dirName)
+		}
+	}
+	sub.dirs = nil
+}
+
+func (hub *Hub) run() {
+	defer close(hub.done)
+	for {
+		select {
+		case event, ok := <-hub.watcher.Events:
+			if !ok {
+				return
+			}
+			hub.handle(event)
+		case _, ok := <-hub.watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+func (hub *Hub) handle(event fsnotify.Event) {
+	op := ""
+	switch {
+	case event.Has(fsnotify.Create):
+		op = OpCreate
+	case event.Has(fsnotify.Remove):
+		op = OpDelete
+	case event.Has(fsnotify.Rename):
+		op = OpRename
+	case event.Has(fsnotify.Write), event.Has(fsnotify.Chmod):
+		op = OpModify
+	default:
+		return
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if op == OpCreate {
+		for sub := range hub.subs {
+			if sub.recursive && sub.matches(event.Name) {
+				hub.addWatch(sub, event.Name)
+			}
+		}
+	}
+
+	if hub.debounce <= 0 {
+		hub.emit(op, event.Name)
+		return
+	}
+	p, ok := hub.pending[event.Name]
+	if !ok {
+		path := event.Name
+		hub.pending[path] = &pending{op: op, timer: time.AfterFunc(hub.debounce, func() {
+			hub.flush(path)
+		})}
+		return
+	}
+	p.op = merge(p.op, op)
+	p.timer.Reset(hub.debounce)
+}
+
+// merge folds a burst of events on one path into one: a file that was
+// created and then written is still a create, anything that ends with a
+// delete or rename is reported as such.
+func merge(first, next string) string {
+	switch {
+	case next == OpDelete || next == OpRename:
+		return next
+	case first == OpDelete || first == OpRename:
+		return OpCreate
+	case first == OpCreate:
+		return OpCreate
+	}
+	return next
+}
+
+func (hub *Hub) flush(path string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	p, ok := hub.pending[path]
+	if !ok {
+		return
+	}
+	delete(hub.pending, path)
+	hub.emit(p.op, path)
+}
+
+func (hub *Hub) emit(op, path string) {
+	event := Event{ID: hub.nextID, Op: op, Path: path, Time: time.Now()}
+	hub.nextID++
+
+	hub.history = append(hub.history, event)
+	if len(hub.history) > hub.historySize {
+		hub.history = hub.history[len(hub.history)-hub.historySize:]
+	}
+
+	for sub := range hub.subs {
+		if !sub.matches(path) {
+			continue
+		}
+		if !sub.send(event) {
+			hub.removeWatches(sub)
+			delete(hub.subs, sub)
+		}
+	}
+}
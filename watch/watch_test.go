@@ -0,0 +1,114 @@
+package watch_test
+
+import (
+	"files_server/watch"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func next(t *testing.T, sub *watch.Subscription) watch.Event {
+	select {
+	case event, ok := <-sub.Events():
+		require.True(t, ok)
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event")
+	}
+	return watch.Event{}
+}
+
+func TestHub(t *testing.T) {
+	dir := t.TempDir()
+	hub, err := watch.NewHub(50*time.Millisecond, 100)
+	require.NoError(t, err)
+	defer hub.Close()
+
+	sub, err := hub.Subscribe(dir, false, 0)
+	require.NoError(t, err)
+
+	fileName := filepath.Join(dir, "file.txt")
+	file, err := os.Create(fileName)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = file.WriteString("line\n")
+		require.NoError(t, err)
+	}
+	file.Close()
+
+	event := next(t, sub)
+	require.Equal(t, watch.OpCreate, event.Op)
+	require.Equal(t, fileName, event.Path)
+	require.Equal(t, uint64(1), event.ID)
+
+	require.NoError(t, os.Rename(fileName, filepath.Join(dir, "renamed.txt")))
+	events := map[string]string{}
+	for i := 0; i < 2; i++ {
+		event = next(t, sub)
+		events[filepath.Base(event.Path)] = event.Op
+	}
+	require.Equal(t, map[string]string{"file.txt": watch.OpRename, "renamed.txt": watch.OpCreate}, events)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0777))
+	event = next(t, sub)
+	require.Equal(t, filepath.Join(dir, "sub"), event.Path)
+	sub.Close()
+
+	resumed, err := hub.Subscribe(dir, false, 1)
+	require.NoError(t, err)
+	defer resumed.Close()
+	require.Equal(t, uint64(2), next(t, resumed).ID)
+	require.Equal(t, uint64(3), next(t, resumed).ID)
+	require.Equal(t, uint64(4), next(t, resumed).ID)
+}
+
+func TestHubRecursive(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0777))
+
+	hub, err := watch.NewHub(0, 100)
+	require.NoError(t, err)
+	defer hub.Close()
+
+	sub, err := hub.Subscribe(dir, true, 0)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a.txt"), nil, 0666))
+	event := next(t, sub)
+	require.Equal(t, watch.OpCreate, event.Op)
+	require.Equal(t, filepath.Join(dir, "sub", "a.txt"), event.Path)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "new"), 0777))
+	require.Equal(t, filepath.Join(dir, "new"), next(t, sub).Path)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "b.txt"), nil, 0666))
+	require.Equal(t, filepath.Join(dir, "new", "b.txt"), next(t, sub).Path)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "sub", "a.txt")))
+	event = next(t, sub)
+	require.Equal(t, watch.OpDelete, event.Op)
+}
+
+func TestHubReset(t *testing.T) {
+	dir := t.TempDir()
+	hub, err := watch.NewHub(0, 1)
+	require.NoError(t, err)
+	defer hub.Close()
+
+	sub, err := hub.Subscribe(dir, false, 0)
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0777))
+		next(t, sub)
+	}
+	sub.Close()
+
+	resumed, err := hub.Subscribe(dir, false, 1)
+	require.NoError(t, err)
+	defer resumed.Close()
+	require.Equal(t, watch.OpReset, next(t, resumed).Op)
+	require.Equal(t, uint64(3), next(t, resumed).ID)
+}